@@ -0,0 +1,79 @@
+// serial-bridge shares a local serial port over the network with
+// serial.RFC2217Server:
+//
+//	serial-bridge --port /dev/ttyUSB0:115200,8N1 [--listen :2217] [--raw]
+//	    [--observers] [--idle 30m]
+//
+// By default clients speak RFC 2217, and so can change the port's settings
+// and modem lines. With --raw the port is served as a plain TCP stream, as
+// ser2net's raw mode does. One client is in control at a time; with
+// --observers others may connect to watch.
+//
+// serial-bridge runs until interrupted, or until the port fails, such as
+// when the device is unplugged, in which case it exits with status 1.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+func main() {
+	var port serial.ModeFlag
+	flag.Var(&port, "port", "port to share, as `name:mode`, such as /dev/ttyUSB0:115200,8N1")
+	listen := flag.String("listen", ":2217", "TCP `address` to listen on")
+	raw := flag.Bool("raw", false, "serve a plain TCP stream rather than RFC 2217")
+	observers := flag.Bool("observers", false, "let clients connect read-only while another is in control")
+	idle := flag.Duration("idle", 0, "disconnect clients that send nothing for this long (0 to never)")
+	quiet := flag.Bool("quiet", false, "don't log clients connecting and disconnecting")
+	flag.Parse()
+
+	if port.String() == "" || flag.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: serial-bridge --port name:mode [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	options := port.Options()
+	p, err := serial.Open(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serial-bridge:", err)
+		os.Exit(1)
+	}
+	defer p.Close()
+
+	s := &serial.RFC2217Server{
+		Port:           p,
+		Raw:            *raw,
+		AllowObservers: *observers,
+		IdleTimeout:    *idle,
+	}
+	if !*quiet {
+		s.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serial-bridge: serving %s on %s\n", options.PortName, *listen)
+	if err := s.ListenAndServe(*listen); err != nil {
+		if errors.Is(err, serial.ErrDeviceRemoved) {
+			err = fmt.Errorf("%s disappeared", options.PortName)
+		}
+
+		fmt.Fprintln(os.Stderr, "serial-bridge:", err)
+		p.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,186 @@
+// serial-list lists the serial ports on the system along with the USB
+// device behind each, as reported by serial.DetailedPorts:
+//
+//	serial-list [--json] [--match vid:pid] [--watch]
+//
+// With --watch it keeps running after the listing and reports ports as they
+// are plugged in and removed, until interrupted. With --json each port, or
+// each event, is printed as one JSON object per line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// jsonPort is the form a port takes in JSON output.
+type jsonPort struct {
+	Event        string `json:"event,omitempty"`
+	Name         string `json:"name"`
+	USB          bool   `json:"usb"`
+	VID          string `json:"vid,omitempty"`
+	PID          string `json:"pid,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Driver       string `json:"driver,omitempty"`
+	StablePath   string `json:"stablePath,omitempty"`
+	FriendlyName string `json:"friendlyName,omitempty"`
+}
+
+func toJSON(event string, p serial.PortInfo) jsonPort {
+	j := jsonPort{
+		Event:        event,
+		Name:         p.Name,
+		USB:          p.IsUSB,
+		SerialNumber: p.SerialNumber,
+		Manufacturer: p.Manufacturer,
+		Product:      p.Product,
+		Driver:       p.Driver,
+		StablePath:   p.StablePath,
+		FriendlyName: p.FriendlyName,
+	}
+
+	if p.IsUSB {
+		j.VID = fmt.Sprintf("%04x", p.VendorID)
+		j.PID = fmt.Sprintf("%04x", p.ProductID)
+	}
+
+	return j
+}
+
+// parseMatch parses a --match value, a hex vendor ID optionally followed by
+// a colon and a hex product ID, such as "2341:0043" or "0403".
+func parseMatch(s string) (serial.Filter, error) {
+	vid, pid, hasPID := strings.Cut(s, ":")
+
+	v, err := strconv.ParseUint(vid, 16, 16)
+	if err != nil || v == 0 {
+		return serial.Filter{}, fmt.Errorf("invalid vendor ID %q", vid)
+	}
+
+	f := serial.Filter{VID: uint16(v)}
+	if hasPID {
+		p, err := strconv.ParseUint(pid, 16, 16)
+		if err != nil || p == 0 {
+			return serial.Filter{}, fmt.Errorf("invalid product ID %q", pid)
+		}
+
+		f.PID = uint16(p)
+	}
+
+	return f, nil
+}
+
+func usbID(p serial.PortInfo) string {
+	if !p.IsUSB {
+		return "-"
+	}
+
+	return fmt.Sprintf("%04x:%04x", p.VendorID, p.ProductID)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func printTable(ports []serial.PortInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVID:PID\tSERIAL\tMANUFACTURER\tPRODUCT")
+	for _, p := range ports {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			p.Name,
+			usbID(p),
+			orDash(p.SerialNumber),
+			orDash(p.Manufacturer),
+			orDash(p.Product))
+	}
+
+	w.Flush()
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print one JSON object per port or event")
+	watch := flag.Bool("watch", false, "report ports as they are added and removed")
+	match := flag.String("match", "", "only show USB ports with this `vid:pid` (hex; pid optional)")
+	flag.Parse()
+
+	var filter serial.Filter
+	if *match != "" {
+		var err error
+		if filter, err = parseMatch(*match); err != nil {
+			fmt.Fprintln(os.Stderr, "serial-list: --match:", err)
+			os.Exit(2)
+		}
+	}
+
+	if *watch {
+		if err := watchPorts(filter, *asJSON); err != nil {
+			fmt.Fprintln(os.Stderr, "serial-list:", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	ports, err := serial.FindPorts(filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serial-list:", err)
+		os.Exit(1)
+	}
+
+	if !*asJSON {
+		printTable(ports)
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, p := range ports {
+		enc.Encode(toJSON("", p))
+	}
+}
+
+// watchPorts prints WatchPorts events for ports matching filter until
+// interrupted. The ports already present are reported as added first.
+func watchPorts(filter serial.Filter, asJSON bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	events, err := serial.WatchPorts(ctx)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for e := range events {
+		if !filter.Match(e.Port) {
+			continue
+		}
+
+		event := "added"
+		if e.Type == serial.PORT_REMOVED {
+			event = "removed"
+		}
+
+		if asJSON {
+			enc.Encode(toJSON(event, e.Port))
+		} else {
+			fmt.Printf("%-8s %s\n", event, e.Port)
+		}
+	}
+
+	return nil
+}
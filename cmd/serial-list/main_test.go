@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+func TestParseMatch(t *testing.T) {
+	good := map[string]serial.Filter{
+		"2341:0043": {VID: 0x2341, PID: 0x0043},
+		"0403":      {VID: 0x0403},
+		"10C4:EA60": {VID: 0x10c4, PID: 0xea60},
+	}
+
+	for s, want := range good {
+		got, err := parseMatch(s)
+		if err != nil {
+			t.Errorf("parseMatch(%q): %v", s, err)
+		} else if got != want {
+			t.Errorf("parseMatch(%q): got %+v, want %+v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"", "xyz", "2341:", ":0043", "12345:1", "0:1"} {
+		if _, err := parseMatch(s); err == nil {
+			t.Errorf("parseMatch(%q) succeeded", s)
+		}
+	}
+}
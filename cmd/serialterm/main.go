@@ -0,0 +1,302 @@
+// serialterm is a minimal interactive terminal for a serial port, in the
+// manner of picocom:
+//
+//	serialterm [--hex] name[:mode]
+//
+// where mode is as accepted by serial.ParseMode, such as 115200,8N1.
+// Keystrokes are sent to the port as they are typed and what the port sends
+// is printed, raw or, with --hex, as a hex dump. Commands are typed after
+// Ctrl-A:
+//
+//	Ctrl-A q       quit
+//	Ctrl-A b       send a break
+//	Ctrl-A t       toggle DTR
+//	Ctrl-A g       toggle RTS
+//	Ctrl-A u       raise the baud rate to the next standard rate
+//	Ctrl-A d       lower the baud rate to the previous standard rate
+//	Ctrl-A h       toggle hex dump mode
+//	Ctrl-A Ctrl-A  send Ctrl-A itself
+//	Ctrl-A ?       list the commands
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// The byte that introduces a command: Ctrl-A.
+const escape = 0x01
+
+// How long Ctrl-A b holds the line in the break condition.
+const breakDuration = 250 * time.Millisecond
+
+const help = `commands, after Ctrl-A:
+  q  quit                 b  send a break
+  t  toggle DTR           g  toggle RTS
+  u  raise baud rate      d  lower baud rate
+  h  toggle hex dump      ^A send Ctrl-A`
+
+// term forwards keystrokes to a port and carries out the commands among
+// them.
+type term struct {
+	p   serial.Port
+	out io.Writer // where messages are printed
+
+	// Drivers raise both lines when the port is opened.
+	dtr, rts bool
+
+	hex     atomic.Bool
+	escaped bool // the last byte typed was the escape
+}
+
+// status prints a message for the user. The terminal is in raw mode, so
+// lines must end in CRLF.
+func (t *term) status(format string, a ...interface{}) {
+	fmt.Fprintf(t.out, "\r\n*** "+format+"\r\n", a...)
+}
+
+// input handles what was typed, returning true when the user asked to quit.
+func (t *term) input(b []byte) (bool, error) {
+	var data []byte
+	for _, c := range b {
+		switch {
+		case t.escaped:
+			t.escaped = false
+			if c == escape {
+				data = append(data, c)
+				continue
+			}
+
+			if err := t.send(data); err != nil {
+				return false, err
+			}
+			data = data[:0]
+
+			if c == 'q' {
+				return true, nil
+			}
+			t.command(c)
+
+		case c == escape:
+			t.escaped = true
+
+		default:
+			data = append(data, c)
+		}
+	}
+
+	return false, t.send(data)
+}
+
+func (t *term) send(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	_, err := t.p.Write(data)
+	return err
+}
+
+// command carries out the command c, reporting the outcome.
+func (t *term) command(c byte) {
+	switch c {
+	case 'b':
+		err := t.p.SetBreak(true)
+		if err == nil {
+			time.Sleep(breakDuration)
+			err = t.p.SetBreak(false)
+		}
+		t.report("break sent", err)
+
+	case 't':
+		err := t.p.SetDTR(!t.dtr)
+		if err == nil {
+			t.dtr = !t.dtr
+		}
+		t.report("DTR "+onOff(t.dtr), err)
+
+	case 'g':
+		err := t.p.SetRTS(!t.rts)
+		if err == nil {
+			t.rts = !t.rts
+		}
+		t.report("RTS "+onOff(t.rts), err)
+
+	case 'u', 'd':
+		baud, err := t.p.BaudRate()
+		if err == nil {
+			baud = nextBaudRate(baud, c == 'u')
+			err = t.p.SetBaudRate(baud)
+		}
+		t.report(fmt.Sprintf("baud rate %d", baud), err)
+
+	case 'h':
+		on := !t.hex.Load()
+		t.hex.Store(on)
+		t.status("hex dump %s", onOff(on))
+
+	case '?':
+		t.status("%s", strings.ReplaceAll(help, "\n", "\r\n"))
+
+	default:
+		t.status("unknown command; Ctrl-A ? lists them")
+	}
+}
+
+func (t *term) report(msg string, err error) {
+	if err != nil {
+		t.status("%v", err)
+		return
+	}
+
+	t.status("%s", msg)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+// nextBaudRate returns the standard baud rate above or below baud, or baud
+// itself if there is none.
+func nextBaudRate(baud uint, up bool) uint {
+	var rates []uint
+	for r := range serial.StandardBaudRates {
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i] < rates[j] })
+
+	if up {
+		for _, r := range rates {
+			if r > baud {
+				return r
+			}
+		}
+	} else {
+		for i := len(rates) - 1; i >= 0; i-- {
+			if rates[i] < baud {
+				return rates[i]
+			}
+		}
+	}
+
+	return baud
+}
+
+// output prints what the port sends until it fails or stop is set. A read
+// deadline wakes it to notice stop.
+func (t *term) output(stop *atomic.Bool) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.p.Read(buf)
+		if n > 0 {
+			if t.hex.Load() {
+				io.WriteString(os.Stdout, strings.ReplaceAll(hex.Dump(buf[:n]), "\n", "\r\n"))
+			} else {
+				os.Stdout.Write(buf[:n])
+			}
+		}
+
+		if stop.Load() {
+			return nil
+		}
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+func main() {
+	hexMode := flag.Bool("hex", false, "start in hex dump mode")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: serialterm [--hex] name[:mode]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var port serial.ModeFlag
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := port.Set(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "serialterm:", err)
+		os.Exit(2)
+	}
+
+	p, err := serial.Open(port.Options())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serialterm:", err)
+		os.Exit(1)
+	}
+
+	// If stdin isn't a terminal, such as when it is a pipe, pass it on as
+	// it is.
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		restore = func() {}
+	}
+
+	t := &term{p: p, out: os.Stderr, dtr: true, rts: true}
+	t.hex.Store(*hexMode)
+	t.status("connected to %s; Ctrl-A ? for help, Ctrl-A q to quit", port.String())
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := t.output(&stop); err != nil {
+			restore()
+			p.Close()
+			if errors.Is(err, serial.ErrDeviceRemoved) {
+				err = fmt.Errorf("%s disappeared", port.Options().PortName)
+			}
+			fmt.Fprintln(os.Stderr, "\r\nserialterm:", err)
+			os.Exit(1)
+		}
+	}()
+
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			quit, werr := t.input(buf[:n])
+			if werr != nil {
+				t.status("%v", werr)
+			}
+
+			if quit {
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	stop.Store(true)
+	p.SetReadDeadline(time.Now())
+	wg.Wait()
+
+	restore()
+	p.Close()
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+func TestNextBaudRate(t *testing.T) {
+	cases := []struct {
+		baud uint
+		up   bool
+		want uint
+	}{
+		{9600, true, 14400},
+		{9600, false, 7200},
+		{100000, true, 115200},
+		{100000, false, 76800},
+		{230400, true, 230400},
+		{50, false, 50},
+	}
+
+	for _, c := range cases {
+		if got := nextBaudRate(c.baud, c.up); got != c.want {
+			t.Errorf("nextBaudRate(%d, %v): got %d, want %d", c.baud, c.up, got, c.want)
+		}
+	}
+}
+
+func TestInput(t *testing.T) {
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var out bytes.Buffer
+	term := &term{p: a, out: &out, dtr: true, rts: true}
+
+	// Keystrokes are sent, and Ctrl-A Ctrl-A sends Ctrl-A.
+	if quit, err := term.input([]byte("ab\x01\x01c")); quit || err != nil {
+		t.Fatalf("input: %v, %v", quit, err)
+	}
+
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(b, got); err != nil || string(got) != "ab\x01c" {
+		t.Errorf("peer read %q, %v", got, err)
+	}
+
+	// Ctrl-A t drops DTR, which the peer sees as carrier, even when the
+	// command is split across reads.
+	term.input([]byte("\x01"))
+	term.input([]byte("t"))
+	if dcd, err := b.CarrierDetect(); dcd || err != nil {
+		t.Errorf("CarrierDetect after toggling DTR: %v, %v", dcd, err)
+	}
+
+	// Ctrl-A u raises the baud rate.
+	before, _ := a.BaudRate()
+	term.input([]byte("\x01u"))
+	if baud, _ := a.BaudRate(); baud != nextBaudRate(before, true) {
+		t.Errorf("baud rate: got %d, want %d", baud, nextBaudRate(before, true))
+	}
+
+	// Ctrl-A h toggles hex dump mode.
+	term.input([]byte("\x01h"))
+	if !term.hex.Load() {
+		t.Error("hex dump mode not enabled")
+	}
+
+	// Ctrl-A q quits, after sending what was typed before it.
+	if quit, err := term.input([]byte("z\x01q")); !quit || err != nil {
+		t.Errorf("input: %v, %v", quit, err)
+	}
+
+	if _, err := io.ReadFull(b, got[:1]); err != nil || got[0] != 'z' {
+		t.Errorf("peer read %q, %v", got[:1], err)
+	}
+
+	if !bytes.Contains(out.Bytes(), []byte("DTR off")) {
+		t.Errorf("messages: %q", out.String())
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !freebsd && !linux && !solaris && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw isn't implemented here; keystrokes are sent a line at a time.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw mode not supported")
+}
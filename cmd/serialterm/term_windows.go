@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw puts the console f into raw mode, so that each keystroke is
+// passed on as it is typed, returning a function that restores it. The
+// console is also made to interpret the escape sequences that the device
+// sends, as a Unix terminal would.
+func makeRaw(f *os.File) (func(), error) {
+	in := windows.Handle(f.Fd())
+	var inMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT|windows.ENABLE_PROCESSED_INPUT) |
+		windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+
+	out := windows.Handle(os.Stdout.Fd())
+	var outMode uint32
+	outOK := windows.GetConsoleMode(out, &outMode) == nil
+	if outOK {
+		windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		if outOK {
+			windows.SetConsoleMode(out, outMode)
+		}
+	}, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BridgeStats counts the traffic passed by Bridge. The counters are updated
+// as data flows, so another goroutine may poll them, using the sync/atomic
+// package to read them, for periodic throughput reports.
+type BridgeStats struct {
+	// Bytes copied from the port to the other side, and from the other side
+	// to the port.
+	FromPort int64
+	ToPort   int64
+
+	// Errors that stopped the bridge, by the side they came from. Bridge
+	// stops at the first error, so at most one of these is non-zero; it
+	// tells which side failed.
+	PortErrors  int64
+	OtherErrors int64
+}
+
+// BridgeOptions configures BridgeContext.
+type BridgeOptions struct {
+	// If not nil, counters updated as data flows, with a as the port and b
+	// as the other side: FromPort counts the bytes copied from a to b, and
+	// PortErrors errors from a.
+	Stats *BridgeStats
+
+	// If not nil, writers that are given a copy of the data copied from a
+	// to b and from b to a, for sniffing the protocol. Each chunk is written
+	// to the tap before it is passed on, so taps should be quick. Errors
+	// writing to them are ignored.
+	TapAToB io.Writer
+	TapBToA io.Writer
+}
+
+// Bridge copies data in both directions between port and other, for example
+// a net.Conn, until either side fails or is closed. If stats is not nil its
+// counters are updated as it goes.
+//
+// Bridge returns nil if it stopped because other reported io.EOF or the port
+// was closed, and otherwise the first error encountered. Reads from the port
+// that return io.EOF because of an inter-character timeout are not
+// failures. When one direction stops, Bridge interrupts the other by setting
+// a read deadline (or, if other has no SetReadDeadline method but is an
+// io.Closer, by closing it), and clears the deadlines again before
+// returning, so that the port can go on being used.
+func Bridge(port Port, other io.ReadWriter, stats *BridgeStats) error {
+	if stats == nil {
+		stats = new(BridgeStats)
+	}
+
+	deadliner, _ := other.(interface{ SetReadDeadline(time.Time) error })
+
+	var once sync.Once
+	var result error
+
+	// finish records how the first direction to stop ended and wakes up the
+	// other; the error that causes it to stop in turn is ignored.
+	finish := func(err error, failures *int64) {
+		once.Do(func() {
+			if err != io.EOF && err != ErrPortClosed {
+				result = err
+				atomic.AddInt64(failures, 1)
+			}
+
+			port.SetReadDeadline(time.Now())
+			if deadliner != nil {
+				deadliner.SetReadDeadline(time.Now())
+			} else if c, ok := other.(io.Closer); ok {
+				c.Close()
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		readErr, writeErr := pump(other, port, nil, &stats.FromPort, true)
+		if writeErr != nil {
+			finish(writeErr, &stats.OtherErrors)
+		} else {
+			finish(readErr, &stats.PortErrors)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		readErr, writeErr := pump(port, other, nil, &stats.ToPort, false)
+		if writeErr != nil {
+			finish(writeErr, &stats.PortErrors)
+		} else {
+			finish(readErr, &stats.OtherErrors)
+		}
+	}()
+
+	wg.Wait()
+
+	port.SetReadDeadline(time.Time{})
+	if deadliner != nil {
+		deadliner.SetReadDeadline(time.Time{})
+	}
+
+	return result
+}
+
+// BridgeContext is like Bridge, for splicing two ports together, such as a
+// real port and a pty or a TCPPort for sniffing or adapting a protocol. It
+// copies data both ways until ctx is done, returning ctx.Err(), or either
+// port fails, returning the first error, or is closed, returning nil.
+// Reads of either port that return io.EOF because of an inter-character
+// timeout are not failures.
+//
+// When one direction stops, BridgeContext interrupts the other straight
+// away by setting both ports' read and write deadlines, so that an error
+// such as ErrDeviceRemoved from one port is returned promptly even while
+// the other is idle or blocked by flow control. The deadlines are cleared
+// again before returning, so that the ports can go on being used.
+func BridgeContext(ctx context.Context, a, b Port, opts BridgeOptions) error {
+	stats := opts.Stats
+	if stats == nil {
+		stats = new(BridgeStats)
+	}
+
+	var once sync.Once
+	var result error
+
+	// finish records how the first direction to stop ended and wakes up the
+	// other; the error that causes it to stop in turn is ignored.
+	finish := func(err error, failures *int64) {
+		once.Do(func() {
+			if err != io.EOF && err != ErrPortClosed {
+				result = err
+				if failures != nil {
+					atomic.AddInt64(failures, 1)
+				}
+			}
+
+			now := time.Now()
+			for _, p := range []Port{a, b} {
+				p.SetReadDeadline(now)
+				p.SetWriteDeadline(now)
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		readErr, writeErr := pump(b, a, opts.TapAToB, &stats.FromPort, true)
+		if writeErr != nil {
+			finish(writeErr, &stats.OtherErrors)
+		} else {
+			finish(readErr, &stats.PortErrors)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		readErr, writeErr := pump(a, b, opts.TapBToA, &stats.ToPort, true)
+		if writeErr != nil {
+			finish(writeErr, &stats.PortErrors)
+		} else {
+			finish(readErr, &stats.OtherErrors)
+		}
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			finish(ctx.Err(), nil)
+		case <-stopped:
+		}
+	}()
+
+	wg.Wait()
+	close(stopped)
+
+	for _, p := range []Port{a, b} {
+		p.SetReadDeadline(time.Time{})
+		p.SetWriteDeadline(time.Time{})
+	}
+
+	return result
+}
+
+// pump copies from src to dst until reading or writing fails, adding the
+// bytes written to *count and giving tap, if not nil, a copy of what is
+// read. If skipEOF is set, io.EOF from src is taken to be a Port's
+// inter-character timeout and ignored.
+func pump(dst io.Writer, src io.Reader, tap io.Writer, count *int64, skipEOF bool) (readErr, writeErr error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if tap != nil {
+				tap.Write(buf[:n])
+			}
+
+			m, werr := dst.Write(buf[:n])
+			atomic.AddInt64(count, int64(m))
+			if werr != nil {
+				return nil, werr
+			}
+		}
+
+		if err == io.EOF && skipEOF {
+			continue
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+}
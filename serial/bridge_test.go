@@ -0,0 +1,132 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBridgeContext(t *testing.T) {
+	a, aPeer := Pipe()
+	b, bPeer := Pipe()
+	defer aPeer.Close()
+	defer bPeer.Close()
+
+	var stats BridgeStats
+	var tapAToB, tapBToA bytes.Buffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- BridgeContext(ctx, a, b, BridgeOptions{
+			Stats:   &stats,
+			TapAToB: &tapAToB,
+			TapBToA: &tapBToA,
+		})
+	}()
+
+	aPeer.Write([]byte("hello"))
+	readFull(t, bPeer, []byte("hello"))
+
+	bPeer.Write([]byte("hi"))
+	readFull(t, aPeer, []byte("hi"))
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("BridgeContext: got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BridgeContext didn't return")
+	}
+
+	if stats.FromPort != 5 || stats.ToPort != 2 || stats.PortErrors != 0 || stats.OtherErrors != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	if tapAToB.String() != "hello" || tapBToA.String() != "hi" {
+		t.Errorf("taps: got %q and %q", tapAToB.String(), tapBToA.String())
+	}
+
+	// The deadlines were cleared, so the ports go on working.
+	aPeer.Write([]byte("x"))
+	readFull(t, a, []byte("x"))
+}
+
+func TestBridgeContextDeviceRemoved(t *testing.T) {
+	a, aPeer := Pipe()
+	b, bPeer := Pipe()
+	defer aPeer.Close()
+	defer bPeer.Close()
+
+	var stats BridgeStats
+	done := make(chan error, 1)
+	go func() {
+		done <- BridgeContext(context.Background(), a, b, BridgeOptions{Stats: &stats})
+	}()
+
+	// The device behind a goes away while b is idle. A Read already waiting
+	// fails once the data wakes it up and it reads again.
+	removed := markError(errors.New("device gone"), ErrDeviceRemoved)
+	a.InjectErrors(func(op string) error {
+		if op == "Read" {
+			return removed
+		}
+		return nil
+	})
+	aPeer.Write([]byte("data"))
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrDeviceRemoved) {
+			t.Errorf("BridgeContext: got %v, want ErrDeviceRemoved", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BridgeContext didn't return")
+	}
+
+	if stats.PortErrors != 1 || stats.OtherErrors != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestBridgeContextPeerClosed(t *testing.T) {
+	a, aPeer := Pipe()
+	b, bPeer := Pipe()
+	defer bPeer.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- BridgeContext(context.Background(), a, b, BridgeOptions{})
+	}()
+
+	// The far end of a going away is a failure, not an endless run of
+	// timeouts.
+	aPeer.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrDeviceRemoved) {
+			t.Errorf("BridgeContext: got %v, want ErrDeviceRemoved", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BridgeContext didn't return")
+	}
+}
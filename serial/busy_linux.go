@@ -0,0 +1,69 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portHolders returns the processes that have the device at path open, as
+// "name (pid N)", by looking through /proc. Processes whose descriptors we
+// aren't allowed to see are left out.
+func portHolders(path string) []string {
+	dev, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil
+	}
+
+	pids, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var holders []string
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid.Name()); err != nil {
+			continue
+		}
+
+		if holdsFile(filepath.Join("/proc", pid.Name()), dev) {
+			comm, _ := os.ReadFile(filepath.Join("/proc", pid.Name(), "comm"))
+			holders = append(holders, fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(comm)), pid.Name()))
+		}
+	}
+
+	return holders
+}
+
+// holdsFile reports whether the process whose /proc directory is dir has
+// a descriptor open on path.
+func holdsFile(dir, path string) bool {
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return false
+	}
+
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(dir, "fd", fd.Name())); err == nil && target == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,51 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPortHolders(t *testing.T) {
+	_, name := openPty(t)
+
+	slave, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	self := fmt.Sprintf("(pid %d)", os.Getpid())
+
+	holders := portHolders(name)
+	if len(holders) != 1 || !strings.HasSuffix(holders[0], self) {
+		t.Errorf("portHolders: got %q, want this process", holders)
+	}
+
+	err = openError(&os.PathError{Op: "open", Path: name, Err: syscall.EBUSY})
+	if !errors.Is(err, ErrPortBusy) || !strings.Contains(err.Error(), "in use by ") {
+		t.Errorf("openError: got %v", err)
+	}
+
+	slave.Close()
+	if holders := portHolders(name); len(holders) != 0 {
+		t.Errorf("portHolders after close: got %q", holders)
+	}
+}
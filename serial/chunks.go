@@ -0,0 +1,233 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// signal lets calls block until something they are waiting for changes.
+// Everything guarded by mu should call notify when it changes. The zero
+// value is ready to use.
+type signal struct {
+	mu sync.Mutex
+
+	// Closed when anything changes, and made afresh by the next wait.
+	changed chan struct{}
+}
+
+// notify wakes the calls waiting in wait. The caller must hold mu.
+func (s *signal) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// wait releases mu until something changes or the deadline passes,
+// returning os.ErrDeadlineExceeded in the latter case. The zero deadline
+// never passes. The caller must hold mu.
+func (s *signal) wait(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+
+	changed := s.changed
+	s.mu.Unlock()
+	defer s.mu.Lock()
+
+	select {
+	case <-changed:
+		return nil
+
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+type pipeChunk struct {
+	data []byte
+	t    time.Time
+}
+
+// chunkQueue holds data received and not yet read, with the time each
+// chunk arrived.
+type chunkQueue struct {
+	chunks []pipeChunk
+	last   time.Time
+}
+
+// push adds data that arrived at t.
+func (q *chunkQueue) push(data []byte, t time.Time) {
+	q.chunks = append(q.chunks, pipeChunk{data, t})
+	q.last = t
+}
+
+// buffered returns the number of bytes waiting to be read.
+func (q *chunkQueue) buffered() int {
+	n := 0
+	for _, c := range q.chunks {
+		n += len(c.data)
+	}
+
+	return n
+}
+
+// take moves buffered data into b, returning how much and when the first
+// of it arrived.
+func (q *chunkQueue) take(b []byte) (int, time.Time) {
+	if len(q.chunks) == 0 {
+		return 0, time.Time{}
+	}
+
+	first := q.chunks[0].t
+
+	n := 0
+	for n < len(b) && len(q.chunks) > 0 {
+		c := &q.chunks[0]
+		m := copy(b[n:], c.data)
+		n += m
+
+		if c.data = c.data[m:]; len(c.data) == 0 {
+			q.chunks = q.chunks[1:]
+		}
+	}
+
+	return n, first
+}
+
+// errConnClosed is returned by Read once the far end has closed the
+// connection, so that it isn't mistaken for io.EOF from a timeout.
+var errConnClosed = markError(errors.New("connection closed"), ErrDeviceRemoved)
+
+// chunkPort is the receiving side of a port whose data arrives on a
+// goroutine of its own, reading from a connection and calling deliver.
+// Everything but mu is guarded by mu.
+type chunkPort struct {
+	signal
+	chunkQueue
+
+	closed  bool
+	connErr error // why the connection stopped delivering data
+
+	readDeadline time.Time
+}
+
+// deliver makes data available to Read.
+func (p *chunkPort) deliver(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.push(data, time.Now())
+	p.notify()
+}
+
+// fail records that the connection has stopped delivering data because of
+// err. The caller must hold mu.
+func (p *chunkPort) fail(err error) {
+	if err == io.EOF {
+		err = errConnClosed
+	}
+
+	p.connErr = err
+	p.notify()
+}
+
+// readChunks implements Read for the port called name, honouring
+// MinimumReadSize, InterCharacterTimeout and OverallReadTimeout as Open's
+// ports do. The caller must hold mu.
+func (p *chunkPort) readChunks(name string, b []byte, options OpenOptions) (int, time.Time, error) {
+	if len(b) == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	start := time.Now()
+	want := 1
+	if min := int(options.MinimumReadSize); min > want {
+		want = min
+		if want > len(b) {
+			want = len(b)
+		}
+	}
+
+	ict := time.Duration(options.InterCharacterTimeout) * time.Millisecond
+
+	for {
+		if p.closed {
+			return 0, time.Time{}, ErrPortClosed
+		}
+
+		avail := p.buffered()
+		if avail >= want || (avail > 0 && p.connErr != nil) {
+			n, first := p.take(b)
+			return n, first, nil
+		}
+
+		if p.connErr != nil {
+			return 0, time.Time{}, newPortError(name, "read", p.connErr)
+		}
+
+		// The earliest of the deadline and the timeouts that apply.
+		deadline := p.readDeadline
+		timeout := func(t time.Time) {
+			if deadline.IsZero() || t.Before(deadline) {
+				deadline = t
+			}
+		}
+
+		if options.OverallReadTimeout > 0 {
+			timeout(start.Add(options.OverallReadTimeout))
+		}
+
+		if ict > 0 {
+			if options.MinimumReadSize == 0 {
+				timeout(start.Add(ict))
+			} else if avail > 0 {
+				timeout(p.last.Add(ict))
+			}
+		}
+
+		if p.wait(deadline) == nil {
+			continue
+		}
+
+		n, first := p.take(b)
+		if !p.readDeadline.IsZero() && !time.Now().Before(p.readDeadline) {
+			return n, first, newPortError(name, "read", os.ErrDeadlineExceeded)
+		}
+
+		if n == 0 {
+			return 0, time.Time{}, io.EOF
+		}
+
+		return n, first, nil
+	}
+}
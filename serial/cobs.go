@@ -0,0 +1,123 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+// CobsReader reads packets framed with Consistent Overhead Byte Stuffing,
+// which encodes each packet without any zero bytes so that a zero can end
+// it. The encoding costs at most one byte in 254.
+type CobsReader struct {
+	f      *FrameReader
+	maxLen int
+}
+
+// cobsMaxEncoded returns the longest encoding of a packet of n bytes.
+func cobsMaxEncoded(n int) int {
+	return n + n/254 + 1
+}
+
+// NewCobsReader returns a CobsReader that reads from p, returning packets
+// of at most maxLen bytes. A maxLen of zero or less means no limit.
+func NewCobsReader(p Port, maxLen int) *CobsReader {
+	frameLen := 0
+	if maxLen > 0 {
+		frameLen = cobsMaxEncoded(maxLen)
+	}
+
+	return &CobsReader{f: NewFrameReader(p, 0, frameLen), maxLen: maxLen}
+}
+
+// ReadFrame returns the next packet, decoded. Zero bytes with nothing
+// between them are skipped, so an empty packet must be sent as its
+// encoding, a single 0x01. A packet split across several reads from the
+// port is put back together, and errors are returned as by
+// FrameReader.ReadFrame. A packet whose encoding runs past the end of the
+// frame is discarded with ErrCorruptFrame, and one longer than maxLen with
+// ErrFrameTooLong.
+func (r *CobsReader) ReadFrame() ([]byte, error) {
+	frame, err := r.f.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode in place; the result is always shorter. Each block is a code
+	// byte followed by code-1 bytes of data, and then, unless the code is
+	// 0xFF or it is the last block, by an implicit zero.
+	n := 0
+	for i := 0; i < len(frame); {
+		code := int(frame[i])
+		i++
+
+		if i+code-1 > len(frame) {
+			return nil, ErrCorruptFrame
+		}
+
+		n += copy(frame[n:], frame[i:i+code-1])
+		i += code - 1
+
+		if code < 0xFF && i < len(frame) {
+			frame[n] = 0
+			n++
+		}
+	}
+
+	if r.maxLen > 0 && n > r.maxLen {
+		return nil, ErrFrameTooLong
+	}
+
+	return frame[:n], nil
+}
+
+// CobsWriter writes packets framed with COBS, the counterpart of
+// CobsReader.
+type CobsWriter struct {
+	p Port
+}
+
+// NewCobsWriter returns a CobsWriter that writes to p.
+func NewCobsWriter(p Port) *CobsWriter {
+	return &CobsWriter{p: p}
+}
+
+// WriteFrame writes b as one packet, encoded and followed by a zero byte.
+// The frame is written to the port with a single Write.
+func (w *CobsWriter) WriteFrame(b []byte) error {
+	frame := make([]byte, 1, cobsMaxEncoded(len(b))+1)
+
+	// The index of the current block's code byte, filled in once the block
+	// ends at a zero, at 254 bytes of data with more to follow, or at the
+	// end of b.
+	code := 0
+	for _, c := range b {
+		if len(frame)-code == 0xFF {
+			frame[code] = 0xFF
+			code = len(frame)
+			frame = append(frame, 0)
+		}
+
+		if c == 0 {
+			frame[code] = byte(len(frame) - code)
+			code = len(frame)
+			frame = append(frame, 0)
+			continue
+		}
+
+		frame = append(frame, c)
+	}
+	frame[code] = byte(len(frame) - code)
+	frame = append(frame, 0)
+
+	_, err := w.p.Write(frame)
+	return err
+}
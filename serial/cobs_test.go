@@ -0,0 +1,109 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// seq returns the bytes from first to last inclusive.
+func seq(first, last int) []byte {
+	var b []byte
+	for c := first; c <= last; c++ {
+		b = append(b, byte(c))
+	}
+	return b
+}
+
+func TestCobs(t *testing.T) {
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	cases := []struct {
+		packet, frame []byte
+	}{
+		{[]byte{}, []byte{0x01, 0x00}},
+		{[]byte{0x00}, []byte{0x01, 0x01, 0x00}},
+		{[]byte{0x00, 0x00}, []byte{0x01, 0x01, 0x01, 0x00}},
+		{[]byte{0x11, 0x22, 0x00, 0x33}, []byte{0x03, 0x11, 0x22, 0x02, 0x33, 0x00}},
+		{[]byte{0x11, 0x00, 0x00, 0x00}, []byte{0x02, 0x11, 0x01, 0x01, 0x01, 0x00}},
+		{seq(0x01, 0xFE), join([]byte{0xFF}, seq(0x01, 0xFE), []byte{0x00})},
+		{seq(0x00, 0xFE), join([]byte{0x01, 0xFF}, seq(0x01, 0xFE), []byte{0x00})},
+		{seq(0x01, 0xFF), join([]byte{0xFF}, seq(0x01, 0xFE), []byte{0x02, 0xFF, 0x00})},
+	}
+
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	w := NewCobsWriter(a)
+	r := NewCobsReader(b, 0)
+	for _, c := range cases {
+		if err := w.WriteFrame(c.packet); err != nil {
+			t.Fatal(err)
+		}
+
+		frame := make([]byte, len(c.frame))
+		if _, err := io.ReadFull(b, frame); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(frame, c.frame) {
+			t.Errorf("%x: expected frame %x, got %x", c.packet, c.frame, frame)
+		}
+
+		a.Write(frame)
+		got, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, c.packet) {
+			t.Errorf("expected %x, got %x", c.packet, got)
+		}
+	}
+}
+
+func TestCobsReader(t *testing.T) {
+	// Arriving a byte at a time, with a block running past the end of its
+	// frame and an overlong packet.
+	p := framePort("\x00\x03ab\x02c\x00\x05ab\x00\x06toolo\x00\x00\x03ok\x00")
+	defer p.Close()
+	r := NewCobsReader(p, 4)
+
+	expected := []struct {
+		packet string
+		err    error
+	}{
+		{"ab\x00c", nil},
+		{"", ErrCorruptFrame},
+		{"", ErrFrameTooLong},
+		{"ok", nil},
+		{"", ErrDeviceRemoved},
+	}
+
+	for _, want := range expected {
+		got, err := r.ReadFrame()
+		if !errors.Is(err, want.err) {
+			t.Fatalf("expected error %v, got %v", want.err, err)
+		}
+
+		if string(got) != want.packet {
+			t.Errorf("expected %q, got %q", want.packet, got)
+		}
+	}
+}
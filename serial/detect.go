@@ -0,0 +1,182 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// DetectBaudRate works out the baud rate of a device that is already
+// sending, by listening to it at each of the candidate rates for the probe
+// period. The rate that received data with the fewest framing, parity and
+// break errors wins. Where the driver doesn't count errors (on OS X and
+// Solaris, and with some USB adapters) or two rates tie, the one whose
+// data looks most like text wins, and after that the earlier candidate. It
+// returns an error if nothing is received at any of the rates.
+//
+// The port is opened 8N1 without flow control. The device has to keep
+// sending throughout, which a probe of a second or so per rate usually
+// catches; a silent device can't be detected.
+func DetectBaudRate(portName string, candidates []uint, probe time.Duration) (uint, error) {
+	return DetectBaudRateFunc(portName, candidates, probe, nil)
+}
+
+// DetectBaudRateFunc is like DetectBaudRate, but decides with valid, which
+// is called with the data received at each rate in turn and should report
+// whether it is what the device is expected to send. The first rate whose
+// data valid accepts is returned. If valid is nil, DetectBaudRateFunc
+// behaves like DetectBaudRate.
+func DetectBaudRateFunc(
+	portName string,
+	candidates []uint,
+	probe time.Duration,
+	valid func(data []byte) bool) (uint, error) {
+	if len(candidates) == 0 {
+		return 0, errors.New("serial: no candidate baud rates")
+	}
+
+	port, err := Open(OpenOptions{
+		PortName:              portName,
+		BaudRate:              candidates[0],
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer port.Close()
+
+	return detectBaudRate(port, candidates, probe, valid)
+}
+
+// baudScore records how well the data received at a rate looked.
+type baudScore struct {
+	errors uint64  // line errors, or zero if the driver doesn't count them
+	text   float64 // the fraction of bytes that are printable ASCII
+}
+
+// better reports whether s beats t.
+func (s baudScore) better(t baudScore) bool {
+	if s.errors != t.errors {
+		return s.errors < t.errors
+	}
+
+	return s.text > t.text
+}
+
+// detectBaudRate implements DetectBaudRateFunc for an open port.
+func detectBaudRate(port Port, candidates []uint, probe time.Duration, valid func([]byte) bool) (uint, error) {
+	var best uint
+	var bestScore baudScore
+
+	for _, baud := range candidates {
+		data, errs, err := listen(port, baud, probe)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if valid != nil {
+			if valid(data) {
+				return baud, nil
+			}
+			continue
+		}
+
+		score := baudScore{errors: errs, text: textFraction(data)}
+		if best == 0 || score.better(bestScore) {
+			best, bestScore = baud, score
+		}
+	}
+
+	if best == 0 {
+		return 0, errors.New("serial: no data recognised at any of the candidate baud rates")
+	}
+
+	return best, nil
+}
+
+// listen switches port to baud and returns what it receives during the probe
+// period, along with the number of line errors seen, if the driver counts
+// them. Anything received before the switch is discarded first.
+func listen(port Port, baud uint, probe time.Duration) ([]byte, uint64, error) {
+	if err := port.SetBaudRate(baud); err != nil {
+		return nil, 0, err
+	}
+
+	if err := discardInput(port); err != nil {
+		return nil, 0, err
+	}
+
+	// Many drivers, not just those on OS X and Solaris, don't count errors.
+	// Any other problem with the port will show up in Read.
+	counted := port.ResetErrorCounters() == nil
+
+	var data []byte
+	buf := make([]byte, 256)
+	for deadline := time.Now().Add(probe); time.Now().Before(deadline); {
+		n, err := port.Read(buf)
+		data = append(data, buf[:n]...)
+
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+	}
+
+	if !counted {
+		return data, 0, nil
+	}
+
+	c, err := port.ErrorCounters()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, c.Framing + c.Parity + c.Break, nil
+}
+
+// discardInput reads and drops the data waiting on port.
+func discardInput(port Port) error {
+	n, err := port.BytesAvailable()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	if _, err := port.Read(make([]byte, n)); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// textFraction returns the fraction of data that is printable ASCII or
+// common whitespace, which is high for the console output most devices
+// send and low for the garbage seen at the wrong rate.
+func textFraction(data []byte) float64 {
+	var text int
+	for _, c := range data {
+		if c >= 0x20 && c < 0x7f || c == '\r' || c == '\n' || c == '\t' {
+			text++
+		}
+	}
+
+	return float64(text) / float64(len(data))
+}
@@ -0,0 +1,112 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// probePort is a Port that receives data depending on its baud rate. Methods
+// detectBaudRate doesn't use panic.
+type probePort struct {
+	Port
+
+	baud     uint
+	data     map[uint]string
+	errors   map[uint]uint64
+	counting bool
+	sent     bool
+}
+
+func (p *probePort) SetBaudRate(baud uint) error {
+	p.baud, p.sent = baud, false
+	return nil
+}
+
+func (p *probePort) BytesAvailable() (int, error) { return 0, nil }
+
+func (p *probePort) ResetErrorCounters() error {
+	if !p.counting {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+func (p *probePort) ErrorCounters() (ErrorCounters, error) {
+	return ErrorCounters{Framing: p.errors[p.baud]}, nil
+}
+
+func (p *probePort) Read(b []byte) (int, error) {
+	if p.sent || p.data[p.baud] == "" {
+		time.Sleep(time.Millisecond)
+		return 0, io.EOF
+	}
+
+	p.sent = true
+	return copy(b, p.data[p.baud]), nil
+}
+
+func TestDetectBaudRate(t *testing.T) {
+	candidates := []uint{9600, 19200, 57600, 115200}
+	data := map[uint]string{
+		9600:   "\x80\x00\xf8\x80",
+		57600:  "\xe6\x1c\x9e\xfe",
+		115200: "hello, world\r\n",
+	}
+
+	testCases := []struct {
+		name     string
+		counting bool
+		errors   map[uint]uint64
+		valid    func([]byte) bool
+		want     uint
+	}{
+		{name: "text", want: 115200},
+		{
+			name:     "fewest errors",
+			counting: true,
+			errors:   map[uint]uint64{9600: 3, 57600: 0, 115200: 1},
+			want:     57600,
+		},
+		{
+			name:  "validator",
+			valid: func(b []byte) bool { return b[0] == 0xe6 },
+			want:  57600,
+		},
+	}
+
+	for _, tc := range testCases {
+		port := &probePort{data: data, errors: tc.errors, counting: tc.counting}
+
+		got, err := detectBaudRate(port, candidates, 5*time.Millisecond, tc.valid)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetectBaudRateSilence(t *testing.T) {
+	port := &probePort{}
+	if _, err := detectBaudRate(port, []uint{9600, 115200}, 5*time.Millisecond, nil); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"strings"
+)
+
+// ModeFlag is a flag.Value holding the port to open and its mode, so that a
+// tool can take both in one flag:
+//
+//	var port serial.ModeFlag
+//	flag.Var(&port, "serial", "port to use, as `name:mode`")
+//	...
+//	p, err := serial.Open(port.Options())
+//
+// The value is the port name, then a colon and a mode as accepted by
+// ParseMode, such as "/dev/ttyUSB0:115200,8N1" or "COM3:9600". Since the
+// mode follows the last colon, names that contain colons, like those under
+// /dev/serial/by-path, must be given with a mode. Without one the port runs
+// at 115200 8N1. Read blocks until at least one byte has arrived, as with
+// OpenPort.
+type ModeFlag struct {
+	options OpenOptions
+}
+
+// String implements flag.Value, returning the value in the form Set accepts,
+// or "" if it hasn't been set.
+func (f *ModeFlag) String() string {
+	if f == nil || f.options.PortName == "" {
+		return ""
+	}
+
+	return f.options.PortName + ":" + FormatMode(f.options)
+}
+
+// Set implements flag.Value.
+func (f *ModeFlag) Set(s string) error {
+	name, mode, hasMode := s, "", false
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		name, mode, hasMode = s[:i], s[i+1:], true
+	}
+
+	if name == "" {
+		return errors.New("missing port name")
+	}
+
+	options, err := portOptions(name, nil)
+	if err != nil {
+		return err
+	}
+
+	if hasMode {
+		m, err := parseMode(mode)
+		if err != nil {
+			return err
+		}
+
+		options.BaudRate = m.BaudRate
+		options.DataBits = m.DataBits
+		options.ParityMode = m.ParityMode
+		options.StopBits = m.StopBits
+		options.RTSCTSFlowControl = m.RTSCTSFlowControl
+	}
+
+	f.options = options
+	return nil
+}
+
+// Get implements flag.Getter, returning the same as Options.
+func (f *ModeFlag) Get() interface{} {
+	return f.Options()
+}
+
+// Options returns the OpenOptions to open the port with.
+func (f *ModeFlag) Options() OpenOptions {
+	return f.options
+}
@@ -0,0 +1,85 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestModeFlag(t *testing.T) {
+	testCases := []struct {
+		arg  string
+		name string
+		mode string
+	}{
+		{"/dev/ttyUSB0:115200,8N1", "/dev/ttyUSB0", "115200 8N1"},
+		{"COM3:9600/7-E-2", "COM3", "9600 7E2"},
+		{"/dev/ttyACM0", "/dev/ttyACM0", "115200 8N1"},
+		{"/dev/serial/by-path/pci-0000:00:14.0-usb-0:1:1.0-port0:57600,rtscts",
+			"/dev/serial/by-path/pci-0000:00:14.0-usb-0:1:1.0-port0", "57600 8N1, rtscts"},
+	}
+
+	for _, tc := range testCases {
+		var mode ModeFlag
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&mode, "serial", "")
+
+		if err := fs.Parse([]string{"-serial", tc.arg}); err != nil {
+			t.Errorf("%s: %v", tc.arg, err)
+			continue
+		}
+
+		options := fs.Lookup("serial").Value.(flag.Getter).Get().(OpenOptions)
+		if options.PortName != tc.name || FormatMode(options) != tc.mode {
+			t.Errorf("%s: got %q, %q, want %q, %q", tc.arg, options.PortName, FormatMode(options), tc.name, tc.mode)
+		}
+
+		if options.MinimumReadSize != 1 {
+			t.Errorf("%s: MinimumReadSize %d", tc.arg, options.MinimumReadSize)
+		}
+
+		// String must give back something Set accepts.
+		var again ModeFlag
+		if err := again.Set(mode.String()); err != nil || again.String() != mode.String() {
+			t.Errorf("%s: round trip of %q gave %q, %v", tc.arg, mode.String(), again.String(), err)
+		}
+	}
+}
+
+func TestModeFlagErrors(t *testing.T) {
+	testCases := []struct {
+		arg  string
+		want string
+	}{
+		{":9600", `invalid value ":9600" for flag -serial: missing port name`},
+		{"/dev/ttyUSB0:9600,8Q1", `for flag -serial: unknown parity 'Q'`},
+		{"COM1:", `for flag -serial: empty mode`},
+	}
+
+	for _, tc := range testCases {
+		var mode ModeFlag
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Var(&mode, "serial", "")
+
+		err := fs.Parse([]string{"-serial", tc.arg})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.arg, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"errors"
+)
+
+// ErrFrameTooLong is returned by FrameReader.ReadFrame when a frame
+// reaches the maximum length without its delimiter.
+var ErrFrameTooLong = errors.New("serial: frame too long")
+
+// ErrCorruptFrame is returned by SlipReader and CobsReader when a frame
+// isn't validly encoded, as when a byte was lost or garbled on the line.
+var ErrCorruptFrame = errors.New("serial: corrupt frame")
+
+// FrameReader reads frames of bytes ended by a delimiter, such as the ETX
+// of STX/ETX framing or the 0x7E flag of HDLC and the 0xC0 END of SLIP.
+type FrameReader struct {
+	r         *bufio.Reader
+	delimiter byte
+	maxLen    int
+
+	// The part of the current frame received so far. This survives errors,
+	// so that a frame interrupted by a timeout is completed by the next
+	// call.
+	frame []byte
+
+	// Set after ErrFrameTooLong, until the delimiter ending the overlong
+	// frame has been read.
+	discard bool
+}
+
+// NewFrameReader returns a FrameReader that reads from p, returning frames
+// of at most maxLen bytes. A maxLen of zero or less means no limit.
+func NewFrameReader(p Port, delimiter byte, maxLen int) *FrameReader {
+	return &FrameReader{
+		r:         bufio.NewReader(p),
+		delimiter: delimiter,
+		maxLen:    maxLen,
+	}
+}
+
+// ReadFrame returns the next frame, without its delimiter. Empty frames, as
+// between the back-to-back flags that begin and end HDLC and SLIP frames,
+// are skipped. The returned slice is the caller's to keep.
+//
+// If more than maxLen bytes arrive without the delimiter, ReadFrame returns
+// ErrFrameTooLong, and the next call discards the rest of the frame before
+// reading the one after it.
+//
+// Errors from the port are returned as is; in particular a read deadline
+// set with SetReadDeadline makes ReadFrame fail with a timeout error, as
+// does the io.EOF of an expired InterCharacterTimeout. Any partial frame
+// read before the error is kept and returned by a later call once the rest
+// of it has arrived.
+func (f *FrameReader) ReadFrame() ([]byte, error) {
+	for {
+		c, err := f.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if c == f.delimiter {
+			if f.discard {
+				f.discard = false
+				continue
+			}
+
+			if len(f.frame) == 0 {
+				continue
+			}
+
+			frame := f.frame
+			f.frame = nil
+			return frame, nil
+		}
+
+		if f.discard {
+			continue
+		}
+
+		if f.maxLen > 0 && len(f.frame) == f.maxLen {
+			f.frame = f.frame[:0]
+			f.discard = true
+			return nil, ErrFrameTooLong
+		}
+
+		f.frame = append(f.frame, c)
+	}
+}
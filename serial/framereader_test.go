@@ -0,0 +1,108 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// framePort is a Port that reads from a string, one byte at a time.
+func framePort(s string) Port {
+	a, b := Pipe()
+	go func() {
+		for i := range s {
+			a.Write([]byte{s[i]})
+		}
+		a.Close()
+	}()
+	return b
+}
+
+func TestFrameReader(t *testing.T) {
+	p := framePort("\x7eone\x7e\x7etwo\x7e\x7e\x7ethree")
+	defer p.Close()
+	r := NewFrameReader(p, 0x7e, 0)
+
+	for _, want := range []string{"one", "two"} {
+		got, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	if _, err := r.ReadFrame(); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("expected ErrDeviceRemoved, got %v", err)
+	}
+}
+
+func TestFrameReaderMaxLen(t *testing.T) {
+	p := framePort("\x02abcd\x03\x02abcdef\x03\x02ok\x03")
+	defer p.Close()
+	r := NewFrameReader(p, 0x03, 5)
+
+	expected := []struct {
+		frame string
+		err   error
+	}{
+		{"\x02abcd", nil},
+		{"", ErrFrameTooLong},
+		{"\x02ok", nil},
+	}
+
+	for _, want := range expected {
+		got, err := r.ReadFrame()
+		if err != want.err {
+			t.Fatalf("expected error %v, got %v", want.err, err)
+		}
+
+		if string(got) != want.frame {
+			t.Errorf("expected %q, got %q", want.frame, got)
+		}
+	}
+}
+
+func TestFrameReaderDeadline(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+	r := NewFrameReader(b, '\n', 0)
+
+	a.Write([]byte("par"))
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	if _, err := r.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+
+	// The partial frame is kept.
+	b.SetReadDeadline(time.Time{})
+	a.Write([]byte("tial\n"))
+
+	got, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "partial" {
+		t.Errorf("expected %q, got %q", "partial", got)
+	}
+}
@@ -12,21 +12,31 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Integration tests for the serial package.
+// Integration tests for the serial package. These need real hardware, so
+// they are skipped unless the SERIAL_TEST_DEVICE environment variable names
+// the device to use; the rest of the tests run on pseudo-terminals.
 
 package serial
 
 import (
 	"errors"
 	"io"
+	"os"
 )
 
 import "testing"
 import "time"
 
-const (
-	DEVICE = "/dev/tty.usbserial-A8008HlV"
-)
+// testDevice returns the device named by SERIAL_TEST_DEVICE, skipping the
+// test if there isn't one.
+func testDevice(t *testing.T) string {
+	dev := os.Getenv("SERIAL_TEST_DEVICE")
+	if dev == "" {
+		t.Skip("set SERIAL_TEST_DEVICE to run tests that need hardware")
+	}
+
+	return dev
+}
 
 //////////////////////////////////////////////////////
 // Helpers
@@ -67,7 +77,7 @@ func readWithTimeout(r io.Reader, n int) ([]byte, error) {
 func TestIncrementAndEcho(t *testing.T) {
 	// Open the port.
 	var options OpenOptions
-	options.PortName = DEVICE
+	options.PortName = testDevice(t)
 	options.BaudRate = 19200
 	options.DataBits = 8
 	options.StopBits = 1
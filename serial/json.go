@@ -0,0 +1,309 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// jsonOptions is the JSON form of OpenOptions. Enumerations are spelled out
+// and times are Go duration strings, so that configuration files can be
+// edited by hand.
+type jsonOptions struct {
+	PortName              string  `json:"portName"`
+	BaudRate              uint    `json:"baudRate"`
+	DataBits              uint    `json:"dataBits"`
+	StopBits              float64 `json:"stopBits"`
+	Parity                string  `json:"parity"`
+	RTSCTSFlowControl     bool    `json:"rtsctsFlowControl,omitempty"`
+	HangupOnClose         bool    `json:"hangupOnClose,omitempty"`
+	HonorModemStatusLines bool    `json:"honorModemStatusLines,omitempty"`
+	StripHighBit          bool    `json:"stripHighBit,omitempty"`
+	MapCRToNL             bool    `json:"mapCRToNL,omitempty"`
+	MapNLToCR             bool    `json:"mapNLToCR,omitempty"`
+	IgnoreCR              bool    `json:"ignoreCR,omitempty"`
+	UTF8Input             bool    `json:"utf8Input,omitempty"`
+	RawOutput             bool    `json:"rawOutput,omitempty"`
+	InterCharacterTimeout string  `json:"interCharacterTimeout,omitempty"`
+	MinimumReadSize       uint    `json:"minimumReadSize,omitempty"`
+	OverallReadTimeout    string  `json:"overallReadTimeout,omitempty"`
+	ReadBufferSize        uint    `json:"readBufferSize,omitempty"`
+	InputQueueSize        uint    `json:"inputQueueSize,omitempty"`
+	OutputQueueSize       uint    `json:"outputQueueSize,omitempty"`
+	MaxWriteChunk         int     `json:"maxWriteChunk,omitempty"`
+	WriteChunkDelay       string  `json:"writeChunkDelay,omitempty"`
+	OpenTimeout           string  `json:"openTimeout,omitempty"`
+
+	Rs485Enable             bool   `json:"rs485Enable,omitempty"`
+	Rs485RtsHighDuringSend  bool   `json:"rs485RtsHighDuringSend,omitempty"`
+	Rs485RtsHighAfterSend   bool   `json:"rs485RtsHighAfterSend,omitempty"`
+	Rs485RxDuringTx         bool   `json:"rs485RxDuringTx,omitempty"`
+	Rs485DelayRtsBeforeSend string `json:"rs485DelayRtsBeforeSend,omitempty"`
+	Rs485DelayRtsAfterSend  string `json:"rs485DelayRtsAfterSend,omitempty"`
+
+	InitialDTR string `json:"initialDTR,omitempty"`
+	InitialRTS string `json:"initialRTS,omitempty"`
+}
+
+var parityNames = map[ParityMode]string{
+	PARITY_NONE: "none",
+	PARITY_ODD:  "odd",
+	PARITY_EVEN: "even",
+}
+
+var lineStateNames = map[LineState]string{
+	LINE_LEAVE:    "leave",
+	LINE_ASSERT:   "assert",
+	LINE_DEASSERT: "deassert",
+}
+
+// MarshalJSON implements json.Marshaler. Parity is written as "none", "odd"
+// or "even", InitialDTR and InitialRTS as "leave", "assert" or "deassert",
+// and OverallReadTimeout, WriteChunkDelay, OpenTimeout, InterCharacterTimeout
+// and the RS485 delays as duration strings such as "100ms". Fields left at
+// their zero value are omitted, apart from the port name and mode. RawConfig,
+// RawDCB, Hooks and Logger are not included.
+func (o OpenOptions) MarshalJSON() ([]byte, error) {
+	parity, ok := parityNames[o.ParityMode]
+	if !ok {
+		return nil, fmt.Errorf("serial: invalid ParityMode %d", o.ParityMode)
+	}
+
+	dtr, ok := lineStateNames[o.InitialDTR]
+	if !ok {
+		return nil, fmt.Errorf("serial: invalid InitialDTR %d", o.InitialDTR)
+	}
+
+	rts, ok := lineStateNames[o.InitialRTS]
+	if !ok {
+		return nil, fmt.Errorf("serial: invalid InitialRTS %d", o.InitialRTS)
+	}
+
+	if o.InitialDTR == LINE_LEAVE {
+		dtr = ""
+	}
+
+	if o.InitialRTS == LINE_LEAVE {
+		rts = ""
+	}
+
+	return json.Marshal(jsonOptions{
+		PortName:                o.PortName,
+		BaudRate:                o.BaudRate,
+		DataBits:                o.DataBits,
+		StopBits:                float64(o.StopBits),
+		Parity:                  parity,
+		RTSCTSFlowControl:       o.RTSCTSFlowControl,
+		HangupOnClose:           o.HangupOnClose,
+		HonorModemStatusLines:   o.HonorModemStatusLines,
+		StripHighBit:            o.StripHighBit,
+		MapCRToNL:               o.MapCRToNL,
+		MapNLToCR:               o.MapNLToCR,
+		IgnoreCR:                o.IgnoreCR,
+		UTF8Input:               o.UTF8Input,
+		RawOutput:               o.RawOutput,
+		InterCharacterTimeout:   formatMillis(int64(o.InterCharacterTimeout)),
+		MinimumReadSize:         o.MinimumReadSize,
+		OverallReadTimeout:      formatDuration(o.OverallReadTimeout),
+		ReadBufferSize:          o.ReadBufferSize,
+		InputQueueSize:          o.InputQueueSize,
+		OutputQueueSize:         o.OutputQueueSize,
+		MaxWriteChunk:           o.MaxWriteChunk,
+		WriteChunkDelay:         formatDuration(o.WriteChunkDelay),
+		OpenTimeout:             formatDuration(o.OpenTimeout),
+		Rs485Enable:             o.Rs485Enable,
+		Rs485RtsHighDuringSend:  o.Rs485RtsHighDuringSend,
+		Rs485RtsHighAfterSend:   o.Rs485RtsHighAfterSend,
+		Rs485RxDuringTx:         o.Rs485RxDuringTx,
+		Rs485DelayRtsBeforeSend: formatMillis(int64(o.Rs485DelayRtsBeforeSend)),
+		Rs485DelayRtsAfterSend:  formatMillis(int64(o.Rs485DelayRtsAfterSend)),
+		InitialDTR:              dtr,
+		InitialRTS:              rts,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting what MarshalJSON
+// produces. Unknown fields are rejected, as are values that OpenOptions
+// can't represent, such as 1.5 stop bits. Fields not present are set to
+// their zero value; RawConfig, RawDCB, Hooks and Logger are
+// cleared.
+func (o *OpenOptions) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var j jsonOptions
+	if err := dec.Decode(&j); err != nil {
+		return fmt.Errorf("serial: %w", err)
+	}
+
+	result := OpenOptions{
+		PortName:               j.PortName,
+		BaudRate:               j.BaudRate,
+		DataBits:               j.DataBits,
+		RTSCTSFlowControl:      j.RTSCTSFlowControl,
+		HangupOnClose:          j.HangupOnClose,
+		HonorModemStatusLines:  j.HonorModemStatusLines,
+		StripHighBit:           j.StripHighBit,
+		MapCRToNL:              j.MapCRToNL,
+		MapNLToCR:              j.MapNLToCR,
+		IgnoreCR:               j.IgnoreCR,
+		UTF8Input:              j.UTF8Input,
+		RawOutput:              j.RawOutput,
+		MinimumReadSize:        j.MinimumReadSize,
+		ReadBufferSize:         j.ReadBufferSize,
+		InputQueueSize:         j.InputQueueSize,
+		OutputQueueSize:        j.OutputQueueSize,
+		MaxWriteChunk:          j.MaxWriteChunk,
+		Rs485Enable:            j.Rs485Enable,
+		Rs485RtsHighDuringSend: j.Rs485RtsHighDuringSend,
+		Rs485RtsHighAfterSend:  j.Rs485RtsHighAfterSend,
+		Rs485RxDuringTx:        j.Rs485RxDuringTx,
+	}
+
+	switch j.StopBits {
+	case 0, 1, 2:
+		result.StopBits = uint(j.StopBits)
+	case 1.5:
+		return fmt.Errorf("serial: stopBits 1.5 is not supported")
+	default:
+		return fmt.Errorf("serial: invalid stopBits %v (want 1 or 2)", j.StopBits)
+	}
+
+	var err error
+	if result.ParityMode, err = parseParity(j.Parity); err != nil {
+		return err
+	}
+
+	if result.InitialDTR, err = parseLineState("initialDTR", j.InitialDTR); err != nil {
+		return err
+	}
+
+	if result.InitialRTS, err = parseLineState("initialRTS", j.InitialRTS); err != nil {
+		return err
+	}
+
+	ict, err := parseMillis("interCharacterTimeout", j.InterCharacterTimeout)
+	if err != nil {
+		return err
+	}
+	result.InterCharacterTimeout = uint(ict)
+
+	if result.OverallReadTimeout, err = parseDuration("overallReadTimeout", j.OverallReadTimeout); err != nil {
+		return err
+	}
+
+	if result.WriteChunkDelay, err = parseDuration("writeChunkDelay", j.WriteChunkDelay); err != nil {
+		return err
+	}
+
+	if result.OpenTimeout, err = parseDuration("openTimeout", j.OpenTimeout); err != nil {
+		return err
+	}
+
+	before, err := parseMillis("rs485DelayRtsBeforeSend", j.Rs485DelayRtsBeforeSend)
+	if err != nil {
+		return err
+	}
+	result.Rs485DelayRtsBeforeSend = int(before)
+
+	after, err := parseMillis("rs485DelayRtsAfterSend", j.Rs485DelayRtsAfterSend)
+	if err != nil {
+		return err
+	}
+	result.Rs485DelayRtsAfterSend = int(after)
+
+	*o = result
+	return nil
+}
+
+// parseParity parses the JSON name of a parity mode. The empty string means
+// PARITY_NONE.
+func parseParity(s string) (ParityMode, error) {
+	if s == "" {
+		return PARITY_NONE, nil
+	}
+
+	for mode, name := range parityNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+
+	return PARITY_NONE, fmt.Errorf(`serial: invalid parity %q (want "none", "odd" or "even")`, s)
+}
+
+// parseLineState parses the JSON name of a line state for field. The empty
+// string means LINE_LEAVE.
+func parseLineState(field, s string) (LineState, error) {
+	if s == "" {
+		return LINE_LEAVE, nil
+	}
+
+	for state, name := range lineStateNames {
+		if name == s {
+			return state, nil
+		}
+	}
+
+	return LINE_LEAVE, fmt.Errorf(`serial: invalid %s %q (want "leave", "assert" or "deassert")`, field, s)
+}
+
+// formatMillis returns a duration string for ms milliseconds, or "" for
+// zero.
+func formatMillis(ms int64) string {
+	return formatDuration(time.Duration(ms) * time.Millisecond)
+}
+
+// formatDuration returns a duration string for d, or "" for zero.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return d.String()
+}
+
+// parseMillis parses a duration string for field into milliseconds. The
+// empty string means zero.
+func parseMillis(field, s string) (int64, error) {
+	d, err := parseDuration(field, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 || d%time.Millisecond != 0 {
+		return 0, fmt.Errorf("serial: invalid %s %q (want a whole number of milliseconds)", field, s)
+	}
+
+	return int64(d / time.Millisecond), nil
+}
+
+// parseDuration parses a duration string for field. The empty string means
+// zero.
+func parseDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("serial: invalid %s %q: %v", field, s, err)
+	}
+
+	return d, nil
+}
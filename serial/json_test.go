@@ -0,0 +1,150 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsJSON(t *testing.T) {
+	options := OpenOptions{
+		PortName:              "/dev/ttyUSB0",
+		BaudRate:              9600,
+		DataBits:              7,
+		StopBits:              2,
+		ParityMode:            PARITY_EVEN,
+		InterCharacterTimeout: 1500,
+		InitialDTR:            LINE_DEASSERT,
+	}
+
+	b, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"portName":"/dev/ttyUSB0","baudRate":9600,"dataBits":7,"stopBits":2,"parity":"even",` +
+		`"interCharacterTimeout":"1.5s","initialDTR":"deassert"}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+// jsonRoundTrip marshals and unmarshals options, failing the test unless the
+// result matches.
+func jsonRoundTrip(t *testing.T, options OpenOptions) {
+	t.Helper()
+
+	b, err := json.Marshal(options)
+	if err != nil {
+		t.Fatalf("Marshal(%+v): %v", options, err)
+	}
+
+	var got OpenOptions
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", b, err)
+	}
+
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", options) {
+		t.Fatalf("round trip of %s: got %+v, want %+v", b, got, options)
+	}
+}
+
+func TestOptionsJSONRoundTrip(t *testing.T) {
+	lineStates := []LineState{LINE_LEAVE, LINE_ASSERT, LINE_DEASSERT}
+
+	for _, dataBits := range []uint{5, 6, 7, 8} {
+		for _, stopBits := range []uint{1, 2} {
+			for _, parity := range []ParityMode{PARITY_NONE, PARITY_ODD, PARITY_EVEN} {
+				for _, rtscts := range []bool{false, true} {
+					for _, dtr := range lineStates {
+						for _, rts := range lineStates {
+							jsonRoundTrip(t, OpenOptions{
+								PortName:              "COM3",
+								BaudRate:              115200,
+								DataBits:              dataBits,
+								StopBits:              stopBits,
+								ParityMode:            parity,
+								RTSCTSFlowControl:     rtscts,
+								InterCharacterTimeout: 100,
+								MinimumReadSize:       1,
+								InitialDTR:            dtr,
+								InitialRTS:            rts,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	jsonRoundTrip(t, OpenOptions{
+		PortName:                "/dev/ttyS1",
+		BaudRate:                250000,
+		DataBits:                8,
+		StopBits:                1,
+		HangupOnClose:           true,
+		HonorModemStatusLines:   true,
+		StripHighBit:            true,
+		MapCRToNL:               true,
+		MapNLToCR:               true,
+		IgnoreCR:                true,
+		UTF8Input:               true,
+		RawOutput:               true,
+		MinimumReadSize:         16,
+		OverallReadTimeout:      1500 * time.Microsecond,
+		ReadBufferSize:          4096,
+		InputQueueSize:          8192,
+		OutputQueueSize:         1024,
+		MaxWriteChunk:           64,
+		WriteChunkDelay:         2 * time.Millisecond,
+		OpenTimeout:             3 * time.Second,
+		Rs485Enable:             true,
+		Rs485RtsHighDuringSend:  true,
+		Rs485RtsHighAfterSend:   true,
+		Rs485RxDuringTx:         true,
+		Rs485DelayRtsBeforeSend: 2,
+		Rs485DelayRtsAfterSend:  1000,
+	})
+}
+
+func TestOptionsJSONErrors(t *testing.T) {
+	testCases := []struct {
+		json string
+		want string
+	}{
+		{`{"baudRate":9600,"parity":"evn"}`, `invalid parity "evn"`},
+		{`{"baudRate":9600,"stopBits":1.5}`, "stopBits 1.5 is not supported"},
+		{`{"baudRate":9600,"stopBits":3}`, "invalid stopBits 3"},
+		{`{"baudRate":9600,"initialRTS":"high"}`, `invalid initialRTS "high"`},
+		{`{"interCharacterTimeout":"soon"}`, `invalid interCharacterTimeout "soon"`},
+		{`{"interCharacterTimeout":"1500us"}`, "whole number of milliseconds"},
+		{`{"interCharacterTimeout":"-1s"}`, "whole number of milliseconds"},
+		{`{"overallReadTimeout":"500"}`, `invalid overallReadTimeout "500"`},
+		{`{"baudRate":9600,"flowControl":true}`, `unknown field "flowControl"`},
+		{`{"baudRate":"fast"}`, "baudRate"},
+	}
+
+	for _, tc := range testCases {
+		var options OpenOptions
+		err := json.Unmarshal([]byte(tc.json), &options)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Unmarshal(%s): got %v, want an error containing %q", tc.json, err, tc.want)
+		}
+	}
+}
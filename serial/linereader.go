@@ -0,0 +1,75 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"io"
+)
+
+// LineReader reads lines of text from a line-oriented device such as a GPS
+// receiver or a modem. Lines may be terminated by "\n", "\r\n" or a lone "\r".
+type LineReader struct {
+	r *bufio.Reader
+
+	// The part of the current line received so far. This survives errors, so
+	// that a line interrupted by a timeout is completed by the next call.
+	line []byte
+
+	// Set when the last line ended in '\r', in which case a following '\n'
+	// belongs to the same terminator.
+	skipLF bool
+}
+
+// NewLineReader returns a LineReader that reads from p, which is usually a
+// Port.
+func NewLineReader(p io.Reader) *LineReader {
+	return &LineReader{r: bufio.NewReader(p)}
+}
+
+// ReadLine returns the next line, without its terminator.
+//
+// Errors from the underlying reader are returned as is; in particular a read
+// deadline set with SetReadDeadline makes ReadLine fail with a timeout error.
+// Any partial line read before the error is kept and returned by a later
+// call once the rest of it has arrived.
+func (l *LineReader) ReadLine() (string, error) {
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if l.skipLF {
+			l.skipLF = false
+			if c == '\n' {
+				continue
+			}
+		}
+
+		switch c {
+		case '\r':
+			l.skipLF = true
+			fallthrough
+
+		case '\n':
+			line := string(l.line)
+			l.line = l.line[:0]
+			return line, nil
+		}
+
+		l.line = append(l.line, c)
+	}
+}
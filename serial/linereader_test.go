@@ -0,0 +1,62 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineReaderTerminators(t *testing.T) {
+	r := NewLineReader(iotest.OneByteReader(strings.NewReader(
+		"one\ntwo\r\nthree\rfour\r\r\nfive\n\nsix")))
+
+	expected := []string{"one", "two", "three", "four", "", "five", ""}
+	for _, want := range expected {
+		got, err := r.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestLineReaderKeepsPartialLineAcrossErrors(t *testing.T) {
+	// TimeoutReader fails the second read, leaving the line half read.
+	r := NewLineReader(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader(
+		"$GPGGA\r\n"))))
+
+	if _, err := r.ReadLine(); err != iotest.ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+
+	got, err := r.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "$GPGGA" {
+		t.Errorf("expected %q, got %q", "$GPGGA", got)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ListPorts returns the names of the serial ports present on the system,
+// sorted with numbers in order (COM2 before COM10), in the form Open
+// expects: /dev/cu.* on OS X, /dev/ttyS*, /dev/ttyUSB*, /dev/ttyACM* and
+// similar on Linux, /dev/term/* and /dev/cua/* on Solaris, and COM1 etc. on
+// Windows.
+//
+// The list is based on the device nodes or registry entries that exist, so
+// a port may be listed that the caller lacks permission to open. On Linux,
+// for example, that usually requires membership of the dialout group.
+// Conversely, ports hidden from the caller by the system are not listed.
+func ListPorts() ([]string, error) {
+	names, err := portNames()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return lessPortName(names[i], names[j])
+	})
+
+	return names, nil
+}
+
+// lessPortName orders names by their text, comparing runs of digits by
+// numeric value, so that COM10 sorts after COM9.
+func lessPortName(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == 0 || db == 0 {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		na := strings.TrimLeft(a[:da], "0")
+		nb := strings.TrimLeft(b[:db], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+
+		a, b = a[da:], b[db:]
+	}
+
+	return len(a) < len(b)
+}
+
+// digitPrefix returns the number of leading ASCII digits in s.
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+
+	return n
+}
+
+// PortInfo describes a serial port found by DetailedPorts.
+type PortInfo struct {
+	// The name to pass to Open.
+	Name string
+
+	// Whether the port belongs to a USB device. It is false for native UARTs
+	// and for ports whose details couldn't be read, in which case the USB
+	// fields below are zero.
+	IsUSB bool
+
+	// The USB vendor and product IDs.
+	VendorID  uint16
+	ProductID uint16
+
+	// The USB device's serial number (iSerial), manufacturer and product
+	// strings. Any of these may be empty if the device doesn't provide them.
+	SerialNumber string
+	Manufacturer string
+	Product      string
+
+	// On OS X, the dial-in device for the same port, such as
+	// /dev/tty.usbserial-A8008HlV. Opening it waits for carrier detect, which
+	// most devices never raise, so Name holds the callout (/dev/cu.*) device
+	// instead.
+	DialinPath string
+
+	// The system's identifier for the device providing the port, if known.
+	// On Linux this is the device's directory in sysfs; on Windows, the
+	// device instance ID, such as USB\VID_2341&PID_0043\75735313733351F0F1E1.
+	DevicePath string
+
+	// On Windows, the name Device Manager shows for the port, such as
+	// "USB Serial Port (COM7)".
+	FriendlyName string
+
+	// On Linux, the link udev made to the port under /dev/serial/by-id, or
+	// failing that /dev/serial/by-path, if any. Unlike Name, it stays the
+	// same when the device is unplugged and plugged back in or the system is
+	// rebooted, and it can be passed to Open.
+	StablePath string
+
+	// On Linux, the kernel driver the port's device is bound to, such as
+	// ftdi_sio, cp210x, cdc_acm or serial8250.
+	Driver string
+
+	// Whether the port is a virtual COM port provided by Bluetooth's serial
+	// port profile. Opening one connects to the remote device, which can take
+	// several seconds and fails if it is out of range. Only detected on
+	// Windows.
+	IsBluetooth bool
+}
+
+// DetailedPorts is like ListPorts, but also reports the USB device behind
+// each port, read from sysfs on Linux, the I/O Registry on OS X and
+// SetupAPI on Windows. Ports whose details can't be read are still
+// listed, with IsUSB false.
+func DetailedPorts() ([]PortInfo, error) {
+	names, err := ListPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	return portDetails(names), nil
+}
+
+// StablePath returns a name for the port that doesn't change when the device
+// is replugged, as described for PortInfo.StablePath. It returns
+// ErrNotSupported on systems other than Linux.
+func StablePath(portName string) (string, error) {
+	return stablePath(portName)
+}
+
+// PreferredPortName returns the name to open the port called name by. On
+// OS X each port has two device nodes: a dial-in node, /dev/tty.*, whose
+// open waits for the modem to raise DCD, and a callout node, /dev/cu.*,
+// which doesn't. Open avoids the wait either way, but the callout node is
+// the one meant for talking to devices, and the dial-in node still leaves
+// programs that open it themselves hanging. PreferredPortName maps a tty.*
+// name to the matching cu.* name if that exists, and returns any other name
+// unchanged.
+func PreferredPortName(name string) string {
+	dir, base := filepath.Split(name)
+	if !strings.HasPrefix(base, "tty.") {
+		return name
+	}
+
+	callout := dir + "cu." + strings.TrimPrefix(base, "tty.")
+	if _, err := os.Stat(callout); err != nil {
+		return name
+	}
+
+	return callout
+}
+
+// Filter selects USB serial ports for FindPorts. Zero fields match anything;
+// a Filter with any field set only matches USB ports.
+type Filter struct {
+	VID uint16
+	PID uint16
+
+	// Matches serial numbers beginning with this string.
+	SerialNumberPrefix string
+
+	// Matches product strings containing this string.
+	ProductSubstring string
+}
+
+// Match reports whether the port satisfies the filter.
+func (f Filter) Match(p PortInfo) bool {
+	if f == (Filter{}) {
+		return true
+	}
+
+	return p.IsUSB &&
+		(f.VID == 0 || p.VendorID == f.VID) &&
+		(f.PID == 0 || p.ProductID == f.PID) &&
+		strings.HasPrefix(p.SerialNumber, f.SerialNumberPrefix) &&
+		strings.Contains(p.Product, f.ProductSubstring)
+}
+
+// String formats the port as its name followed by any USB details, for
+// listing to users.
+func (p PortInfo) String() string {
+	if !p.IsUSB {
+		return p.Name
+	}
+
+	s := fmt.Sprintf("%s [%04x:%04x", p.Name, p.VendorID, p.ProductID)
+	if p.SerialNumber != "" {
+		s += " " + p.SerialNumber
+	}
+	if p.Product != "" {
+		s += " " + p.Product
+	}
+
+	return s + "]"
+}
+
+// FindPorts returns the ports listed by DetailedPorts that match f.
+func FindPorts(f Filter) ([]PortInfo, error) {
+	ports, err := DetailedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterPorts(ports, f), nil
+}
+
+// FindPortByFriendlyName returns the ports listed by DetailedPorts whose
+// FriendlyName contains substring, ignoring case. More than one port may
+// match; none will on systems other than Windows.
+func FindPortByFriendlyName(substring string) ([]PortInfo, error) {
+	ports, err := DetailedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	return matchFriendlyName(ports, substring), nil
+}
+
+func matchFriendlyName(ports []PortInfo, substring string) []PortInfo {
+	substring = strings.ToLower(substring)
+
+	var matches []PortInfo
+	for _, p := range ports {
+		if p.FriendlyName != "" && strings.Contains(strings.ToLower(p.FriendlyName), substring) {
+			matches = append(matches, p)
+		}
+	}
+
+	return matches
+}
+
+func filterPorts(ports []PortInfo, f Filter) []PortInfo {
+	var matches []PortInfo
+	for _, p := range ports {
+		if f.Match(p) {
+			matches = append(matches, p)
+		}
+	}
+
+	return matches
+}
+
+// OpenFirst opens the first port matching f, using options for everything
+// but PortName. If there is no match, the error lists the ports that were
+// found.
+func OpenFirst(f Filter, options OpenOptions) (Port, error) {
+	ports, err := DetailedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := filterPorts(ports, f)
+	if len(matches) == 0 {
+		return nil, noMatchError(ports)
+	}
+
+	options.PortName = matches[0].Name
+	return Open(options)
+}
+
+// OpenBySerialNumber opens the USB port whose serial number is exactly
+// serialNumber, using options for everything but PortName. Unlike the
+// port's name, the serial number stays the same across reboots and
+// replugging. If there is no match, the error lists the serial numbers that
+// were found.
+func OpenBySerialNumber(serialNumber string, options OpenOptions) (Port, error) {
+	ports, err := DetailedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := findSerialNumber(ports, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	options.PortName = p.Name
+	return Open(options)
+}
+
+func findSerialNumber(ports []PortInfo, serialNumber string) (PortInfo, error) {
+	var seen []string
+	for _, p := range ports {
+		if !p.IsUSB || p.SerialNumber == "" {
+			continue
+		}
+
+		if p.SerialNumber == serialNumber {
+			return p, nil
+		}
+
+		seen = append(seen, p.SerialNumber)
+	}
+
+	if len(seen) == 0 {
+		return PortInfo{}, fmt.Errorf("serial: no port with serial number %q; no USB serial numbers found", serialNumber)
+	}
+
+	return PortInfo{}, fmt.Errorf("serial: no port with serial number %q; found %s", serialNumber, strings.Join(seen, ", "))
+}
+
+func noMatchError(ports []PortInfo) error {
+	if len(ports) == 0 {
+		return errors.New("serial: no matching port; no ports found")
+	}
+
+	found := make([]string, len(ports))
+	for i, p := range ports {
+		found[i] = p.String()
+	}
+
+	return fmt.Errorf("serial: no matching port; found %s", strings.Join(found, ", "))
+}
@@ -0,0 +1,275 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The I/O Registry is read with ioreg(8) rather than IOKit, so that the
+// package keeps building without cgo.
+
+package serial
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// portDetails looks the ports up in the I/O Registry. Every port has an
+// IOSerialBSDClient naming its callout and dial-in devices; the USB details
+// come from the USB device above it, so the subtrees below USB devices are
+// searched as well.
+func portDetails(names []string) []PortInfo {
+	found := map[string]PortInfo{}
+	for _, class := range []string{"IOSerialBSDClient", "IOUSBHostDevice"} {
+		if tree, err := ioregTree(class); err == nil {
+			findSerialPorts(tree, nil, found)
+		}
+	}
+
+	infos := make([]PortInfo, len(names))
+	for i, name := range names {
+		info, ok := found[name]
+		if !ok {
+			info = PortInfo{Name: name}
+		}
+		infos[i] = info
+	}
+
+	return infos
+}
+
+// ioregTree returns the I/O Registry subtrees rooted at objects of the given
+// class, with their properties.
+func ioregTree(class string) (interface{}, error) {
+	out, err := exec.Command("ioreg", "-a", "-l", "-r", "-c", class).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePlist(bytes.NewReader(out))
+}
+
+// findSerialPorts walks an I/O Registry tree as printed by ioreg -a,
+// recording every serial device in found by its callout device. usb is the
+// nearest USB device above node, if any. Details already in found are kept.
+func findSerialPorts(node interface{}, usb map[string]interface{}, found map[string]PortInfo) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			findSerialPorts(child, usb, found)
+		}
+
+	case map[string]interface{}:
+		if _, ok := n["idVendor"]; ok {
+			usb = n
+		}
+
+		if path, ok := n["IOCalloutDevice"].(string); ok {
+			info := found[path]
+			info.Name = path
+
+			if dialin, ok := n["IODialinDevice"].(string); ok {
+				info.DialinPath = dialin
+			}
+
+			if usb != nil {
+				info.IsUSB = true
+				info.VendorID = uint16(plistInt(usb["idVendor"]))
+				info.ProductID = uint16(plistInt(usb["idProduct"]))
+				info.SerialNumber = plistString(usb, "USB Serial Number", "kUSBSerialNumberString")
+				info.Manufacturer = plistString(usb, "USB Vendor Name", "kUSBVendorString")
+				info.Product = plistString(usb, "USB Product Name", "kUSBProductString")
+			}
+
+			found[path] = info
+		}
+
+		if children, ok := n["IORegistryEntryChildren"]; ok {
+			findSerialPorts(children, usb, found)
+		}
+	}
+}
+
+// plistString returns the first of the keys present in dict as a string.
+func plistString(dict map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := dict[k].(string); ok {
+			return s
+		}
+	}
+
+	return ""
+}
+
+func plistInt(v interface{}) int64 {
+	n, _ := v.(int64)
+	return n
+}
+
+// parsePlist decodes an XML property list into maps, slices, strings and
+// int64s. Other value types decode to nil.
+func parsePlist(r io.Reader) (interface{}, error) {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local != "plist" {
+			return parsePlistValue(d, se)
+		}
+	}
+}
+
+func parsePlistValue(d *xml.Decoder, se xml.StartElement) (interface{}, error) {
+	switch se.Name.Local {
+	case "dict":
+		dict := map[string]interface{}{}
+		var key string
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return dict, nil
+
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+
+				v, err := parsePlistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+			}
+		}
+
+	case "array":
+		var array []interface{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := tok.(type) {
+			case xml.EndElement:
+				return array, nil
+
+			case xml.StartElement:
+				v, err := parsePlistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			}
+		}
+
+	case "string":
+		var s string
+		err := d.DecodeElement(&s, &se)
+		return s, err
+
+	case "integer":
+		var s string
+		if err := d.DecodeElement(&s, &se); err != nil {
+			return nil, err
+		}
+		n, _ := strconv.ParseInt(s, 0, 64)
+		return n, nil
+	}
+
+	return nil, d.Skip()
+}
+
+func stablePath(portName string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// watchChanges watches /dev with kqueue, sending on the returned channel
+// whenever a device node is created or removed. An EVFILT_USER event wakes
+// the watcher when ctx is cancelled.
+func watchChanges(ctx context.Context) (<-chan struct{}, error) {
+	dir, err := unix.Open("/dev", unix.O_RDONLY|unix.O_EVTONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+
+	kq, err := unix.Kqueue()
+	if err != nil {
+		unix.Close(dir)
+		return nil, os.NewSyscallError("kqueue", err)
+	}
+
+	const cancelIdent = 1
+	events := make([]unix.Kevent_t, 2)
+	unix.SetKevent(&events[0], dir, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	events[0].Fflags = unix.NOTE_WRITE
+	unix.SetKevent(&events[1], cancelIdent, unix.EVFILT_USER, unix.EV_ADD|unix.EV_CLEAR)
+
+	if _, err := unix.Kevent(kq, events, nil, nil); err != nil {
+		unix.Close(kq)
+		unix.Close(dir)
+		return nil, os.NewSyscallError("kevent", err)
+	}
+
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		<-ctx.Done()
+
+		trigger := make([]unix.Kevent_t, 1)
+		unix.SetKevent(&trigger[0], cancelIdent, unix.EVFILT_USER, 0)
+		trigger[0].Fflags = unix.NOTE_TRIGGER
+		unix.Kevent(kq, trigger, nil, nil)
+	}()
+
+	go func() {
+		defer close(changes)
+		defer unix.Close(kq)
+		defer unix.Close(dir)
+
+		got := make([]unix.Kevent_t, 1)
+		for {
+			n, err := unix.Kevent(kq, nil, got, nil)
+			if err == unix.EINTR {
+				continue
+			}
+
+			if err != nil || (n > 0 && got[0].Filter == unix.EVFILT_USER) {
+				return
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"strings"
+	"testing"
+)
+
+// Trimmed output of ioreg -a -l -r -c IOSerialBSDClient.
+const serialClients = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<array>
+	<dict>
+		<key>IOCalloutDevice</key>
+		<string>/dev/cu.Bluetooth-Incoming-Port</string>
+		<key>IODialinDevice</key>
+		<string>/dev/tty.Bluetooth-Incoming-Port</string>
+	</dict>
+	<dict>
+		<key>IOCalloutDevice</key>
+		<string>/dev/cu.usbserial-A8008HlV</string>
+		<key>IODialinDevice</key>
+		<string>/dev/tty.usbserial-A8008HlV</string>
+	</dict>
+</array>
+</plist>
+`
+
+// Trimmed output of ioreg -a -l -r -c IOUSBHostDevice.
+const usbDevices = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<array>
+	<dict>
+		<key>idVendor</key>
+		<integer>1027</integer>
+		<key>idProduct</key>
+		<integer>24577</integer>
+		<key>USB Serial Number</key>
+		<string>A8008HlV</string>
+		<key>USB Product Name</key>
+		<string>FT232R USB UART</string>
+		<key>IORegistryEntryChildren</key>
+		<array>
+			<dict>
+				<key>IORegistryEntryChildren</key>
+				<array>
+					<dict>
+						<key>IOCalloutDevice</key>
+						<string>/dev/cu.usbserial-A8008HlV</string>
+						<key>IODialinDevice</key>
+						<string>/dev/tty.usbserial-A8008HlV</string>
+					</dict>
+				</array>
+			</dict>
+		</array>
+	</dict>
+</array>
+</plist>
+`
+
+func TestFindSerialPorts(t *testing.T) {
+	found := map[string]PortInfo{}
+	for _, doc := range []string{serialClients, usbDevices} {
+		tree, err := parsePlist(strings.NewReader(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		findSerialPorts(tree, nil, found)
+	}
+
+	want := map[string]PortInfo{
+		"/dev/cu.Bluetooth-Incoming-Port": {
+			Name:       "/dev/cu.Bluetooth-Incoming-Port",
+			DialinPath: "/dev/tty.Bluetooth-Incoming-Port",
+		},
+		"/dev/cu.usbserial-A8008HlV": {
+			Name:         "/dev/cu.usbserial-A8008HlV",
+			DialinPath:   "/dev/tty.usbserial-A8008HlV",
+			IsUSB:        true,
+			VendorID:     0x0403,
+			ProductID:    0x6001,
+			SerialNumber: "A8008HlV",
+			Product:      "FT232R USB UART",
+		},
+	}
+
+	if len(found) != len(want) {
+		t.Fatalf("got %v, want %v", found, want)
+	}
+	for name, info := range want {
+		if found[name] != info {
+			t.Errorf("%s: got %+v, want %+v", name, found[name], info)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Directories where udev makes links to serial ports, named after the device
+// and where it is plugged in respectively, in order of preference.
+var stableDirs = []string{
+	"/dev/serial/by-id",
+	"/dev/serial/by-path",
+}
+
+// portDetails looks up each port in sysfs, and its links in stableDirs.
+func portDetails(names []string) []PortInfo {
+	links := stableLinks(stableDirs)
+
+	infos := make([]PortInfo, len(names))
+	for i, name := range names {
+		infos[i] = sysfsPortInfo("/sys", name)
+		if dev, err := filepath.EvalSymlinks(name); err == nil {
+			infos[i].StablePath = links[dev]
+		}
+	}
+
+	return infos
+}
+
+func stablePath(portName string) (string, error) {
+	dev, err := filepath.EvalSymlinks(portName)
+	if err != nil {
+		return "", err
+	}
+
+	if link, ok := stableLinks(stableDirs)[dev]; ok {
+		return link, nil
+	}
+
+	return "", fmt.Errorf("serial: no link to %s in /dev/serial", dev)
+}
+
+// stableLinks maps the devices linked to from the given directories to the
+// first link found for each, preferring earlier directories.
+func stableLinks(dirs []string) map[string]string {
+	links := make(map[string]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			link := filepath.Join(dir, e.Name())
+			dev, err := filepath.EvalSymlinks(link)
+			if err != nil {
+				continue
+			}
+
+			if _, ok := links[dev]; !ok {
+				links[dev] = link
+			}
+		}
+	}
+
+	return links
+}
+
+// isPhantomPort reports whether the port is one of the ttyS* nodes the 8250
+// driver creates whether or not there is a UART behind them.
+func isPhantomPort(name string) bool {
+	return sysfsPhantomPort("/sys", name)
+}
+
+// sysfsPhantomPort reports whether sysfs gives the port's type as 0
+// (PORT_UNKNOWN), meaning no hardware was found. Only ports handled by the
+// serial core have a type attribute.
+func sysfsPhantomPort(root string, name string) bool {
+	return readSysfsString(filepath.Join(root, "class", "tty", filepath.Base(name), "type")) == "0"
+}
+
+// sysfsPortInfo describes the port name using the sysfs tree mounted at
+// root. The tty's device link leads to the device the driver is bound to:
+// the USB interface or usb-serial port for an adapter, or the platform
+// device for a native UART. The USB device itself is the nearest ancestor
+// with an idVendor attribute.
+func sysfsPortInfo(root string, name string) PortInfo {
+	info := PortInfo{Name: name}
+
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return info
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Join(root, "class", "tty", filepath.Base(name), "device"))
+	if err != nil {
+		return info
+	}
+
+	info.DevicePath = dir
+	if driver, err := filepath.EvalSymlinks(filepath.Join(dir, "driver")); err == nil {
+		info.Driver = filepath.Base(driver)
+	}
+
+	devices := filepath.Join(root, "devices")
+	for ; strings.HasPrefix(dir, devices); dir = filepath.Dir(dir) {
+		vid, err := readSysfsHex(filepath.Join(dir, "idVendor"))
+		if err != nil {
+			continue
+		}
+
+		pid, err := readSysfsHex(filepath.Join(dir, "idProduct"))
+		if err != nil {
+			return info
+		}
+
+		info.IsUSB = true
+		info.VendorID = vid
+		info.ProductID = pid
+		info.SerialNumber = readSysfsString(filepath.Join(dir, "serial"))
+		info.Manufacturer = readSysfsString(filepath.Join(dir, "manufacturer"))
+		info.Product = readSysfsString(filepath.Join(dir, "product"))
+		break
+	}
+
+	return info
+}
+
+// readSysfsString returns the contents of a sysfs attribute, or the empty
+// string if it can't be read.
+func readSysfsString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// readSysfsHex reads a sysfs attribute holding a 16-bit hex number.
+func readSysfsHex(path string) (uint16, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 16)
+	return uint16(n), err
+}
+
+// watchChanges listens for kernel uevents, sending on the returned channel
+// whenever a tty is added or removed.
+func watchChanges(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(
+		unix.AF_NETLINK,
+		unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK,
+		unix.NETLINK_KOBJECT_UEVENT)
+
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	// Group 1 carries the kernel's own events, as opposed to udev's.
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "uevent")
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		defer close(changes)
+
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := f.Read(buf)
+			if errors.Is(err, unix.ENOBUFS) {
+				// Events were dropped; one of them may have been ours.
+				notify()
+				continue
+			}
+
+			if err != nil {
+				return
+			}
+
+			if isTTYUevent(buf[:n]) {
+				notify()
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// isTTYUevent reports whether msg, a kernel uevent, is the addition or
+// removal of a tty. A uevent is a header such as "add@/devices/..." followed
+// by NUL-separated KEY=value pairs.
+func isTTYUevent(msg []byte) bool {
+	var tty, action bool
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		switch string(field) {
+		case "SUBSYSTEM=tty":
+			tty = true
+		case "ACTION=add", "ACTION=remove":
+			action = true
+		}
+	}
+
+	return tty && action
+}
@@ -0,0 +1,186 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSysfs creates the files in a fake sysfs tree under root.
+func writeSysfs(t *testing.T, root string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSysfsPortInfo(t *testing.T) {
+	root := t.TempDir()
+
+	usb := "devices/pci0000:00/0000:00:14.0/usb1/1-2"
+	writeSysfs(t, root, map[string]string{
+		usb + "/idVendor":                  "0403\n",
+		usb + "/idProduct":                 "6001\n",
+		usb + "/serial":                    "A8008HlV\n",
+		usb + "/manufacturer":              "FTDI\n",
+		usb + "/product":                   "FT232R USB UART\n",
+		usb + "/1-2:1.0/ttyUSB0/uevent":    "",
+		"devices/platform/serial8250/tty0": "",
+	})
+
+	drivers := map[string]string{
+		usb + "/1-2:1.0/ttyUSB0":      "bus/usb-serial/drivers/ftdi_sio",
+		"devices/platform/serial8250": "bus/platform/drivers/serial8250",
+	}
+	for dev, driver := range drivers {
+		if err := os.MkdirAll(filepath.Join(root, driver), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, driver), filepath.Join(root, dev, "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tty := filepath.Join(root, "class", "tty")
+	if err := os.MkdirAll(filepath.Join(tty, "ttyUSB0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tty, "ttyS0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, usb, "1-2:1.0", "ttyUSB0"), filepath.Join(tty, "ttyUSB0", "device")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "devices/platform/serial8250"), filepath.Join(tty, "ttyS0", "device")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := sysfsPortInfo(root, "/dev/ttyUSB0")
+	want := PortInfo{
+		Name:         "/dev/ttyUSB0",
+		IsUSB:        true,
+		VendorID:     0x0403,
+		ProductID:    0x6001,
+		SerialNumber: "A8008HlV",
+		Manufacturer: "FTDI",
+		Product:      "FT232R USB UART",
+		DevicePath:   filepath.Join(root, usb, "1-2:1.0", "ttyUSB0"),
+		Driver:       "ftdi_sio",
+	}
+	if got != want {
+		t.Errorf("ttyUSB0: got %+v, want %+v", got, want)
+	}
+
+	got = sysfsPortInfo(root, "/dev/ttyS0")
+	want = PortInfo{
+		Name:       "/dev/ttyS0",
+		DevicePath: filepath.Join(root, "devices/platform/serial8250"),
+		Driver:     "serial8250",
+	}
+	if got != want {
+		t.Errorf("ttyS0: got %+v, want %+v", got, want)
+	}
+
+	got = sysfsPortInfo(root, "/dev/ttyUSB9")
+	if got != (PortInfo{Name: "/dev/ttyUSB9"}) {
+		t.Errorf("missing port: got %+v", got)
+	}
+}
+
+func TestStableLinks(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"ttyUSB0": "",
+		"ttyACM0": "",
+	})
+
+	byID := filepath.Join(root, "by-id")
+	byPath := filepath.Join(root, "by-path")
+	links := map[string]string{
+		filepath.Join(byID, "usb-FTDI_FT232R_USB_UART_A8008HlV-if00-port0"): "ttyUSB0",
+		filepath.Join(byPath, "pci-0000:00:14.0-usb-0:2:1.0-port0"):         "ttyUSB0",
+		filepath.Join(byPath, "pci-0000:00:14.0-usb-0:3:1.0"):               "ttyACM0",
+	}
+	for link, dev := range links {
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", dev), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := stableLinks([]string{byID, byPath, filepath.Join(root, "missing")})
+	want := map[string]string{
+		filepath.Join(root, "ttyUSB0"): filepath.Join(byID, "usb-FTDI_FT232R_USB_UART_A8008HlV-if00-port0"),
+		filepath.Join(root, "ttyACM0"): filepath.Join(byPath, "pci-0000:00:14.0-usb-0:3:1.0"),
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for dev, link := range want {
+		if got[dev] != link {
+			t.Errorf("%s: got %q, want %q", dev, got[dev], link)
+		}
+	}
+}
+
+func TestSysfsPhantomPort(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"class/tty/ttyS0/type":  "4\n",
+		"class/tty/ttyS1/type":  "0\n",
+		"class/tty/ttyUSB0/dev": "188:0\n",
+	})
+
+	testCases := map[string]bool{
+		"/dev/ttyS0":   false,
+		"/dev/ttyS1":   true,
+		"/dev/ttyUSB0": false,
+		"/dev/ttyS9":   false,
+	}
+
+	for name, want := range testCases {
+		if got := sysfsPhantomPort(root, name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestIsTTYUevent(t *testing.T) {
+	testCases := []struct {
+		msg  string
+		want bool
+	}{
+		{"add@/devices/pci0000:00/usb1/1-2/1-2:1.0/ttyUSB0/tty/ttyUSB0\x00ACTION=add\x00SUBSYSTEM=tty\x00DEVNAME=ttyUSB0", true},
+		{"remove@/devices/virtual/tty/ttyACM0\x00ACTION=remove\x00SUBSYSTEM=tty", true},
+		{"change@/devices/virtual/tty/tty1\x00ACTION=change\x00SUBSYSTEM=tty", false},
+		{"add@/devices/pci0000:00/usb1/1-2\x00ACTION=add\x00SUBSYSTEM=usb", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isTTYUevent([]byte(tc.msg)); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.msg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !windows
+
+package serial
+
+import "context"
+
+// portDetails lists the ports without details, since there's no support for
+// finding the USB device behind a port on this OS.
+func portDetails(names []string) []PortInfo {
+	infos := make([]PortInfo, len(names))
+	for i, name := range names {
+		infos[i] = PortInfo{Name: name}
+	}
+
+	return infos
+}
+
+func stablePath(portName string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func watchChanges(ctx context.Context) (<-chan struct{}, error) {
+	return nil, ErrNotSupported
+}
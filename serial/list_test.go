@@ -0,0 +1,156 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLessPortName(t *testing.T) {
+	names := []string{"COM10", "COM2", "COM1", "/dev/ttyUSB10", "/dev/ttyUSB9", "/dev/ttyACM0", "COM02"}
+	sort.Slice(names, func(i, j int) bool { return lessPortName(names[i], names[j]) })
+
+	want := "/dev/ttyACM0 /dev/ttyUSB9 /dev/ttyUSB10 COM1 COM2 COM02 COM10"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPreferredPortName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tty.usbserial-A1", "cu.usbserial-A1", "tty.Bluetooth"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name, want string
+	}{
+		{"tty.usbserial-A1", "cu.usbserial-A1"},
+		{"cu.usbserial-A1", "cu.usbserial-A1"},
+		{"tty.Bluetooth", "tty.Bluetooth"},
+		{"ttyUSB0", "ttyUSB0"},
+	}
+
+	for _, tc := range testCases {
+		name := filepath.Join(dir, tc.name)
+		want := filepath.Join(dir, tc.want)
+		if got := PreferredPortName(name); got != want {
+			t.Errorf("PreferredPortName(%q): got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ftdi := PortInfo{
+		Name:         "/dev/ttyUSB0",
+		IsUSB:        true,
+		VendorID:     0x0403,
+		ProductID:    0x6015,
+		SerialNumber: "DN01ABCD",
+		Product:      "FT230X Basic UART",
+	}
+	native := PortInfo{Name: "/dev/ttyS0"}
+
+	testCases := []struct {
+		name   string
+		filter Filter
+		want   []PortInfo
+	}{
+		{"empty", Filter{}, []PortInfo{ftdi, native}},
+		{"vid and pid", Filter{VID: 0x0403, PID: 0x6015}, []PortInfo{ftdi}},
+		{"wrong pid", Filter{VID: 0x0403, PID: 0x6001}, nil},
+		{"serial prefix", Filter{SerialNumberPrefix: "DN01"}, []PortInfo{ftdi}},
+		{"product", Filter{ProductSubstring: "Basic"}, []PortInfo{ftdi}},
+		{"wrong product", Filter{ProductSubstring: "CP2102"}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := filterPorts([]PortInfo{ftdi, native}, tc.filter)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+
+	err := noMatchError([]PortInfo{ftdi, native})
+	if !strings.Contains(err.Error(), "/dev/ttyUSB0 [0403:6015 DN01ABCD FT230X Basic UART], /dev/ttyS0") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFindSerialNumber(t *testing.T) {
+	ports := []PortInfo{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, SerialNumber: "A8008HlV"},
+		{Name: "/dev/ttyUSB1", IsUSB: true, SerialNumber: "A8008HlVX"},
+	}
+
+	p, err := findSerialNumber(ports, "A8008HlVX")
+	if err != nil || p.Name != "/dev/ttyUSB1" {
+		t.Errorf("exact match: %v, %v", p, err)
+	}
+
+	_, err = findSerialNumber(ports, "A8008")
+	if err == nil || !strings.Contains(err.Error(), "found A8008HlV, A8008HlVX") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = findSerialNumber(ports[:1], "A8008HlV")
+	if err == nil || !strings.Contains(err.Error(), "no USB serial numbers found") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMatchFriendlyName(t *testing.T) {
+	ports := []PortInfo{
+		{Name: "COM1", FriendlyName: "Communications Port (COM1)"},
+		{Name: "COM7", FriendlyName: "USB Serial Port (COM7)"},
+		{Name: "COM8", FriendlyName: "USB Serial Port (COM8)"},
+		{Name: "/dev/ttyUSB0"},
+	}
+
+	testCases := []struct {
+		substring string
+		want      []string
+	}{
+		{"usb serial port (com7)", []string{"COM7"}},
+		{"USB SERIAL", []string{"COM7", "COM8"}},
+		{"bluetooth", nil},
+		{"", []string{"COM1", "COM7", "COM8"}},
+	}
+
+	for _, tc := range testCases {
+		var got []string
+		for _, p := range matchFriendlyName(ports, tc.substring) {
+			got = append(got, p.Name)
+		}
+
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("%q: got %v, want %v", tc.substring, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Enumerators under HKLM\SYSTEM\CurrentControlSet\Enum that hold USB serial
+// devices, and the separator between the parts of their device IDs.
+var usbEnumerators = []struct {
+	name string
+	sep  string
+}{
+	{"USB", "&"},     // USB\VID_2341&PID_0043\<serial>
+	{"FTDIBUS", "+"}, // FTDIBUS\VID_0403+PID_6001+<serial>A\0000
+}
+
+// portDetails asks SetupAPI for the devices that provide a COM port
+// interface, reading each one's port name and instance ID. If SetupAPI
+// can't be used it falls back to looking for USB devices with a PortName
+// value in the Plug and Play manager's registry keys.
+func portDetails(names []string) []PortInfo {
+	found := map[string]PortInfo{}
+	if err := findComPorts(found); err != nil {
+		for _, e := range usbEnumerators {
+			findUSBSerialPorts(e.name, e.sep, found)
+		}
+	}
+
+	infos := make([]PortInfo, len(names))
+	for i, name := range names {
+		info, ok := found[name]
+		if !ok {
+			info = PortInfo{Name: name}
+		}
+		infos[i] = info
+	}
+
+	return infos
+}
+
+func findComPorts(found map[string]PortInfo) error {
+	devs, err := windows.SetupDiGetClassDevsEx(
+		&guidDevinterfaceComport,
+		"",
+		0,
+		windows.DIGCF_PRESENT|windows.DIGCF_DEVICEINTERFACE,
+		0,
+		"")
+	if err != nil {
+		return err
+	}
+	defer devs.Close()
+
+	for i := 0; ; i++ {
+		data, err := devs.EnumDeviceInfo(i)
+		if err == windows.ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			continue
+		}
+
+		name := devicePortName(devs, data)
+		if name == "" {
+			continue
+		}
+
+		id, err := devs.DeviceInstanceID(data)
+		if err != nil {
+			continue
+		}
+
+		// The serial number of a composite device, such as a CDC ACM port
+		// with a second interface, belongs to its parent.
+		info, composite := parseInstanceID(id)
+		if composite {
+			if parent, ok := parentInstanceID(data.DevInst); ok {
+				if p, _ := parseInstanceID(parent); p.IsUSB {
+					info.SerialNumber = p.SerialNumber
+				}
+			}
+		}
+
+		info.Name = name
+		info.DevicePath = id
+		info.Manufacturer = deviceProperty(devs, data, windows.SPDRP_MFG)
+		info.Product = deviceProperty(devs, data, windows.SPDRP_DEVICEDESC)
+		info.FriendlyName = deviceProperty(devs, data, windows.SPDRP_FRIENDLYNAME)
+		found[name] = info
+	}
+
+	return nil
+}
+
+// devicePortName reads the PortName value from a device's hardware key.
+func devicePortName(devs windows.DevInfo, data *windows.DevInfoData) string {
+	h, err := devs.OpenDevRegKey(data, windows.DICS_FLAG_GLOBAL, 0, windows.DIREG_DEV, windows.KEY_READ)
+	if err != nil {
+		return ""
+	}
+
+	k := registry.Key(h)
+	defer k.Close()
+
+	name, _, err := k.GetStringValue("PortName")
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// deviceProperty reads a string property of a device, returning "" if it is
+// missing or not a string.
+func deviceProperty(devs windows.DevInfo, data *windows.DevInfoData, prop windows.SPDRP) string {
+	v, err := devs.DeviceRegistryProperty(data, prop)
+	if err != nil {
+		return ""
+	}
+
+	s, _ := v.(string)
+	return s
+}
+
+// parentInstanceID returns the instance ID of a device's parent.
+func parentInstanceID(inst windows.DEVINST) (string, bool) {
+	var parent windows.DEVINST
+	r, _, _ := procCMGetParent.Call(uintptr(unsafe.Pointer(&parent)), uintptr(inst), 0)
+	if r != 0 {
+		return "", false
+	}
+
+	// MAX_DEVICE_ID_LEN, plus the terminating NUL.
+	var buf [201]uint16
+	r, _, _ = procCMGetDeviceID.Call(uintptr(parent), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0)
+	if r != 0 {
+		return "", false
+	}
+
+	return windows.UTF16ToString(buf[:]), true
+}
+
+// parseInstanceID extracts what it can from a device instance ID such as
+// USB\VID_2341&PID_0043\75735313733351F0F1E1. composite is true for an
+// interface of a composite USB device, whose own ID doesn't include the
+// serial number.
+func parseInstanceID(id string) (info PortInfo, composite bool) {
+	parts := strings.SplitN(id, `\`, 3)
+	if len(parts) < 3 {
+		return info, false
+	}
+
+	switch strings.ToUpper(parts[0]) {
+	case "USB":
+		info, _ = parseDeviceID(parts[1], "&")
+		composite = info.IsUSB && strings.Contains(parts[1], "&MI_")
+
+		// An instance ID containing '&' was made up by Windows because the
+		// device has no serial number.
+		if info.IsUSB && !composite && !strings.Contains(parts[2], "&") {
+			info.SerialNumber = parts[2]
+		}
+
+	case "FTDIBUS":
+		info, _ = parseDeviceID(parts[1], "+")
+
+	case "BTHENUM":
+		info.IsBluetooth = true
+	}
+
+	return info, composite
+}
+
+func findUSBSerialPorts(enumerator, sep string, found map[string]PortInfo) {
+	root, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Enum\`+enumerator, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return
+	}
+	defer root.Close()
+
+	devices, err := root.ReadSubKeyNames(0)
+	if err != nil {
+		return
+	}
+
+	for _, device := range devices {
+		info, ok := parseDeviceID(device, sep)
+		if !ok {
+			continue
+		}
+
+		dk, err := registry.OpenKey(root, device, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+
+		instances, _ := dk.ReadSubKeyNames(0)
+		for _, instance := range instances {
+			ik, err := registry.OpenKey(dk, instance, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+
+			pk, err := registry.OpenKey(ik, "Device Parameters", registry.QUERY_VALUE)
+			if err == nil {
+				name, _, err := pk.GetStringValue("PortName")
+				pk.Close()
+
+				if err == nil {
+					i := info
+					i.Name = name
+					i.DevicePath = enumerator + `\` + device + `\` + instance
+
+					// An instance ID containing '&' was made up by Windows
+					// because the device has no serial number.
+					if enumerator == "USB" && !strings.Contains(instance, "&") {
+						i.SerialNumber = instance
+					}
+
+					i.Manufacturer = registryText(ik, "Mfg")
+					i.Product = registryText(ik, "DeviceDesc")
+					i.FriendlyName = registryText(ik, "FriendlyName")
+					found[name] = i
+				}
+			}
+			ik.Close()
+		}
+		dk.Close()
+	}
+}
+
+// parseDeviceID extracts the vendor and product IDs from a device ID such as
+// VID_2341&PID_0043&MI_00. FTDI's driver appends the serial number and a
+// letter identifying the port on the device to the ID itself.
+func parseDeviceID(id, sep string) (PortInfo, bool) {
+	var info PortInfo
+	var vidOK, pidOK bool
+	for i, part := range strings.Split(id, sep) {
+		switch {
+		case strings.HasPrefix(part, "VID_"):
+			n, err := strconv.ParseUint(part[4:], 16, 16)
+			info.VendorID, vidOK = uint16(n), err == nil
+
+		case strings.HasPrefix(part, "PID_"):
+			n, err := strconv.ParseUint(part[4:], 16, 16)
+			info.ProductID, pidOK = uint16(n), err == nil
+
+		case i == 2 && sep == "+" && len(part) > 1:
+			info.SerialNumber = part[:len(part)-1]
+		}
+	}
+
+	info.IsUSB = vidOK && pidOK
+	return info, info.IsUSB
+}
+
+// registryText reads a string value such as "@oem12.inf,%ftdi%;FTDI",
+// returning the text after the last semicolon.
+func registryText(k registry.Key, name string) string {
+	s, _, err := k.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+
+	if i := strings.LastIndex(s, ";"); i >= 0 {
+		s = s[i+1:]
+	}
+
+	return s
+}
+
+func stablePath(portName string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// CM_NOTIFY_FILTER from cfgmgr32.h, for a device interface class.
+type cmNotifyFilter struct {
+	cbSize     uint32
+	flags      uint32
+	filterType uint32
+	reserved   uint32
+	classGuid  windows.GUID
+	_          [400 - 16]byte // The rest of the union.
+}
+
+var (
+	// GUID_DEVINTERFACE_COMPORT, registered by drivers for COM ports.
+	guidDevinterfaceComport = windows.GUID{
+		Data1: 0x86E0D1E0,
+		Data2: 0x8089,
+		Data3: 0x11D0,
+		Data4: [8]byte{0x9C, 0xE4, 0x08, 0x00, 0x3E, 0x30, 0x1F, 0x73},
+	}
+
+	cfgmgr32                     = syscall.NewLazyDLL("cfgmgr32.dll")
+	procCMRegisterNotification   = cfgmgr32.NewProc("CM_Register_Notification")
+	procCMUnregisterNotification = cfgmgr32.NewProc("CM_Unregister_Notification")
+	procCMGetParent              = cfgmgr32.NewProc("CM_Get_Parent")
+	procCMGetDeviceID            = cfgmgr32.NewProc("CM_Get_Device_IDW")
+
+	// Callbacks can't be freed, so a single one serves every watcher,
+	// finding its channel by the context value it is passed.
+	notifyCallbackOnce sync.Once
+	notifyCallback     uintptr
+
+	watchersMu  sync.Mutex
+	watchers    = map[uintptr]chan struct{}{}
+	nextWatcher uintptr
+)
+
+func onDeviceChange(hNotify, context, action, eventData, eventDataSize uintptr) uintptr {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+
+	if ch, ok := watchers[context]; ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	return 0 // ERROR_SUCCESS
+}
+
+// watchChanges registers for COM port interface arrivals and removals,
+// sending on the returned channel when one happens. CM_Register_Notification
+// needs Windows 8 or later; on older versions this returns ErrNotSupported.
+func watchChanges(ctx context.Context) (<-chan struct{}, error) {
+	if err := procCMRegisterNotification.Find(); err != nil {
+		return nil, ErrNotSupported
+	}
+
+	notifyCallbackOnce.Do(func() {
+		notifyCallback = syscall.NewCallback(onDeviceChange)
+	})
+
+	changes := make(chan struct{}, 1)
+
+	watchersMu.Lock()
+	nextWatcher++
+	id := nextWatcher
+	watchers[id] = changes
+	watchersMu.Unlock()
+
+	filter := cmNotifyFilter{
+		classGuid: guidDevinterfaceComport,
+	}
+	filter.cbSize = uint32(unsafe.Sizeof(filter))
+
+	var handle uintptr
+	r, _, _ := procCMRegisterNotification.Call(
+		uintptr(unsafe.Pointer(&filter)),
+		id,
+		notifyCallback,
+		uintptr(unsafe.Pointer(&handle)))
+
+	if r != 0 {
+		watchersMu.Lock()
+		delete(watchers, id)
+		watchersMu.Unlock()
+		return nil, fmt.Errorf("CM_Register_Notification: CONFIGRET %d", r)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		// Unregistering waits for callbacks in progress to finish.
+		procCMUnregisterNotification.Call(handle)
+
+		watchersMu.Lock()
+		delete(watchers, id)
+		close(changes)
+		watchersMu.Unlock()
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import "testing"
+
+func TestParseInstanceID(t *testing.T) {
+	testCases := []struct {
+		id        string
+		want      PortInfo
+		composite bool
+	}{
+		{
+			`USB\VID_2341&PID_0043\75735313733351F0F1E1`,
+			PortInfo{IsUSB: true, VendorID: 0x2341, ProductID: 0x0043, SerialNumber: "75735313733351F0F1E1"},
+			false,
+		},
+		{
+			`USB\VID_1A86&PID_7523\5&2B5A3F1&0&2`,
+			PortInfo{IsUSB: true, VendorID: 0x1a86, ProductID: 0x7523},
+			false,
+		},
+		{
+			`USB\VID_2E8A&PID_000A&MI_00\6&1C0B8F2D&0&0000`,
+			PortInfo{IsUSB: true, VendorID: 0x2e8a, ProductID: 0x000a},
+			true,
+		},
+		{
+			`FTDIBUS\VID_0403+PID_6001+A8008HLVA\0000`,
+			PortInfo{IsUSB: true, VendorID: 0x0403, ProductID: 0x6001, SerialNumber: "A8008HLV"},
+			false,
+		},
+		{
+			`BTHENUM\{00001101-0000-1000-8000-00805F9B34FB}_LOCALMFG&0000\7&2D9A2E5&0&000000000000_00000003`,
+			PortInfo{IsBluetooth: true},
+			false,
+		},
+		{
+			`ACPI\PNP0501\0`,
+			PortInfo{},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, composite := parseInstanceID(tc.id)
+		if got != tc.want || composite != tc.composite {
+			t.Errorf("parseInstanceID(%q) = %+v, %v; want %+v, %v", tc.id, got, composite, tc.want, tc.composite)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// portLogger holds the logger a port explains itself to, which SetLogger may
+// replace while the port is in use.
+type portLogger struct {
+	v atomic.Value // *slog.Logger
+}
+
+// set installs l, with the port's name attached to everything it logs. A nil
+// l turns logging off.
+func (pl *portLogger) set(l *slog.Logger, name string) {
+	if l != nil {
+		l = l.With("port", name)
+	}
+	pl.v.Store(l)
+}
+
+// get returns the logger, or nil if logging is off. Callers check for nil
+// before preparing anything to log, so that a port without a logger does no
+// work on its behalf.
+func (pl *portLogger) get() *slog.Logger {
+	l, _ := pl.v.Load().(*slog.Logger)
+	return l
+}
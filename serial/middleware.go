@@ -0,0 +1,186 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// ReadWriteFunc is the shape of Read and Write, as passed along a chain of
+// Middleware.
+type ReadWriteFunc func(b []byte) (int, error)
+
+// Middleware transforms the bytes passing through a port, for example
+// translating line endings or throttling writes. Read and Write are given
+// the function that reads from or writes to the next layer down, and
+// return the function to use in its place; either may be nil to pass that
+// direction through untouched. Close, if non-nil, is called as the port is
+// closed, to flush or release whatever the middleware holds.
+type Middleware struct {
+	Read  func(next ReadWriteFunc) ReadWriteFunc
+	Write func(next ReadWriteFunc) ReadWriteFunc
+	Close func() error
+}
+
+// middlewarePort is the Port returned by Use.
+type middlewarePort struct {
+	Port
+
+	mws []Middleware
+
+	read     ReadWriteFunc
+	readTS   ReadWriteFunc
+	write    ReadWriteFunc
+	writeAdr ReadWriteFunc
+	writeDat ReadWriteFunc
+	writeRTS ReadWriteFunc
+
+	// Guards ts, the time the first byte of a ReadWithTimestamp arrived.
+	rl sync.Mutex
+	ts time.Time
+
+	cm     sync.Mutex
+	closed bool
+}
+
+// Use returns a Port that passes reads and writes through mws, while still
+// offering everything else port does, such as SetRTS and SetBaudRate.
+// Each Middleware wraps those before it, so Use(port, a, b) is the same as
+// Use(Use(port, a), b): bytes written go through b, then a, then to port,
+// and bytes read come from port through a and then b. Read,
+// ReadWithTimestamp, Write, WriteNineBit and WriteWithRTS all pass through
+// the chain; ReadWithTimestamp reports when the first byte of the
+// underlying reads arrived.
+//
+// Close unwinds the chain, calling each Middleware's Close from the last
+// to the first and then closing port. It returns the first error, having
+// made every call regardless. Later calls just close port again.
+//
+// Methods that count or wait for bytes, such as Stats, BytesAvailable and
+// WaitForData, see the traffic beneath the chain.
+func Use(port Port, mws ...Middleware) Port {
+	p := &middlewarePort{
+		Port: port,
+		mws:  mws,
+	}
+
+	p.read = p.chainRead(port.Read)
+	p.readTS = p.chainRead(p.readWithTimestamp)
+	p.write = p.chainWrite(port.Write)
+	p.writeAdr = p.chainWrite(func(b []byte) (int, error) { return port.WriteNineBit(b, true) })
+	p.writeDat = p.chainWrite(func(b []byte) (int, error) { return port.WriteNineBit(b, false) })
+	p.writeRTS = p.chainWrite(port.WriteWithRTS)
+
+	return p
+}
+
+func (p *middlewarePort) chainRead(f ReadWriteFunc) ReadWriteFunc {
+	for _, mw := range p.mws {
+		if mw.Read != nil {
+			f = mw.Read(f)
+		}
+	}
+
+	return f
+}
+
+func (p *middlewarePort) chainWrite(f ReadWriteFunc) ReadWriteFunc {
+	for _, mw := range p.mws {
+		if mw.Write != nil {
+			f = mw.Write(f)
+		}
+	}
+
+	return f
+}
+
+// readWithTimestamp is the bottom of the ReadWithTimestamp chain. It's
+// called with rl held.
+func (p *middlewarePort) readWithTimestamp(b []byte) (int, error) {
+	n, at, err := p.Port.ReadWithTimestamp(b)
+	if n > 0 && p.ts.IsZero() {
+		p.ts = at
+	}
+
+	return n, err
+}
+
+// Read implements io.Reader.
+func (p *middlewarePort) Read(b []byte) (int, error) {
+	return p.read(b)
+}
+
+// ReadWithTimestamp implements Port.
+func (p *middlewarePort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	p.ts = time.Time{}
+	n, err := p.readTS(b)
+	if n == 0 {
+		return n, time.Time{}, err
+	}
+
+	return n, p.ts, err
+}
+
+// Write implements io.Writer.
+func (p *middlewarePort) Write(b []byte) (int, error) {
+	return p.write(b)
+}
+
+// WriteNineBit implements Port.
+func (p *middlewarePort) WriteNineBit(b []byte, address bool) (int, error) {
+	if address {
+		return p.writeAdr(b)
+	}
+
+	return p.writeDat(b)
+}
+
+// WriteWithRTS implements Port.
+func (p *middlewarePort) WriteWithRTS(b []byte) (int, error) {
+	return p.writeRTS(b)
+}
+
+// Close implements io.Closer.
+func (p *middlewarePort) Close() error {
+	p.cm.Lock()
+	closed := p.closed
+	p.closed = true
+	p.cm.Unlock()
+
+	if closed {
+		return p.Port.Close()
+	}
+
+	var first error
+	for i := len(p.mws) - 1; i >= 0; i-- {
+		if p.mws[i].Close == nil {
+			continue
+		}
+
+		if err := p.mws[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	if err := p.Port.Close(); err != nil && first == nil {
+		first = err
+	}
+
+	return first
+}
@@ -0,0 +1,181 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// crlf translates NL to CRNL on the way out.
+var crlf = Middleware{
+	Write: func(next ReadWriteFunc) ReadWriteFunc {
+		return func(b []byte) (int, error) {
+			if _, err := next(bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		}
+	},
+}
+
+// upper upper-cases what is read.
+var upper = Middleware{
+	Read: func(next ReadWriteFunc) ReadWriteFunc {
+		return func(b []byte) (int, error) {
+			n, err := next(b)
+			copy(b, bytes.ToUpper(b[:n]))
+			return n, err
+		}
+	},
+}
+
+// tagging returns a Middleware that records its name in log as bytes pass
+// through it and as it is closed.
+func tagging(name string, log *[]string) Middleware {
+	return Middleware{
+		Read: func(next ReadWriteFunc) ReadWriteFunc {
+			return func(b []byte) (int, error) {
+				n, err := next(b)
+				*log = append(*log, "read "+name)
+				return n, err
+			}
+		},
+		Write: func(next ReadWriteFunc) ReadWriteFunc {
+			return func(b []byte) (int, error) {
+				*log = append(*log, "write "+name)
+				return next(b)
+			}
+		},
+		Close: func() error {
+			*log = append(*log, "close "+name)
+			return nil
+		},
+	}
+}
+
+func TestUse(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	port := Use(a, crlf, upper)
+	defer port.Close()
+
+	if n, err := port.Write([]byte("hi\nthere\n")); n != 9 || err != nil {
+		t.Fatalf("Write: got %d, %v", n, err)
+	}
+
+	buf := make([]byte, 16)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(buf[:n]), "hi\r\nthere\r\n"; got != want {
+		t.Errorf("peer read %q, want %q", got, want)
+	}
+
+	b.Write([]byte("ok"))
+	n, at, err := port.ReadWithTimestamp(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buf[:n]); got != "OK" {
+		t.Errorf("ReadWithTimestamp: got %q, want %q", got, "OK")
+	}
+
+	if at.IsZero() {
+		t.Error("ReadWithTimestamp returned a zero time")
+	}
+
+	// The rest of the Port API is still there.
+	if err := port.SetRTS(true); err != nil {
+		t.Errorf("SetRTS: %v", err)
+	}
+
+	if got := port.String(); got != a.String() {
+		t.Errorf("String: got %q, want %q", got, a.String())
+	}
+}
+
+func TestUseOrder(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	var log []string
+	port := Use(Use(a, tagging("a", &log)), tagging("b", &log))
+
+	port.Write([]byte("x"))
+	b.Write([]byte("y"))
+	port.Read(make([]byte, 1))
+	port.WriteNineBit([]byte("z"), true)
+
+	if err := port.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"write b", "write a",
+		"read a", "read b",
+		"write b", "write a",
+		"close b", "close a",
+	}
+
+	if len(log) != len(want) {
+		t.Fatalf("got %q, want %q", log, want)
+	}
+
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("got %q, want %q", log, want)
+		}
+	}
+
+	// The port itself was closed, and closing again doesn't unwind the
+	// chain twice.
+	if _, err := a.Write([]byte("x")); !errors.Is(err, ErrPortClosed) {
+		t.Errorf("Write after Close: got %v, want ErrPortClosed", err)
+	}
+
+	if err := port.Close(); err != nil || len(log) != len(want) {
+		t.Errorf("second Close: %v, log %q", err, log)
+	}
+}
+
+func TestUseCloseError(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	wantErr := errors.New("taco")
+	var closed bool
+	port := Use(a,
+		Middleware{Close: func() error { closed = true; return nil }},
+		Middleware{Close: func() error { return wantErr }},
+	)
+
+	if err := port.Close(); err != wantErr {
+		t.Errorf("Close: got %v, want %v", err, wantErr)
+	}
+
+	if !closed {
+		t.Error("inner Middleware not closed after the outer one failed")
+	}
+
+	if _, err := b.Read(make([]byte, 1)); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("peer Read: got %v, want ErrDeviceRemoved", err)
+	}
+}
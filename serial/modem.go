@@ -0,0 +1,96 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Modem sends AT commands to a Hayes-compatible modem attached to a port.
+type Modem struct {
+	p Port
+	r *LineReader
+}
+
+// NewModem returns a Modem that talks to the device on p. The Modem buffers
+// input, so p should not be read from directly while the Modem is in use.
+func NewModem(p Port) *Modem {
+	return &Modem{p: p, r: NewLineReader(p)}
+}
+
+// ATError is returned by SendATCommand when the modem responds with an
+// error result code.
+type ATError struct {
+	// The command that failed.
+	Command string
+
+	// The final result code, e.g. "ERROR" or "+CME ERROR: 10".
+	Result string
+}
+
+func (e *ATError) Error() string {
+	return "serial: " + e.Command + ": " + e.Result
+}
+
+// SendATCommand writes cmd followed by CRLF, then reads the modem's response
+// until a final OK or error result code arrives. It returns the information
+// lines of the response joined by "\n", without the echoed command, blank
+// lines or the result code itself. Error result codes are returned as an
+// *ATError.
+//
+// The timeout bounds the whole exchange; it is implemented with the port's
+// read deadline, which is cleared again before returning.
+func (m *Modem) SendATCommand(cmd string, timeout time.Duration) (string, error) {
+	if err := m.p.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	defer m.p.SetReadDeadline(time.Time{})
+
+	if _, err := io.WriteString(m.p, cmd+"\r\n"); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for {
+		line, err := m.r.ReadLine()
+		if err == io.EOF {
+			// The inter-character timeout expired without any data; keep going
+			// until the deadline.
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line == cmd:
+			continue
+
+		case line == "OK":
+			return strings.Join(lines, "\n"), nil
+
+		case line == "ERROR" ||
+			strings.HasPrefix(line, "+CME ERROR") ||
+			strings.HasPrefix(line, "+CMS ERROR"):
+			return "", &ATError{Command: cmd, Result: line}
+		}
+
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeModem answers AT commands written to the slave side of a pty.
+func fakeModem(master *os.File) {
+	r := bufio.NewReader(master)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.TrimSpace(line)
+		switch cmd {
+		case "AT+CGMI":
+			master.WriteString(cmd + "\r\r\nACME Wireless\r\n\r\nOK\r\n")
+		case "AT+CPIN?":
+			master.WriteString("\r\n+CME ERROR: 10\r\n")
+		}
+	}
+}
+
+func TestSendATCommand(t *testing.T) {
+	master, name := openPty(t)
+	go fakeModem(master)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	m := NewModem(port)
+
+	resp, err := m.SendATCommand("AT+CGMI", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != "ACME Wireless" {
+		t.Errorf("unexpected response %q", resp)
+	}
+
+	_, err = m.SendATCommand("AT+CPIN?", time.Second)
+
+	var atErr *ATError
+	if !errors.As(err, &atErr) || atErr.Result != "+CME ERROR: 10" {
+		t.Errorf("expected +CME ERROR, got %v", err)
+	}
+
+	_, err = m.SendATCommand("ATZ", 100*time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
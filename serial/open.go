@@ -0,0 +1,614 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds the public API for opening ports. The per-OS files supply
+// openInternal, openFdInternal and the Port implementation behind them.
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// OpenOptions is the struct containing all of the options necessary for
+// opening a serial port.
+type OpenOptions struct {
+	// The name of the port, e.g. "/dev/cu.usbserial-A8008HlV". On OS X use
+	// the /dev/cu.* node rather than /dev/tty.*; see PreferredPortName.
+	PortName string
+
+	// The baud rate for the port.
+	BaudRate uint
+
+	// The number of data bits per frame. Legal values are 5, 6, 7, and 8.
+	DataBits uint
+
+	// The number of stop bits per frame. Legal values are 1 and 2.
+	StopBits uint
+
+	// The type of parity bits to use for the connection. Currently parity errors
+	// are simply ignored; that is, bytes are delivered to the user no matter
+	// whether they were received with a parity error or not.
+	ParityMode ParityMode
+
+	// Enable RTS/CTS (hardware) flow control.
+	RTSCTSFlowControl bool
+
+	// Set HUPCL, so that DTR is lowered when the port is last closed,
+	// including when the process exits. A modem takes this as the signal to
+	// hang up. It is ignored on Windows, where drivers generally lower DTR on
+	// close anyway.
+	HangupOnClose bool
+
+	// Clear CLOCAL, so that the modem status lines matter, as a dial-up
+	// modem needs. When carrier (DCD) drops, the driver hangs the port up:
+	// Read returns io.EOF, and the port must be reopened for the next call.
+	// Open doesn't wait for carrier; poll CarrierDetect for that. It is
+	// ignored on Windows.
+	//
+	// By default CLOCAL is set and the lines are ignored, as they always
+	// have been. The option is the opposite of an IgnoreModemStatusLines
+	// that defaults to true, since the zero value of OpenOptions must keep
+	// that behaviour.
+	HonorModemStatusLines bool
+
+	// Input processing, for ports that serve as interactive terminals.
+	// These set ISTRIP (clear the top bit of each byte), ICRNL (translate
+	// CR to NL), INLCR (translate NL to CR) and IGNCR (drop CRs). UTF8Input
+	// sets IUTF8 on Linux, so that a line discipline put into canonical mode
+	// with RawConfig erases multi-byte characters correctly. StripHighBit
+	// also suits 7-bit devices that set the top bit unpredictably, though it
+	// corrupts 8-bit data. By default input is passed through untouched.
+	// They are ignored on Windows.
+	StripHighBit bool
+	MapCRToNL    bool
+	MapNLToCR    bool
+	IgnoreCR     bool
+	UTF8Input    bool
+
+	// Output processing, such as the NL to CRNL translation of ONLCR, is
+	// off unless RawConfig turns it on, so that bytes are written exactly
+	// as given. RawOutput clears OPOST after RawConfig has run, for a
+	// RawConfig that applies a terminal's usual settings but shouldn't
+	// mangle binary writes. It is ignored on Windows.
+	RawOutput bool
+
+	// An inter-character timeout value, in milliseconds, and a minimum number of
+	// bytes to block for on each read. A call to Read() that otherwise may block
+	// waiting for more data will return immediately if the specified amount of
+	// time elapses between successive bytes received from the device or if the
+	// minimum number of bytes has been exceeded.
+	//
+	// Note that the inter-character timeout value may be rounded to the nearest
+	// 100 ms on some systems, and that behavior is undefined if calls to Read
+	// supply a buffer whose length is less than the minimum read size.
+	//
+	// The inter-character timer restarts with every byte, so it doesn't
+	// bound how long a Read takes while data keeps arriving; use
+	// OverallReadTimeout for that.
+	//
+	// Behaviors for various settings for these values are described below. For
+	// more information, see the discussion of VMIN and VTIME here:
+	//
+	//     http://www.unixwiz.net/techtips/termios-vmin-vtime.html
+	//
+	// InterCharacterTimeout = 0 and MinimumReadSize = 0 (the default):
+	//     This arrangement is not legal; you must explicitly set at least one of
+	//     these fields to a positive number. (If MinimumReadSize is zero then
+	//     InterCharacterTimeout must be at least 100.)
+	//
+	// InterCharacterTimeout > 0 and MinimumReadSize = 0
+	//     If data is already available on the read queue, it is transferred to
+	//     the caller's buffer and the Read() call returns immediately.
+	//     Otherwise, the call blocks until some data arrives or the
+	//     InterCharacterTimeout milliseconds elapse from the start of the call.
+	//     Note that in this configuration, InterCharacterTimeout must be at
+	//     least 100 ms.
+	//
+	// InterCharacterTimeout > 0 and MinimumReadSize > 0
+	//     Calls to Read() return when at least MinimumReadSize bytes are
+	//     available or when InterCharacterTimeout milliseconds elapse between
+	//     received bytes. The inter-character timer is not started until the
+	//     first byte arrives.
+	//
+	// InterCharacterTimeout = 0 and MinimumReadSize > 0
+	//     Calls to Read() return only when at least MinimumReadSize bytes are
+	//     available. The inter-character timer is not used.
+	//
+	// For windows usage, these options (termios) do not conform well to the
+	//     windows serial port / comms abstractions.  Please see the code in
+	//		 open_windows setCommTimeouts function for full documentation.
+	//   	 Summary:
+	//			Setting MinimumReadSize > 0 will cause the serialPort to block until
+	//			until data is available on the port.
+	//			Setting IntercharacterTimeout > 0 and MinimumReadSize == 0 will cause
+	//			the port to either wait until IntercharacterTimeout wait time is
+	//			exceeded OR there is character data to return from the port.
+	//
+
+	InterCharacterTimeout uint
+	MinimumReadSize       uint
+
+	// If non-zero, a limit on the duration of each Read as a whole. This
+	// differs from InterCharacterTimeout, which like VTIME is measured from
+	// the last byte received and so never expires while bytes keep
+	// trickling in. Once OverallReadTimeout has elapsed from the start of
+	// the call, Read returns what has arrived however it was spaced, or
+	// io.EOF if nothing has, as when InterCharacterTimeout expires.
+	//
+	// Read still returns as soon as MinimumReadSize bytes have arrived or
+	// InterCharacterTimeout expires. With MinimumReadSize zero it instead
+	// goes on reading until the buffer is full, and InterCharacterTimeout
+	// may be zero too, so that an OverallReadTimeout of 500ms alone means
+	// "whatever arrives within half a second". The limit is enforced by the
+	// package rather than the driver, and applies in addition to any
+	// deadline set with SetReadDeadline.
+	OverallReadTimeout time.Duration
+
+	// If non-zero, the size of a buffer that input is read from the driver
+	// into, so that a run of small Reads costs a single system call. Bytes
+	// wait in the buffer until they are read; WaitForData and BytesAvailable
+	// take them into account, and deadlines and InterCharacterTimeout apply
+	// as usual. Reads at least this large bypass the buffer. It is ignored
+	// on Windows.
+	ReadBufferSize uint
+
+	// If non-zero, the sizes in bytes to recommend for the driver's input
+	// and output queues, which hold bytes that have arrived but not been
+	// read and bytes that have been written but not transmitted. Only
+	// Windows lets them be chosen, passing them to SetupComm; zero there
+	// means 64, which drivers generally treat as a minimum, and drivers may
+	// round them up or ignore them. Elsewhere the kernel's buffers are fixed
+	// and these are ignored, and ReadBufferSize is the way to absorb bursts
+	// that arrive while the reader is busy. BufferSizes reports the sizes
+	// in effect.
+	InputQueueSize  uint
+	OutputQueueSize uint
+
+	// If non-zero, the most that Write and WriteNineBit hand to the driver at
+	// once. Larger buffers are written in chunks of this size, with a pause
+	// of WriteChunkDelay after each but the last, for the USB adapters whose
+	// drivers silently drop bytes when given a large write. A Write that
+	// fails part way returns the bytes written so far.
+	MaxWriteChunk   int
+	WriteChunkDelay time.Duration
+
+	// If non-zero, a limit on how long Open and OpenContext may take to
+	// open and configure the port, for startup sequences that must fail
+	// fast rather than hang on a bad device or USB hub. When it expires
+	// they return an error satisfying errors.Is(err, os.ErrDeadlineExceeded)
+	// and the open carries on in the background, as OpenContext describes.
+	OpenTimeout time.Duration
+
+	// Use to enable RS485 mode -- probably only valid on some Linux platforms
+	Rs485Enable bool
+
+	// Set to true for logic level high during send
+	Rs485RtsHighDuringSend bool
+
+	// Set to true for logic level high after send
+	Rs485RtsHighAfterSend bool
+
+	// set to receive data during sending
+	Rs485RxDuringTx bool
+
+	// RTS delay before send, in milliseconds. WriteWithRTS uses it too.
+	Rs485DelayRtsBeforeSend int
+
+	// RTS delay after send, in milliseconds. WriteWithRTS uses it too.
+	Rs485DelayRtsAfterSend int
+
+	// The state to put DTR and RTS in once the port has been configured.
+	// Deasserting DTR avoids resetting boards that reset on DTR, although
+	// on Linux and OS X the line is still raised briefly by the open itself.
+	// RTS is left alone when RTSCTSFlowControl is set.
+	InitialDTR LineState
+	InitialRTS LineState
+
+	// RawConfig, if set, is called on POSIX systems with the termios
+	// settings derived from the options above, just before they are applied.
+	// It may adjust flags the package doesn't otherwise model, or overwrite
+	// every flag and control character to apply a complete termios of its
+	// own; only the speed, which comes from BaudRate, is out of its reach.
+	// An error aborts Open. SetMode doesn't call it again. It is ignored on
+	// Windows.
+	RawConfig func(*Termios) error
+
+	// RawDCB is the Windows equivalent of RawConfig, called with the DCB
+	// before SetCommState. It is ignored on other systems.
+	RawDCB func(*DCB) error
+
+	// Functions to call as the port counts its traffic, for metrics. See
+	// PortHooks and Port.SetHooks.
+	Hooks PortHooks
+
+	// If non-nil, the port explains what it is doing to Logger: at Debug
+	// level the settings it applies and the timeouts and deadlines that
+	// end Reads and Writes, and at Warn level the oddities it works around,
+	// such as interrupted system calls being retried or a driver running at
+	// a baud rate other than the one asked for. The messages are meant for
+	// people and may change. Without a Logger, Read and Write do no work
+	// for it. See also Port.SetLogger.
+	Logger *slog.Logger
+}
+
+// Port is an open serial port, as returned by Open.
+//
+// All methods may be called concurrently. In particular one goroutine may
+// Read while another Writes, as a full-duplex protocol needs: the two
+// directions are independent and never wait for each other. Concurrent
+// Reads are serialized, as are concurrent Writes. Methods that change the
+// port's settings or modem lines, such as SetMode, SetBaudRate, Pause and
+// PulseDTR, are serialized with each other but don't wait for a Read or
+// Write in progress.
+//
+// Close may be called any number of times: the first call closes the port
+// and interrupts any Read or Write in progress, which then return
+// ErrPortClosed, and later calls return nil. Any other call made after the
+// port is closed returns ErrPortClosed. Calls interrupted by a signal are
+// restarted rather than failing with EINTR. If the device goes away, for
+// example because a USB adapter is unplugged, calls fail with errors
+// matching ErrDeviceRemoved; Read may instead report io.EOF. Errors other
+// than io.EOF, ErrPortClosed and ErrNotSupported name the device, usually
+// as a *PortError wrapping the system's error.
+type Port interface {
+	io.ReadWriteCloser
+
+	// Pause asks the remote end to stop sending. If RTS/CTS flow control is
+	// enabled this lowers RTS; otherwise an XOFF character is sent.
+	Pause() error
+
+	// Resume undoes the effect of Pause, raising RTS or sending XON.
+	Resume() error
+
+	// ErrorCounters returns the line error counts accumulated since the port
+	// was opened. It returns ErrNotSupported on platforms that don't keep
+	// them (OS X, Solaris).
+	//
+	// On Windows the driver only reports which kinds of error occurred since
+	// it was last asked, so each kind is counted at most once per call.
+	ErrorCounters() (ErrorCounters, error)
+
+	// ResetErrorCounters sets the counts returned by ErrorCounters back to
+	// zero, so that a monitor can report the errors seen in each interval.
+	ResetErrorCounters() error
+
+	// BufferSizes reports the sizes of the buffers that received and
+	// written bytes wait in, as far as the driver reveals them.
+	BufferSizes() (BufferSizes, error)
+
+	// Stats returns the number of bytes read and written and of failed and
+	// timed out reads and writes since the port was opened or ResetStats
+	// was called. It is cheap enough to poll from another goroutine, and
+	// keeps working after Close.
+	Stats() PortStats
+
+	// ResetStats sets the counts returned by Stats back to zero. The counts
+	// are reset one at a time, so a Read or Write in progress may be
+	// counted in some but not others.
+	ResetStats()
+
+	// SetHooks replaces the hooks given by OpenOptions.Hooks, which are
+	// called as the traffic counted by Stats happens.
+	SetHooks(hooks PortHooks)
+
+	// SetLowLatency asks the driver to deliver received bytes as soon as
+	// they arrive rather than batching them, at the cost of more interrupts.
+	// On Linux this sets ASYNC_LOW_LATENCY, which for FTDI adapters lowers
+	// the 16ms latency timer to 1ms. It returns ErrNotSupported elsewhere.
+	SetLowLatency(on bool) error
+
+	// String returns the name of the device the port was opened on.
+	String() string
+
+	// CurrentOptions reads the port's configuration back from the driver.
+	// BaudRate, DataBits, StopBits, ParityMode and RTSCTSFlowControl reflect
+	// the device's actual settings; the other fields are as the port was
+	// opened or last reconfigured.
+	CurrentOptions() (OpenOptions, error)
+
+	// BaudRate reads the port's baud rate back from the driver, as
+	// CurrentOptions does. Where the hardware can't do the rate requested
+	// exactly, many drivers report the rate it is actually running at, such
+	// as 256000 for 250000, though some report the rate requested.
+	BaudRate() (uint, error)
+
+	// SetLogger replaces the logger given by OpenOptions.Logger, taking
+	// effect for calls in progress too. A nil logger turns logging off.
+	SetLogger(l *slog.Logger)
+
+	// DumpSettings describes the port's live configuration and modem status
+	// lines for diagnostics, in a form like:
+	//
+	//     /dev/cu.usbserial: 115200 8N1, raw, VMIN=1 VTIME=0, CTS=1 DSR=1 DCD=0 RI=0
+	//
+	// The format is meant for people and may change.
+	DumpSettings() (string, error)
+
+	// DescribeTermios reads the port's termios settings back from the driver
+	// and lists them for bug reports: each of c_iflag, c_oflag, c_cflag and
+	// c_lflag in hex with the names of the flags set, followed by the
+	// control characters, for example:
+	//
+	//     iflag: 0x00000000
+	//     oflag: 0x00000000
+	//     cflag: 0x000018b0 CS8 CREAD CLOCAL BOTHER
+	//     lflag: 0x00000000
+	//     cc: VINTR=^@ ... VSTART=^Q VSTOP=^S ... VMIN=1 VTIME=0
+	//
+	// Like DumpSettings, the format is meant for people and may change. It
+	// returns ErrNotSupported on Windows, which has no termios.
+	DescribeTermios() (string, error)
+
+	// SetMode changes the framing and flow control settings of the open port,
+	// leaving everything else as it is. Output already written is transmitted
+	// with the old settings before the change takes effect. The arguments are
+	// validated as they would be by Open.
+	SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error
+
+	// SetBaudRate changes the baud rate of the open port, leaving everything
+	// else as it is. Like SetMode, it lets output already written be
+	// transmitted at the old rate first.
+	SetBaudRate(baud uint) error
+
+	// WithBaudRate switches the port to baud, runs fn, and switches back to
+	// the previous rate, as some bootloaders need for a block of firmware.
+	// The rate is restored even if fn fails or panics, once fn's output has
+	// been transmitted. It returns fn's error, or else any error restoring
+	// the rate.
+	WithBaudRate(baud uint, fn func() error) error
+
+	// Drain waits until all output written so far has been transmitted.
+	Drain() error
+
+	// Quiesce waits until all output written so far has been transmitted and
+	// then discards any input received but not yet read, giving a clean
+	// boundary between phases of a protocol. No settings or modem lines
+	// change in between. If the drain fails, input is left alone.
+	Quiesce() error
+
+	// WriteNineBit writes b as 9-bit characters whose ninth bit is set if
+	// address is true and clear otherwise, as multidrop protocols use to
+	// mark address bytes. The ninth bit is sent as the parity bit: the port
+	// is switched to mark or space parity once earlier output has drained,
+	// and back again once b has been transmitted, so the port should be
+	// opened with 8 data bits and no parity. Receiving the ninth bit isn't
+	// supported. It returns ErrNotSupported where the driver has no mark or
+	// space parity, which is everywhere but Linux and Windows.
+	WriteNineBit(b []byte, address bool) (int, error)
+
+	// SetReceiverEnabled turns the receiver on or off by setting or clearing
+	// CREAD, for example to check a half-duplex transceiver's direction
+	// control. While it is off the driver discards incoming data, though
+	// some drivers, pseudo-terminals among them, ignore the flag. Ports are
+	// opened with the receiver on. It returns ErrNotSupported on Windows.
+	SetReceiverEnabled(on bool) error
+
+	// PulseDTR lowers DTR for d and then raises it again, as is needed to
+	// reset many development boards. Once DTR is back up, anything received
+	// so far (typically noise or a bootloader banner caused by the reset) is
+	// discarded. The pulse lasts at least d; it may last longer if the
+	// goroutine isn't scheduled promptly. If the device has no modem control
+	// lines, PulseDTR returns ErrNotSupported without waiting.
+	//
+	// Boards such as the Arduino Uno, whose reset pin is capacitively coupled
+	// to DTR, reset on any pulse of a few milliseconds; 50 to 250ms is the
+	// usual range, with 100ms a safe choice. Boards with native USB are reset
+	// with TouchReset instead.
+	PulseDTR(d time.Duration) error
+
+	// PulseRTS is like PulseDTR, for RTS. It conflicts with RTS/CTS flow
+	// control.
+	PulseRTS(d time.Duration) error
+
+	// SetRTS raises or lowers RTS and leaves it that way, for example to
+	// switch the direction of a half-duplex RS-485 transceiver. The line
+	// keeps its state when the port is reconfigured with SetMode or
+	// SetBaudRate. SetRTS fails while RTS/CTS flow control is enabled, since
+	// the driver then drives RTS itself, and returns ErrNotSupported if the
+	// device has no modem control lines.
+	SetRTS(on bool) error
+
+	// SetDTR raises or lowers DTR and leaves it that way. It returns
+	// ErrNotSupported if the device has no modem control lines.
+	SetDTR(on bool) error
+
+	// SetBreak starts or ends a break condition, holding the transmit line
+	// low until it is ended. Output written meanwhile isn't transmitted.
+	SetBreak(on bool) error
+
+	// CarrierDetect reports whether DCD is asserted, as a modem asserts it
+	// while it has a connection. It returns ErrNotSupported if the device
+	// has no modem status lines.
+	CarrierDetect() (bool, error)
+
+	// WriteWithRTS writes b with RTS raised, for RS-485 transceivers whose
+	// direction has to be switched by hand because the kernel's RS485 mode
+	// (Rs485Enable) isn't available. It raises RTS, waits
+	// Rs485DelayRtsBeforeSend milliseconds, writes b, waits for it to be
+	// transmitted as Drain does, waits Rs485DelayRtsAfterSend milliseconds
+	// and then lowers RTS, even if the write failed. Other Writes wait until
+	// it has finished. Some drivers, notably those of USB adapters, consider
+	// output transmitted while the last bytes are still in the adapter, so
+	// the delay after should allow a few character times for them.
+	// WriteWithRTS fails as SetRTS does.
+	WriteWithRTS(b []byte) (int, error)
+
+	// WaitForData waits until there is data to be read or the timeout
+	// elapses, reporting which happened. The data is left for a subsequent
+	// Read. A negative timeout waits indefinitely. Close interrupts
+	// WaitForData, which then returns ErrPortClosed. On POSIX systems the
+	// read deadline also ends the wait, with the same error as Read.
+	WaitForData(timeout time.Duration) (bool, error)
+
+	// BytesAvailable returns the number of bytes that can be read without
+	// waiting, including any held in the read buffer (see ReadBufferSize).
+	BytesAvailable() (int, error)
+
+	// ReadWithTimestamp is like Read, but also returns the time at which the
+	// first of the bytes was received, or the zero time if none were. Serial
+	// drivers don't timestamp input, so this is when the bytes were taken
+	// from the driver: as soon as the driver made them available on POSIX
+	// systems, and when the read completed on Windows. It is still closer to the
+	// arrival time than a timestamp taken by the caller once a Read waiting
+	// for MinimumReadSize bytes returns.
+	ReadWithTimestamp(b []byte) (int, time.Time, error)
+
+	// SetReadDeadline sets a deadline for Read calls, including any that are
+	// currently blocked. A Read that reaches the deadline returns the bytes
+	// received so far and an error satisfying
+	// errors.Is(err, os.ErrDeadlineExceeded) that implements net.Error, with
+	// a Timeout method returning true. The deadline applies in addition to
+	// InterCharacterTimeout. A zero value for t means Read will not time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets a deadline for Write calls, including any that
+	// are currently blocked, for example because the remote end is holding
+	// CTS low. A Write that reaches the deadline returns the number of bytes
+	// handed to the driver and the same kind of error as Read. A zero value
+	// for t means Write will not time out.
+	SetWriteDeadline(t time.Time) error
+}
+
+// Open creates a Port based on the supplied options struct. Its errors can be
+// told apart with errors.Is: os.ErrNotExist if there is no such device,
+// ErrPortBusy if another program has it open (on Linux, the message says
+// which), os.ErrPermission if access is denied, ErrInvalidBaudRate and
+// ErrInvalidDataBits for those settings, and ErrUnsupportedPlatform on
+// systems the package doesn't support.
+//
+// The port's descriptor is close-on-exec (on Windows, its handle isn't
+// inheritable), so child processes don't keep the port busy.
+//
+// On Unix, a PortName that is a named pipe or Unix domain socket is opened
+// with OpenStream instead, so that tests can put a simulated device in
+// place of a real one.
+func Open(options OpenOptions) (Port, error) {
+	return OpenContext(context.Background(), options)
+}
+
+// OpenContext is like Open, but gives up when ctx is done, returning
+// ctx.Err(). Opening and configuring a port normally takes moments, but it
+// can block for much longer, for example with Bluetooth ports on Windows
+// or USB adapters that are still being set up. Since the system calls
+// involved can't be interrupted, the open carries on in the background
+// after OpenContext returns, and the port is closed once it completes.
+func OpenContext(ctx context.Context, options OpenOptions) (Port, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	parent := ctx
+	if options.OpenTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.OpenTimeout)
+		defer cancel()
+	}
+
+	// Without a way of being cancelled there's no point in a goroutine.
+	if ctx.Done() == nil {
+		return openInternal(options)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		port Port
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		p, err := openInternal(options)
+		done <- result{p, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.port, r.err
+
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.port.Close()
+			}
+		}()
+
+		if parent.Err() == nil {
+			return nil, newPortError(options.PortName, "opening", os.ErrDeadlineExceeded)
+		}
+
+		return nil, parent.Err()
+	}
+}
+
+// openError adds a hint about the likely cause to errors from opening the
+// device that users commonly run into, and marks those caused by another
+// program using the port with ErrPortBusy, naming the program where
+// possible. The result still satisfies errors.Is for the original error.
+func openError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w (is the device connected, and is the name right?)", err)
+
+	case errors.Is(err, os.ErrPermission):
+		err = fmt.Errorf("%w (check the permissions on the device, and that no other program is using it)", err)
+		if runtime.GOOS == "windows" {
+			err = markError(err, ErrPortBusy)
+		}
+
+	case errors.Is(err, syscall.EBUSY):
+		hint := "another program has the port open"
+
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			if holders := portHolders(pathErr.Path); len(holders) > 0 {
+				hint = "in use by " + strings.Join(holders, ", ")
+			}
+		}
+
+		err = markError(fmt.Errorf("%w (%s)", err, hint), ErrPortBusy)
+	}
+
+	return err
+}
+
+// OpenFd creates a Port from a file descriptor (a HANDLE on Windows) that was
+// opened elsewhere, for example by a privileged helper process, and applies
+// the supplied options to it. PortName is used only for error messages.
+//
+// The descriptor is duplicated, so the caller remains responsible for closing
+// fd and closing the Port does not affect it. Note that the two share their
+// open file description, including the terminal settings and, on POSIX
+// systems, the O_NONBLOCK flag, which OpenFd sets. On Windows the handle must
+// have been opened with FILE_FLAG_OVERLAPPED. The duplicate is close-on-exec
+// whether or not fd is.
+func OpenFd(fd uintptr, options OpenOptions) (Port, error) {
+	if err := options.validate(false); err != nil {
+		return nil, err
+	}
+
+	return openFdInternal(fd, options)
+}
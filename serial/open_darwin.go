@@ -27,7 +27,8 @@ package serial
 
 import (
 	"errors"
-	"io"
+	"fmt"
+	"math"
 )
 import "os"
 import "syscall"
@@ -46,6 +47,7 @@ const (
 	kCS8        = 0x00000300
 	kCLOCAL     = 0x00008000
 	kCREAD      = 0x00000800
+	kHUPCL      = 0x00004000
 	kCSTOPB     = 0x00000400
 	kIGNPAR     = 0x00000004
 	kPARENB     = 0x00001000
@@ -53,23 +55,78 @@ const (
 	kCCTS_OFLOW = 0x00010000
 	kCRTS_IFLOW = 0x00020000
 	kCRTSCTS    = kCCTS_OFLOW | kCRTS_IFLOW
+	kCDTR_IFLOW = 0x00040000
+	kCDSR_OFLOW = 0x00080000
+	kCCAR_OFLOW = 0x00100000
+	kCSIZE      = 0x00000300
+	kINPCK      = 0x00000010
+	kPARMRK     = 0x00000008
+	kISTRIP     = 0x00000020
+	kINLCR      = 0x00000040
+	kIGNCR      = 0x00000080
+	kICRNL      = 0x00000100
+	kOPOST      = 0x00000001
+	kECHO       = 0x00000008
+	kISIG       = 0x00000080
+	kICANON     = 0x00000100
 
 	kNCCS = 20
 
-	kVMIN  = tcflag_t(16)
-	kVTIME = tcflag_t(17)
+	kVDSUSP  = 11
+	kVMIN    = tcflag_t(16)
+	kVTIME   = tcflag_t(17)
+	kVSTATUS = 18
+)
+
+// Flow control characters.
+const (
+	kXON  = 0x11
+	kXOFF = 0x13
 )
 
 const (
 
 	// sys/ttycom.h
-	kTIOCGETA = 1078490131
-	kTIOCSETA = 2152231956
+	kTIOCGETA  = 1078490131
+	kTIOCSETA  = 2152231956
+	kTIOCSETAW = 2152231957
+
+	// sys/filio.h
+	kFIONREAD = 0x4004667f
 
 	// IOKit: serial/ioss.h
 	kIOSSIOSPEED = 0x80045402
 )
 
+// Glob patterns matching the device nodes of serial ports. Only the callout
+// devices are listed, since the tty.* devices block in open until DCD is
+// raised.
+var portPatterns = []string{
+	"/dev/cu.*",
+}
+
+// Termios flags and control characters specific to OS X, for
+// DescribeTermios.
+var osTermiosNames = termiosNames{
+	cflag: []flagName{
+		bitFlag(kCCTS_OFLOW, "CCTS_OFLOW"),
+		bitFlag(kCRTS_IFLOW, "CRTS_IFLOW"),
+		bitFlag(kCDTR_IFLOW, "CDTR_IFLOW"),
+		bitFlag(kCDSR_OFLOW, "CDSR_OFLOW"),
+		bitFlag(kCCAR_OFLOW, "CCAR_OFLOW"),
+	},
+	cc: []ccName{
+		{kVDSUSP, "VDSUSP"},
+		{kVSTATUS, "VSTATUS"},
+	},
+}
+
+// isPhantomPort reports whether a device node has no port behind it, which
+// doesn't happen on OS X.
+func isPhantomPort(name string) bool {
+	return false
+}
+
 // sys/termios.h
 type termios struct {
 	c_iflag  tcflag_t
@@ -85,33 +142,123 @@ type termios struct {
 // descriptor. This sets appropriate options for how the OS interacts with the
 // port.
 func setTermios(fd uintptr, src *termios) error {
-	// Make the ioctl syscall that sets the termios struct.
-	r1, _, errno :=
-		syscall.Syscall(
-			syscall.SYS_IOCTL,
-			fd,
-			uintptr(kTIOCSETA),
-			uintptr(unsafe.Pointer(src)))
+	return termiosIoctl(fd, kTIOCSETA, src)
+}
 
-	// Did the syscall return an error?
-	if errno != 0 {
-		return os.NewSyscallError("SYS_IOCTL", errno)
+// getTermios reads the termios struct associated with a serial port file
+// descriptor.
+func getTermios(fd uintptr) (*termios, error) {
+	var result termios
+	if err := termiosIoctl(fd, kTIOCGETA, &result); err != nil {
+		return nil, err
 	}
 
-	// Just in case, check the return value as well.
-	if r1 != 0 {
-		return errors.New("Unknown error from SYS_IOCTL.")
+	return &result, nil
+}
+
+// termiosIoctlNames names the requests termiosIoctl makes, for errors.
+var termiosIoctlNames = map[uint]string{
+	kTIOCGETA:  "TIOCGETA",
+	kTIOCSETA:  "TIOCSETA",
+	kTIOCSETAW: "TIOCSETAW",
+}
+
+// termiosIoctl makes an ioctl syscall that reads or writes a termios struct.
+func termiosIoctl(fd uintptr, req uint, t *termios) error {
+	if err := sys.ioctl(fd, req, unsafe.Pointer(t)); err != nil {
+		return os.NewSyscallError(termiosIoctlNames[req], err)
 	}
 
 	return nil
 }
 
+// termiosSpeed returns the speed to put in the termios struct for baudRate,
+// and whether setBaudRate must then set the real rate. The drivers only
+// accept the standard rates through termios; anything else, such as 921600,
+// is set with the IOSSIOSPEED ioctl, with a standard rate as a placeholder
+// until then.
+func termiosSpeed(baudRate uint) (speed_t, bool) {
+	if IsStandardBaudRate(baudRate) {
+		return speed_t(baudRate), false
+	}
+
+	return 14400, true
+}
+
+// How far the speed a driver reports may be from the rate asked for. Many
+// report the rate the hardware actually runs at, such as 256000 for 250000;
+// one further off means the request was ignored.
+const baudRateTolerance = 0.03
+
+// setBaudRate finishes setting the baud rate once the termios struct from
+// convertOptions has been applied: rates that termiosSpeed couldn't put in
+// the struct are set with the IOSSIOSPEED ioctl. Either way the speed is
+// then read back, since some drivers accept a rate without error and carry
+// on at the old one or at the placeholder.
+func setBaudRate(fd uintptr, baudRate uint) error {
+	placeholder, custom := termiosSpeed(baudRate)
+	if custom {
+		speed := speed_t(baudRate)
+		if err := sys.ioctl(fd, kIOSSIOSPEED, unsafe.Pointer(&speed)); err != nil {
+			return os.NewSyscallError("IOSSIOSPEED", err)
+		}
+	}
+
+	t, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+
+	got, want := t.c_ospeed, speed_t(baudRate)
+	off := math.Abs(float64(got)-float64(want)) > baudRateTolerance*float64(want)
+	if got != want && (off || custom && got == placeholder) {
+		err := fmt.Errorf("serial: driver ignored baud rate %d and is running at %d", baudRate, t.c_ospeed)
+		return markError(err, ErrInvalidBaudRate)
+	}
+
+	return nil
+}
+
+// setMode updates the speed, framing and flow control settings of the port to
+// match options, waiting for pending output to drain first. Other settings
+// are left as they are.
+func setMode(fd uintptr, options OpenOptions) error {
+	want, err := convertOptions(options)
+	if err != nil {
+		return err
+	}
+
+	t, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+
+	const cmask = kCSIZE | kCSTOPB | kPARENB | kPARODD | kCRTSCTS
+	const imask = kINPCK | kIGNPAR | kPARMRK
+
+	t.c_cflag = t.c_cflag&^cmask | want.c_cflag&cmask
+	t.c_iflag = t.c_iflag&^imask | want.c_iflag&imask
+
+	// The driver may report a non-standard speed that it won't accept back.
+	t.c_ispeed = want.c_ispeed
+	t.c_ospeed = want.c_ospeed
+
+	if err := termiosIoctl(fd, kTIOCSETAW, t); err != nil {
+		return err
+	}
+
+	return setBaudRate(fd, options.BaudRate)
+}
+
 func convertOptions(options OpenOptions) (*termios, error) {
 	var result termios
 
 	// Ignore modem status lines. We don't want to receive SIGHUP when the serial
-	// port is disconnected, for example.
-	result.c_cflag |= kCLOCAL
+	// port is disconnected, for example. A modem that should hang up on loss
+	// of carrier asks for them with HonorModemStatusLines.
+	if !options.HonorModemStatusLines {
+		result.c_cflag |= kCLOCAL
+	}
 
 	// Enable receiving data.
 	//
@@ -123,7 +270,7 @@ func convertOptions(options OpenOptions) (*termios, error) {
 	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
 	vmin := options.MinimumReadSize
 
-	if vmin == 0 && vtime < 100 {
+	if vmin == 0 && vtime < 100 && options.OverallReadTimeout == 0 {
 		return nil, errors.New("Invalid values for InterCharacterTimeout and MinimumReadSize.")
 	}
 
@@ -131,20 +278,20 @@ func convertOptions(options OpenOptions) (*termios, error) {
 		return nil, errors.New("Invalid value for InterCharacterTimeout.")
 	}
 
+	// With VMIN and VTIME both zero, read(2) returns nothing at once even on
+	// a non-blocking descriptor. OverallReadTimeout is enforced by the
+	// package, so have the driver wait for a byte.
+	if vmin == 0 && vtime == 0 {
+		vmin = 1
+	}
+
 	// Set VMIN and VTIME. Make sure to convert to tenths of seconds for VTIME.
 	result.c_cc[kVTIME] = cc_t(vtime / 100)
 	result.c_cc[kVMIN] = cc_t(vmin)
 
-	if !IsStandardBaudRate(options.BaudRate) {
-		// Non-standard baud-rates cannot be set via the standard IOCTL.
-		//
-		// Set an arbitrary baudrate. We'll set the real one later.
-		result.c_ispeed = 14400
-		result.c_ospeed = 14400
-	} else {
-		result.c_ispeed = speed_t(options.BaudRate)
-		result.c_ospeed = speed_t(options.BaudRate)
-	}
+	speed, _ := termiosSpeed(options.BaudRate)
+	result.c_ispeed = speed
+	result.c_ospeed = speed
 
 	// Data bits
 	switch options.DataBits {
@@ -157,7 +304,7 @@ func convertOptions(options OpenOptions) (*termios, error) {
 	case 8:
 		result.c_cflag |= kCS8
 	default:
-		return nil, errors.New("Invalid setting for DataBits.")
+		return nil, markError(errors.New("Invalid setting for DataBits."), ErrInvalidDataBits)
 	}
 
 	// Stop bits
@@ -193,66 +340,210 @@ func convertOptions(options OpenOptions) (*termios, error) {
 		result.c_cflag |= kCRTSCTS
 	}
 
+	if options.HangupOnClose {
+		result.c_cflag |= kHUPCL
+	}
+
+	// Input processing. There's no IUTF8 to go with UTF8Input.
+	if options.StripHighBit {
+		result.c_iflag |= kISTRIP
+	}
+
+	if options.MapCRToNL {
+		result.c_iflag |= kICRNL
+	}
+
+	if options.MapNLToCR {
+		result.c_iflag |= kINLCR
+	}
+
+	if options.IgnoreCR {
+		result.c_iflag |= kIGNCR
+	}
+
+	// c_oflag stays zero: without OPOST, writes reach the wire untouched.
+
 	return &result, nil
 }
 
-func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
-	// Open the serial port in non-blocking mode, since otherwise the OS will
-	// wait for the CARRIER line to be asserted.
-	file, err :=
-		os.OpenFile(
-			options.PortName,
-			syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
-			0600)
+// OS X has no ioctl for sending XON and XOFF, so Pause and Resume write the
+// characters like any other data, just as tcflow does.
+var flowControlByWrite = true
+
+// sendFlowControl isn't used; see flowControlByWrite.
+func sendFlowControl(fd uintptr, stop bool) error {
+	return ErrNotSupported
+}
+
+// driverInputQueue is zero, since the driver doesn't report its input
+// queue's size.
+const driverInputQueue = 0
 
+// getErrorCounters always fails, since there's no TIOCGICOUNT equivalent.
+func getErrorCounters(fd uintptr) (ErrorCounters, error) {
+	return ErrorCounters{}, ErrNotSupported
+}
+
+// readTermios reads the port's termios settings back from the driver. With
+// IOSSIOSPEED the driver reports the custom speed in c_ospeed.
+func readTermios(fd uintptr) (termiosState, error) {
+	t, err := getTermios(fd)
+	if err != nil {
+		return termiosState{}, err
+	}
+
+	ts := termiosState{
+		baudRate: uint(t.c_ospeed),
+		stopBits: 1,
+		rtscts:   t.c_cflag&kCRTSCTS != 0,
+		raw:      t.c_lflag&(kICANON|kECHO|kISIG) == 0 && t.c_oflag&kOPOST == 0,
+		noRecv:   t.c_cflag&kCREAD == 0,
+		vmin:     uint8(t.c_cc[kVMIN]),
+		vtime:    uint8(t.c_cc[kVTIME]),
+	}
+
+	switch t.c_cflag & kCSIZE {
+	case kCS5:
+		ts.dataBits = 5
+	case kCS6:
+		ts.dataBits = 6
+	case kCS7:
+		ts.dataBits = 7
+	case kCS8:
+		ts.dataBits = 8
+	}
+
+	if t.c_cflag&kCSTOPB != 0 {
+		ts.stopBits = 2
+	}
+
+	if t.c_cflag&kPARENB != 0 {
+		ts.parityMode = PARITY_EVEN
+		if t.c_cflag&kPARODD != 0 {
+			ts.parityMode = PARITY_ODD
+		}
+	}
+
+	return ts, nil
+}
+
+// setLowLatency always fails, since the driver has no low-latency flag.
+func setLowLatency(fd uintptr, on bool) error {
+	return ErrNotSupported
+}
+
+// setStickParity always fails, since the driver has no mark or space parity.
+func setStickParity(fd uintptr, mark bool) (func(fd uintptr) error, error) {
+	return nil, ErrNotSupported
+}
+
+// newTermios copies t into a Termios.
+func newTermios(t *termios) *Termios {
+	rt := &Termios{
+		iflag: uint64(t.c_iflag),
+		oflag: uint64(t.c_oflag),
+		cflag: uint64(t.c_cflag),
+		lflag: uint64(t.c_lflag),
+		cc:    make([]byte, kNCCS),
+	}
+	for i, c := range t.c_cc {
+		rt.cc[i] = byte(c)
+	}
+
+	return rt
+}
+
+// readRawTermios reads the port's termios settings back from the driver.
+func readRawTermios(fd uintptr) (*Termios, error) {
+	t, err := getTermios(fd)
 	if err != nil {
 		return nil, err
 	}
 
-	// We want to do blocking I/O, so clear the non-blocking flag set above.
-	r1, _, errno :=
-		syscall.Syscall(
-			syscall.SYS_FCNTL,
-			uintptr(file.Fd()),
-			uintptr(syscall.F_SETFL),
-			uintptr(0))
+	return newTermios(t), nil
+}
 
-	if errno != 0 {
-		return nil, os.NewSyscallError("SYS_FCNTL", errno)
+// applyRawConfig lets options.RawConfig adjust t.
+func applyRawConfig(options OpenOptions, t *termios) error {
+	if options.RawConfig == nil {
+		return nil
 	}
 
-	if r1 != 0 {
-		return nil, errors.New("Unknown error from SYS_FCNTL.")
+	rt := newTermios(t)
+	if err := options.RawConfig(rt); err != nil {
+		return err
 	}
 
+	t.c_iflag = tcflag_t(rt.iflag)
+	t.c_oflag = tcflag_t(rt.oflag)
+	t.c_cflag = tcflag_t(rt.cflag)
+	t.c_lflag = tcflag_t(rt.lflag)
+	for i, c := range rt.cc {
+		t.c_cc[i] = cc_t(c)
+	}
+
+	if options.RawOutput {
+		t.c_oflag &^= kOPOST
+	}
+
+	return nil
+}
+
+// flushInput discards data received but not yet read.
+func flushInput(fd uintptr) error {
+	const FREAD = 0x1
+	which := FREAD
+
+	if err := sys.ioctl(fd, syscall.TIOCFLUSH, unsafe.Pointer(&which)); err != nil {
+		return os.NewSyscallError("TIOCFLUSH", err)
+	}
+
+	return nil
+}
+
+// setReceiver sets or clears CREAD.
+func setReceiver(fd uintptr, on bool) error {
+	t, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+
+	if on {
+		t.c_cflag |= kCREAD
+	} else {
+		t.c_cflag &^= kCREAD
+	}
+
+	return setTermios(fd, t)
+}
+
+// drainOutput waits until the output written so far has been transmitted.
+func drainOutput(fd uintptr) error {
+	if err := sys.ioctl(fd, syscall.TIOCDRAIN, nil); err != nil {
+		return os.NewSyscallError("TIOCDRAIN", err)
+	}
+
+	return nil
+}
+
+// configureFunc validates options and returns a function that applies them
+// to a port's file descriptor.
+func configureFunc(options OpenOptions) (func(fd uintptr) error, error) {
 	// Set standard termios options.
 	terminalOptions, err := convertOptions(options)
 	if err != nil {
 		return nil, err
 	}
 
-	err = setTermios(file.Fd(), terminalOptions)
-	if err != nil {
+	if err := applyRawConfig(options, terminalOptions); err != nil {
 		return nil, err
 	}
 
-	if !IsStandardBaudRate(options.BaudRate) {
-		// Set baud rate with the IOSSIOSPEED ioctl, to support non-standard speeds.
-		r2, _, errno2 := syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(file.Fd()),
-			uintptr(kIOSSIOSPEED),
-			uintptr(unsafe.Pointer(&options.BaudRate)))
-
-		if errno2 != 0 {
-			return nil, os.NewSyscallError("SYS_IOCTL", errno2)
+	return func(fd uintptr) error {
+		if err := setTermios(fd, terminalOptions); err != nil {
+			return err
 		}
 
-		if r2 != 0 {
-			return nil, errors.New("Unknown error from SYS_IOCTL.")
-		}
-	}
-
-	// We're done.
-	return file, nil
+		return setBaudRate(fd, options.BaudRate)
+	}, nil
 }
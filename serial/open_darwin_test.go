@@ -0,0 +1,250 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenRequests(t *testing.T) {
+	// Every open switches the descriptor to blocking mode, since the fake's
+	// files can't be polled, then applies the termios struct and reads the
+	// speed back.
+	blocking := []sysCall{
+		{"open", 0},
+		{"fcntl", unix.F_GETFL},
+		{"fcntl", unix.F_SETFL},
+		{"ioctl", kTIOCSETA},
+	}
+	readBack := sysCall{"ioctl", kTIOCGETA}
+
+	testCases := []struct {
+		name    string
+		options OpenOptions
+		calls   []sysCall
+		cflag   tcflag_t
+		iflag   tcflag_t
+		speed   speed_t
+		custom  speed_t
+		vmin    cc_t
+		vtime   cc_t
+	}{
+		{
+			name: "8N1",
+			options: OpenOptions{
+				BaudRate:              115200,
+				DataBits:              8,
+				StopBits:              1,
+				InterCharacterTimeout: 100,
+			},
+			calls: append(blocking, readBack),
+			cflag: kCLOCAL | kCREAD | kCS8,
+			speed: 115200,
+			vtime: 1,
+		},
+		{
+			name: "7E2 with flow control",
+			options: OpenOptions{
+				BaudRate:          19200,
+				DataBits:          7,
+				StopBits:          2,
+				ParityMode:        PARITY_EVEN,
+				RTSCTSFlowControl: true,
+				HangupOnClose:     true,
+				MinimumReadSize:   4,
+			},
+			calls: append(blocking, readBack),
+			cflag: kCLOCAL | kCREAD | kCS7 | kCSTOPB | kPARENB | kCRTSCTS | kHUPCL,
+			speed: 19200,
+			vmin:  4,
+		},
+		{
+			name: "custom speed",
+			options: OpenOptions{
+				BaudRate:        250000,
+				DataBits:        8,
+				StopBits:        1,
+				ParityMode:      PARITY_ODD,
+				MinimumReadSize: 1,
+			},
+			calls:  append(blocking, sysCall{"ioctl", kIOSSIOSPEED}, readBack),
+			cflag:  kCLOCAL | kCREAD | kCS8 | kPARENB | kPARODD,
+			speed:  14400,
+			custom: 250000,
+			vmin:   1,
+		},
+		{
+			name: "terminal input",
+			options: OpenOptions{
+				BaudRate:        9600,
+				DataBits:        8,
+				StopBits:        1,
+				MinimumReadSize: 1,
+				MapNLToCR:       true,
+				IgnoreCR:        true,
+			},
+			calls: append(blocking, readBack),
+			cflag: kCLOCAL | kCREAD | kCS8,
+			iflag: kINLCR | kIGNCR,
+			speed: 9600,
+			vmin:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := useFakeSys(t)
+
+			// Behave like a driver: the custom speed is reported by later
+			// reads of the termios struct.
+			var tios, driver termios
+			var custom speed_t
+			f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+				switch req {
+				case kTIOCSETA:
+					tios = *(*termios)(arg)
+					driver = tios
+				case kIOSSIOSPEED:
+					custom = *(*speed_t)(arg)
+					driver.c_ispeed, driver.c_ospeed = custom, custom
+				case kTIOCGETA:
+					*(*termios)(arg) = driver
+				}
+				return nil
+			}
+
+			options := tc.options
+			options.PortName = "/dev/cu.usbserial"
+
+			port, err := Open(options)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer port.Close()
+
+			checkCalls(t, f.Calls(), tc.calls)
+
+			if tios.c_cflag != tc.cflag {
+				t.Errorf("c_cflag: got %#x, want %#x", tios.c_cflag, tc.cflag)
+			}
+
+			if tios.c_iflag != tc.iflag {
+				t.Errorf("c_iflag: got %#x, want %#x", tios.c_iflag, tc.iflag)
+			}
+
+			if tios.c_ispeed != tc.speed || tios.c_ospeed != tc.speed {
+				t.Errorf("speeds: got %d/%d, want %d", tios.c_ispeed, tios.c_ospeed, tc.speed)
+			}
+
+			if custom != tc.custom {
+				t.Errorf("IOSSIOSPEED: got %d, want %d", custom, tc.custom)
+			}
+
+			if tios.c_cc[kVMIN] != tc.vmin || tios.c_cc[kVTIME] != tc.vtime {
+				t.Errorf(
+					"VMIN/VTIME: got %d/%d, want %d/%d",
+					tios.c_cc[kVMIN], tios.c_cc[kVTIME], tc.vmin, tc.vtime)
+			}
+		})
+	}
+}
+
+func TestIgnoredBaudRate(t *testing.T) {
+	// A driver that accepts IOSSIOSPEED but stays at the placeholder speed.
+	f := useFakeSys(t)
+
+	var driver termios
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		switch req {
+		case kTIOCSETA:
+			driver = *(*termios)(arg)
+		case kTIOCGETA:
+			*(*termios)(arg) = driver
+		}
+		return nil
+	}
+
+	_, err := Open(OpenOptions{
+		PortName:        "/dev/cu.usbserial",
+		BaudRate:        250000,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err == nil || !strings.Contains(err.Error(), "ignored baud rate 250000") {
+		t.Errorf("expected the ignored rate to be reported, got %v", err)
+	}
+}
+
+func TestRoundedBaudRate(t *testing.T) {
+	// A driver that runs at the nearest rate the hardware manages, and
+	// reports that.
+	for _, tc := range []struct {
+		baud, actual uint
+		ok           bool
+	}{
+		{250000, 256000, true},
+		{250000, 300000, false},
+	} {
+		f := useFakeSys(t)
+
+		var driver termios
+		f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+			switch req {
+			case kTIOCSETA:
+				driver = *(*termios)(arg)
+			case kIOSSIOSPEED:
+				driver.c_ispeed, driver.c_ospeed = speed_t(tc.actual), speed_t(tc.actual)
+			case kTIOCGETA:
+				*(*termios)(arg) = driver
+			}
+			return nil
+		}
+
+		port, err := Open(OpenOptions{
+			PortName:        "/dev/cu.usbserial",
+			BaudRate:        tc.baud,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+		})
+
+		if !tc.ok {
+			if !errors.Is(err, ErrInvalidBaudRate) {
+				t.Errorf("%d running at %d: expected ErrInvalidBaudRate, got %v", tc.baud, tc.actual, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%d running at %d: %v", tc.baud, tc.actual, err)
+		}
+
+		if baud, err := port.BaudRate(); err != nil || baud != tc.actual {
+			t.Errorf("BaudRate: got %d, %v; want %d", baud, err, tc.actual)
+		}
+		port.Close()
+	}
+}
+
+// fakeErrorCounters skips the test: OS X drivers don't count line errors.
+func fakeErrorCounters(t *testing.T, f *fakeSys, c *ErrorCounters) {
+	t.Skip("no error counters on OS X")
+}
@@ -14,8 +14,18 @@
 
 package serial
 
-import "io"
+func openInternal(options OpenOptions) (Port, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+func openFdInternal(fd uintptr, options OpenOptions) (Port, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+func portReady(name string) error {
+	return ErrUnsupportedPlatform
+}
 
-func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
-	return nil, "Not implemented on this OS."
+func portNames() ([]string, error) {
+	return nil, ErrUnsupportedPlatform
 }
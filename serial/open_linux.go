@@ -2,7 +2,6 @@ package serial
 
 import (
 	"errors"
-	"io"
 	"os"
 	"syscall"
 	"unsafe"
@@ -30,6 +29,37 @@ const (
 	kNCCS    = 19
 )
 
+const kFIONREAD = unix.TIOCINQ
+
+// Glob patterns matching the device nodes of serial ports.
+var portPatterns = []string{
+	"/dev/ttyS*",
+	"/dev/ttyUSB*",
+	"/dev/ttyACM*",
+	"/dev/ttyAMA*",
+	"/dev/rfcomm*",
+}
+
+// Termios flags and control characters specific to Linux, for
+// DescribeTermios.
+var osTermiosNames = termiosNames{
+	iflag: []flagName{
+		bitFlag(unix.IUCLC, "IUCLC"),
+		bitFlag(unix.IUTF8, "IUTF8"),
+	},
+	oflag: []flagName{
+		bitFlag(unix.OLCUC, "OLCUC"),
+	},
+	cflag: []flagName{
+		bitFlag(unix.CRTSCTS, "CRTSCTS"),
+		bitFlag(unix.CMSPAR, "CMSPAR"),
+		{unix.CBAUD, kBOTHER, "BOTHER"},
+	},
+	lflag: []flagName{
+		bitFlag(unix.EXTPROC, "EXTPROC"),
+	},
+}
+
 //
 // Types from asm-generic/termbits.h
 //
@@ -65,6 +95,40 @@ type serial_rs485 struct {
 	padding               [5]uint32
 }
 
+// Interrupt counters from linux/serial.h, as returned by TIOCGICOUNT.
+type serial_icounter_struct struct {
+	cts, dsr, rng, dcd int32
+	rx, tx             int32
+	frame, overrun     int32
+	parity, brk        int32
+	buf_overrun        int32
+	reserved           [9]int32
+}
+
+// Line settings from linux/serial.h, as used by TIOCGSERIAL and TIOCSSERIAL.
+type serial_struct struct {
+	typ             int32
+	line            int32
+	port            uint32
+	irq             int32
+	flags           int32
+	xmit_fifo_size  int32
+	custom_divisor  int32
+	baud_base       int32
+	close_delay     uint16
+	io_type         byte
+	reserved_char   [1]byte
+	hub6            int32
+	closing_wait    uint16
+	closing_wait2   uint16
+	iomem_base      uintptr
+	iomem_reg_shift uint16
+	port_high       uint32
+	iomap_base      uintptr
+}
+
+const aSYNC_LOW_LATENCY = 1 << 13
+
 //
 // Returns a pointer to an instantiates termios2 struct, based on the given
 // OpenOptions. Termios2 is a Linux extension which allows arbitrary baud rates
@@ -77,7 +141,7 @@ func makeTermios2(options OpenOptions) (*termios2, error) {
 	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
 	vmin := options.MinimumReadSize
 
-	if vmin == 0 && vtime < 100 {
+	if vmin == 0 && vtime < 100 && options.OverallReadTimeout == 0 {
 		return nil, errors.New("invalid values for InterCharacterTimeout and MinimumReadSize")
 	}
 
@@ -85,10 +149,21 @@ func makeTermios2(options OpenOptions) (*termios2, error) {
 		return nil, errors.New("invalid value for InterCharacterTimeout")
 	}
 
+	// With VMIN and VTIME both zero, read(2) returns nothing at once even on
+	// a non-blocking descriptor. OverallReadTimeout is enforced by the
+	// package, so have the driver wait for a byte.
+	if vmin == 0 && vtime == 0 {
+		vmin = 1
+	}
+
 	ccOpts := [kNCCS]cc_t{}
 	ccOpts[syscall.VTIME] = cc_t(vtime / 100)
 	ccOpts[syscall.VMIN] = cc_t(vmin)
 
+	// Make the flow control characters available to TCXONC.
+	ccOpts[unix.VSTART] = 0x11
+	ccOpts[unix.VSTOP] = 0x13
+
 	t2 := &termios2{
 		c_cflag:  syscall.CLOCAL | syscall.CREAD | kBOTHER,
 		c_ispeed: speed_t(options.BaudRate),
@@ -128,82 +203,367 @@ func makeTermios2(options OpenOptions) (*termios2, error) {
 	case 8:
 		t2.c_cflag |= syscall.CS8
 	default:
-		return nil, errors.New("invalid setting for DataBits")
+		return nil, markError(errors.New("invalid setting for DataBits"), ErrInvalidDataBits)
 	}
 
 	if options.RTSCTSFlowControl {
 		t2.c_cflag |= unix.CRTSCTS
 	}
 
+	if options.HangupOnClose {
+		t2.c_cflag |= syscall.HUPCL
+	}
+
+	if options.HonorModemStatusLines {
+		t2.c_cflag &^= syscall.CLOCAL
+	}
+
+	if options.StripHighBit {
+		t2.c_iflag |= syscall.ISTRIP
+	}
+
+	if options.MapCRToNL {
+		t2.c_iflag |= syscall.ICRNL
+	}
+
+	if options.MapNLToCR {
+		t2.c_iflag |= syscall.INLCR
+	}
+
+	if options.IgnoreCR {
+		t2.c_iflag |= syscall.IGNCR
+	}
+
+	if options.UTF8Input {
+		t2.c_iflag |= unix.IUTF8
+	}
+
+	return t2, nil
+}
+
+// setTermios2 applies a termios2 struct to the given file descriptor.
+func setTermios2(fd uintptr, t2 *termios2) error {
+	if err := sys.ioctl(fd, kTCSETS2, unsafe.Pointer(t2)); err != nil {
+		return os.NewSyscallError("TCSETS2", err)
+	}
+
+	return nil
+}
+
+// getTermios2 reads the termios2 struct associated with a file descriptor.
+func getTermios2(fd uintptr) (*termios2, error) {
+	t2 := &termios2{}
+
+	if err := sys.ioctl(fd, unix.TCGETS2, unsafe.Pointer(t2)); err != nil {
+		return nil, os.NewSyscallError("TCGETS2", err)
+	}
+
 	return t2, nil
 }
 
-func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
+// setMode updates the speed, framing and flow control settings of the port to
+// match options, waiting for pending output to drain first. Other settings
+// are left as they are.
+func setMode(fd uintptr, options OpenOptions) error {
+	want, err := makeTermios2(options)
+	if err != nil {
+		return err
+	}
 
-	file, openErr :=
-		os.OpenFile(
-			options.PortName,
-			syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK,
-			0600)
-	if openErr != nil {
-		return nil, openErr
+	t2, err := getTermios2(fd)
+	if err != nil {
+		return err
 	}
 
-	// Clear the non-blocking flag set above.
-	nonblockErr := syscall.SetNonblock(int(file.Fd()), false)
-	if nonblockErr != nil {
-		return nil, nonblockErr
+	const cmask = syscall.CSIZE | syscall.CSTOPB | syscall.PARENB | syscall.PARODD | unix.CRTSCTS | unix.CBAUD | unix.CIBAUD
+	const imask = syscall.INPCK | syscall.IGNPAR | syscall.PARMRK
+
+	t2.c_cflag = t2.c_cflag&^cmask | want.c_cflag&cmask
+	t2.c_iflag = t2.c_iflag&^imask | want.c_iflag&imask
+	t2.c_ispeed = want.c_ispeed
+	t2.c_ospeed = want.c_ospeed
+
+	return setTermios2Drained(fd, t2)
+}
+
+// setTermios2Drained is like setTermios2, but waits for pending output to
+// drain first.
+func setTermios2Drained(fd uintptr, t2 *termios2) error {
+	if err := sys.ioctl(fd, unix.TCSETSW2, unsafe.Pointer(t2)); err != nil {
+		return os.NewSyscallError("TCSETSW2", err)
 	}
 
-	t2, optErr := makeTermios2(options)
-	if optErr != nil {
-		return nil, optErr
+	return nil
+}
+
+// setStickParity switches the port to mark parity if mark is set and to
+// space parity otherwise, once pending output has drained. It returns a
+// function that, again once output has drained, puts back the settings
+// that were replaced.
+func setStickParity(fd uintptr, mark bool) (func(fd uintptr) error, error) {
+	t2, err := getTermios2(fd)
+	if err != nil {
+		return nil, err
 	}
 
-	r, _, errno := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		uintptr(file.Fd()),
-		uintptr(kTCSETS2),
-		uintptr(unsafe.Pointer(t2)))
+	saved := *t2
 
-	if errno != 0 {
-		return nil, os.NewSyscallError("SYS_IOCTL", errno)
+	// With CMSPAR, PARODD selects mark parity rather than odd.
+	t2.c_cflag |= syscall.PARENB | unix.CMSPAR
+	if mark {
+		t2.c_cflag |= syscall.PARODD
+	} else {
+		t2.c_cflag &^= syscall.PARODD
 	}
 
-	if r != 0 {
-		return nil, errors.New("unknown error from SYS_IOCTL")
+	if err := setTermios2Drained(fd, t2); err != nil {
+		return nil, err
 	}
 
-	if options.Rs485Enable {
-		rs485 := serial_rs485{
-			sER_RS485_ENABLED,
-			uint32(options.Rs485DelayRtsBeforeSend),
-			uint32(options.Rs485DelayRtsAfterSend),
-			[5]uint32{0, 0, 0, 0, 0},
-		}
+	return func(fd uintptr) error { return setTermios2Drained(fd, &saved) }, nil
+}
 
-		if options.Rs485RtsHighDuringSend {
-			rs485.flags |= sER_RS485_RTS_ON_SEND
-		}
+// setRS485 enables the kernel's RS485 mode on the given file descriptor.
+func setRS485(fd uintptr, options OpenOptions) error {
+	rs485 := serial_rs485{
+		sER_RS485_ENABLED,
+		uint32(options.Rs485DelayRtsBeforeSend),
+		uint32(options.Rs485DelayRtsAfterSend),
+		[5]uint32{0, 0, 0, 0, 0},
+	}
+
+	if options.Rs485RtsHighDuringSend {
+		rs485.flags |= sER_RS485_RTS_ON_SEND
+	}
+
+	if options.Rs485RtsHighAfterSend {
+		rs485.flags |= sER_RS485_RTS_AFTER_SEND
+	}
+
+	if err := sys.ioctl(fd, tIOCSRS485, unsafe.Pointer(&rs485)); err != nil {
+		return os.NewSyscallError("RS485", err)
+	}
+
+	return nil
+}
+
+// The driver sends XON and XOFF itself, ahead of any queued output, so
+// Pause and Resume use sendFlowControl. A variable for the tests.
+var flowControlByWrite = false
+
+// sendFlowControl sends XOFF if stop is true and XON otherwise.
+func sendFlowControl(fd uintptr, stop bool) error {
+	action := unix.TCION
+	if stop {
+		action = unix.TCIOFF
+	}
+
+	if err := unix.IoctlSetInt(int(fd), unix.TCXONC, action); err != nil {
+		return os.NewSyscallError("TCXONC", err)
+	}
+
+	return nil
+}
+
+// driverInputQueue is the size of the N_TTY line discipline's read buffer,
+// N_TTY_BUF_SIZE, which the kernel doesn't let be changed.
+const driverInputQueue = 4096
 
-		if options.Rs485RtsHighAfterSend {
-			rs485.flags |= sER_RS485_RTS_AFTER_SEND
+// getErrorCounters reads the driver's interrupt counters. These count from
+// when the driver was loaded, not from when the port was opened.
+func getErrorCounters(fd uintptr) (ErrorCounters, error) {
+	var ic serial_icounter_struct
+
+	if err := sys.ioctl(fd, unix.TIOCGICOUNT, unsafe.Pointer(&ic)); err != nil {
+		return ErrorCounters{}, os.NewSyscallError("TIOCGICOUNT", err)
+	}
+
+	return ErrorCounters{
+		Framing:       uint64(uint32(ic.frame)),
+		Parity:        uint64(uint32(ic.parity)),
+		Overrun:       uint64(uint32(ic.overrun)),
+		BufferOverrun: uint64(uint32(ic.buf_overrun)),
+		Break:         uint64(uint32(ic.brk)),
+	}, nil
+}
+
+// readTermios reads the port's termios settings back from the driver.
+func readTermios(fd uintptr) (termiosState, error) {
+	t2, err := getTermios2(fd)
+	if err != nil {
+		return termiosState{}, err
+	}
+
+	ts := termiosState{
+		baudRate: uint(t2.c_ospeed),
+		stopBits: 1,
+		rtscts:   t2.c_cflag&unix.CRTSCTS != 0,
+		raw:      t2.c_lflag&(syscall.ICANON|syscall.ECHO|syscall.ISIG) == 0 && t2.c_oflag&syscall.OPOST == 0,
+		noRecv:   t2.c_cflag&syscall.CREAD == 0,
+		vmin:     uint8(t2.c_cc[syscall.VMIN]),
+		vtime:    uint8(t2.c_cc[syscall.VTIME]),
+	}
+
+	switch t2.c_cflag & syscall.CSIZE {
+	case syscall.CS5:
+		ts.dataBits = 5
+	case syscall.CS6:
+		ts.dataBits = 6
+	case syscall.CS7:
+		ts.dataBits = 7
+	case syscall.CS8:
+		ts.dataBits = 8
+	}
+
+	if t2.c_cflag&syscall.CSTOPB != 0 {
+		ts.stopBits = 2
+	}
+
+	if t2.c_cflag&syscall.PARENB != 0 {
+		ts.parityMode = PARITY_EVEN
+		if t2.c_cflag&syscall.PARODD != 0 {
+			ts.parityMode = PARITY_ODD
 		}
+	}
+
+	return ts, nil
+}
+
+// setLowLatency sets or clears ASYNC_LOW_LATENCY, which asks the driver to
+// push received bytes to the tty layer immediately. For FTDI adapters this
+// also drops the latency timer to 1ms.
+func setLowLatency(fd uintptr, on bool) error {
+	var ss serial_struct
 
-		r, _, errno := syscall.Syscall(
-			syscall.SYS_IOCTL,
-			uintptr(file.Fd()),
-			uintptr(tIOCSRS485),
-			uintptr(unsafe.Pointer(&rs485)))
+	if err := sys.ioctl(fd, unix.TIOCGSERIAL, unsafe.Pointer(&ss)); err != nil {
+		return os.NewSyscallError("TIOCGSERIAL", err)
+	}
+
+	if on {
+		ss.flags |= aSYNC_LOW_LATENCY
+	} else {
+		ss.flags &^= aSYNC_LOW_LATENCY
+	}
+
+	if err := sys.ioctl(fd, unix.TIOCSSERIAL, unsafe.Pointer(&ss)); err != nil {
+		return os.NewSyscallError("TIOCSSERIAL", err)
+	}
 
-		if errno != 0 {
-			return nil, os.NewSyscallError("SYS_IOCTL (RS485)", errno)
+	return nil
+}
+
+// newTermios copies t2 into a Termios.
+func newTermios(t2 *termios2) *Termios {
+	t := &Termios{
+		iflag: uint64(t2.c_iflag),
+		oflag: uint64(t2.c_oflag),
+		cflag: uint64(t2.c_cflag),
+		lflag: uint64(t2.c_lflag),
+		cc:    make([]byte, kNCCS),
+	}
+	for i, c := range t2.c_cc {
+		t.cc[i] = byte(c)
+	}
+
+	return t
+}
+
+// readRawTermios reads the port's termios settings back from the driver.
+func readRawTermios(fd uintptr) (*Termios, error) {
+	t2, err := getTermios2(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTermios(t2), nil
+}
+
+// applyRawConfig lets options.RawConfig adjust t2.
+func applyRawConfig(options OpenOptions, t2 *termios2) error {
+	if options.RawConfig == nil {
+		return nil
+	}
+
+	t := newTermios(t2)
+	if err := options.RawConfig(t); err != nil {
+		return err
+	}
+
+	t2.c_iflag = tcflag_t(t.iflag)
+	t2.c_oflag = tcflag_t(t.oflag)
+	t2.c_cflag = tcflag_t(t.cflag)
+	t2.c_lflag = tcflag_t(t.lflag)
+	for i, c := range t.cc {
+		t2.c_cc[i] = cc_t(c)
+	}
+
+	if options.RawOutput {
+		t2.c_oflag &^= syscall.OPOST
+	}
+
+	return nil
+}
+
+// flushInput discards data received but not yet read.
+func flushInput(fd uintptr) error {
+	if err := unix.IoctlSetInt(int(fd), unix.TCFLSH, unix.TCIFLUSH); err != nil {
+		return os.NewSyscallError("TCFLSH", err)
+	}
+
+	return nil
+}
+
+// setReceiver sets or clears CREAD.
+func setReceiver(fd uintptr, on bool) error {
+	t2, err := getTermios2(fd)
+	if err != nil {
+		return err
+	}
+
+	if on {
+		t2.c_cflag |= syscall.CREAD
+	} else {
+		t2.c_cflag &^= syscall.CREAD
+	}
+
+	return setTermios2(fd, t2)
+}
+
+// drainOutput waits until the output written so far has been transmitted.
+func drainOutput(fd uintptr) error {
+	err := ignoringEINTR(func() error {
+		return unix.IoctlSetInt(int(fd), unix.TCSBRK, 1)
+	})
+
+	if err != nil {
+		return os.NewSyscallError("TCSBRK", err)
+	}
+
+	return nil
+}
+
+// configureFunc validates options and returns a function that applies them
+// to a port's file descriptor.
+func configureFunc(options OpenOptions) (func(fd uintptr) error, error) {
+
+	t2, optErr := makeTermios2(options)
+	if optErr != nil {
+		return nil, optErr
+	}
+
+	if err := applyRawConfig(options, t2); err != nil {
+		return nil, err
+	}
+
+	return func(fd uintptr) error {
+		if err := setTermios2(fd, t2); err != nil {
+			return err
 		}
 
-		if r != 0 {
-			return nil, errors.New("Unknown error from SYS_IOCTL (RS485)")
+		if options.Rs485Enable {
+			return setRS485(fd, options)
 		}
-	}
 
-	return file, nil
+		return nil
+	}, nil
 }
@@ -0,0 +1,325 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenRequests(t *testing.T) {
+	// Every open switches the descriptor to blocking mode, since the fake's
+	// files can't be polled, then applies termios2 and reads the error
+	// counters.
+	blocking := []sysCall{
+		{"open", 0},
+		{"fcntl", unix.F_GETFL},
+		{"fcntl", unix.F_SETFL},
+		{"ioctl", kTCSETS2},
+	}
+	counters := sysCall{"ioctl", unix.TIOCGICOUNT}
+
+	testCases := []struct {
+		name      string
+		options   OpenOptions
+		calls     []sysCall
+		cflag     tcflag_t
+		iflag     tcflag_t
+		vmin      cc_t
+		vtime     cc_t
+		rs485Flag uint32
+	}{
+		{
+			name: "8N1",
+			options: OpenOptions{
+				BaudRate:              115200,
+				DataBits:              8,
+				StopBits:              1,
+				InterCharacterTimeout: 100,
+			},
+			calls: append(blocking, counters),
+			cflag: syscall.CLOCAL | syscall.CREAD | kBOTHER | syscall.CS8,
+			vtime: 1,
+		},
+		{
+			name: "7E2 with flow control",
+			options: OpenOptions{
+				BaudRate:          250000,
+				DataBits:          7,
+				StopBits:          2,
+				ParityMode:        PARITY_EVEN,
+				RTSCTSFlowControl: true,
+				HangupOnClose:     true,
+				MinimumReadSize:   4,
+			},
+			calls: append(blocking, counters),
+			cflag: syscall.CLOCAL | syscall.CREAD | kBOTHER | syscall.CS7 |
+				syscall.CSTOPB | syscall.PARENB | unix.CRTSCTS | syscall.HUPCL,
+			vmin: 4,
+		},
+		{
+			name: "RS485",
+			options: OpenOptions{
+				BaudRate:               9600,
+				DataBits:               8,
+				StopBits:               1,
+				ParityMode:             PARITY_ODD,
+				MinimumReadSize:        1,
+				Rs485Enable:            true,
+				Rs485RtsHighDuringSend: true,
+			},
+			calls: append(blocking, sysCall{"ioctl", tIOCSRS485}, counters),
+			cflag: syscall.CLOCAL | syscall.CREAD | kBOTHER | syscall.CS8 |
+				syscall.PARENB | syscall.PARODD,
+			vmin:      1,
+			rs485Flag: sER_RS485_ENABLED | sER_RS485_RTS_ON_SEND,
+		},
+		{
+			name: "terminal input",
+			options: OpenOptions{
+				BaudRate:        115200,
+				DataBits:        8,
+				StopBits:        1,
+				MinimumReadSize: 1,
+				StripHighBit:    true,
+				MapCRToNL:       true,
+				UTF8Input:       true,
+			},
+			calls: append(blocking, counters),
+			cflag: syscall.CLOCAL | syscall.CREAD | kBOTHER | syscall.CS8,
+			iflag: syscall.ISTRIP | syscall.ICRNL | unix.IUTF8,
+			vmin:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := useFakeSys(t)
+
+			var t2 termios2
+			var rs485 serial_rs485
+			f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+				switch req {
+				case kTCSETS2:
+					t2 = *(*termios2)(arg)
+				case tIOCSRS485:
+					rs485 = *(*serial_rs485)(arg)
+				}
+				return nil
+			}
+
+			options := tc.options
+			options.PortName = "/dev/ttyUSB0"
+
+			port, err := Open(options)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer port.Close()
+
+			checkCalls(t, f.Calls(), tc.calls)
+
+			if t2.c_cflag != tc.cflag {
+				t.Errorf("c_cflag: got %#x, want %#x", t2.c_cflag, tc.cflag)
+			}
+
+			if t2.c_iflag != tc.iflag {
+				t.Errorf("c_iflag: got %#x, want %#x", t2.c_iflag, tc.iflag)
+			}
+
+			baud := speed_t(tc.options.BaudRate)
+			if t2.c_ispeed != baud || t2.c_ospeed != baud {
+				t.Errorf("speeds: got %d/%d, want %d", t2.c_ispeed, t2.c_ospeed, baud)
+			}
+
+			if t2.c_cc[syscall.VMIN] != tc.vmin || t2.c_cc[syscall.VTIME] != tc.vtime {
+				t.Errorf(
+					"VMIN/VTIME: got %d/%d, want %d/%d",
+					t2.c_cc[syscall.VMIN], t2.c_cc[syscall.VTIME], tc.vmin, tc.vtime)
+			}
+
+			if rs485.flags != tc.rs485Flag {
+				t.Errorf("RS485 flags: got %#x, want %#x", rs485.flags, tc.rs485Flag)
+			}
+		})
+	}
+}
+
+func TestOpenRequestFails(t *testing.T) {
+	f := useFakeSys(t)
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		if req == kTCSETS2 {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+
+	_, err := Open(OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Nothing more should be attempted once termios2 is refused.
+	calls := f.Calls()
+	if last := calls[len(calls)-1]; last != (sysCall{"ioctl", kTCSETS2}) {
+		t.Errorf("last call: got %v, want TCSETS2", last)
+	}
+}
+
+func TestWriteNineBit(t *testing.T) {
+	f := useFakeSys(t)
+
+	// Keep the termios2 the port sets, so that it reads it back, and note
+	// the c_cflag of every change that waits for output to drain.
+	var current termios2
+	var drained []tcflag_t
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		switch req {
+		case kTCSETS2:
+			current = *(*termios2)(arg)
+		case unix.TCSETSW2:
+			current = *(*termios2)(arg)
+			drained = append(drained, current.c_cflag)
+		case unix.TCGETS2:
+			*(*termios2)(arg) = current
+		}
+		return nil
+	}
+
+	port, err := Open(OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        9600,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer port.Close()
+
+	cflag := current.c_cflag
+
+	if n, err := port.WriteNineBit([]byte{0x12}, true); n != 1 || err != nil {
+		t.Fatalf("WriteNineBit(address): %d, %v", n, err)
+	}
+
+	if n, err := port.WriteNineBit([]byte("data"), false); n != 4 || err != nil {
+		t.Fatalf("WriteNineBit(data): %d, %v", n, err)
+	}
+
+	const stick = syscall.PARENB | unix.CMSPAR
+	want := []tcflag_t{cflag | stick | syscall.PARODD, cflag, cflag | stick, cflag}
+	if len(drained) != len(want) {
+		t.Fatalf("c_cflag changes: got %#x, want %#x", drained, want)
+	}
+
+	for i := range want {
+		if drained[i] != want[i] {
+			t.Errorf("c_cflag change %d: got %#x, want %#x", i, drained[i], want[i])
+		}
+	}
+
+	written, err := os.ReadFile(f.files[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(written) != "\x12data" {
+		t.Errorf("written: got %q", written)
+	}
+}
+
+// fakeErrorCounters has f's driver report *c in answer to TIOCGICOUNT.
+func fakeErrorCounters(t *testing.T, f *fakeSys, c *ErrorCounters) {
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		if req == unix.TIOCGICOUNT {
+			ic := (*serial_icounter_struct)(arg)
+			ic.frame = int32(c.Framing)
+			ic.parity = int32(c.Parity)
+			ic.overrun = int32(c.Overrun)
+			ic.buf_overrun = int32(c.BufferOverrun)
+			ic.brk = int32(c.Break)
+		}
+		return nil
+	}
+}
+
+func TestSetLowLatency(t *testing.T) {
+	f := useFakeSys(t)
+
+	// A driver that keeps the flags TIOCSSERIAL sets, with another flag
+	// already set.
+	driver := serial_struct{flags: 0x40}
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		switch req {
+		case unix.TIOCGSERIAL:
+			*(*serial_struct)(arg) = driver
+		case unix.TIOCSSERIAL:
+			driver = *(*serial_struct)(arg)
+		}
+		return nil
+	}
+
+	port, err := Open(OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer port.Close()
+
+	if err := port.SetLowLatency(true); err != nil {
+		t.Fatalf("SetLowLatency(true): %v", err)
+	}
+
+	if driver.flags != 0x40|aSYNC_LOW_LATENCY {
+		t.Errorf("flags after setting: got %#x, want %#x", driver.flags, 0x40|aSYNC_LOW_LATENCY)
+	}
+
+	if err := port.SetLowLatency(false); err != nil {
+		t.Fatalf("SetLowLatency(false): %v", err)
+	}
+
+	if driver.flags != 0x40 {
+		t.Errorf("flags after clearing: got %#x, want 0x40", driver.flags)
+	}
+
+	// A driver without TIOCSSERIAL makes it fail.
+	f.onIoctl = func(req uint, arg unsafe.Pointer) error {
+		if req == unix.TIOCSSERIAL {
+			return unix.ENOTTY
+		}
+		return nil
+	}
+
+	if err := port.SetLowLatency(true); err == nil {
+		t.Error("SetLowLatency succeeded without TIOCSSERIAL")
+	}
+}
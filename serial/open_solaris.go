@@ -0,0 +1,447 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file contains the Solaris and illumos implementation. Unlike Linux and
+// OS X there is no way to pass an arbitrary speed to the driver, so only the
+// rates that have a B* constant in sys/termios.h are supported. Rates above
+// B38400 are encoded with the CBAUDEXT extension bit.
+//
+// Helpful documentation:
+//
+//     https://illumos.org/man/4I/termio
+
+package serial
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sys/termios.h
+	kCBAUDEXT  = 0x200000
+	kCIBAUDEXT = 0x400000
+	kCRTSXOFF  = 0x40000000
+
+	// sys/filio.h
+	kFIONREAD = 0x4004667f
+)
+
+// Glob patterns matching the device nodes of serial ports.
+var portPatterns = []string{
+	"/dev/term/*",
+	"/dev/cua/*",
+}
+
+// Termios flags and control characters specific to Solaris, for
+// DescribeTermios. The speed is in CBAUD, with CBAUDEXT for the higher
+// rates.
+var osTermiosNames = termiosNames{
+	cflag: []flagName{
+		bitFlag(unix.CRTSCTS, "CRTSCTS"),
+		bitFlag(kCRTSXOFF, "CRTSXOFF"),
+		bitFlag(kCBAUDEXT, "CBAUDEXT"),
+	},
+	cc: []ccName{
+		{unix.VDSUSP, "VDSUSP"},
+	},
+}
+
+// isPhantomPort reports whether a device node has no port behind it. The
+// nodes under /dev/term and /dev/cua are only created for ports that exist.
+func isPhantomPort(name string) bool {
+	return false
+}
+
+// ioctl is never used on Solaris, where there is no raw syscall interface;
+// the code here calls the typed wrappers in x/sys/unix instead.
+func (realSys) ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	return unix.ENOTSUP
+}
+
+// Speed codes for the baud rates supported by the Solaris termios interface.
+var solarisBaudRates = map[uint]uint32{
+	50:     unix.B50,
+	75:     unix.B75,
+	110:    unix.B110,
+	134:    unix.B134,
+	150:    unix.B150,
+	200:    unix.B200,
+	300:    unix.B300,
+	600:    unix.B600,
+	1200:   unix.B1200,
+	1800:   unix.B1800,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	76800:  unix.B76800,
+	115200: unix.B115200,
+	153600: unix.B153600,
+	230400: unix.B230400,
+	307200: unix.B307200,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// makeTermios returns a termios struct based on the given OpenOptions. The
+// input speed is left at zero, which tells the driver to use the output speed
+// for both directions.
+func makeTermios(options OpenOptions) (*unix.Termios, error) {
+
+	// Sanity check inter-character timeout and minimum read size options.
+
+	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
+	vmin := options.MinimumReadSize
+
+	if vmin == 0 && vtime < 100 && options.OverallReadTimeout == 0 {
+		return nil, errors.New("invalid values for InterCharacterTimeout and MinimumReadSize")
+	}
+
+	if vtime > 25500 {
+		return nil, errors.New("invalid value for InterCharacterTimeout")
+	}
+
+	// With VMIN and VTIME both zero, read(2) returns nothing at once even on
+	// a non-blocking descriptor. OverallReadTimeout is enforced by the
+	// package, so have the driver wait for a byte.
+	if vmin == 0 && vtime == 0 {
+		vmin = 1
+	}
+
+	t := &unix.Termios{
+		Cflag: unix.CLOCAL | unix.CREAD,
+	}
+
+	t.Cc[unix.VTIME] = uint8(vtime / 100)
+	t.Cc[unix.VMIN] = uint8(vmin)
+
+	// Make the flow control characters available to TCXONC.
+	t.Cc[unix.VSTART] = 0x11
+	t.Cc[unix.VSTOP] = 0x13
+
+	speed, ok := solarisBaudRates[options.BaudRate]
+	if !ok {
+		return nil, markError(errors.New("unsupported BaudRate"), ErrInvalidBaudRate)
+	}
+
+	if speed > unix.CBAUD {
+		t.Cflag |= kCBAUDEXT
+		speed -= unix.CBAUD + 1
+	}
+	t.Cflag |= speed
+
+	switch options.StopBits {
+	case 1:
+	case 2:
+		t.Cflag |= unix.CSTOPB
+
+	default:
+		return nil, errors.New("invalid setting for StopBits")
+	}
+
+	switch options.ParityMode {
+	case PARITY_NONE:
+	case PARITY_ODD:
+		t.Cflag |= unix.PARENB
+		t.Cflag |= unix.PARODD
+
+	case PARITY_EVEN:
+		t.Cflag |= unix.PARENB
+
+	default:
+		return nil, errors.New("invalid setting for ParityMode")
+	}
+
+	switch options.DataBits {
+	case 5:
+		t.Cflag |= unix.CS5
+	case 6:
+		t.Cflag |= unix.CS6
+	case 7:
+		t.Cflag |= unix.CS7
+	case 8:
+		t.Cflag |= unix.CS8
+	default:
+		return nil, markError(errors.New("invalid setting for DataBits"), ErrInvalidDataBits)
+	}
+
+	if options.RTSCTSFlowControl {
+		t.Cflag |= unix.CRTSCTS
+	}
+
+	if options.HangupOnClose {
+		t.Cflag |= unix.HUPCL
+	}
+
+	if options.HonorModemStatusLines {
+		t.Cflag &^= unix.CLOCAL
+	}
+
+	// Input processing. There's no IUTF8 to go with UTF8Input.
+	if options.StripHighBit {
+		t.Iflag |= unix.ISTRIP
+	}
+
+	if options.MapCRToNL {
+		t.Iflag |= unix.ICRNL
+	}
+
+	if options.MapNLToCR {
+		t.Iflag |= unix.INLCR
+	}
+
+	if options.IgnoreCR {
+		t.Iflag |= unix.IGNCR
+	}
+
+	return t, nil
+}
+
+// setMode updates the speed, framing and flow control settings of the port to
+// match options, waiting for pending output to drain first. Other settings
+// are left as they are.
+func setMode(fd uintptr, options OpenOptions) error {
+	want, err := makeTermios(options)
+	if err != nil {
+		return err
+	}
+
+	t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return os.NewSyscallError("TCGETS", err)
+	}
+
+	const cmask = unix.CSIZE | unix.CSTOPB | unix.PARENB | unix.PARODD | unix.CRTSCTS |
+		unix.CBAUD | kCBAUDEXT | unix.CIBAUD | kCIBAUDEXT
+	const imask = unix.INPCK | unix.IGNPAR | unix.PARMRK
+
+	t.Cflag = t.Cflag&^cmask | want.Cflag&cmask
+	t.Iflag = t.Iflag&^imask | want.Iflag&imask
+
+	err = ignoringEINTR(func() error {
+		return unix.IoctlSetTermios(int(fd), unix.TCSETSW, t)
+	})
+
+	if err != nil {
+		return os.NewSyscallError("TCSETSW", err)
+	}
+
+	return nil
+}
+
+// The driver sends XON and XOFF itself, ahead of any queued output, so
+// Pause and Resume use sendFlowControl. A variable for the tests.
+var flowControlByWrite = false
+
+// sendFlowControl sends XOFF if stop is true and XON otherwise.
+func sendFlowControl(fd uintptr, stop bool) error {
+	action := unix.TCION
+	if stop {
+		action = unix.TCIOFF
+	}
+
+	if err := unix.IoctlSetInt(int(fd), unix.TCXONC, action); err != nil {
+		return os.NewSyscallError("TCXONC", err)
+	}
+
+	return nil
+}
+
+// driverInputQueue is zero, since the driver doesn't report its input
+// queue's size.
+const driverInputQueue = 0
+
+// getErrorCounters always fails, since there's no TIOCGICOUNT equivalent.
+func getErrorCounters(fd uintptr) (ErrorCounters, error) {
+	return ErrorCounters{}, ErrNotSupported
+}
+
+// readTermios reads the port's termios settings back from the driver.
+func readTermios(fd uintptr) (termiosState, error) {
+	t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return termiosState{}, os.NewSyscallError("TCGETS", err)
+	}
+
+	ts := termiosState{
+		stopBits: 1,
+		rtscts:   t.Cflag&unix.CRTSCTS != 0,
+		raw:      t.Lflag&(unix.ICANON|unix.ECHO|unix.ISIG) == 0 && t.Oflag&unix.OPOST == 0,
+		noRecv:   t.Cflag&unix.CREAD == 0,
+		vmin:     t.Cc[unix.VMIN],
+		vtime:    t.Cc[unix.VTIME],
+	}
+
+	speed := t.Cflag & unix.CBAUD
+	if t.Cflag&kCBAUDEXT != 0 {
+		speed += unix.CBAUD + 1
+	}
+	for rate, s := range solarisBaudRates {
+		if s == speed {
+			ts.baudRate = rate
+		}
+	}
+
+	switch t.Cflag & unix.CSIZE {
+	case unix.CS5:
+		ts.dataBits = 5
+	case unix.CS6:
+		ts.dataBits = 6
+	case unix.CS7:
+		ts.dataBits = 7
+	case unix.CS8:
+		ts.dataBits = 8
+	}
+
+	if t.Cflag&unix.CSTOPB != 0 {
+		ts.stopBits = 2
+	}
+
+	if t.Cflag&unix.PARENB != 0 {
+		ts.parityMode = PARITY_EVEN
+		if t.Cflag&unix.PARODD != 0 {
+			ts.parityMode = PARITY_ODD
+		}
+	}
+
+	return ts, nil
+}
+
+// setLowLatency always fails, since the driver has no low-latency flag.
+func setLowLatency(fd uintptr, on bool) error {
+	return ErrNotSupported
+}
+
+// setStickParity always fails, since termios has no mark or space parity.
+func setStickParity(fd uintptr, mark bool) (func(fd uintptr) error, error) {
+	return nil, ErrNotSupported
+}
+
+// newTermios copies t into a Termios.
+func newTermios(t *unix.Termios) *Termios {
+	return &Termios{
+		iflag: uint64(t.Iflag),
+		oflag: uint64(t.Oflag),
+		cflag: uint64(t.Cflag),
+		lflag: uint64(t.Lflag),
+		cc:    append([]byte(nil), t.Cc[:]...),
+	}
+}
+
+// readRawTermios reads the port's termios settings back from the driver.
+func readRawTermios(fd uintptr) (*Termios, error) {
+	t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, os.NewSyscallError("TCGETS", err)
+	}
+
+	return newTermios(t), nil
+}
+
+// applyRawConfig lets options.RawConfig adjust t.
+func applyRawConfig(options OpenOptions, t *unix.Termios) error {
+	if options.RawConfig == nil {
+		return nil
+	}
+
+	rt := newTermios(t)
+	if err := options.RawConfig(rt); err != nil {
+		return err
+	}
+
+	t.Iflag = uint32(rt.iflag)
+	t.Oflag = uint32(rt.oflag)
+	t.Cflag = uint32(rt.cflag)
+	t.Lflag = uint32(rt.lflag)
+	copy(t.Cc[:], rt.cc)
+
+	if options.RawOutput {
+		t.Oflag &^= unix.OPOST
+	}
+
+	return nil
+}
+
+// flushInput discards data received but not yet read.
+func flushInput(fd uintptr) error {
+	if err := unix.IoctlSetInt(int(fd), unix.TCFLSH, unix.TCIFLUSH); err != nil {
+		return os.NewSyscallError("TCFLSH", err)
+	}
+
+	return nil
+}
+
+// setReceiver sets or clears CREAD.
+func setReceiver(fd uintptr, on bool) error {
+	t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return os.NewSyscallError("TCGETS", err)
+	}
+
+	if on {
+		t.Cflag |= unix.CREAD
+	} else {
+		t.Cflag &^= unix.CREAD
+	}
+
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, t); err != nil {
+		return os.NewSyscallError("TCSETS", err)
+	}
+
+	return nil
+}
+
+// drainOutput waits until the output written so far has been transmitted.
+func drainOutput(fd uintptr) error {
+	err := ignoringEINTR(func() error {
+		return unix.IoctlSetInt(int(fd), unix.TCSBRK, 1)
+	})
+
+	if err != nil {
+		return os.NewSyscallError("TCSBRK", err)
+	}
+
+	return nil
+}
+
+// configureFunc validates options and returns a function that applies them
+// to a port's file descriptor.
+func configureFunc(options OpenOptions) (func(fd uintptr) error, error) {
+	if options.Rs485Enable {
+		return nil, errors.New("RS485 mode is not supported on this OS")
+	}
+
+	t, optErr := makeTermios(options)
+	if optErr != nil {
+		return nil, optErr
+	}
+
+	if err := applyRawConfig(options, t); err != nil {
+		return nil, err
+	}
+
+	return func(fd uintptr) error {
+		if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, t); err != nil {
+			return os.NewSyscallError("TCSETS", err)
+		}
+
+		return nil
+	}, nil
+}
@@ -15,21 +15,81 @@
 package serial
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
+
+	"golang.org/x/sys/windows/registry"
 )
 
 type serialPort struct {
+	// First, for alignment.
+	counters portCounters
+
 	f  *os.File
 	fd syscall.Handle
+
+	// The options the port is currently configured with, guarded by cm. The
+	// methods that change the DCB or the modem lines also hold cm throughout.
+	cm      sync.Mutex
+	options OpenOptions
+
 	rl sync.Mutex
 	wl sync.Mutex
 	ro *syscall.Overlapped
 	wo *syscall.Overlapped
+
+	// Used by WaitForData for WaitCommEvent, under rl.
+	eo *syscall.Overlapped
+
+	// Set to 1 by Close. Read, Write and the control methods hold cl for
+	// reading while they use fd, so that Close can't release the handle out
+	// from under them.
+	closed    int32
+	cl        sync.RWMutex
+	closeOnce sync.Once
+
+	// The deadlines set by SetReadDeadline and SetWriteDeadline, guarded by
+	// dl. Setting one signals the auto-reset event rk or wk so that a pending
+	// Read or Write notices.
+	dl            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	rk            syscall.Handle
+	wk            syscall.Handle
+
+	// Error counts accumulated from ClearCommError, guarded by el.
+	el        sync.Mutex
+	errCounts ErrorCounters
+
+	// Where the port explains itself, if anywhere.
+	log portLogger
+}
+
+type structComstat struct {
+	flags    uint32
+	cbInQue  uint32
+	cbOutQue uint32
+}
+
+type structCommProp struct {
+	wPacketLength, wPacketVersion                          uint16
+	dwServiceMask, dwReserved1, dwMaxTxQueue, dwMaxRxQueue uint32
+	dwMaxBaud, dwProvSubType, dwProvCapabilities           uint32
+	dwSettableParams, dwSettableBaud                       uint32
+	wSettableData, wSettableStopParity                     uint16
+	dwCurrentTxQueue, dwCurrentRxQueue                     uint32
+	dwProvSpec1, dwProvSpec2                               uint32
+	wcProvChar                                             [1]uint16
 }
 
 type structDCB struct {
@@ -49,7 +109,7 @@ type structTimeouts struct {
 	WriteTotalTimeoutConstant   uint32
 }
 
-func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
+func openInternal(options OpenOptions) (Port, error) {
 	if len(options.PortName) > 0 && options.PortName[0] != '\\' {
 		options.PortName = "\\\\.\\" + options.PortName
 	}
@@ -61,20 +121,59 @@ func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
 		syscall.OPEN_EXISTING,
 		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
 		0)
+	if err != nil {
+		return nil, openError(&os.PathError{Op: "open", Path: options.PortName, Err: err})
+	}
+	return wrapHandle(h, options)
+}
+
+func openFdInternal(fd uintptr, options OpenOptions) (Port, error) {
+	p, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return nil, err
+	}
+	var h syscall.Handle
+	err = syscall.DuplicateHandle(p, syscall.Handle(fd), p, &h, 0, false, syscall.DUPLICATE_SAME_ACCESS)
+	if err != nil {
+		return nil, newPortError(options.PortName, "duplicating handle", os.NewSyscallError("DuplicateHandle", err))
+	}
+	return wrapHandle(h, options)
+}
+
+// wrapHandle is newSerialPort for callers returning a Port, taking care not to
+// return a non-nil interface holding a nil pointer.
+func wrapHandle(h syscall.Handle, options OpenOptions) (Port, error) {
+	port, err := newSerialPort(h, options)
 	if err != nil {
 		return nil, err
 	}
+	return port, nil
+}
+
+// newSerialPort configures the port open on h and wraps it in a serialPort.
+// The handle is closed if anything goes wrong.
+func newSerialPort(h syscall.Handle, options OpenOptions) (port *serialPort, err error) {
 	f := os.NewFile(uintptr(h), options.PortName)
+	p := &serialPort{f: f, fd: h, options: options}
 	defer func() {
 		if err != nil {
+			p.closeEvents()
 			f.Close()
+			err = newPortError(options.PortName, "configuring", err)
 		}
 	}()
 
 	if err = setCommState(h, options); err != nil {
 		return nil, err
 	}
-	if err = setupComm(h, 64, 64); err != nil {
+	in, out := options.InputQueueSize, options.OutputQueueSize
+	if in == 0 {
+		in = 64
+	}
+	if out == 0 {
+		out = 64
+	}
+	if err = setupComm(h, in, out); err != nil {
 		return nil, err
 	}
 	if err = setCommTimeouts(h, options); err != nil {
@@ -84,69 +183,1114 @@ func openInternal(options OpenOptions) (io.ReadWriteCloser, error) {
 		return nil, err
 	}
 
-	ro, err := newOverlapped()
+	if p.ro, err = newOverlapped(); err != nil {
+		return nil, err
+	}
+	if p.wo, err = newOverlapped(); err != nil {
+		return nil, err
+	}
+	if p.eo, err = newOverlapped(); err != nil {
+		return nil, err
+	}
+	if p.rk, err = newEvent(false); err != nil {
+		return nil, err
+	}
+	if p.wk, err = newEvent(false); err != nil {
+		return nil, err
+	}
+
+	p.log.set(options.Logger, options.PortName)
+	p.counters.setHooks(options.Hooks)
+	p.logSettings("serial: configured", options.BaudRate)
+
+	return p, nil
+}
+
+// closeEvents closes those of the port's event handles that have been
+// created.
+func (p *serialPort) closeEvents() {
+	for _, o := range []*syscall.Overlapped{p.ro, p.wo, p.eo} {
+		if o != nil {
+			syscall.CloseHandle(o.HEvent)
+		}
+	}
+	for _, h := range []syscall.Handle{p.rk, p.wk} {
+		if h != 0 {
+			syscall.CloseHandle(h)
+		}
+	}
+}
+
+// Close closes the port. Any Read or Write in progress is cancelled and
+// returns ErrPortClosed. Only the first call has any effect; later calls
+// return nil.
+func (p *serialPort) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		atomic.StoreInt32(&p.closed, 1)
+		cancelIo(p.fd)
+
+		// Wait for the cancelled calls to return before releasing the handles.
+		p.cl.Lock()
+		defer p.cl.Unlock()
+		err = p.f.Close()
+		p.closeEvents()
+	})
+	return err
+}
+
+// acquire prevents the handle from being closed until release is called. It
+// returns false if the port is already closed.
+func (p *serialPort) acquire() bool {
+	p.cl.RLock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		p.cl.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (p *serialPort) release() {
+	p.cl.RUnlock()
+}
+
+// wait waits for an overlapped operation started by the caller to complete.
+// If Close was called after the caller's acquire but before the operation
+// was issued, Close's cancellation will have missed it, so it is cancelled
+// here instead.
+func (p *serialPort) wait(overlapped *syscall.Overlapped) (int, error) {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		cancelIo(p.fd)
+	}
+	n, err := getOverlappedResult(p.fd, overlapped)
+	return n, p.portError(err)
+}
+
+// Pause implements Port. With RTS/CTS flow control enabled, RTS is lowered by
+// temporarily disabling the handshake, since Windows doesn't allow the line
+// to be driven by hand while the driver owns it.
+func (p *serialPort) Pause() error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if p.options.RTSCTSFlowControl {
+		return p.portError(p.setRtsControl(0x00)) // RTS_CONTROL_DISABLE
+	}
+	return p.portError(transmitCommChar(p.fd, 0x13)) // XOFF
+}
+
+// Resume implements Port.
+func (p *serialPort) Resume() error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if p.options.RTSCTSFlowControl {
+		return p.portError(p.setRtsControl(0x20)) // RTS_CONTROL_HANDSHAKE
+	}
+	return p.portError(transmitCommChar(p.fd, 0x11)) // XON
+}
+
+// PulseDTR implements Port.
+func (p *serialPort) PulseDTR(d time.Duration) error {
+	const (
+		SETDTR = 5
+		CLRDTR = 6
+	)
+	return p.portError(p.pulse(CLRDTR, SETDTR, d))
+}
+
+// PulseRTS implements Port.
+func (p *serialPort) PulseRTS(d time.Duration) error {
+	const (
+		SETRTS = 3
+		CLRRTS = 4
+	)
+	return p.portError(p.pulse(CLRRTS, SETRTS, d))
+}
+
+// pulse applies the EscapeCommFunction function clr, waits for d and then
+// applies set, discarding any input received in the meantime. Nothing is
+// held while sleeping, so that Close can proceed; the handle and cm are
+// taken in that order, as elsewhere.
+func (p *serialPort) pulse(clr, set uint32, d time.Duration) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	p.cm.Lock()
+	err := escapeComm(p.fd, clr)
+	p.cm.Unlock()
+	p.release()
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := escapeComm(p.fd, set); err != nil {
+		return err
+	}
+
+	const PURGE_RXCLEAR = 0x0008
+	return purgeComm(p.fd, PURGE_RXCLEAR)
+}
+
+// portReady returns nil if the port named name is listed in the registry,
+// which Windows does once the driver has the port ready, and otherwise an
+// error matching os.ErrNotExist.
+func portReady(name string) error {
+	names, err := portNames()
+	if err != nil {
+		return err
+	}
+
+	short := strings.TrimPrefix(name, `\\.\`)
+	for _, n := range names {
+		if strings.EqualFold(n, short) {
+			return nil
+		}
+	}
+
+	return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// portNames returns the names of the COM ports listed in the registry.
+func portNames() ([]string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		// The key only exists while at least one port is present.
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	wo, err := newOverlapped()
+	defer k.Close()
+
+	values, err := k.ReadValueNames(0)
 	if err != nil {
 		return nil, err
 	}
-	port := new(serialPort)
-	port.f = f
-	port.fd = h
-	port.ro = ro
-	port.wo = wo
 
-	return port, nil
+	var names []string
+	for _, v := range values {
+		name, _, err := k.GetStringValue(v)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
 }
 
-func (p *serialPort) Close() error {
-	return p.f.Close()
+// SetMode implements Port. Pending output is flushed with FlushFileBuffers
+// before the DCB is updated.
+func (p *serialPort) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	if dataBits < 5 || dataBits > 8 {
+		return markError(errors.New("invalid setting for DataBits"), ErrInvalidDataBits)
+	}
+	if stopBits != 1 && stopBits != 2 {
+		return errors.New("invalid setting for StopBits")
+	}
+	if parity != PARITY_NONE && parity != PARITY_ODD && parity != PARITY_EVEN {
+		return errors.New("invalid setting for ParityMode")
+	}
+
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := syscall.FlushFileBuffers(p.fd); err != nil {
+		return p.portError(err)
+	}
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return p.portError(err)
+	}
+
+	params.ByteSize = byte(dataBits)
+	params.Parity = byte(parity)
+	params.flags[0] &^= 0x02 | 0x04 // fParity, fOutxCtsFlow
+	if parity != PARITY_NONE {
+		params.flags[0] |= 0x02
+	}
+	params.StopBits = 0
+	if stopBits == 2 {
+		params.StopBits = 2
+	}
+	// fRtsControl is only changed when entering or leaving handshake mode,
+	// so that RTS otherwise stays as SetRTS left it.
+	if rtscts {
+		params.flags[0] |= 0x04
+		params.flags[1] = params.flags[1]&^0x30 | 0x20
+	} else if params.flags[1]&0x30 == 0x20 {
+		params.flags[1] &^= 0x30
+	}
+
+	if err := putCommState(p.fd, params); err != nil {
+		return p.portError(err)
+	}
+
+	p.options.DataBits = dataBits
+	p.options.ParityMode = parity
+	p.options.StopBits = stopBits
+	p.options.RTSCTSFlowControl = rtscts
+	p.logSettings("serial: changed mode", p.options.BaudRate)
+	return nil
+}
+
+// SetBaudRate implements Port. Pending output is flushed with
+// FlushFileBuffers before the DCB is updated.
+func (p *serialPort) SetBaudRate(baud uint) error {
+	if baud == 0 {
+		return markError(errors.New("serial: BaudRate is zero"), ErrInvalidBaudRate)
+	}
+
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := syscall.FlushFileBuffers(p.fd); err != nil {
+		return p.portError(err)
+	}
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return p.portError(err)
+	}
+
+	params.BaudRate = uint32(baud)
+	if err := putCommState(p.fd, params); err != nil {
+		return p.portError(err)
+	}
+
+	p.options.BaudRate = baud
+	p.logSettings("serial: changed baud rate", baud)
+	return nil
+}
+
+// logSettings logs the DCB the driver is using, with msg, and warns if it
+// isn't running at the baud rate asked for. The DCB is only read back if
+// there is a logger.
+func (p *serialPort) logSettings(msg string, baud uint) {
+	log := p.log.get()
+	if log == nil {
+		return
+	}
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		log.Warn("serial: can't read settings back", "err", err)
+		return
+	}
+
+	log.Debug(msg,
+		"baud", params.BaudRate,
+		"byteSize", params.ByteSize,
+		"parity", params.Parity,
+		"stopBits", params.StopBits,
+		"flags", fmt.Sprintf("%#x", params.flags))
+
+	if uint(params.BaudRate) != baud {
+		log.Warn("serial: driver is using a different baud rate", "requested", baud, "actual", params.BaudRate)
+	}
+}
+
+// SetLogger implements Port.
+func (p *serialPort) SetLogger(l *slog.Logger) {
+	p.cm.Lock()
+	p.options.Logger = l
+	p.cm.Unlock()
+
+	p.log.set(l, p.f.Name())
+}
+
+// WithBaudRate implements Port.
+func (p *serialPort) WithBaudRate(baud uint, fn func() error) error {
+	p.cm.Lock()
+	prev := p.options.BaudRate
+	p.cm.Unlock()
+
+	return withBaudRate(p, prev, baud, fn)
+}
+
+// SetReceiverEnabled implements Port. The DCB has no equivalent of CREAD.
+func (p *serialPort) SetReceiverEnabled(on bool) error {
+	return ErrNotSupported
+}
+
+// Drain implements Port, using FlushFileBuffers.
+func (p *serialPort) Drain() error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	return p.portError(syscall.FlushFileBuffers(p.fd))
+}
+
+// Quiesce implements Port, using FlushFileBuffers and then PurgeComm.
+func (p *serialPort) Quiesce() error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := syscall.FlushFileBuffers(p.fd); err != nil {
+		return p.portError(err)
+	}
+
+	const PURGE_RXCLEAR = 0x0008
+	return p.portError(purgeComm(p.fd, PURGE_RXCLEAR))
+}
+
+// SetRTS implements Port. RTS is set through fRtsControl rather than with
+// EscapeCommFunction, which would be undone the next time the DCB is
+// written, for example by SetMode.
+func (p *serialPort) SetRTS(on bool) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.setRTS(on)
+}
+
+// setRTS implements SetRTS. The caller must hold cm and have acquired the
+// port.
+func (p *serialPort) setRTS(on bool) error {
+	if p.options.RTSCTSFlowControl {
+		return errRTSFlowControl
+	}
+
+	mode := byte(0x00) // RTS_CONTROL_DISABLE
+	if on {
+		mode = 0x10 // RTS_CONTROL_ENABLE
+	}
+	return p.portError(p.setRtsControl(mode))
+}
+
+// SetDTR implements Port. Like SetRTS, it changes fDtrControl so that the
+// line keeps its state when the DCB is rewritten.
+func (p *serialPort) SetDTR(on bool) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	mode := byte(0x00) // DTR_CONTROL_DISABLE
+	if on {
+		mode = 0x10 // DTR_CONTROL_ENABLE
+	}
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return p.portError(err)
+	}
+	params.flags[0] = params.flags[0]&^0x30 | mode
+	return p.portError(putCommState(p.fd, params))
+}
+
+// SetBreak implements Port.
+func (p *serialPort) SetBreak(on bool) error {
+	const (
+		SETBREAK = 8
+		CLRBREAK = 9
+	)
+
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	fn := uint32(CLRBREAK)
+	if on {
+		fn = SETBREAK
+	}
+	return p.portError(escapeCommFunction(p.fd, fn))
+}
+
+// WriteWithRTS implements Port, using FlushFileBuffers to wait for the data
+// to be transmitted.
+func (p *serialPort) WriteWithRTS(buf []byte) (int, error) {
+	n, err := p.writeWithRTS(buf)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *serialPort) writeWithRTS(buf []byte) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := p.setRTS(true); err != nil {
+		return 0, err
+	}
+
+	time.Sleep(time.Duration(p.options.Rs485DelayRtsBeforeSend) * time.Millisecond)
+
+	n, err := writeChunked(buf, p.options.MaxWriteChunk, p.options.WriteChunkDelay, p.writeFile)
+	if flushErr := syscall.FlushFileBuffers(p.fd); err == nil {
+		err = p.portError(flushErr)
+	}
+
+	time.Sleep(time.Duration(p.options.Rs485DelayRtsAfterSend) * time.Millisecond)
+
+	if rtsErr := p.setRTS(false); err == nil {
+		err = rtsErr
+	}
+	return n, err
+}
+
+// setRtsControl updates the fRtsControl bits of the port's DCB.
+func (p *serialPort) setRtsControl(mode byte) error {
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return err
+	}
+	params.flags[1] = params.flags[1]&^0x30 | mode
+	return putCommState(p.fd, params)
+}
+
+// ErrorCounters implements Port.
+func (p *serialPort) ErrorCounters() (ErrorCounters, error) {
+	if !p.acquire() {
+		return ErrorCounters{}, ErrPortClosed
+	}
+	defer p.release()
+
+	if _, err := p.clearErrors(); err != nil {
+		return ErrorCounters{}, p.portError(err)
+	}
+
+	p.el.Lock()
+	defer p.el.Unlock()
+	return p.errCounts, nil
+}
+
+// String implements Port.
+func (p *serialPort) String() string {
+	return p.f.Name()
+}
+
+// CurrentOptions implements Port, reading the DCB back from the driver.
+func (p *serialPort) CurrentOptions() (OpenOptions, error) {
+	if !p.acquire() {
+		return OpenOptions{}, ErrPortClosed
+	}
+	defer p.release()
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return OpenOptions{}, p.portError(err)
+	}
+
+	p.cm.Lock()
+	options := p.options
+	p.cm.Unlock()
+
+	options.BaudRate = uint(params.BaudRate)
+	options.DataBits = uint(params.ByteSize)
+	options.ParityMode = ParityMode(params.Parity)
+	options.StopBits = 1
+	if params.StopBits == 2 {
+		options.StopBits = 2
+	}
+	options.RTSCTSFlowControl = params.flags[0]&0x04 != 0 // fOutxCtsFlow
+
+	return options, nil
+}
+
+// BaudRate implements Port.
+func (p *serialPort) BaudRate() (uint, error) {
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return 0, p.portError(err)
+	}
+
+	return uint(params.BaudRate), nil
+}
+
+// DumpSettings implements Port. The read timeouts aren't part of the DCB, so
+// unlike on POSIX systems they aren't included.
+func (p *serialPort) DumpSettings() (string, error) {
+	options, err := p.CurrentOptions()
+	if err != nil {
+		return "", p.portError(err)
+	}
+
+	m, err := p.modemStatus()
+	if err != nil {
+		return "", err
+	}
+
+	return joinSettings(p.String(), FormatMode(options), m.String()), nil
+}
+
+// CarrierDetect implements Port, reading RLSD, as Windows calls DCD.
+func (p *serialPort) CarrierDetect() (bool, error) {
+	m, err := p.modemStatus()
+	return m.dcd, err
+}
+
+// modemStatus reads the modem status lines.
+func (p *serialPort) modemStatus() (modemStatus, error) {
+	if !p.acquire() {
+		return modemStatus{}, ErrPortClosed
+	}
+	defer p.release()
+
+	status, err := getCommModemStatus(p.fd)
+	if err != nil {
+		return modemStatus{}, p.portError(err)
+	}
+
+	const (
+		MS_CTS_ON  = 0x0010
+		MS_DSR_ON  = 0x0020
+		MS_RING_ON = 0x0040
+		MS_RLSD_ON = 0x0080
+	)
+	return modemStatus{
+		cts: status&MS_CTS_ON != 0,
+		dsr: status&MS_DSR_ON != 0,
+		dcd: status&MS_RLSD_ON != 0,
+		ri:  status&MS_RING_ON != 0,
+	}, nil
+}
+
+// DescribeTermios implements Port. There's no termios on Windows; the DCB
+// settings are in DumpSettings.
+func (p *serialPort) DescribeTermios() (string, error) {
+	return "", ErrNotSupported
+}
+
+// SetLowLatency implements Port. The latency timer of USB adapters is a
+// driver setting on Windows, so this always fails.
+func (p *serialPort) SetLowLatency(on bool) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	return ErrNotSupported
+}
+
+// ResetErrorCounters implements Port. Errors the driver has flagged but not
+// yet reported are discarded too.
+func (p *serialPort) ResetErrorCounters() error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	if _, err := p.clearErrors(); err != nil {
+		return p.portError(err)
+	}
+
+	p.el.Lock()
+	p.errCounts = ErrorCounters{}
+	p.el.Unlock()
+	return nil
+}
+
+// BufferSizes implements Port, reporting the queue sizes the driver chose
+// with GetCommProperties. There is no read buffer, ReadBufferSize being
+// ignored on Windows.
+func (p *serialPort) BufferSizes() (BufferSizes, error) {
+	if !p.acquire() {
+		return BufferSizes{}, ErrPortClosed
+	}
+	defer p.release()
+
+	prop, err := getCommProperties(p.fd)
+	if err != nil {
+		return BufferSizes{}, p.portError(err)
+	}
+
+	return BufferSizes{
+		InputQueue:  int(prop.dwCurrentRxQueue),
+		OutputQueue: int(prop.dwCurrentTxQueue),
+	}, nil
+}
+
+// Stats implements Port.
+func (p *serialPort) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *serialPort) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *serialPort) SetHooks(hooks PortHooks) {
+	p.cm.Lock()
+	p.options.Hooks = hooks
+	p.cm.Unlock()
+
+	p.counters.setHooks(hooks)
+}
+
+// clearErrors calls ClearCommError, adding any errors it reports to the
+// accumulated counts, and returns the device status. The caller must hold
+// the handle with acquire.
+func (p *serialPort) clearErrors() (*structComstat, error) {
+	p.el.Lock()
+	defer p.el.Unlock()
+
+	flags, stat, err := clearCommError(p.fd)
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		CE_RXOVER   = 0x0001
+		CE_OVERRUN  = 0x0002
+		CE_RXPARITY = 0x0004
+		CE_FRAME    = 0x0008
+		CE_BREAK    = 0x0010
+	)
+	if flags&CE_FRAME != 0 {
+		p.errCounts.Framing++
+	}
+	if flags&CE_RXPARITY != 0 {
+		p.errCounts.Parity++
+	}
+	if flags&CE_OVERRUN != 0 {
+		p.errCounts.Overrun++
+	}
+	if flags&CE_RXOVER != 0 {
+		p.errCounts.BufferOverrun++
+	}
+	if flags&CE_BREAK != 0 {
+		p.errCounts.Break++
+	}
+	return stat, nil
+}
+
+// portError replaces errors caused by the port having been closed with
+// ErrPortClosed, marks those caused by the device going away with
+// ErrDeviceRemoved, and adds the port's name.
+func (p *serialPort) portError(err error) error {
+	if err != nil && atomic.LoadInt32(&p.closed) != 0 {
+		return ErrPortClosed
+	}
+
+	const (
+		ERROR_BAD_COMMAND    = syscall.Errno(22)
+		ERROR_GEN_FAILURE    = syscall.Errno(31)
+		ERROR_DEVICE_REMOVED = syscall.Errno(1617)
+	)
+	if errors.Is(err, ERROR_BAD_COMMAND) || errors.Is(err, ERROR_GEN_FAILURE) || errors.Is(err, ERROR_DEVICE_REMOVED) {
+		err = markError(err, ErrDeviceRemoved)
+	}
+	return newPortError(p.f.Name(), "", err)
 }
 
 func (p *serialPort) Write(buf []byte) (int, error) {
+	n, err := p.write(buf)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *serialPort) write(buf []byte) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	max, delay := p.options.MaxWriteChunk, p.options.WriteChunkDelay
+	p.cm.Unlock()
+
+	return writeChunked(buf, max, delay, p.writeFile)
+}
+
+// WriteNineBit implements Port, using mark and space parity.
+func (p *serialPort) WriteNineBit(buf []byte, address bool) (int, error) {
+	n, err := p.writeNineBit(buf, address)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *serialPort) writeNineBit(buf []byte, address bool) (int, error) {
 	p.wl.Lock()
 	defer p.wl.Unlock()
 
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := syscall.FlushFileBuffers(p.fd); err != nil {
+		return 0, p.portError(err)
+	}
+
+	params, err := getCommState(p.fd)
+	if err != nil {
+		return 0, p.portError(err)
+	}
+	saved := *params
+
+	const (
+		MARKPARITY  = 3
+		SPACEPARITY = 4
+	)
+	params.Parity = SPACEPARITY
+	if address {
+		params.Parity = MARKPARITY
+	}
+
+	if err := putCommState(p.fd, params); err != nil {
+		return 0, p.portError(err)
+	}
+
+	n, err := writeChunked(buf, p.options.MaxWriteChunk, p.options.WriteChunkDelay, p.writeFile)
+	if flushErr := syscall.FlushFileBuffers(p.fd); err == nil {
+		err = p.portError(flushErr)
+	}
+	if restoreErr := putCommState(p.fd, &saved); err == nil {
+		err = p.portError(restoreErr)
+	}
+	return n, err
+}
+
+// writeFile writes buf with overlapped I/O, honouring the write deadline.
+// The caller must hold wl and have acquired the port.
+func (p *serialPort) writeFile(buf []byte) (int, error) {
 	if err := resetEvent(p.wo.HEvent); err != nil {
-		return 0, err
+		return 0, p.portError(err)
 	}
 	var n uint32
 	err := syscall.WriteFile(p.fd, buf, &n, p.wo)
 	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(n), err
+		return int(n), p.portError(err)
 	}
-	return getOverlappedResult(p.fd, p.wo)
+	return p.waitDeadline(p.wo, p.wk, &p.writeDeadline, time.Time{}, "write")
 }
 
 func (p *serialPort) Read(buf []byte) (int, error) {
 	if p == nil || p.f == nil {
-		return 0, fmt.Errorf("Invalid port on read %v %v", p, p.f)
+		var f *os.File
+		if p != nil {
+			f = p.f
+		}
+		return 0, fmt.Errorf("Invalid port on read %v %v", p, f)
 	}
 
+	n, err := p.read(buf)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+func (p *serialPort) read(buf []byte) (int, error) {
 	p.rl.Lock()
 	defer p.rl.Unlock()
 
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	p.cm.Lock()
+	overall := p.options.OverallReadTimeout
+	want := int(p.options.MinimumReadSize)
+	p.cm.Unlock()
+
+	if overall <= 0 {
+		return p.readFile(buf, time.Time{})
+	}
+
+	// With an overall timeout, keep reading until MinimumReadSize bytes (or
+	// with none, a full buffer) have arrived or the time is up.
+	if want == 0 || want > len(buf) {
+		want = len(buf)
+	}
+
+	end := time.Now().Add(overall)
+	n := 0
+	for n < want {
+		m, err := p.readFile(buf[n:], end)
+		n += m
+
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return n, err
+			}
+
+			p.dl.Lock()
+			deadline := p.readDeadline
+			p.dl.Unlock()
+
+			if !deadline.IsZero() && !time.Now().Before(deadline) {
+				return n, err
+			}
+
+			break
+		}
+
+		if !time.Now().Before(end) {
+			break
+		}
+	}
+
+	if n == 0 && len(buf) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// readFile issues a single ReadFile, which the read deadline or limit, if
+// earlier, interrupts. The caller must hold rl.
+func (p *serialPort) readFile(buf []byte, limit time.Time) (int, error) {
 	if err := resetEvent(p.ro.HEvent); err != nil {
-		return 0, err
+		return 0, p.portError(err)
 	}
 	var done uint32
 	err := syscall.ReadFile(p.fd, buf, &done, p.ro)
 	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(done), err
+		return int(done), p.portError(err)
+	}
+	return p.waitDeadline(p.ro, p.rk, &p.readDeadline, limit, "read")
+}
+
+// BytesAvailable implements Port, using ClearCommError.
+func (p *serialPort) BytesAvailable() (int, error) {
+	if !p.acquire() {
+		return 0, ErrPortClosed
+	}
+	defer p.release()
+
+	stat, err := p.clearErrors()
+	if err != nil {
+		return 0, p.portError(err)
+	}
+
+	return int(stat.cbInQue), nil
+}
+
+// WaitForData implements Port, using WaitCommEvent to wait for EV_RXCHAR.
+func (p *serialPort) WaitForData(timeout time.Duration) (bool, error) {
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	if !p.acquire() {
+		return false, ErrPortClosed
+	}
+	defer p.release()
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		stat, err := p.clearErrors()
+		if err != nil {
+			return false, p.portError(err)
+		}
+		if stat.cbInQue > 0 {
+			return true, nil
+		}
+
+		if err := resetEvent(p.eo.HEvent); err != nil {
+			return false, p.portError(err)
+		}
+		var mask uint32
+		err = waitCommEvent(p.fd, &mask, p.eo)
+		if err != nil && err != syscall.ERROR_IO_PENDING {
+			return false, p.portError(err)
+		}
+		if atomic.LoadInt32(&p.closed) != 0 {
+			cancelIo(p.fd)
+		}
+
+		const INFINITE = 0xFFFFFFFF
+		ms := uint32(INFINITE)
+		if !deadline.IsZero() {
+			ms = 0
+			if d := time.Until(deadline); d > 0 {
+				ms = uint32((d + time.Millisecond - 1) / time.Millisecond)
+			}
+		}
+
+		i, err := waitForMultipleObjects([]syscall.Handle{p.eo.HEvent}, ms)
+		if err != nil {
+			return false, p.portError(err)
+		}
+		if i == syscall.WAIT_TIMEOUT {
+			cancelIoEx(p.fd, p.eo)
+			getOverlappedResult(p.fd, p.eo)
+			return false, nil
+		}
+		if _, err := p.wait(p.eo); err != nil {
+			return false, p.portError(err)
+		}
+
+		// The mask may report some other event, or a character that has
+		// already been read; check the queue again.
+	}
+}
+
+// ReadWithTimestamp implements Port.
+func (p *serialPort) ReadWithTimestamp(buf []byte) (int, time.Time, error) {
+	n, err := p.Read(buf)
+	if n == 0 {
+		return n, time.Time{}, err
+	}
+	return n, time.Now(), err
+}
+
+// SetReadDeadline implements Port.
+func (p *serialPort) SetReadDeadline(t time.Time) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.dl.Lock()
+	p.readDeadline = t
+	p.dl.Unlock()
+	return p.portError(setEvent(p.rk))
+}
+
+// SetWriteDeadline implements Port.
+func (p *serialPort) SetWriteDeadline(t time.Time) error {
+	if !p.acquire() {
+		return ErrPortClosed
+	}
+	defer p.release()
+
+	p.dl.Lock()
+	p.writeDeadline = t
+	p.dl.Unlock()
+	return p.portError(setEvent(p.wk))
+}
+
+// waitDeadline waits for an overlapped Read or Write to complete, or for the
+// deadline or, if earlier and not zero, limit to pass, in which case the
+// operation is cancelled. The deadline is guarded by dl, and kick is
+// signalled when it changes.
+func (p *serialPort) waitDeadline(overlapped *syscall.Overlapped, kick syscall.Handle, deadlinePtr *time.Time, limit time.Time, op string) (int, error) {
+	const INFINITE = 0xFFFFFFFF
+	for {
+		if atomic.LoadInt32(&p.closed) != 0 {
+			break
+		}
+
+		p.dl.Lock()
+		deadline := *deadlinePtr
+		p.dl.Unlock()
+
+		if !limit.IsZero() && (deadline.IsZero() || limit.Before(deadline)) {
+			deadline = limit
+		}
+
+		timeout := uint32(INFINITE)
+		if !deadline.IsZero() {
+			timeout = 0
+			if d := time.Until(deadline); d > 0 {
+				timeout = uint32((d + time.Millisecond - 1) / time.Millisecond)
+			}
+		}
+
+		i, err := waitForMultipleObjects([]syscall.Handle{overlapped.HEvent, kick}, timeout)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			break
+		}
+		if i == syscall.WAIT_TIMEOUT {
+			cancelIoEx(p.fd, overlapped)
+			n, _ := getOverlappedResult(p.fd, overlapped)
+			if log := p.log.get(); log != nil {
+				log.Debug("serial: "+op+" deadline passed", "bytes", n)
+			}
+			return n, newPortError(p.f.Name(), op, os.ErrDeadlineExceeded)
+		}
+		// The deadline changed; go around again.
 	}
-	return getOverlappedResult(p.fd, p.ro)
+	return p.wait(overlapped)
 }
 
 var (
+	nGetCommState,
 	nSetCommState,
 	nSetCommTimeouts,
 	nSetCommMask,
 	nSetupComm,
 	nGetOverlappedResult,
 	nCreateEvent,
-	nResetEvent uintptr
+	nResetEvent,
+	nCancelIoEx,
+	nTransmitCommChar,
+	nClearCommError,
+	nSetEvent,
+	nWaitForMultipleObjects,
+	nWaitCommEvent,
+	nGetCommModemStatus,
+	nGetCommProperties,
+	nEscapeCommFunction,
+	nPurgeComm uintptr
 )
 
 func init() {
@@ -156,6 +1300,7 @@ func init() {
 	}
 	defer syscall.FreeLibrary(k32)
 
+	nGetCommState = getProcAddr(k32, "GetCommState")
 	nSetCommState = getProcAddr(k32, "SetCommState")
 	nSetCommTimeouts = getProcAddr(k32, "SetCommTimeouts")
 	nSetCommMask = getProcAddr(k32, "SetCommMask")
@@ -163,6 +1308,16 @@ func init() {
 	nGetOverlappedResult = getProcAddr(k32, "GetOverlappedResult")
 	nCreateEvent = getProcAddr(k32, "CreateEventW")
 	nResetEvent = getProcAddr(k32, "ResetEvent")
+	nCancelIoEx = getProcAddr(k32, "CancelIoEx")
+	nTransmitCommChar = getProcAddr(k32, "TransmitCommChar")
+	nClearCommError = getProcAddr(k32, "ClearCommError")
+	nSetEvent = getProcAddr(k32, "SetEvent")
+	nWaitForMultipleObjects = getProcAddr(k32, "WaitForMultipleObjects")
+	nWaitCommEvent = getProcAddr(k32, "WaitCommEvent")
+	nGetCommModemStatus = getProcAddr(k32, "GetCommModemStatus")
+	nGetCommProperties = getProcAddr(k32, "GetCommProperties")
+	nEscapeCommFunction = getProcAddr(k32, "EscapeCommFunction")
+	nPurgeComm = getProcAddr(k32, "PurgeComm")
 }
 
 func getProcAddr(lib syscall.Handle, name string) uintptr {
@@ -194,12 +1349,87 @@ func setCommState(h syscall.Handle, options OpenOptions) error {
 	params.BaudRate = uint32(options.BaudRate)
 	params.ByteSize = byte(options.DataBits)
 
+	switch options.InitialDTR {
+	case LINE_LEAVE, LINE_ASSERT:
+	case LINE_DEASSERT:
+		params.flags[0] &^= 0x30 // fDtrControl = DTR_CONTROL_DISABLE
+	default:
+		return errors.New("invalid setting for InitialDTR")
+	}
+
+	switch options.InitialRTS {
+	case LINE_LEAVE, LINE_DEASSERT:
+	case LINE_ASSERT:
+		params.flags[1] |= 0x10 // fRtsControl = RTS_CONTROL_ENABLE
+	default:
+		return errors.New("invalid setting for InitialRTS")
+	}
+
 	if options.RTSCTSFlowControl {
 		params.flags[0] |= 0x04 // fOutxCtsFlow = 0x1
+		params.flags[1] &^= 0x30
 		params.flags[1] |= 0x20 // fRtsControl = RTS_CONTROL_HANDSHAKE (0x2)
 	}
 
-	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(&params)), 0)
+	if options.RawDCB != nil {
+		if err := applyRawDCB(options.RawDCB, &params); err != nil {
+			return err
+		}
+	}
+
+	return putCommState(h, &params)
+}
+
+// applyRawDCB lets fn adjust params.
+func applyRawDCB(fn func(*DCB) error, params *structDCB) error {
+	d := &DCB{
+		BaudRate:  params.BaudRate,
+		Flags:     binary.LittleEndian.Uint32(params.flags[:]),
+		XonLim:    params.XonLim,
+		XoffLim:   params.XoffLim,
+		ByteSize:  params.ByteSize,
+		Parity:    params.Parity,
+		StopBits:  params.StopBits,
+		XonChar:   params.XonChar,
+		XoffChar:  params.XoffChar,
+		ErrorChar: params.ErrorChar,
+		EofChar:   params.EofChar,
+		EvtChar:   params.EvtChar,
+	}
+
+	if err := fn(d); err != nil {
+		return err
+	}
+
+	params.BaudRate = d.BaudRate
+	binary.LittleEndian.PutUint32(params.flags[:], d.Flags)
+	params.XonLim = d.XonLim
+	params.XoffLim = d.XoffLim
+	params.ByteSize = d.ByteSize
+	params.Parity = d.Parity
+	params.StopBits = d.StopBits
+	params.XonChar = d.XonChar
+	params.XoffChar = d.XoffChar
+	params.ErrorChar = d.ErrorChar
+	params.EofChar = d.EofChar
+	params.EvtChar = d.EvtChar
+
+	return nil
+}
+
+func getCommState(h syscall.Handle) (*structDCB, error) {
+	var params structDCB
+	params.DCBlength = uint32(unsafe.Sizeof(params))
+
+	r, _, err := syscall.Syscall(nGetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(&params)), 0)
+	if r == 0 {
+		return nil, err
+	}
+	return &params, nil
+}
+
+func putCommState(h syscall.Handle, params *structDCB) error {
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(params)), 0)
 	if r == 0 {
 		return err
 	}
@@ -273,7 +1503,7 @@ func setCommTimeouts(h syscall.Handle, options OpenOptions) error {
 	return nil
 }
 
-func setupComm(h syscall.Handle, in, out int) error {
+func setupComm(h syscall.Handle, in, out uint) error {
 	r, _, err := syscall.Syscall(nSetupComm, 3, uintptr(h), uintptr(in), uintptr(out))
 	if r == 0 {
 		return err
@@ -290,6 +1520,71 @@ func setCommMask(h syscall.Handle) error {
 	return nil
 }
 
+func clearCommError(h syscall.Handle) (uint32, *structComstat, error) {
+	var flags uint32
+	var stat structComstat
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(h), uintptr(unsafe.Pointer(&flags)), uintptr(unsafe.Pointer(&stat)))
+	if r == 0 {
+		return 0, nil, err
+	}
+	return flags, &stat, nil
+}
+
+func waitCommEvent(h syscall.Handle, mask *uint32, overlapped *syscall.Overlapped) error {
+	r, _, err := syscall.Syscall(nWaitCommEvent, 3, uintptr(h), uintptr(unsafe.Pointer(mask)), uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func getCommModemStatus(h syscall.Handle) (uint32, error) {
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(h), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return 0, err
+	}
+	return status, nil
+}
+
+func getCommProperties(h syscall.Handle) (*structCommProp, error) {
+	var prop structCommProp
+	prop.wPacketLength = uint16(unsafe.Sizeof(prop))
+	r, _, err := syscall.Syscall(nGetCommProperties, 2, uintptr(h), uintptr(unsafe.Pointer(&prop)), 0)
+	if r == 0 {
+		return nil, err
+	}
+	return &prop, nil
+}
+
+// escapeComm is escapeCommFunction, as used by pulse. A variable for the
+// tests.
+var escapeComm = escapeCommFunction
+
+func escapeCommFunction(h syscall.Handle, fn uint32) error {
+	r, _, err := syscall.Syscall(nEscapeCommFunction, 2, uintptr(h), uintptr(fn), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func purgeComm(h syscall.Handle, flags uint32) error {
+	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(h), uintptr(flags), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func transmitCommChar(h syscall.Handle, c byte) error {
+	r, _, err := syscall.Syscall(nTransmitCommChar, 2, uintptr(h), uintptr(c), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
 func resetEvent(h syscall.Handle) error {
 	r, _, err := syscall.Syscall(nResetEvent, 1, uintptr(h), 0, 0)
 	if r == 0 {
@@ -298,16 +1593,67 @@ func resetEvent(h syscall.Handle) error {
 	return nil
 }
 
+// cancelIo cancels all outstanding overlapped I/O on the handle, causing
+// pending calls to getOverlappedResult to return.
+func cancelIo(h syscall.Handle) error {
+	return cancelIoEx(h, nil)
+}
+
+// cancelIoEx cancels a single overlapped operation, or all of them if
+// overlapped is nil.
+func cancelIoEx(h syscall.Handle, overlapped *syscall.Overlapped) error {
+	r, _, err := syscall.Syscall(nCancelIoEx, 2, uintptr(h), uintptr(unsafe.Pointer(overlapped)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func setEvent(h syscall.Handle) error {
+	r, _, err := syscall.Syscall(nSetEvent, 1, uintptr(h), 0, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// waitForMultipleObjects waits for any of the handles to be signalled and
+// returns its index, or WAIT_TIMEOUT.
+func waitForMultipleObjects(handles []syscall.Handle, timeout uint32) (uint32, error) {
+	const WAIT_FAILED = 0xFFFFFFFF
+	r, _, err := syscall.Syscall6(nWaitForMultipleObjects, 4,
+		uintptr(len(handles)),
+		uintptr(unsafe.Pointer(&handles[0])),
+		0,
+		uintptr(timeout), 0, 0)
+	if uint32(r) == WAIT_FAILED {
+		return 0, err
+	}
+	return uint32(r), nil
+}
+
 func newOverlapped() (*syscall.Overlapped, error) {
 	var overlapped syscall.Overlapped
-	r, _, err := syscall.Syscall6(nCreateEvent, 4, 0, 1, 0, 0, 0, 0)
-	if r == 0 {
+	h, err := newEvent(true)
+	if err != nil {
 		return nil, err
 	}
-	overlapped.HEvent = syscall.Handle(r)
+	overlapped.HEvent = h
 	return &overlapped, nil
 }
 
+func newEvent(manualReset bool) (syscall.Handle, error) {
+	var manual uintptr
+	if manualReset {
+		manual = 1
+	}
+	r, _, err := syscall.Syscall6(nCreateEvent, 4, 0, manual, 0, 0, 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return syscall.Handle(r), nil
+}
+
 func getOverlappedResult(h syscall.Handle, overlapped *syscall.Overlapped) (int, error) {
 	var n int
 	r, _, err := syscall.Syscall6(nGetOverlappedResult, 4,
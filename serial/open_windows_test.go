@@ -0,0 +1,61 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPulseDTRClose(t *testing.T) {
+	// Stand in for the driver, so that no port is needed.
+	defer func(f func(syscall.Handle, uint32) error) { escapeComm = f }(escapeComm)
+	escapeComm = func(syscall.Handle, uint32) error { return nil }
+
+	f, err := os.CreateTemp(t.TempDir(), "port")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &serialPort{f: f, fd: syscall.Handle(f.Fd())}
+
+	pulsed := make(chan error, 1)
+	go func() { pulsed <- p.PulseDTR(200 * time.Millisecond) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// A control call and Close during the pulse must not deadlock with it.
+	set := make(chan error, 1)
+	go func() { set <- p.SetDTR(true) }()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+
+	for _, c := range []struct {
+		name string
+		ch   chan error
+	}{{"Close", closed}, {"SetDTR", set}, {"PulseDTR", pulsed}} {
+		select {
+		case err := <-c.ch:
+			if c.name == "PulseDTR" && err != ErrPortClosed {
+				t.Errorf("PulseDTR: got %v, want ErrPortClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s deadlocked", c.name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"fmt"
+	"time"
+)
+
+// Option configures a port opened with OpenPort. Each one validates its
+// argument, and OpenPort fails with the first error.
+type Option func(*OpenOptions) error
+
+// OpenPort opens the named port, configured by opts. It is a shorthand for
+// Open: without options the port runs at 115200 baud, 8N1, without flow
+// control, and Read blocks until at least one byte has arrived. Later
+// options override earlier ones.
+func OpenPort(name string, opts ...Option) (Port, error) {
+	options, err := portOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(options)
+}
+
+// portOptions returns the OpenOptions that OpenPort opens name with.
+func portOptions(name string, opts []Option) (OpenOptions, error) {
+	options := OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		ParityMode:      PARITY_NONE,
+		MinimumReadSize: 1,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return OpenOptions{}, err
+		}
+	}
+
+	return options, nil
+}
+
+// WithBaudRate sets the baud rate, which must be non-zero.
+func WithBaudRate(baud uint) Option {
+	return func(o *OpenOptions) error {
+		if baud == 0 {
+			return fmt.Errorf("serial: invalid baud rate %d", baud)
+		}
+
+		o.BaudRate = baud
+		return nil
+	}
+}
+
+// WithDataBits sets the number of data bits per frame: 5, 6, 7 or 8.
+func WithDataBits(bits uint) Option {
+	return func(o *OpenOptions) error {
+		if bits < 5 || bits > 8 {
+			return fmt.Errorf("serial: invalid number of data bits %d", bits)
+		}
+
+		o.DataBits = bits
+		return nil
+	}
+}
+
+// WithStopBits sets the number of stop bits per frame: 1 or 2.
+func WithStopBits(bits uint) Option {
+	return func(o *OpenOptions) error {
+		if bits != 1 && bits != 2 {
+			return fmt.Errorf("serial: invalid number of stop bits %d", bits)
+		}
+
+		o.StopBits = bits
+		return nil
+	}
+}
+
+// WithParity sets the parity mode.
+func WithParity(mode ParityMode) Option {
+	return func(o *OpenOptions) error {
+		switch mode {
+		case PARITY_NONE, PARITY_ODD, PARITY_EVEN:
+		default:
+			return fmt.Errorf("serial: invalid parity mode %d", mode)
+		}
+
+		o.ParityMode = mode
+		return nil
+	}
+}
+
+// WithRTSCTSFlowControl turns RTS/CTS (hardware) flow control on or off.
+func WithRTSCTSFlowControl(on bool) Option {
+	return func(o *OpenOptions) error {
+		o.RTSCTSFlowControl = on
+		return nil
+	}
+}
+
+// WithReadTimeout makes Read give up if no data arrives within d, rather than
+// blocking until it does (see InterCharacterTimeout). Data already waiting is
+// returned at once. d must be between 100ms and 25.5s, and is rounded to the
+// nearest 100ms on some systems.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *OpenOptions) error {
+		if d < 100*time.Millisecond || d > 25500*time.Millisecond {
+			return fmt.Errorf("serial: read timeout %v outside 100ms to 25.5s", d)
+		}
+
+		o.InterCharacterTimeout = uint(d / time.Millisecond)
+		o.MinimumReadSize = 0
+		return nil
+	}
+}
+
+// WithMinimumReadSize makes Read wait for at least n bytes, or for the read
+// timeout to pass between bytes if WithReadTimeout is also given. It must
+// come after any WithReadTimeout option, which resets it, and n must be
+// between 1 and 255.
+func WithMinimumReadSize(n uint) Option {
+	return func(o *OpenOptions) error {
+		if n < 1 || n > 255 {
+			return fmt.Errorf("serial: invalid minimum read size %d", n)
+		}
+
+		o.MinimumReadSize = n
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPortOptions(t *testing.T) {
+	options, err := portOptions("/dev/ttyUSB0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.PortName != "/dev/ttyUSB0" {
+		t.Errorf("PortName: got %q", options.PortName)
+	}
+
+	if got := FormatMode(options); got != "115200 8N1" {
+		t.Errorf("default mode: got %q", got)
+	}
+
+	if options.InterCharacterTimeout != 0 || options.MinimumReadSize != 1 {
+		t.Errorf("default timeouts: got %d ms, %d bytes", options.InterCharacterTimeout, options.MinimumReadSize)
+	}
+
+	options, err = portOptions("/dev/ttyUSB0", []Option{
+		WithBaudRate(9600),
+		WithDataBits(7),
+		WithParity(PARITY_EVEN),
+		WithStopBits(2),
+		WithRTSCTSFlowControl(true),
+		WithReadTimeout(500 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := FormatMode(options); got != "9600 7E2, rtscts" {
+		t.Errorf("mode: got %q", got)
+	}
+
+	if options.InterCharacterTimeout != 500 || options.MinimumReadSize != 0 {
+		t.Errorf("timeouts: got %d ms, %d bytes", options.InterCharacterTimeout, options.MinimumReadSize)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	testCases := []struct {
+		name string
+		opt  Option
+	}{
+		{"zero baud rate", WithBaudRate(0)},
+		{"4 data bits", WithDataBits(4)},
+		{"9 data bits", WithDataBits(9)},
+		{"3 stop bits", WithStopBits(3)},
+		{"unknown parity", WithParity(ParityMode(7))},
+		{"short timeout", WithReadTimeout(50 * time.Millisecond)},
+		{"long timeout", WithReadTimeout(time.Minute)},
+		{"zero read size", WithMinimumReadSize(0)},
+		{"huge read size", WithMinimumReadSize(256)},
+	}
+
+	for _, tc := range testCases {
+		if _, err := OpenPort("/dev/ttyUSB0", tc.opt); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,607 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// PipePort is one end of an in-memory serial link made by Pipe, for testing
+// code that uses a Port without hardware. It implements every Port method,
+// including deadlines, and InjectErrors lets a test make calls fail.
+//
+// Data written to one end can be read from the other straight away; the link
+// has no baud rate, so Drain returns at once and SetMode, SetBaudRate and the
+// like merely record the settings for CurrentOptions. Pause stops the other
+// end's Writes until Resume is called, as hardware flow control would, and
+// with the receiver disabled incoming data is dropped. As in a null-modem
+// cable, each end's DTR is the other's carrier: CarrierDetect reports whether
+// the other end is open with DTR raised, as it is to begin with. Once one end
+// is closed, the other reads what is left and then fails with an error
+// matching ErrDeviceRemoved, and its Writes fail with io.ErrClosedPipe.
+type PipePort struct {
+	// First, for alignment.
+	counters portCounters
+
+	s    *pipeState
+	name string
+	peer *PipePort
+
+	// The rest is guarded by s.mu.
+
+	// Data written by the peer and not yet read, with the time of each write.
+	chunkQueue
+
+	closed      bool
+	paused      bool // the peer may not write
+	receiverOff bool
+	dtrOff      bool
+
+	options       OpenOptions
+	readDeadline  time.Time
+	writeDeadline time.Time
+	inject        func(op string) error
+	errorCounters ErrorCounters
+}
+
+// pipeState is shared by the two ends of a pipe.
+type pipeState struct {
+	signal
+}
+
+// Pipe returns the two ends of an in-memory serial link, named "pipe0" and
+// "pipe1", each configured as 115200 8N1.
+func Pipe() (*PipePort, *PipePort) {
+	s := &pipeState{}
+
+	a := &PipePort{s: s, name: "pipe0"}
+	b := &PipePort{s: s, name: "pipe1"}
+	a.peer, b.peer = b, a
+
+	for _, p := range []*PipePort{a, b} {
+		p.options, _ = portOptions(p.name, nil)
+	}
+
+	return a, b
+}
+
+// InjectErrors arranges for fn to be called at the start of every method
+// other than Close, String, Stats and InjectErrors itself, with the method's
+// name (such as "Read" or "SetBaudRate"). If fn returns an error, the method
+// fails with it without doing anything. A nil fn stops the injection.
+func (p *PipePort) InjectErrors(fn func(op string) error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	p.inject = fn
+}
+
+// SetErrorCounters sets the counts that ErrorCounters reports, to simulate
+// line errors.
+func (p *PipePort) SetErrorCounters(c ErrorCounters) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	p.errorCounters = c
+}
+
+// begin runs the injection hook for op, then takes the lock, failing if the
+// port is closed. On success the caller must unlock s.mu.
+func (p *PipePort) begin(op string) error {
+	p.s.mu.Lock()
+	inject := p.inject
+	p.s.mu.Unlock()
+
+	if inject != nil {
+		if err := inject(op); err != nil {
+			return err
+		}
+	}
+
+	p.s.mu.Lock()
+	if p.closed {
+		p.s.mu.Unlock()
+		return ErrPortClosed
+	}
+
+	return nil
+}
+
+// Read implements io.Reader. It waits until data is available, then returns
+// as much as fits in b.
+func (p *PipePort) Read(b []byte) (int, error) {
+	n, _, err := p.read("Read", b)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port, giving the time at which the first of
+// the bytes was written to the other end.
+func (p *PipePort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, first, err := p.read("ReadWithTimestamp", b)
+	p.counters.countRead(n, err)
+	return n, first, err
+}
+
+func (p *PipePort) read(op string, b []byte) (int, time.Time, error) {
+	if err := p.begin(op); err != nil {
+		return 0, time.Time{}, err
+	}
+	defer p.s.mu.Unlock()
+
+	for len(p.chunks) == 0 {
+		if p.peer.closed {
+			return 0, time.Time{}, errConnClosed
+		}
+
+		if err := p.s.wait(p.readDeadline); err != nil {
+			return 0, time.Time{}, err
+		}
+
+		if p.closed {
+			return 0, time.Time{}, ErrPortClosed
+		}
+	}
+
+	n, first := p.take(b)
+	return n, first, nil
+}
+
+// Write implements io.Writer. It waits while the other end has paused the
+// link, and otherwise hands the data over at once.
+func (p *PipePort) Write(b []byte) (int, error) {
+	n, err := p.write("Write", b)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *PipePort) write(op string, b []byte) (int, error) {
+	if err := p.begin(op); err != nil {
+		return 0, err
+	}
+	defer p.s.mu.Unlock()
+
+	for p.peer.paused && !p.peer.closed {
+		if err := p.s.wait(p.writeDeadline); err != nil {
+			return 0, err
+		}
+
+		if p.closed {
+			return 0, ErrPortClosed
+		}
+	}
+
+	if p.peer.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if len(b) > 0 && !p.peer.receiverOff {
+		data := append([]byte(nil), b...)
+		p.peer.push(data, time.Now())
+		p.s.notify()
+	}
+
+	return len(b), nil
+}
+
+// Close implements Port.
+func (p *PipePort) Close() error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		p.chunks = nil
+		p.s.notify()
+	}
+
+	return nil
+}
+
+// String implements Port.
+func (p *PipePort) String() string {
+	return p.name
+}
+
+// Stats implements Port.
+func (p *PipePort) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *PipePort) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *PipePort) SetHooks(hooks PortHooks) {
+	p.s.mu.Lock()
+	p.options.Hooks = hooks
+	p.s.mu.Unlock()
+
+	p.counters.setHooks(hooks)
+}
+
+// setFlowControl implements Pause and Resume.
+func (p *PipePort) setFlowControl(op string, paused bool) error {
+	if err := p.begin(op); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.paused = paused
+	p.s.notify()
+
+	return nil
+}
+
+// Pause implements Port, making Writes at the other end wait.
+func (p *PipePort) Pause() error {
+	return p.setFlowControl("Pause", true)
+}
+
+// Resume implements Port.
+func (p *PipePort) Resume() error {
+	return p.setFlowControl("Resume", false)
+}
+
+// ErrorCounters implements Port, returning the counts set with
+// SetErrorCounters, plus a break for each the other end has started.
+func (p *PipePort) ErrorCounters() (ErrorCounters, error) {
+	if err := p.begin("ErrorCounters"); err != nil {
+		return ErrorCounters{}, err
+	}
+	defer p.s.mu.Unlock()
+
+	return p.errorCounters, nil
+}
+
+// ResetErrorCounters implements Port.
+func (p *PipePort) ResetErrorCounters() error {
+	if err := p.begin("ResetErrorCounters"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.errorCounters = ErrorCounters{}
+	return nil
+}
+
+// BufferSizes implements Port. A pipe's buffers have no fixed size, so all
+// are reported as zero.
+func (p *PipePort) BufferSizes() (BufferSizes, error) {
+	if err := p.begin("BufferSizes"); err != nil {
+		return BufferSizes{}, err
+	}
+	defer p.s.mu.Unlock()
+
+	return BufferSizes{}, nil
+}
+
+// SetLowLatency implements Port. It has no effect.
+func (p *PipePort) SetLowLatency(on bool) error {
+	if err := p.begin("SetLowLatency"); err != nil {
+		return err
+	}
+	p.s.mu.Unlock()
+
+	return nil
+}
+
+// CurrentOptions implements Port.
+func (p *PipePort) CurrentOptions() (OpenOptions, error) {
+	if err := p.begin("CurrentOptions"); err != nil {
+		return OpenOptions{}, err
+	}
+	defer p.s.mu.Unlock()
+
+	return p.options, nil
+}
+
+// BaudRate implements Port.
+func (p *PipePort) BaudRate() (uint, error) {
+	if err := p.begin("BaudRate"); err != nil {
+		return 0, err
+	}
+	defer p.s.mu.Unlock()
+
+	return p.options.BaudRate, nil
+}
+
+// SetLogger implements Port. A pipe has nothing to explain, so the logger is
+// only recorded in the options.
+func (p *PipePort) SetLogger(l *slog.Logger) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	p.options.Logger = l
+}
+
+// DumpSettings implements Port.
+func (p *PipePort) DumpSettings() (string, error) {
+	if err := p.begin("DumpSettings"); err != nil {
+		return "", err
+	}
+	defer p.s.mu.Unlock()
+
+	receiver := ""
+	if p.receiverOff {
+		receiver = "receiver off"
+	}
+
+	return joinSettings(p.name, FormatMode(p.options), receiver), nil
+}
+
+// DescribeTermios implements Port. A pipe has no termios, so it returns
+// ErrNotSupported.
+func (p *PipePort) DescribeTermios() (string, error) {
+	if err := p.begin("DescribeTermios"); err != nil {
+		return "", err
+	}
+	p.s.mu.Unlock()
+
+	return "", ErrNotSupported
+}
+
+// SetMode implements Port.
+func (p *PipePort) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	if err := p.begin("SetMode"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	options.ParityMode = parity
+	options.StopBits = stopBits
+	options.RTSCTSFlowControl = rtscts
+
+	if err := options.validate(false); err != nil {
+		return err
+	}
+
+	p.options = options
+	return nil
+}
+
+// SetBaudRate implements Port.
+func (p *PipePort) SetBaudRate(baud uint) error {
+	if err := p.begin("SetBaudRate"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	options := p.options
+	options.BaudRate = baud
+
+	if err := options.validate(false); err != nil {
+		return err
+	}
+
+	p.options = options
+	return nil
+}
+
+// WithBaudRate implements Port.
+func (p *PipePort) WithBaudRate(baud uint, fn func() error) error {
+	if err := p.begin("WithBaudRate"); err != nil {
+		return err
+	}
+	prev := p.options.BaudRate
+	p.s.mu.Unlock()
+
+	return withBaudRate(p, prev, baud, fn)
+}
+
+// Drain implements Port. Written data is handed over immediately, so there
+// is nothing to wait for.
+func (p *PipePort) Drain() error {
+	if err := p.begin("Drain"); err != nil {
+		return err
+	}
+	p.s.mu.Unlock()
+
+	return nil
+}
+
+// Quiesce implements Port.
+func (p *PipePort) Quiesce() error {
+	if err := p.begin("Quiesce"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.chunks = nil
+	return nil
+}
+
+// WriteNineBit implements Port. A pipe carries bytes, so the ninth bit is
+// dropped and b is written as Write would.
+func (p *PipePort) WriteNineBit(b []byte, address bool) (int, error) {
+	n, err := p.write("WriteNineBit", b)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// SetRTS implements Port. The other end isn't told.
+func (p *PipePort) SetRTS(on bool) error {
+	if err := p.begin("SetRTS"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	if p.options.RTSCTSFlowControl {
+		return errRTSFlowControl
+	}
+	return nil
+}
+
+// SetDTR implements Port. The other end sees DTR as its carrier.
+func (p *PipePort) SetDTR(on bool) error {
+	if err := p.begin("SetDTR"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.dtrOff = !on
+	return nil
+}
+
+// CarrierDetect implements Port, reporting whether the other end is open
+// with DTR raised.
+func (p *PipePort) CarrierDetect() (bool, error) {
+	if err := p.begin("CarrierDetect"); err != nil {
+		return false, err
+	}
+	defer p.s.mu.Unlock()
+
+	return !p.peer.closed && !p.peer.dtrOff, nil
+}
+
+// SetBreak implements Port. Starting a break counts one in the other end's
+// ErrorCounters.
+func (p *PipePort) SetBreak(on bool) error {
+	if err := p.begin("SetBreak"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	if on {
+		p.peer.errorCounters.Break++
+	}
+	return nil
+}
+
+// WriteWithRTS implements Port. RTS is raised and lowered around the write
+// as by SetRTS, but without the delays, since the data arrives at once.
+func (p *PipePort) WriteWithRTS(b []byte) (int, error) {
+	if err := p.SetRTS(true); err != nil {
+		p.counters.countWrite(0, err)
+		return 0, err
+	}
+
+	n, err := p.write("WriteWithRTS", b)
+	if rtsErr := p.SetRTS(false); err == nil {
+		err = rtsErr
+	}
+
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// SetReceiverEnabled implements Port.
+func (p *PipePort) SetReceiverEnabled(on bool) error {
+	if err := p.begin("SetReceiverEnabled"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.receiverOff = !on
+	return nil
+}
+
+// PulseDTR implements Port. The other end isn't told; the pulse just takes
+// d and discards the input received meanwhile.
+func (p *PipePort) PulseDTR(d time.Duration) error {
+	return p.pulse("PulseDTR", d)
+}
+
+// PulseRTS implements Port, like PulseDTR.
+func (p *PipePort) PulseRTS(d time.Duration) error {
+	return p.pulse("PulseRTS", d)
+}
+
+func (p *PipePort) pulse(op string, d time.Duration) error {
+	if err := p.begin(op); err != nil {
+		return err
+	}
+	p.s.mu.Unlock()
+
+	time.Sleep(d)
+
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	p.chunks = nil
+	return nil
+}
+
+// WaitForData implements Port. Once the other end is closed it reports that
+// data is available, so that a subsequent Read reports it.
+func (p *PipePort) WaitForData(timeout time.Duration) (bool, error) {
+	if err := p.begin("WaitForData"); err != nil {
+		return false, err
+	}
+	defer p.s.mu.Unlock()
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for len(p.chunks) == 0 && !p.peer.closed {
+		if err := p.s.wait(deadline); err != nil {
+			return false, nil
+		}
+
+		if p.closed {
+			return false, ErrPortClosed
+		}
+	}
+
+	return true, nil
+}
+
+// BytesAvailable implements Port.
+func (p *PipePort) BytesAvailable() (int, error) {
+	if err := p.begin("BytesAvailable"); err != nil {
+		return 0, err
+	}
+	defer p.s.mu.Unlock()
+
+	return p.buffered(), nil
+}
+
+// SetReadDeadline implements Port.
+func (p *PipePort) SetReadDeadline(t time.Time) error {
+	if err := p.begin("SetReadDeadline"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.readDeadline = t
+	p.s.notify()
+
+	return nil
+}
+
+// SetWriteDeadline implements Port.
+func (p *PipePort) SetWriteDeadline(t time.Time) error {
+	if err := p.begin("SetWriteDeadline"); err != nil {
+		return err
+	}
+	defer p.s.mu.Unlock()
+
+	p.writeDeadline = t
+	p.s.notify()
+
+	return nil
+}
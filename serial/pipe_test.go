@@ -0,0 +1,383 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var port Port = a
+	if _, err := port.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.BytesAvailable(); n != 5 || err != nil {
+		t.Errorf("BytesAvailable: %d, %v", n, err)
+	}
+
+	buf := make([]byte, 3)
+	n, ts, err := b.ReadWithTimestamp(buf)
+	if err != nil || string(buf[:n]) != "hel" || time.Since(ts) > time.Second {
+		t.Fatalf("ReadWithTimestamp: %q, %v, %v", buf[:n], ts, err)
+	}
+
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "lo" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	// The ninth bit doesn't survive the trip.
+	if _, err := port.WriteNineBit([]byte("a"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "a" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	// A blocked Read returns once the other end writes.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Write([]byte("x"))
+	}()
+
+	if n, err := a.Read(buf); err != nil || string(buf[:n]) != "x" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+}
+
+func TestPipeDeadlines(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: got %v, want a deadline error", err)
+	}
+
+	// Writes wait while the other end has paused the link.
+	if err := b.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	a.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := a.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write: got %v, want a deadline error", err)
+	}
+
+	a.SetWriteDeadline(time.Time{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Resume()
+	}()
+
+	if _, err := a.Write([]byte("x")); err != nil {
+		t.Errorf("Write after Resume: %v", err)
+	}
+
+	if ok, err := b.WaitForData(time.Second); !ok || err != nil {
+		t.Errorf("WaitForData: %v, %v", ok, err)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := Pipe()
+
+	a.Write([]byte("x"))
+
+	// Closing interrupts a Read in progress.
+	done := make(chan error)
+	go func() {
+		_, err := a.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	a.Close()
+
+	if err := <-done; err != ErrPortClosed {
+		t.Errorf("interrupted Read: got %v, want ErrPortClosed", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	if err := a.SetBaudRate(9600); err != ErrPortClosed {
+		t.Errorf("SetBaudRate after Close: %v", err)
+	}
+
+	// The other end reads what's left, then fails.
+	buf := make([]byte, 4)
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "x" {
+		t.Errorf("Read: %q, %v", buf[:n], err)
+	}
+
+	if _, err := b.Read(buf); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Read: got %v, want ErrDeviceRemoved", err)
+	}
+
+	if _, err := b.Write(buf); err != io.ErrClosedPipe {
+		t.Errorf("Write: got %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestPipeInjectErrors(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	failure := errors.New("unplugged")
+	a.InjectErrors(func(op string) error {
+		if op == "Write" {
+			return failure
+		}
+		return nil
+	})
+
+	if _, err := a.Write([]byte("x")); err != failure {
+		t.Errorf("Write: got %v, want the injected error", err)
+	}
+
+	if n, _ := b.BytesAvailable(); n != 0 {
+		t.Errorf("failed Write delivered %d bytes", n)
+	}
+
+	if err := a.SetBaudRate(9600); err != nil {
+		t.Errorf("SetBaudRate: %v", err)
+	}
+
+	a.InjectErrors(nil)
+	if _, err := a.Write([]byte("x")); err != nil {
+		t.Errorf("Write after clearing: %v", err)
+	}
+}
+
+func TestPipeSettings(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if err := a.SetMode(7, PARITY_EVEN, 2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.SetMode(9, PARITY_NONE, 1, false); err == nil {
+		t.Error("expected an error for 9 data bits")
+	}
+
+	err := a.WithBaudRate(9600, func() error {
+		if got, _ := a.CurrentOptions(); got.BaudRate != 9600 {
+			t.Errorf("inside WithBaudRate: %d", got.BaudRate)
+		}
+		if baud, err := a.BaudRate(); baud != 9600 || err != nil {
+			t.Errorf("BaudRate: %d, %v", baud, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ := a.DumpSettings(); s != "pipe0: 115200 7E2, rtscts" {
+		t.Errorf("DumpSettings: %q", s)
+	}
+
+	// Input arriving with the receiver off is lost.
+	b.SetReceiverEnabled(false)
+	a.Write([]byte("lost"))
+	b.SetReceiverEnabled(true)
+
+	if n, _ := b.BytesAvailable(); n != 0 {
+		t.Errorf("%d bytes received with the receiver off", n)
+	}
+}
+
+func TestPipeCarrierDetect(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+
+	check := func(want bool) {
+		t.Helper()
+
+		got, err := a.CarrierDetect()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("CarrierDetect: expected %t, got %t", want, got)
+		}
+	}
+
+	// The other end's DTR is the carrier, until it closes.
+	check(true)
+
+	b.SetDTR(false)
+	check(false)
+
+	b.SetDTR(true)
+	check(true)
+
+	b.Close()
+	check(false)
+}
+
+func TestPipeWriteWithRTS(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var ops []string
+	a.InjectErrors(func(op string) error {
+		ops = append(ops, op)
+		return nil
+	})
+
+	if n, err := a.WriteWithRTS([]byte("frame")); n != 5 || err != nil {
+		t.Fatalf("WriteWithRTS: %d, %v", n, err)
+	}
+
+	if got := strings.Join(ops, " "); got != "SetRTS WriteWithRTS SetRTS" {
+		t.Errorf("ops: %s", got)
+	}
+
+	buf := make([]byte, 5)
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "frame" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	// With RTS/CTS flow control the driver owns RTS.
+	if err := a.SetMode(8, PARITY_NONE, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.SetRTS(true); err != errRTSFlowControl {
+		t.Errorf("SetRTS: %v", err)
+	}
+
+	if _, err := a.WriteWithRTS([]byte("x")); err != errRTSFlowControl {
+		t.Errorf("WriteWithRTS: %v", err)
+	}
+
+	if n, _ := b.BytesAvailable(); n != 0 {
+		t.Errorf("failed WriteWithRTS delivered %d bytes", n)
+	}
+}
+
+func TestPipeStats(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	a.Write([]byte("hello"))
+	a.WriteNineBit([]byte("!"), true)
+	b.Read(make([]byte, 8))
+
+	// A passed deadline counts as a failed read.
+	b.SetReadDeadline(time.Now())
+	b.ReadWithTimestamp(make([]byte, 4))
+	b.ReadWithTimestamp(make([]byte, 4))
+
+	b.Close()
+	a.Write([]byte("lost"))
+
+	if got, want := a.Stats(), (PortStats{BytesWritten: 6, WriteErrors: 1}); got != want {
+		t.Errorf("a: got %+v, want %+v", got, want)
+	}
+
+	if got, want := b.Stats(), (PortStats{BytesRead: 6, ReadErrors: 2, ReadTimeouts: 2}); got != want {
+		t.Errorf("b: got %+v, want %+v", got, want)
+	}
+
+	b.ResetStats()
+	if got := b.Stats(); got != (PortStats{}) {
+		t.Errorf("after ResetStats: got %+v", got)
+	}
+}
+
+func TestPipeHooks(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var read, written int
+	var errs []error
+	b.SetHooks(PortHooks{
+		OnRead:  func(n int) { read += n },
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	a.SetHooks(PortHooks{OnWrite: func(n int) { written += n }})
+
+	a.Write([]byte("hello"))
+	b.Read(make([]byte, 8))
+	b.SetReadDeadline(time.Now())
+	b.Read(make([]byte, 8))
+
+	if read != 5 || written != 5 {
+		t.Errorf("hooks saw %d bytes read and %d written, want 5 and 5", read, written)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], os.ErrDeadlineExceeded) {
+		t.Errorf("OnError got %v, want one deadline error", errs)
+	}
+}
+
+func TestReadFullClosedPipe(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	// The frame is cut short by the other end closing, which fails at once
+	// rather than being taken for a timeout.
+	a.Write([]byte("ab"))
+	a.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 4)
+		n, err := ReadFull(b, buf)
+		if n != 2 || !errors.Is(err, ErrDeviceRemoved) {
+			t.Errorf("ReadFull: %d, %v", n, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadFull still blocked after the other end closed")
+	}
+
+	// From a plain reader, io.EOF is the end of the data.
+	buf := make([]byte, 4)
+	if n, err := ReadFull(strings.NewReader("ab"), buf); n != 2 || err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFull: %d, %v", n, err)
+	}
+
+	if n, err := ReadFull(strings.NewReader(""), buf); n != 0 || err != io.EOF {
+		t.Errorf("ReadFull: %d, %v", n, err)
+	}
+}
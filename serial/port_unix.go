@@ -0,0 +1,1407 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux || solaris
+
+// This file contains the port type shared by the POSIX implementations. The
+// per-OS files are responsible for translating OpenOptions into the right
+// termios flavour; everything after that lives here.
+//
+// The descriptor is left in non-blocking mode and registered with the Go
+// runtime poller, which is what allows Close to interrupt a Read that is
+// blocked in another goroutine. The kernel ignores VMIN and VTIME for
+// non-blocking descriptors, so Read emulates them instead.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type unixPort struct {
+	// First, for alignment.
+	counters portCounters
+
+	f *os.File
+
+	// The options the port is currently configured with, guarded by cm. The
+	// methods that change the port's settings or modem lines also hold cm
+	// throughout, so that their read-modify-write ioctls don't interleave.
+	cm      sync.Mutex
+	options OpenOptions
+
+	// Set if the runtime poller refused the descriptor, in which case it has
+	// been put back into blocking mode and the kernel enforces VMIN and VTIME.
+	blocking bool
+
+	// The emulated VMIN and VTIME settings, and OverallReadTimeout. See
+	// OpenOptions for details.
+	minimumReadSize       uint
+	interCharacterTimeout time.Duration
+	overallReadTimeout    time.Duration
+
+	// The MaxWriteChunk and WriteChunkDelay settings.
+	maxWriteChunk   int
+	writeChunkDelay time.Duration
+
+	// Held for the duration of a Read, and of a Write.
+	rl sync.Mutex
+	wl sync.Mutex
+
+	// Input read from the driver ahead of the caller, if ReadBufferSize is
+	// set. rbuf is only used by the holder of rl; pending, the unread part of
+	// it, and pendingTime, when it was read, are guarded by bl.
+	rbuf        []byte
+	bl          sync.Mutex
+	pending     []byte
+	pendingTime time.Time
+
+	// The deadline set by SetReadDeadline, guarded by dl. Whoever holds dl
+	// also owns the descriptor's read deadline.
+	dl           sync.Mutex
+	readDeadline time.Time
+
+	// The driver's error counters at the time the port was opened or the
+	// counts were last reset, guarded by el.
+	el        sync.Mutex
+	errorBase ErrorCounters
+
+	// Where the port explains itself, if anywhere.
+	log portLogger
+
+	closeOnce sync.Once
+
+	// Set to 1 by Close.
+	closed int32
+}
+
+// errHangup is returned by Read when the driver reports end of file on a
+// non-blocking descriptor, which some do once the device has gone.
+var errHangup = markError(errors.New("hung up"), ErrDeviceRemoved)
+
+func openInternal(options OpenOptions) (Port, error) {
+	// A named pipe or socket stands in for a device in simulations.
+	if fi, err := os.Stat(options.PortName); err == nil && fi.Mode()&(os.ModeNamedPipe|os.ModeSocket) != 0 {
+		return openStream(options)
+	}
+
+	configure, err := configureFunc(options)
+	if err != nil {
+		return nil, newPortError(options.PortName, "", err)
+	}
+
+	// Open the serial port in non-blocking mode, since otherwise the OS will
+	// wait for the CARRIER line to be asserted. We leave it that way so that
+	// the runtime poller can manage the descriptor. os.OpenFile adds
+	// O_CLOEXEC anyway, but a descriptor leaked across exec would keep the
+	// port busy, so we ask for it explicitly.
+	file, err := sys.open(options.PortName, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC)
+
+	if err != nil {
+		return nil, openError(resolvedError(options.PortName, err))
+	}
+
+	p, err := newUnixPort(file, options, configure)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// resolvedError adds the device a symbolic link such as one under
+// /dev/serial/by-id resolves to to an error from opening it, since that's
+// what the permissions and locks that cause trouble apply to.
+func resolvedError(name string, err error) error {
+	dev, evalErr := filepath.EvalSymlinks(name)
+	if evalErr != nil || dev == name {
+		return err
+	}
+
+	return fmt.Errorf("%w (resolved to %s)", err, dev)
+}
+
+func openFdInternal(fd uintptr, options OpenOptions) (Port, error) {
+	configure, err := configureFunc(options)
+	if err != nil {
+		return nil, newPortError(options.PortName, "", err)
+	}
+
+	dup, err := sys.fcntl(fd, unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, newPortError(options.PortName, "duplicating descriptor", os.NewSyscallError("fcntl", err))
+	}
+
+	// os.NewFile only hands descriptors that are already non-blocking to the
+	// runtime poller.
+	if err := setNonblock(uintptr(dup), true); err != nil {
+		syscall.Close(dup)
+		return nil, newPortError(options.PortName, "setting O_NONBLOCK", err)
+	}
+
+	p, err := newUnixPort(os.NewFile(uintptr(dup), options.PortName), options, configure)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// newUnixPort wraps an open file in a unixPort and hands its descriptor to
+// configure, which is expected to apply the termios settings. The file is
+// closed again if configure fails.
+func newUnixPort(file *os.File, options OpenOptions, configure func(fd uintptr) error) (*unixPort, error) {
+	vtime := uint(round(float64(options.InterCharacterTimeout)/100.0) * 100)
+
+	p := &unixPort{
+		f:                     file,
+		options:               options,
+		minimumReadSize:       options.MinimumReadSize,
+		interCharacterTimeout: time.Duration(vtime) * time.Millisecond,
+		overallReadTimeout:    options.OverallReadTimeout,
+		maxWriteChunk:         options.MaxWriteChunk,
+		writeChunkDelay:       options.WriteChunkDelay,
+	}
+
+	if options.ReadBufferSize > 0 {
+		p.rbuf = make([]byte, options.ReadBufferSize)
+	}
+
+	p.log.set(options.Logger, file.Name())
+	p.counters.setHooks(options.Hooks)
+
+	// Some drivers can't be watched by the poller (kqueue is notoriously picky
+	// about character devices). Fall back to plain blocking I/O for those.
+	if file.SetReadDeadline(time.Time{}) == os.ErrNoDeadline {
+		if log := p.log.get(); log != nil {
+			log.Warn("serial: runtime poller refused the descriptor; using blocking reads")
+		}
+
+		p.blocking = true
+		configure = clearNonblock(configure)
+	}
+
+	if err := p.controlOp("configuring", configure); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	p.logSettings("serial: configured", options.BaudRate)
+
+	setLines := func(fd uintptr) error { return setInitialLines(fd, options) }
+	if err := p.controlOp("setting modem lines", setLines); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// The driver counts errors from boot rather than from open. Failure here
+	// will be reported by ErrorCounters, if anyone asks.
+	p.control(func(fd uintptr) error {
+		p.errorBase, _ = getErrorCounters(fd)
+		return nil
+	})
+
+	return p, nil
+}
+
+// clearNonblock wraps configure so that it first puts the descriptor into
+// blocking mode.
+func clearNonblock(configure func(fd uintptr) error) func(fd uintptr) error {
+	return func(fd uintptr) error {
+		if err := setNonblock(fd, false); err != nil {
+			return err
+		}
+
+		return configure(fd)
+	}
+}
+
+// control runs fn with the port's file descriptor. The descriptor is
+// guaranteed to stay valid until fn returns, even if the port is closed
+// concurrently.
+//
+// Note that we must never call p.f.Fd(), which would put the descriptor back
+// into blocking mode behind the poller's back.
+func (p *unixPort) control(fn func(fd uintptr) error) error {
+	return p.controlOp("", fn)
+}
+
+// controlOp is like control, naming the operation in any error.
+func (p *unixPort) controlOp(op string, fn func(fd uintptr) error) error {
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return p.opError(op, err)
+	}
+
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) { fnErr = fn(fd) }); err != nil {
+		return p.opError(op, err)
+	}
+
+	return p.opError(op, fnErr)
+}
+
+// Read implements io.Reader, honouring the MinimumReadSize and
+// InterCharacterTimeout settings the port was opened with as well as any
+// deadline set with SetReadDeadline. If the port is closed while Read is
+// blocked, Read returns ErrPortClosed.
+func (p *unixPort) Read(b []byte) (int, error) {
+	n, err := p.read(b, nil)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port.
+func (p *unixPort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	var first time.Time
+	n, err := p.read(b, &first)
+	p.counters.countRead(n, err)
+	return n, first, err
+}
+
+// read implements Read. If first is non-nil, it is set to the time the first
+// bytes were taken from the driver.
+func (p *unixPort) read(b []byte, first *time.Time) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// Concurrent reads would fight over the descriptor's deadline and the
+	// read buffer.
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	// Behaviour is undefined if the buffer is smaller than the minimum read
+	// size; we choose to return once it is full.
+	want := int(p.minimumReadSize)
+	if want > len(b) {
+		want = len(b)
+	}
+
+	// The overall timeout ends the call, not the first bytes.
+	var end time.Time
+	if p.overallReadTimeout > 0 {
+		end = time.Now().Add(p.overallReadTimeout)
+		if want == 0 {
+			want = len(b)
+		}
+	}
+
+	if want == 0 {
+		want = 1
+	}
+
+	if p.blocking {
+		if !end.IsZero() {
+			return p.readBlockingUntil(b, want, end, first)
+		}
+
+		n, at, err := p.readSome(b)
+		if n > 0 && first != nil {
+			*first = at
+		}
+
+		return n, p.portError(err)
+	}
+
+	// With a minimum read size the inter-character timer doesn't start until
+	// the first byte arrives. Without one it bounds the whole call.
+	var timer time.Time
+	if p.minimumReadSize == 0 && p.interCharacterTimeout > 0 {
+		timer = time.Now().Add(p.interCharacterTimeout)
+	}
+
+	n := 0
+	for {
+		if err := p.armReadDeadline(earliest(timer, end)); err != nil {
+			return n, p.portError(err)
+		}
+
+		m, at, err := p.readSome(b[n:])
+		if m > 0 && n == 0 && first != nil {
+			*first = at
+		}
+
+		n += m
+
+		if err != nil {
+			// The kernel doesn't apply VMIN and VTIME to a non-blocking
+			// descriptor, so end of file means a hangup, not a timeout.
+			if err == io.EOF {
+				// With CLOCAL clear, that's what losing carrier looks
+				// like, and the caller asked to see it.
+				if p.honorsModemLines() {
+					return n, io.EOF
+				}
+
+				if log := p.log.get(); log != nil {
+					log.Warn("serial: driver reported end of file; treating it as a hangup")
+				}
+
+				return n, p.opError("read", errHangup)
+			}
+
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return n, p.portError(err)
+			}
+
+			now := time.Now()
+
+			p.dl.Lock()
+			deadline := p.readDeadline
+			p.dl.Unlock()
+
+			if !deadline.IsZero() && !now.Before(deadline) {
+				if log := p.log.get(); log != nil {
+					log.Debug("serial: read deadline passed", "bytes", n)
+				}
+
+				return n, p.portError(err)
+			}
+
+			if stop := earliest(timer, end); !stop.IsZero() && !now.Before(stop) {
+				if log := p.log.get(); log != nil {
+					timeout := "inter-character"
+					if stop.Equal(end) {
+						timeout = "overall"
+					}
+					log.Debug("serial: read timed out", "timeout", timeout, "bytes", n)
+				}
+
+				// The inter-character timer or the overall timeout fired. As
+				// with a VTIME expiry on a blocking descriptor, an empty read
+				// is reported as end of file.
+				if n == 0 {
+					return 0, io.EOF
+				}
+
+				return n, nil
+			}
+
+			// The deadline was changed while we were waiting. Try again.
+			continue
+		}
+
+		if n >= want {
+			return n, nil
+		}
+
+		if p.interCharacterTimeout > 0 {
+			timer = time.Now().Add(p.interCharacterTimeout)
+		}
+	}
+}
+
+// readBlockingUntil implements read for a blocking descriptor with an
+// overall timeout. The kernel can't be told about the timeout, so poll(2)
+// waits for input before each read, which VMIN and VTIME then bound as
+// usual. Deadlines set with SetReadDeadline don't apply to blocking
+// descriptors. The caller must hold rl.
+func (p *unixPort) readBlockingUntil(b []byte, want int, end time.Time, first *time.Time) (int, error) {
+	n := 0
+	for n < want {
+		if p.buffered() == 0 {
+			remaining := time.Until(end)
+			if remaining < 0 {
+				remaining = 0
+			}
+
+			ready, err := p.pollForData(remaining)
+			if err != nil {
+				return n, err
+			}
+
+			if !ready {
+				break
+			}
+		}
+
+		m, at, err := p.readSome(b[n:])
+		if m > 0 && n == 0 && first != nil {
+			*first = at
+		}
+
+		n += m
+
+		// A read that returns nothing is VTIME expiring, and not an error.
+		if err != nil && !(m == 0 && err == io.EOF) {
+			return n, p.portError(err)
+		}
+
+		if !time.Now().Before(end) {
+			break
+		}
+	}
+
+	if n == 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// earliest returns the earlier of a and b, ignoring whichever is zero.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// readSome reads into b, from the read buffer if it holds anything and
+// otherwise from the descriptor, returning the time the bytes were taken from
+// the driver. Reads too small to fill b are made into the read buffer
+// instead, if there is one, and the rest kept for later. The caller must
+// hold rl.
+func (p *unixPort) readSome(b []byte) (int, time.Time, error) {
+	p.bl.Lock()
+	if len(p.pending) > 0 {
+		n := copy(b, p.pending)
+		p.pending = p.pending[n:]
+		at := p.pendingTime
+		p.bl.Unlock()
+
+		return n, at, nil
+	}
+	p.bl.Unlock()
+
+	if len(b) >= len(p.rbuf) {
+		n, err := p.f.Read(b)
+		return n, time.Now(), err
+	}
+
+	n, err := p.f.Read(p.rbuf)
+	at := time.Now()
+	m := copy(b, p.rbuf[:n])
+
+	p.bl.Lock()
+	p.pending = p.rbuf[m:n]
+	p.pendingTime = at
+	p.bl.Unlock()
+
+	return m, at, err
+}
+
+// buffered returns the number of bytes in the read buffer.
+func (p *unixPort) buffered() int {
+	p.bl.Lock()
+	defer p.bl.Unlock()
+
+	return len(p.pending)
+}
+
+// discardBuffered empties the read buffer.
+func (p *unixPort) discardBuffered() {
+	p.bl.Lock()
+	defer p.bl.Unlock()
+
+	p.pending = nil
+}
+
+// armReadDeadline sets the descriptor's read deadline to the earlier of timer
+// and the caller's deadline, ignoring whichever is zero.
+func (p *unixPort) armReadDeadline(timer time.Time) error {
+	p.dl.Lock()
+	defer p.dl.Unlock()
+
+	deadline := p.readDeadline
+	if !timer.IsZero() && (deadline.IsZero() || timer.Before(deadline)) {
+		deadline = timer
+	}
+
+	return p.f.SetReadDeadline(deadline)
+}
+
+// WaitForData implements Port. It waits on the runtime poller, so Close
+// interrupts it just as it does Read.
+func (p *unixPort) WaitForData(timeout time.Duration) (bool, error) {
+	if p.buffered() > 0 {
+		return true, nil
+	}
+
+	if p.blocking {
+		return p.pollForData(timeout)
+	}
+
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	var timer time.Time
+	if timeout >= 0 {
+		timer = time.Now().Add(timeout)
+	}
+
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return false, p.portError(err)
+	}
+
+	for {
+		if err := p.armReadDeadline(timer); err != nil {
+			return false, p.portError(err)
+		}
+
+		var n int
+		var ioctlErr error
+		err := rc.Read(func(fd uintptr) bool {
+			n, ioctlErr = inputQueueLen(fd)
+			return n > 0 || ioctlErr != nil
+		})
+
+		if ioctlErr != nil {
+			return false, p.portError(ioctlErr)
+		}
+
+		if err == nil {
+			return true, nil
+		}
+
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return false, p.portError(err)
+		}
+
+		now := time.Now()
+
+		p.dl.Lock()
+		deadline := p.readDeadline
+		p.dl.Unlock()
+
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return false, p.opError("read", err)
+		}
+
+		if !timer.IsZero() && !now.Before(timer) {
+			return false, nil
+		}
+	}
+}
+
+// pollForData is WaitForData for descriptors the runtime poller refused.
+// Close can't interrupt it.
+func (p *unixPort) pollForData(timeout time.Duration) (bool, error) {
+	ms := -1
+	if timeout >= 0 {
+		ms = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	var ready bool
+	err := p.control(func(fd uintptr) error {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		for {
+			n, err := unix.Poll(fds, ms)
+			if err == unix.EINTR {
+				if log := p.log.get(); log != nil {
+					log.Warn("serial: poll interrupted; retrying")
+				}
+				continue
+			}
+
+			if err != nil {
+				return os.NewSyscallError("poll", err)
+			}
+
+			ready = n > 0
+			return nil
+		}
+	})
+
+	return ready, err
+}
+
+// inputQueueLen returns the number of bytes waiting to be read.
+func inputQueueLen(fd uintptr) (int, error) {
+	n, err := unix.IoctlGetInt(int(fd), kFIONREAD)
+	if err != nil {
+		return 0, os.NewSyscallError("FIONREAD", err)
+	}
+
+	return n, nil
+}
+
+// BytesAvailable implements Port.
+func (p *unixPort) BytesAvailable() (int, error) {
+	var n int
+	err := p.control(func(fd uintptr) (err error) {
+		n, err = inputQueueLen(fd)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n + p.buffered(), nil
+}
+
+// SetReadDeadline implements Port.
+func (p *unixPort) SetReadDeadline(t time.Time) error {
+	if p.blocking {
+		return ErrNotSupported
+	}
+
+	p.dl.Lock()
+	defer p.dl.Unlock()
+
+	p.readDeadline = t
+
+	// Wake any Read in progress so that it picks up the new deadline.
+	return p.portError(p.f.SetReadDeadline(time.Unix(1, 0)))
+}
+
+// SetWriteDeadline implements Port.
+func (p *unixPort) SetWriteDeadline(t time.Time) error {
+	if p.blocking {
+		return ErrNotSupported
+	}
+
+	return p.portError(p.f.SetWriteDeadline(t))
+}
+
+// Write implements io.Writer.
+func (p *unixPort) Write(b []byte) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	n, err := writeChunked(b, p.maxWriteChunk, p.writeChunkDelay, p.f.Write)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if log := p.log.get(); log != nil {
+			log.Debug("serial: write deadline passed", "bytes", n)
+		}
+	}
+
+	err = p.portError(err)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// WriteNineBit implements Port. wl keeps other Writes from going out with
+// the wrong parity, and cm keeps the settings from changing meanwhile.
+func (p *unixPort) WriteNineBit(b []byte, address bool) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	var restore func(fd uintptr) error
+	err := p.controlOp("setting stick parity", func(fd uintptr) (err error) {
+		restore, err = setStickParity(fd, address)
+		return err
+	})
+
+	if err != nil {
+		p.counters.countWrite(0, err)
+		return 0, err
+	}
+
+	n, err := writeChunked(b, p.maxWriteChunk, p.writeChunkDelay, p.f.Write)
+	err = p.portError(err)
+
+	if restoreErr := p.controlOp("restoring parity", restore); err == nil {
+		err = restoreErr
+	}
+
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// Close closes the port, causing any pending Read or Write to return
+// ErrPortClosed. Only the first call has any effect; later calls return nil.
+//
+// The os.File underneath holds a reference to the descriptor for the
+// duration of every I/O call and control operation, so the descriptor is not
+// released (and can't be recycled by the OS) until they have all finished.
+func (p *unixPort) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		atomic.StoreInt32(&p.closed, 1)
+		err = p.f.Close()
+	})
+	return err
+}
+
+// Pause implements Port.
+func (p *unixPort) Pause() error {
+	return p.flowControl(true)
+}
+
+// Resume implements Port.
+func (p *unixPort) Resume() error {
+	return p.flowControl(false)
+}
+
+// flowControl implements Pause, if stop is true, and Resume. Where XOFF and
+// XON must be written like data, wl keeps them from landing in the middle
+// of a Write, and is taken before cm as in WriteNineBit.
+func (p *unixPort) flowControl(stop bool) error {
+	byWrite := flowControlByWrite
+	if byWrite {
+		p.wl.Lock()
+		defer p.wl.Unlock()
+	}
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if p.options.RTSCTSFlowControl {
+		return p.control(func(fd uintptr) error {
+			return setModemLines(fd, unix.TIOCM_RTS, !stop)
+		})
+	}
+
+	if byWrite {
+		c := byte(0x11) // XON
+		if stop {
+			c = 0x13 // XOFF
+		}
+
+		_, err := p.f.Write([]byte{c})
+		return p.opError("write", err)
+	}
+
+	return p.control(func(fd uintptr) error {
+		return sendFlowControl(fd, stop)
+	})
+}
+
+// PulseDTR implements Port.
+func (p *unixPort) PulseDTR(d time.Duration) error {
+	return p.pulse(unix.TIOCM_DTR, d)
+}
+
+// PulseRTS implements Port.
+func (p *unixPort) PulseRTS(d time.Duration) error {
+	return p.pulse(unix.TIOCM_RTS, d)
+}
+
+// pulse lowers the modem control line for d, then raises it again and
+// discards any input received in the meantime. The descriptor isn't held
+// while sleeping, so Close can proceed, but cm is.
+func (p *unixPort) pulse(line int, d time.Duration) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	err := p.control(func(fd uintptr) error {
+		return setModemLines(fd, line, false)
+	})
+
+	if err != nil {
+		return modemLinesError(err)
+	}
+
+	time.Sleep(d)
+
+	return p.control(func(fd uintptr) error {
+		if err := setModemLines(fd, line, true); err != nil {
+			return modemLinesError(err)
+		}
+
+		p.discardBuffered()
+		return flushInput(fd)
+	})
+}
+
+// SetRTS implements Port.
+func (p *unixPort) SetRTS(on bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.setRTS(on)
+}
+
+// setRTS implements SetRTS. The caller must hold cm.
+func (p *unixPort) setRTS(on bool) error {
+	if p.options.RTSCTSFlowControl {
+		return errRTSFlowControl
+	}
+
+	return p.controlOp("setting RTS", func(fd uintptr) error {
+		return modemLinesError(setModemLines(fd, unix.TIOCM_RTS, on))
+	})
+}
+
+// SetDTR implements Port.
+func (p *unixPort) SetDTR(on bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.controlOp("setting DTR", func(fd uintptr) error {
+		return modemLinesError(setModemLines(fd, unix.TIOCM_DTR, on))
+	})
+}
+
+// SetBreak implements Port.
+func (p *unixPort) SetBreak(on bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.controlOp("setting break", func(fd uintptr) error {
+		return setBreak(fd, on)
+	})
+}
+
+// WriteWithRTS implements Port. Like WriteNineBit, it holds wl throughout so
+// that no other Write goes out while RTS is raised.
+func (p *unixPort) WriteWithRTS(b []byte) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := p.setRTS(true); err != nil {
+		p.counters.countWrite(0, err)
+		return 0, err
+	}
+
+	time.Sleep(time.Duration(p.options.Rs485DelayRtsBeforeSend) * time.Millisecond)
+
+	n, err := writeChunked(b, p.maxWriteChunk, p.writeChunkDelay, p.f.Write)
+	err = p.portError(err)
+
+	if drainErr := p.controlOp("draining", drainOutput); err == nil {
+		err = drainErr
+	}
+
+	time.Sleep(time.Duration(p.options.Rs485DelayRtsAfterSend) * time.Millisecond)
+
+	if rtsErr := p.setRTS(false); err == nil {
+		err = rtsErr
+	}
+
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// setInitialLines applies the InitialDTR and InitialRTS options.
+func setInitialLines(fd uintptr, options OpenOptions) error {
+	lines := []struct {
+		name  string
+		bit   int
+		state LineState
+	}{
+		{"InitialDTR", unix.TIOCM_DTR, options.InitialDTR},
+		{"InitialRTS", unix.TIOCM_RTS, options.InitialRTS},
+	}
+
+	for _, l := range lines {
+		if l.bit == unix.TIOCM_RTS && options.RTSCTSFlowControl {
+			continue
+		}
+
+		switch l.state {
+		case LINE_LEAVE:
+			continue
+
+		case LINE_ASSERT, LINE_DEASSERT:
+			if err := setModemLines(fd, l.bit, l.state == LINE_ASSERT); err != nil {
+				return modemLinesError(err)
+			}
+
+		default:
+			return errors.New("invalid setting for " + l.name)
+		}
+	}
+
+	return nil
+}
+
+// modemLinesError reports the errors drivers without modem control lines
+// (such as ptys) return as ErrNotSupported.
+func modemLinesError(err error) error {
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EINVAL) {
+		return ErrNotSupported
+	}
+
+	return err
+}
+
+// SetMode implements Port.
+func (p *unixPort) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	options.ParityMode = parity
+	options.StopBits = stopBits
+	options.RTSCTSFlowControl = rtscts
+
+	err := p.control(func(fd uintptr) error {
+		return setMode(fd, options)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	p.options = options
+	p.logSettings("serial: changed mode", options.BaudRate)
+	return nil
+}
+
+// SetBaudRate implements Port.
+func (p *unixPort) SetBaudRate(baud uint) error {
+	if baud == 0 {
+		return markError(errors.New("serial: BaudRate is zero"), ErrInvalidBaudRate)
+	}
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	options := p.options
+	options.BaudRate = baud
+
+	err := p.control(func(fd uintptr) error {
+		return setMode(fd, options)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	p.options = options
+	p.logSettings("serial: changed baud rate", baud)
+	return nil
+}
+
+// logSettings logs the termios settings the driver is using, with msg, and
+// warns if it isn't running at the baud rate asked for. The settings are
+// only read back if there is a logger.
+func (p *unixPort) logSettings(msg string, baud uint) {
+	log := p.log.get()
+	if log == nil {
+		return
+	}
+
+	var t *Termios
+	var ts termiosState
+	err := p.control(func(fd uintptr) (err error) {
+		if t, err = readRawTermios(fd); err != nil {
+			return err
+		}
+
+		ts, err = readTermios(fd)
+		return err
+	})
+
+	if err != nil {
+		log.Warn("serial: can't read settings back", "err", err)
+		return
+	}
+
+	log.Debug(msg,
+		"baud", ts.baudRate,
+		"iflag", fmt.Sprintf("%#x", t.iflag),
+		"oflag", fmt.Sprintf("%#x", t.oflag),
+		"cflag", fmt.Sprintf("%#x", t.cflag),
+		"lflag", fmt.Sprintf("%#x", t.lflag))
+
+	if ts.baudRate != baud {
+		log.Warn("serial: driver is using a different baud rate", "requested", baud, "actual", ts.baudRate)
+	}
+}
+
+// SetLogger implements Port.
+func (p *unixPort) SetLogger(l *slog.Logger) {
+	p.cm.Lock()
+	p.options.Logger = l
+	p.cm.Unlock()
+
+	p.log.set(l, p.f.Name())
+}
+
+// WithBaudRate implements Port.
+func (p *unixPort) WithBaudRate(baud uint, fn func() error) error {
+	p.cm.Lock()
+	prev := p.options.BaudRate
+	p.cm.Unlock()
+
+	return withBaudRate(p, prev, baud, fn)
+}
+
+// SetReceiverEnabled implements Port.
+func (p *unixPort) SetReceiverEnabled(on bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.control(func(fd uintptr) error {
+		return setReceiver(fd, on)
+	})
+}
+
+// Drain implements Port.
+func (p *unixPort) Drain() error {
+	return p.control(drainOutput)
+}
+
+// Quiesce implements Port.
+func (p *unixPort) Quiesce() error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.control(func(fd uintptr) error {
+		if err := drainOutput(fd); err != nil {
+			return err
+		}
+
+		p.discardBuffered()
+		return flushInput(fd)
+	})
+}
+
+// ErrorCounters implements Port.
+func (p *unixPort) ErrorCounters() (ErrorCounters, error) {
+	var c ErrorCounters
+	err := p.control(func(fd uintptr) (err error) {
+		c, err = getErrorCounters(fd)
+		return err
+	})
+
+	if err != nil {
+		return ErrorCounters{}, err
+	}
+
+	p.el.Lock()
+	defer p.el.Unlock()
+
+	c.Framing -= p.errorBase.Framing
+	c.Parity -= p.errorBase.Parity
+	c.Overrun -= p.errorBase.Overrun
+	c.BufferOverrun -= p.errorBase.BufferOverrun
+	c.Break -= p.errorBase.Break
+
+	return c, nil
+}
+
+// ResetErrorCounters implements Port.
+func (p *unixPort) ResetErrorCounters() error {
+	var c ErrorCounters
+	err := p.control(func(fd uintptr) (err error) {
+		c, err = getErrorCounters(fd)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	p.el.Lock()
+	p.errorBase = c
+	p.el.Unlock()
+
+	return nil
+}
+
+// BufferSizes implements Port.
+func (p *unixPort) BufferSizes() (BufferSizes, error) {
+	if err := p.control(func(fd uintptr) error { return nil }); err != nil {
+		return BufferSizes{}, err
+	}
+
+	return BufferSizes{InputQueue: driverInputQueue, ReadBuffer: len(p.rbuf)}, nil
+}
+
+// Stats implements Port.
+func (p *unixPort) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *unixPort) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *unixPort) SetHooks(hooks PortHooks) {
+	p.cm.Lock()
+	p.options.Hooks = hooks
+	p.cm.Unlock()
+
+	p.counters.setHooks(hooks)
+}
+
+// termiosState is the subset of a port's termios settings that
+// CurrentOptions and DumpSettings report.
+type termiosState struct {
+	baudRate    uint
+	dataBits    uint
+	stopBits    uint
+	parityMode  ParityMode
+	rtscts      bool
+	raw         bool
+	noRecv      bool
+	vmin, vtime uint8
+}
+
+// String implements Port.
+func (p *unixPort) String() string {
+	return p.f.Name()
+}
+
+// CurrentOptions implements Port.
+func (p *unixPort) CurrentOptions() (OpenOptions, error) {
+	var ts termiosState
+	err := p.control(func(fd uintptr) (err error) {
+		ts, err = readTermios(fd)
+		return err
+	})
+
+	if err != nil {
+		return OpenOptions{}, err
+	}
+
+	return p.withTermios(ts), nil
+}
+
+// BaudRate implements Port.
+func (p *unixPort) BaudRate() (uint, error) {
+	var ts termiosState
+	err := p.control(func(fd uintptr) (err error) {
+		ts, err = readTermios(fd)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return ts.baudRate, nil
+}
+
+// withTermios returns the port's options with the fields that termios
+// records replaced by the values in ts.
+func (p *unixPort) withTermios(ts termiosState) OpenOptions {
+	p.cm.Lock()
+	options := p.options
+	p.cm.Unlock()
+
+	options.BaudRate = ts.baudRate
+	options.DataBits = ts.dataBits
+	options.StopBits = ts.stopBits
+	options.ParityMode = ts.parityMode
+	options.RTSCTSFlowControl = ts.rtscts
+
+	return options
+}
+
+// DumpSettings implements Port.
+func (p *unixPort) DumpSettings() (string, error) {
+	var ts termiosState
+	var status string
+	err := p.control(func(fd uintptr) (err error) {
+		if ts, err = readTermios(fd); err != nil {
+			return err
+		}
+
+		m, err := getModemStatus(fd)
+		if err == nil {
+			status = m.String()
+		} else if !errors.Is(err, unix.ENOTTY) && !errors.Is(err, unix.EINVAL) {
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	mode := "cooked"
+	if ts.raw {
+		mode = "raw"
+	}
+
+	receiver := ""
+	if ts.noRecv {
+		receiver = "receiver off"
+	}
+
+	return joinSettings(
+		p.String(),
+		FormatMode(p.withTermios(ts)),
+		mode,
+		receiver,
+		fmt.Sprintf("VMIN=%d VTIME=%d", ts.vmin, ts.vtime),
+		status), nil
+}
+
+// DescribeTermios implements Port.
+func (p *unixPort) DescribeTermios() (string, error) {
+	var t *Termios
+	err := p.control(func(fd uintptr) (err error) {
+		t, err = readRawTermios(fd)
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return describeTermios(t), nil
+}
+
+// CarrierDetect implements Port.
+func (p *unixPort) CarrierDetect() (bool, error) {
+	m, err := p.modemStatus()
+	return m.dcd, err
+}
+
+// honorsModemLines reports whether the port was opened with
+// HonorModemStatusLines.
+func (p *unixPort) honorsModemLines() bool {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.options.HonorModemStatusLines
+}
+
+// modemStatus reads the modem status lines for CarrierDetect and
+// RFC2217Server.
+func (p *unixPort) modemStatus() (modemStatus, error) {
+	var m modemStatus
+	err := p.controlOp("reading modem status", func(fd uintptr) (err error) {
+		m, err = getModemStatus(fd)
+		return modemLinesError(err)
+	})
+
+	return m, err
+}
+
+// getModemStatus reads the modem status lines. Pseudo-terminals don't have
+// any, and fail with ENOTTY or EINVAL.
+func getModemStatus(fd uintptr) (modemStatus, error) {
+	bits, err := unix.IoctlGetInt(int(fd), unix.TIOCMGET)
+	if err != nil {
+		return modemStatus{}, os.NewSyscallError("TIOCMGET", err)
+	}
+
+	return modemStatus{
+		cts: bits&unix.TIOCM_CTS != 0,
+		dsr: bits&unix.TIOCM_DSR != 0,
+		dcd: bits&unix.TIOCM_CAR != 0,
+		ri:  bits&unix.TIOCM_RNG != 0,
+	}, nil
+}
+
+// SetLowLatency implements Port.
+func (p *unixPort) SetLowLatency(on bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.control(func(fd uintptr) error {
+		return setLowLatency(fd, on)
+	})
+}
+
+// setModemLines raises or lowers the modem control lines in the TIOCM_* mask.
+func setModemLines(fd uintptr, lines int, on bool) error {
+	if on {
+		if err := unix.IoctlSetPointerInt(int(fd), unix.TIOCMBIS, lines); err != nil {
+			return os.NewSyscallError("TIOCMBIS", err)
+		}
+
+		return nil
+	}
+
+	if err := unix.IoctlSetPointerInt(int(fd), unix.TIOCMBIC, lines); err != nil {
+		return os.NewSyscallError("TIOCMBIC", err)
+	}
+
+	return nil
+}
+
+// setBreak starts or ends a break condition.
+func setBreak(fd uintptr, on bool) error {
+	if on {
+		if err := unix.IoctlSetInt(int(fd), unix.TIOCSBRK, 0); err != nil {
+			return os.NewSyscallError("TIOCSBRK", err)
+		}
+
+		return nil
+	}
+
+	if err := unix.IoctlSetInt(int(fd), unix.TIOCCBRK, 0); err != nil {
+		return os.NewSyscallError("TIOCCBRK", err)
+	}
+
+	return nil
+}
+
+// portReady returns nil if the port named name exists and is readable and
+// writable by this process, and otherwise the reason it isn't.
+func portReady(name string) error {
+	if err := unix.Access(name, unix.R_OK|unix.W_OK); err != nil {
+		return &os.PathError{Op: "access", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// portNames returns the names of the serial ports on the system. Anything
+// matching portPatterns that isn't a character device, or that
+// isPhantomPort rejects, is skipped.
+func portNames() ([]string, error) {
+	var names []string
+	for _, pattern := range portPatterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || fi.Mode()&os.ModeCharDevice == 0 || isPhantomPort(m) {
+				continue
+			}
+
+			names = append(names, m)
+		}
+	}
+
+	return names, nil
+}
+
+// portError replaces errors caused by the port having been closed with
+// ErrPortClosed, marks those caused by the device going away with
+// ErrDeviceRemoved, and adds the port's name.
+func (p *unixPort) portError(err error) error {
+	return p.opError("", err)
+}
+
+// opError is like portError, naming the operation that failed.
+func (p *unixPort) opError(op string, err error) error {
+	if err != nil && (errors.Is(err, os.ErrClosed) || atomic.LoadInt32(&p.closed) != 0) {
+		return ErrPortClosed
+	}
+
+	if errors.Is(err, unix.EIO) || errors.Is(err, unix.ENXIO) || errors.Is(err, unix.ENODEV) {
+		err = markError(err, ErrDeviceRemoved)
+	}
+
+	return newPortError(p.f.Name(), op, err)
+}
@@ -0,0 +1,1933 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCloseUnblocksRead(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := port.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	// Give the reader a chance to block.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := port.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPortClosed) {
+			t.Errorf("expected ErrPortClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after Close")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Every byte value survives the trip each way, so the port is in raw
+	// mode: no echo, no newline translation, no special characters.
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	if _, err := port.Write(all); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(all))
+	if _, err := io.ReadFull(master, got); err != nil || string(got) != string(all) {
+		t.Fatalf("master read % x, %v", got, err)
+	}
+
+	if _, err := master.Write(all); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(port, got); err != nil || string(got) != string(all) {
+		t.Fatalf("port read % x, %v", got, err)
+	}
+
+	// Nothing was echoed back to the master.
+	master.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _ := master.Read(got); n != 0 {
+		t.Errorf("master read back % x", got[:n])
+	}
+}
+
+func TestReadTiming(t *testing.T) {
+	master, name := openPty(t)
+
+	// VMIN 3, VTIME 2: Read waits for three bytes, or for a 200ms gap once
+	// the first has arrived.
+	port, err := Open(OpenOptions{
+		PortName:              name,
+		BaudRate:              115200,
+		DataBits:              8,
+		StopBits:              1,
+		MinimumReadSize:       3,
+		InterCharacterTimeout: 200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	go func() {
+		master.Write([]byte("a"))
+		time.Sleep(50 * time.Millisecond)
+		master.Write([]byte("bc"))
+	}()
+
+	buf := make([]byte, 8)
+	if n, err := port.Read(buf); err != nil || string(buf[:n]) != "abc" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	// A short frame is returned once the gap passes.
+	master.Write([]byte("d"))
+
+	start := time.Now()
+	if n, err := port.Read(buf); err != nil || string(buf[:n]) != "d" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("short frame took %v", d)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:              name,
+		BaudRate:              115200,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Echo everything written to the slave back to it.
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			master.Write(buf[:n])
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 2; i++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 16)
+			for {
+				if _, err := port.Read(buf); err != nil && err != io.EOF {
+					errs <- err
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := port.Write([]byte("hello")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for {
+				if err := port.Pause(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	var closers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if err := port.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		}()
+	}
+	closers.Wait()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("I/O did not stop after Close")
+	}
+
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrPortClosed) {
+			t.Errorf("expected ErrPortClosed, got %v", err)
+		}
+	}
+}
+
+func TestFullDuplex(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Keep changing the settings while the reader and writer run.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for baud := uint(9600); ; baud ^= 9600 ^ 19200 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if err := port.SetBaudRate(baud); err != nil {
+				t.Errorf("SetBaudRate: %v", err)
+				return
+			}
+		}
+	}()
+	defer func() { close(stop); wg.Wait() }()
+
+	// A Read blocked waiting for input must not hold up a Write.
+	type result struct {
+		b   []byte
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, err := port.Read(buf)
+		read <- result{buf[:n], err}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := port.Write([]byte("ping"))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked behind Read")
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(master, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("master read %q, %v", buf, err)
+	}
+
+	if _, err := master.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-read:
+		if r.err != nil || string(r.b) != "pong" {
+			t.Errorf("Read: %q, %v", r.b, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := port.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+
+	if err := port.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	if _, err := port.Read(make([]byte, 1)); err != ErrPortClosed {
+		t.Errorf("Read after Close: expected ErrPortClosed, got %v", err)
+	}
+
+	if _, err := port.Write([]byte("x")); err != ErrPortClosed {
+		t.Errorf("Write after Close: expected ErrPortClosed, got %v", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if err := port.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = port.Read(make([]byte, 16))
+	checkTimeout(t, err)
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Read took %v", d)
+	}
+
+	// With the deadline passed, WaitForData gives up in the same way.
+	if ok, err := port.WaitForData(time.Second); ok {
+		t.Error("WaitForData found data")
+	} else {
+		checkTimeout(t, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if _, err := master.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(port, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := port.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	port.SetReadDeadline(time.Now())
+	port.Read(make([]byte, 1))
+
+	want := PortStats{BytesRead: 3, BytesWritten: 5, ReadErrors: 1, ReadTimeouts: 1}
+	if got := port.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	port.ResetStats()
+	if got := port.Stats(); got != (PortStats{}) {
+		t.Errorf("after ResetStats: got %+v", got)
+	}
+}
+
+func TestStatsReadTimeout(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:              name,
+		BaudRate:              115200,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// The io.EOF of an expired timeout counts as a timeout but not an error.
+	if _, err := port.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read: got %v, want io.EOF", err)
+	}
+
+	if got, want := port.Stats(), (PortStats{ReadTimeouts: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// checkTimeout fails the test unless err is a deadline error that generic
+// code can recognise as a timeout.
+func checkTimeout(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a net.Error timeout, got %#v", err)
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if err := port.SetMode(7, PARITY_EVEN, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := port.SetMode(9, PARITY_NONE, 1, false); !errors.Is(err, ErrInvalidDataBits) {
+		t.Errorf("SetMode with 9 data bits: got %v, want ErrInvalidDataBits", err)
+	}
+
+	if err := port.SetBaudRate(0); !errors.Is(err, ErrInvalidBaudRate) {
+		t.Errorf("SetBaudRate(0): got %v, want ErrInvalidBaudRate", err)
+	}
+}
+
+func TestDeviceRemoved(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Closing the master is as close as a pty gets to being unplugged.
+	master.Close()
+
+	if _, err := port.Read(make([]byte, 1)); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Read: got %v, want ErrDeviceRemoved", err)
+	}
+
+	if _, err := port.Write([]byte("x")); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Write: got %v, want ErrDeviceRemoved", err)
+	}
+
+	// Errors from control methods name the port and keep the errno.
+	err = port.Quiesce()
+	var portErr *PortError
+	var errno unix.Errno
+	if !errors.As(err, &portErr) || portErr.Port != name || !errors.As(err, &errno) {
+		t.Errorf("Quiesce: got %v, want a *PortError for %s wrapping an errno", err, name)
+	}
+}
+
+func TestReadHangup(t *testing.T) {
+	// Some drivers report a hangup as end of file rather than an error. A
+	// pipe, which the runtime poller accepts, behaves the same way.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := &unixPort{f: r, minimumReadSize: 1}
+	defer port.Close()
+
+	w.Write([]byte("x"))
+	w.Close()
+
+	buf := make([]byte, 2)
+	if n, err := port.Read(buf); n != 1 || err != nil {
+		t.Fatalf("Read: got %d, %v", n, err)
+	}
+
+	if _, err := port.Read(buf); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Read: got %v, want ErrDeviceRemoved", err)
+	}
+}
+
+func TestReadCarrierLost(t *testing.T) {
+	// With CLOCAL clear, the hangup is the modem losing carrier.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := &unixPort{
+		f:               r,
+		minimumReadSize: 1,
+		options:         OpenOptions{HonorModemStatusLines: true},
+	}
+	defer port.Close()
+
+	w.Close()
+
+	if _, err := port.Read(make([]byte, 2)); err != io.EOF {
+		t.Errorf("Read: got %v, want io.EOF", err)
+	}
+}
+
+func TestCloseOnExec(t *testing.T) {
+	_, name := openPty(t)
+
+	options := OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+
+	// A descriptor without FD_CLOEXEC, as OpenFd might be handed.
+	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+
+	opened, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+
+	dup, err := OpenFd(uintptr(fd), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dup.Close()
+
+	for _, port := range []Port{opened, dup} {
+		var flags int
+		err := port.(*unixPort).control(func(fd uintptr) (err error) {
+			flags, err = unix.FcntlInt(fd, unix.F_GETFD, 0)
+			return err
+		})
+
+		if err != nil || flags&unix.FD_CLOEXEC == 0 {
+			t.Errorf("F_GETFD: got %#x, %v; want FD_CLOEXEC", flags, err)
+		}
+	}
+}
+
+func TestOpenFd(t *testing.T) {
+	master, name := openPty(t)
+
+	slave, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+
+	port, err := OpenFd(slave.Fd(), OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := master.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := port.Read(buf); err != nil || buf[0] != 'x' {
+		t.Fatalf("Read: %q, %v", buf, err)
+	}
+
+	// Closing the port must leave the caller's descriptor open.
+	port.Close()
+
+	if _, err := slave.Write([]byte("y")); err != nil {
+		t.Errorf("original descriptor unusable after Close: %v", err)
+	}
+}
+
+func TestOpenContextCancelled(t *testing.T) {
+	_, name := openPty(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := OpenContext(ctx, OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != context.Canceled {
+		t.Fatalf("OpenContext: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOpenContextClosesLatePort(t *testing.T) {
+	f := useFakeSys(t)
+
+	release := make(chan struct{})
+	f.onOpen = func(string) error {
+		<-release
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := OpenContext(ctx, OpenOptions{
+		PortName:        "/dev/ttyFake0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("OpenContext: %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Let the open finish; the port it produces must be closed for us.
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		files := f.files
+		f.mu.Unlock()
+
+		if len(files) == 1 {
+			if _, err := files[0].Stat(); errors.Is(err, os.ErrClosed) {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("port opened after cancellation was never closed")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpenTimeout(t *testing.T) {
+	f := useFakeSys(t)
+
+	release := make(chan struct{})
+	f.onOpen = func(string) error {
+		<-release
+		return nil
+	}
+
+	start := time.Now()
+	_, err := Open(OpenOptions{
+		PortName:        "/dev/ttyFake0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		OpenTimeout:     20 * time.Millisecond,
+	})
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Open: got %v, want a deadline error", err)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Open took %v", d)
+	}
+
+	// Let the open finish before the fake is torn down; the port it
+	// produces is closed.
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		files := f.files
+		f.mu.Unlock()
+
+		if len(files) == 1 {
+			if _, err := files[0].Stat(); errors.Is(err, os.ErrClosed) {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("port opened after the timeout was never closed")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOverallReadTimeout(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:           name,
+		BaudRate:           115200,
+		DataBits:           8,
+		StopBits:           1,
+		OverallReadTimeout: 300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Nothing arrives.
+	start := time.Now()
+	if n, err := port.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Errorf("Read with no input: %d, %v", n, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Read with no input took %v", elapsed)
+	}
+
+	// A byte every 50ms keeps the line busy throughout; Read returns what
+	// came within the timeout rather than waiting for a gap or a full buffer.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				master.Write([]byte("x"))
+			}
+		}
+	}()
+
+	start = time.Now()
+	n, err := port.Read(make([]byte, 64))
+	elapsed := time.Since(start)
+
+	if err != nil || n < 2 || n > 7 {
+		t.Errorf("Read with trickling input: %d, %v", n, err)
+	}
+
+	if elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Read with trickling input took %v", elapsed)
+	}
+}
+
+func TestWaitForData(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	ready, err := port.WaitForData(50 * time.Millisecond)
+	if err != nil || ready {
+		t.Fatalf("WaitForData on an idle port: %v, %v", ready, err)
+	}
+
+	master.Write([]byte("z"))
+
+	ready, err = port.WaitForData(time.Second)
+	if err != nil || !ready {
+		t.Fatalf("WaitForData with pending data: %v, %v", ready, err)
+	}
+
+	// The byte must still be there for Read.
+	buf := make([]byte, 1)
+	if _, err := port.Read(buf); err != nil || buf[0] != 'z' {
+		t.Errorf("Read: %q, %v", buf, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := port.WaitForData(-1)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	port.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPortClosed) {
+			t.Errorf("expected ErrPortClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitForData did not return after Close")
+	}
+}
+
+func TestDumpSettings(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// Linux ptys force 8 data bits and no parity, which is a good reason for
+	// reading the settings back, but limits what can be tested here.
+	if err := port.SetMode(8, PARITY_NONE, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := port.CurrentOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.BaudRate != 115200 || options.DataBits != 8 || options.StopBits != 2 {
+		t.Errorf("CurrentOptions: %+v", options)
+	}
+
+	if baud, err := port.BaudRate(); baud != 115200 || err != nil {
+		t.Errorf("BaudRate: %d, %v", baud, err)
+	}
+
+	if port.String() != name {
+		t.Errorf("String: got %q, want %q", port.String(), name)
+	}
+
+	s, err := port.DumpSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := name + ": 115200 8N2, raw, VMIN=1 VTIME=0"
+	if !strings.HasPrefix(s, want) {
+		t.Errorf("DumpSettings: got %q, want prefix %q", s, want)
+	}
+}
+
+func TestDescribeTermios(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        2,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	s, err := port.DescribeTermios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("DescribeTermios:\n%s", s)
+
+	for _, want := range []string{" CS8", " CSTOPB", " CREAD", " CLOCAL", " VSTOP=^S", " VMIN=1 "} {
+		if !strings.Contains(s, want) {
+			t.Errorf("DescribeTermios: %q doesn't contain %q", s, want)
+		}
+	}
+
+	if strings.Contains(s, "ICANON") {
+		t.Errorf("DescribeTermios: %q reports canonical mode", s)
+	}
+
+	// Each flag word is shown in full, as eight hex digits.
+	for _, line := range strings.SplitN(s, "\n", 5)[:4] {
+		if f := strings.Fields(line); len(f) < 2 || len(f[1]) != len("0x00000000") {
+			t.Errorf("DescribeTermios: %q isn't eight hex digits", line)
+		}
+	}
+}
+
+func TestIgnoringEINTR(t *testing.T) {
+	calls := 0
+	err := ignoringEINTR(func() error {
+		calls++
+		if calls < 3 {
+			return unix.EINTR
+		}
+		return unix.EIO
+	})
+
+	if err != unix.EIO || calls != 3 {
+		t.Errorf("got %v after %d calls, want EIO after 3", err, calls)
+	}
+}
+
+func TestDescribeFlags(t *testing.T) {
+	names := []flagName{
+		{0x3, 0x0, "A0"},
+		{0x3, 0x2, "A2"},
+		bitFlag(0x4, "B"),
+		bitFlag(0x8, "C"),
+	}
+
+	testCases := []struct {
+		bits uint64
+		want string
+	}{
+		{0x0, "A0"},
+		{0x6, "A2 B"},
+		{0xd, "B C 0x1"},
+		{0x30, "A0 0x30"},
+	}
+
+	for _, tc := range testCases {
+		got := strings.Join(describeFlags(tc.bits, names), " ")
+		if got != tc.want {
+			t.Errorf("describeFlags(%#x): got %q, want %q", tc.bits, got, tc.want)
+		}
+	}
+}
+
+func TestRawConfig(t *testing.T) {
+	_, name := openPty(t)
+
+	options := OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		RawConfig: func(rt *Termios) error {
+			rt.SetControlChar(unix.VMIN, 5)
+			return nil
+		},
+	}
+
+	port, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	s, err := port.DumpSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(s, "VMIN=5") {
+		t.Errorf("RawConfig change not applied: %q", s)
+	}
+
+	// An error from the callback aborts Open.
+	wantErr := errors.New("taco")
+	options.RawConfig = func(*Termios) error { return wantErr }
+
+	if _, err := Open(options); !errors.Is(err, wantErr) {
+		t.Errorf("expected %v, got %v", wantErr, err)
+	}
+}
+
+func TestRawOutput(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		master, name := openPty(t)
+
+		port, err := Open(OpenOptions{
+			PortName:        name,
+			BaudRate:        115200,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+			RawOutput:       raw,
+			RawConfig: func(rt *Termios) error {
+				rt.SetOutputFlags(unix.OPOST | unix.ONLCR)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := port.Write([]byte("a\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "a\r\n"
+		if raw {
+			want = "a\n"
+		}
+
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(master, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != want {
+			t.Errorf("RawOutput %v: got %q, want %q", raw, buf, want)
+		}
+
+		port.Close()
+	}
+}
+
+func TestWithBaudRate(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	baudRate := func() uint {
+		baud, err := port.BaudRate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return baud
+	}
+
+	wantErr := errors.New("taco")
+	err = port.WithBaudRate(9600, func() error {
+		if got := baudRate(); got != 9600 {
+			t.Errorf("inside WithBaudRate: got %d", got)
+		}
+		return wantErr
+	})
+
+	if err != wantErr {
+		t.Errorf("expected %v, got %v", wantErr, err)
+	}
+	if got := baudRate(); got != 115200 {
+		t.Errorf("after error: got %d", got)
+	}
+
+	// The rate is restored when fn panics too.
+	func() {
+		defer func() { recover() }()
+		port.WithBaudRate(9600, func() error { panic("burrito") })
+	}()
+
+	if got := baudRate(); got != 115200 {
+		t.Errorf("after panic: got %d", got)
+	}
+
+	if err := port.Drain(); err != nil {
+		t.Errorf("Drain: %v", err)
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if _, err := master.Write([]byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := port.Write([]byte("out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := port.Quiesce(); err != nil {
+		t.Fatalf("Quiesce: %v", err)
+	}
+
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(master, buf); err != nil || string(buf) != "out" {
+		t.Fatalf("master read %q, %v", buf, err)
+	}
+
+	if n, err := port.BytesAvailable(); err != nil || n != 0 {
+		t.Errorf("BytesAvailable after Quiesce: %d, %v", n, err)
+	}
+
+	if _, err := master.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "new" {
+		t.Errorf("Read: %q, %v", buf, err)
+	}
+}
+
+func TestSetReceiverEnabled(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	for _, on := range []bool{false, true} {
+		if err := port.SetReceiverEnabled(on); err != nil {
+			t.Fatal(err)
+		}
+
+		// Linux ptys force CREAD on, so the change can't be seen there.
+		if runtime.GOOS == "linux" {
+			continue
+		}
+
+		s, err := port.DumpSettings()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(s, "receiver off") == on {
+			t.Errorf("receiver %v: %q", on, s)
+		}
+	}
+}
+
+func TestHangupOnClose(t *testing.T) {
+	_, name := openPty(t)
+
+	for _, hangup := range []bool{false, true} {
+		var cflag uint64
+		port, err := Open(OpenOptions{
+			PortName:        name,
+			BaudRate:        115200,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+			HangupOnClose:   hangup,
+			RawConfig: func(rt *Termios) error {
+				cflag = rt.ControlFlags()
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port.Close()
+
+		if got := cflag&unix.HUPCL != 0; got != hangup {
+			t.Errorf("HangupOnClose %v: HUPCL set = %v", hangup, got)
+		}
+	}
+}
+
+func TestHonorModemStatusLines(t *testing.T) {
+	_, name := openPty(t)
+
+	for _, honor := range []bool{false, true} {
+		var cflag uint64
+		port, err := Open(OpenOptions{
+			PortName:              name,
+			BaudRate:              115200,
+			DataBits:              8,
+			StopBits:              1,
+			MinimumReadSize:       1,
+			HonorModemStatusLines: honor,
+			RawConfig: func(rt *Termios) error {
+				cflag = rt.ControlFlags()
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port.Close()
+
+		if got := cflag&unix.CLOCAL == 0; got != honor {
+			t.Errorf("HonorModemStatusLines %v: CLOCAL clear = %v", honor, got)
+		}
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if err := port.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the master side, so this fills the pty's buffer and blocks.
+	_, err = port.Write(make([]byte, 1<<20))
+	checkTimeout(t, err)
+}
+
+func TestPulseDTRWithoutModemLines(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux ptys are known to reject TIOCMBIC")
+	}
+
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// A pty has no modem control lines, so this should fail without sleeping.
+	start := time.Now()
+	if err := port.PulseDTR(time.Second); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("PulseDTR waited before failing")
+	}
+}
+
+func TestSetRTS(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:          name,
+		BaudRate:          115200,
+		DataBits:          8,
+		StopBits:          1,
+		MinimumReadSize:   1,
+		RTSCTSFlowControl: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if err := port.SetRTS(false); err != errRTSFlowControl {
+		t.Errorf("SetRTS with flow control: %v", err)
+	}
+
+	if _, err := port.WriteWithRTS([]byte("x")); err != errRTSFlowControl {
+		t.Errorf("WriteWithRTS with flow control: %v", err)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	// As for PulseDTR, a Linux pty has no modem control lines, and nothing
+	// should be written when RTS can't be raised.
+	if err := port.SetMode(8, PARITY_NONE, 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := port.SetRTS(true); err != ErrNotSupported {
+		t.Errorf("SetRTS: expected ErrNotSupported, got %v", err)
+	}
+
+	if n, err := port.WriteWithRTS([]byte("x")); n != 0 || err != ErrNotSupported {
+		t.Errorf("WriteWithRTS: %d, %v", n, err)
+	}
+
+	if s := port.Stats(); s.BytesWritten != 0 || s.WriteErrors != 2 {
+		t.Errorf("Stats: %+v", s)
+	}
+}
+
+func TestSetDTRAndBreak(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// A pty ignores breaks, but accepts the requests.
+	if err := port.SetBreak(true); err != nil {
+		t.Fatalf("SetBreak(true): %v", err)
+	}
+
+	if err := port.SetBreak(false); err != nil {
+		t.Fatalf("SetBreak(false): %v", err)
+	}
+
+	if _, err := port.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(master, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS == "linux" {
+		if err := port.SetDTR(true); err != ErrNotSupported {
+			t.Errorf("SetDTR: expected ErrNotSupported, got %v", err)
+		}
+
+		if _, err := port.CarrierDetect(); err != ErrNotSupported {
+			t.Errorf("CarrierDetect: expected ErrNotSupported, got %v", err)
+		}
+	}
+}
+
+func TestInvalidInitialDTR(t *testing.T) {
+	_, name := openPty(t)
+
+	_, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		InitialDTR:      LineState(7),
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid InitialDTR")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	options := OpenOptions{
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+
+	if _, err := Open(options); err == nil {
+		t.Error("expected an error for an empty PortName")
+	}
+
+	options.PortName = "/dev/no-such-serial-port"
+	_, err := Open(options)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a not-exist error, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "connected") {
+		t.Errorf("error lacks a hint: %v", err)
+	}
+}
+
+func TestOpenSymlink(t *testing.T) {
+	_, name := openPty(t)
+
+	link := filepath.Join(t.TempDir(), "usb-Taco_Serial_0001-if00-port0")
+	if err := os.Symlink(name, link); err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := Open(OpenOptions{
+		PortName:        link,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Close()
+
+	// Errors name the device the link resolves to.
+	wantErr := os.ErrPermission
+	err = resolvedError(link, wantErr)
+	if !errors.Is(err, wantErr) || !strings.Contains(err.Error(), "resolved to "+name) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := resolvedError(name, wantErr); err != wantErr {
+		t.Errorf("unexpected error for a device: %v", err)
+	}
+}
+
+func TestResetErrorCounters(t *testing.T) {
+	f := useFakeSys(t)
+
+	// The driver has counted errors since before the port was opened.
+	driver := ErrorCounters{Framing: 7, Parity: 2}
+	fakeErrorCounters(t, f, &driver)
+
+	port, err := Open(OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	driver.Framing++
+	driver.Overrun++
+	if c, err := port.ErrorCounters(); err != nil || c != (ErrorCounters{Framing: 1, Overrun: 1}) {
+		t.Errorf("ErrorCounters: got %+v, %v", c, err)
+	}
+
+	if err := port.ResetErrorCounters(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := port.ErrorCounters(); err != nil || c != (ErrorCounters{}) {
+		t.Errorf("ErrorCounters after reset: got %+v, %v", c, err)
+	}
+
+	// Only errors after the reset are counted.
+	driver.Parity += 3
+	driver.Break++
+	if c, err := port.ErrorCounters(); err != nil || c != (ErrorCounters{Parity: 3, Break: 1}) {
+		t.Errorf("ErrorCounters: got %+v, %v", c, err)
+	}
+}
+
+func TestFlowControlByWrite(t *testing.T) {
+	// As on OS X, where XON and XOFF are written like data.
+	defer func(b bool) { flowControlByWrite = b }(flowControlByWrite)
+	flowControlByWrite = true
+
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	port.Write([]byte("a"))
+	if err := port.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	port.Write([]byte("b"))
+	if err := port.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	master.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(master, got); err != nil || string(got) != "a\x13b\x11" {
+		t.Errorf("master read %q, %v", got, err)
+	}
+}
+
+func TestReadFull(t *testing.T) {
+	master, name := openPty(t)
+
+	// A short inter-character timeout makes Read return io.EOF between the
+	// two halves of the frame.
+	port, err := Open(OpenOptions{
+		PortName:              name,
+		BaudRate:              115200,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	go func() {
+		master.Write([]byte("abc"))
+		time.Sleep(250 * time.Millisecond)
+		master.Write([]byte("de"))
+	}()
+
+	buf := make([]byte, 5)
+	if n, err := ReadFull(port, buf); err != nil || string(buf[:n]) != "abcde" {
+		t.Fatalf("ReadFull: %q, %v", buf[:n], err)
+	}
+
+	// A partial frame times out, reporting what was received.
+	master.Write([]byte("fg"))
+	port.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+
+	n, err := ReadFull(port, buf)
+	if n != 2 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFull: %d, %v", n, err)
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// What is read is appended, growing the buffer as needed.
+	buf := []byte("ab")
+	master.Write([]byte("cd"))
+	if buf, err = ReadInto(port, buf); err != nil || string(buf) != "abcd" {
+		t.Fatalf("ReadInto: %q, %v", buf, err)
+	}
+
+	// A buffer with room to spare is reused.
+	buf = make([]byte, 0, 1024)
+	master.Write([]byte("ef"))
+	got, err := ReadInto(port, buf)
+	if err != nil || string(got) != "ef" || cap(got) != cap(buf) {
+		t.Errorf("ReadInto: %q, %v, capacity %d", got, err, cap(got))
+	}
+}
+
+// benchmarkRead reads from a pty that is kept full with read.
+func benchmarkRead(b *testing.B, read func(Port)) {
+	master, name := openPty(b)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer port.Close()
+
+	go func() {
+		data := make([]byte, 256)
+		for {
+			if _, err := master.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		read(port)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	buf := make([]byte, 4096)
+	benchmarkRead(b, func(p Port) {
+		if _, err := p.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkReadInto(b *testing.B) {
+	buf := make([]byte, 0, 4096)
+	benchmarkRead(b, func(p Port) {
+		var err error
+		if buf, err = ReadInto(p, buf); err != nil {
+			b.Fatal(err)
+		}
+
+		// Handle the data, then reuse the buffer.
+		buf = buf[:0]
+	})
+}
+
+func TestBridge(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	near, far := net.Pipe()
+
+	var stats BridgeStats
+	done := make(chan error, 1)
+	go func() { done <- Bridge(port, near, &stats) }()
+
+	master.Write([]byte("taco"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(far, buf); err != nil || string(buf) != "taco" {
+		t.Fatalf("from port: %q, %v", buf, err)
+	}
+
+	far.Write([]byte("burrito"))
+	buf = make([]byte, 7)
+	if _, err := io.ReadFull(master, buf); err != nil || string(buf) != "burrito" {
+		t.Fatalf("to port: %q, %v", buf, err)
+	}
+
+	// Closing the other side stops the bridge cleanly.
+	far.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Bridge: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bridge didn't return")
+	}
+
+	if stats.FromPort != 4 || stats.ToPort != 7 || stats.PortErrors != 0 || stats.OtherErrors != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// The port is still usable.
+	master.Write([]byte("x"))
+	port.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := port.Read(buf); n != 1 || err != nil {
+		t.Errorf("Read after Bridge: %d, %v", n, err)
+	}
+}
+
+func TestReadBuffer(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		ReadBufferSize:  64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	master.Write([]byte("abcdef"))
+	if ok, err := port.WaitForData(time.Second); !ok || err != nil {
+		t.Fatalf("WaitForData: %v, %v", ok, err)
+	}
+
+	// Give the pty time to pass everything through.
+	time.Sleep(50 * time.Millisecond)
+
+	buf := make([]byte, 2)
+	if n, err := port.Read(buf); err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	// The rest is in the buffer.
+	if n, err := port.BytesAvailable(); n != 4 || err != nil {
+		t.Errorf("BytesAvailable: %d, %v", n, err)
+	}
+
+	if ok, err := port.WaitForData(0); !ok || err != nil {
+		t.Errorf("WaitForData with buffered data: %v, %v", ok, err)
+	}
+
+	buf = make([]byte, 8)
+	n, at, err := port.ReadWithTimestamp(buf)
+	if err != nil || string(buf[:n]) != "cdef" || at.IsZero() {
+		t.Errorf("ReadWithTimestamp: %q, %v, %v", buf[:n], at, err)
+	}
+
+	// With the buffer empty, deadlines apply as usual.
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := port.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
+
+func TestReadWithTimestamp(t *testing.T) {
+	master, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:              name,
+		BaudRate:              115200,
+		DataBits:              8,
+		StopBits:              1,
+		MinimumReadSize:       4,
+		InterCharacterTimeout: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// The timestamp should reflect the first two bytes, not the last two.
+	sentCh := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		sentCh <- time.Now()
+		master.Write([]byte("ab"))
+		time.Sleep(200 * time.Millisecond)
+		master.Write([]byte("cd"))
+	}()
+
+	buf := make([]byte, 4)
+	n, ts, err := port.ReadWithTimestamp(buf)
+	if err != nil || n != 4 {
+		t.Fatalf("ReadWithTimestamp: %d, %v", n, err)
+	}
+
+	if d := ts.Sub(<-sentCh); d < 0 || d > 100*time.Millisecond {
+		t.Errorf("timestamp %v after the first write", d)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	_, name := openPty(t)
+
+	var out strings.Builder
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		Logger:          logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if s := out.String(); !strings.Contains(s, `msg="serial: configured" port=`+name+" baud=115200") {
+		t.Errorf("after Open:\n%s", s)
+	}
+
+	port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	port.Read(make([]byte, 1))
+
+	if s := out.String(); !strings.Contains(s, `msg="serial: read deadline passed"`) {
+		t.Errorf("after Read:\n%s", s)
+	}
+
+	if err := port.SetBaudRate(9600); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := out.String(); !strings.Contains(s, `msg="serial: changed baud rate" port=`+name+" baud=9600") {
+		t.Errorf("after SetBaudRate:\n%s", s)
+	}
+
+	port.SetLogger(nil)
+	out.Reset()
+
+	port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	port.Read(make([]byte, 1))
+
+	if out.Len() != 0 {
+		t.Errorf("logged after SetLogger(nil):\n%s", out.String())
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	_, name := openPty(t)
+
+	port, err := Open(OpenOptions{
+		PortName:        name,
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		ReadBufferSize:  512,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes, err := port.BufferSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := BufferSizes{InputQueue: driverInputQueue, ReadBuffer: 512}
+	if sizes != want {
+		t.Errorf("BufferSizes: got %+v, want %+v", sizes, want)
+	}
+
+	port.Close()
+	if _, err := port.BufferSizes(); err != ErrPortClosed {
+		t.Errorf("BufferSizes after Close: got %v, want ErrPortClosed", err)
+	}
+}
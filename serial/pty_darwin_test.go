@@ -0,0 +1,66 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty allocates a pseudo-terminal pair, returning the master side and the
+// path of the slave device. The master is closed when the test finishes.
+func openPty(t testing.TB) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var name [128]byte
+	var ioctlErr error
+	rc.Control(func(fd uintptr) {
+		for _, req := range []uintptr{unix.TIOCPTYGRANT, unix.TIOCPTYUNLK} {
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, 0); errno != 0 {
+				ioctlErr = errno
+				return
+			}
+		}
+
+		_, _, errno := syscall.Syscall(
+			syscall.SYS_IOCTL,
+			fd,
+			unix.TIOCPTYGNAME,
+			uintptr(unsafe.Pointer(&name[0])))
+		if errno != 0 {
+			ioctlErr = errno
+		}
+	})
+
+	if ioctlErr != nil {
+		t.Fatal(ioctlErr)
+	}
+
+	return master, string(name[:bytes.IndexByte(name[:], 0)])
+}
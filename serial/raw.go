@@ -0,0 +1,76 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+// Termios is the termios structure Open is about to apply, as passed to
+// OpenOptions.RawConfig. The layout of the underlying structure differs
+// between systems, so it is reached through accessors. The flag bits
+// themselves are system specific; the constants in golang.org/x/sys/unix
+// have the right values.
+type Termios struct {
+	iflag, oflag, cflag, lflag uint64
+	cc                         []byte
+}
+
+// InputFlags returns c_iflag.
+func (t *Termios) InputFlags() uint64 { return t.iflag }
+
+// SetInputFlags sets c_iflag.
+func (t *Termios) SetInputFlags(f uint64) { t.iflag = f }
+
+// OutputFlags returns c_oflag.
+func (t *Termios) OutputFlags() uint64 { return t.oflag }
+
+// SetOutputFlags sets c_oflag.
+func (t *Termios) SetOutputFlags(f uint64) { t.oflag = f }
+
+// ControlFlags returns c_cflag.
+func (t *Termios) ControlFlags() uint64 { return t.cflag }
+
+// SetControlFlags sets c_cflag.
+func (t *Termios) SetControlFlags(f uint64) { t.cflag = f }
+
+// LocalFlags returns c_lflag.
+func (t *Termios) LocalFlags() uint64 { return t.lflag }
+
+// SetLocalFlags sets c_lflag.
+func (t *Termios) SetLocalFlags(f uint64) { t.lflag = f }
+
+// NumControlChars returns the length of c_cc.
+func (t *Termios) NumControlChars() int { return len(t.cc) }
+
+// ControlChar returns c_cc[i]. It panics if i is out of range.
+func (t *Termios) ControlChar(i int) byte { return t.cc[i] }
+
+// SetControlChar sets c_cc[i]. It panics if i is out of range.
+func (t *Termios) SetControlChar(i int, c byte) { t.cc[i] = c }
+
+// DCB holds the fields of the Windows DCB structure Open is about to apply,
+// as passed to OpenOptions.RawDCB. Flags holds the DCB's bit fields, with
+// fBinary in bit 0.
+type DCB struct {
+	BaudRate  uint32
+	Flags     uint32
+	XonLim    uint16
+	XoffLim   uint16
+	ByteSize  byte
+	Parity    byte
+	StopBits  byte
+	XonChar   byte
+	XoffChar  byte
+	ErrorChar byte
+	EofChar   byte
+	EvtChar   byte
+}
@@ -0,0 +1,79 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"slices"
+)
+
+// ReadFull reads exactly len(buf) bytes from p, which is usually a Port,
+// calling Read as many times as it takes. It is meant for fixed-length
+// frames.
+//
+// Unlike io.ReadFull, it keeps going when a Port's Read reports io.EOF, which
+// is how it signals an inter-character timeout with nothing received. To
+// bound the wait, set a deadline with SetReadDeadline: once it passes,
+// ReadFull returns the number of bytes of the frame it did receive along
+// with the timeout error. Any other error is returned likewise, including
+// the one matching ErrDeviceRemoved that a port reports once its connection
+// has closed. From a reader that isn't a Port, io.EOF means the end of the
+// data, and ReadFull returns it as io.ReadFull does.
+func ReadFull(p io.Reader, buf []byte) (int, error) {
+	_, isPort := p.(Port)
+
+	n := 0
+	for n < len(buf) {
+		m, err := p.Read(buf[n:])
+		n += m
+
+		switch {
+		case err == nil || err == io.EOF && isPort:
+		case err != io.EOF || n == len(buf):
+			return n, err
+		case n > 0:
+			return n, io.ErrUnexpectedEOF
+		default:
+			return 0, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// ReadInto grows buf, when it has less spare capacity than this, before
+// reading into it.
+const readIntoMin = 512
+
+// ReadInto reads once from p into the spare capacity of buf, after its
+// length, and returns buf extended by what was read. It is meant for
+// collecting a fast stream, as a logger does: passing the result back in,
+// and truncating it with buf[:0] once its contents have been handled,
+// reuses one buffer for every call. buf is grown only when it has fewer
+// than 512 bytes to spare, so once it has reached a working size ReadInto
+// allocates nothing.
+//
+// Neither does Read on a port opened with Open, except to return an error
+// other than io.EOF, so at steady state reading from a device costs no
+// garbage. Ports that receive over a network or a Pipe allocate for each
+// chunk they receive instead.
+func ReadInto(p io.Reader, buf []byte) ([]byte, error) {
+	if cap(buf)-len(buf) < readIntoMin {
+		buf = slices.Grow(buf, readIntoMin)
+	}
+
+	n, err := p.Read(buf[len(buf):cap(buf)])
+	return buf[:len(buf)+n], err
+}
@@ -0,0 +1,269 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Sessions are recorded as JSON Lines: a header, then one event per line.
+// The version is raised whenever the schema changes in a way older readers
+// would misunderstand.
+const (
+	sessionFormat  = "go-serial session"
+	sessionVersion = 1
+)
+
+type sessionHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Port    string    `json:"port"`
+	Start   time.Time `json:"start"`
+}
+
+// sessionEvent is a Read or Write. T is the time since the start of the
+// session in nanoseconds, and Dir is "r" or "w". Data is written as base64.
+type sessionEvent struct {
+	T       int64  `json:"t"`
+	Dir     string `json:"dir"`
+	Data    []byte `json:"data,omitempty"`
+	Address bool   `json:"address,omitempty"`
+	Err     string `json:"err,omitempty"`
+}
+
+// Recorder is a Port that passes everything through to another, logging the
+// data read and written, with timestamps and any errors, so that a session
+// in the field can be captured and played back with a Replayer.
+type Recorder struct {
+	Port
+
+	start time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // from writing the log
+}
+
+// NewRecorder returns a Recorder for port that logs to w. The first line
+// written is a header giving the format version and the port's name.
+func NewRecorder(port Port, w io.Writer) *Recorder {
+	r := &Recorder{
+		Port:  port,
+		start: time.Now(),
+		enc:   json.NewEncoder(w),
+	}
+
+	r.err = r.enc.Encode(sessionHeader{
+		Format:  sessionFormat,
+		Version: sessionVersion,
+		Port:    port.String(),
+		Start:   r.start,
+	})
+
+	return r
+}
+
+// Err returns the first error writing the log, after which nothing more is
+// logged. The port itself carries on regardless.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *Recorder) log(dir string, data []byte, address bool, err error) {
+	if len(data) == 0 && err == nil {
+		return
+	}
+
+	e := sessionEvent{
+		T:       int64(time.Since(r.start)),
+		Dir:     dir,
+		Data:    data,
+		Address: address,
+	}
+
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// Read implements io.Reader.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Port.Read(b)
+	r.log("r", b[:n], false, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port.
+func (r *Recorder) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, at, err := r.Port.ReadWithTimestamp(b)
+	r.log("r", b[:n], false, err)
+	return n, at, err
+}
+
+// Write implements io.Writer.
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.Port.Write(b)
+	r.log("w", b[:n], false, err)
+	return n, err
+}
+
+// WriteNineBit implements Port.
+func (r *Recorder) WriteNineBit(b []byte, address bool) (int, error) {
+	n, err := r.Port.WriteNineBit(b, address)
+	r.log("w", b[:n], address, err)
+	return n, err
+}
+
+// WriteWithRTS implements Port.
+func (r *Recorder) WriteWithRTS(b []byte) (int, error) {
+	n, err := r.Port.WriteWithRTS(b)
+	r.log("w", b[:n], false, err)
+	return n, err
+}
+
+// Replayer is a Port that plays back the device side of a session captured
+// by a Recorder, for reproducing field problems at a desk. It is the end of
+// a Pipe, so deadlines and the other Port methods work as they do for a
+// PipePort, and the recorded data is written to the other end.
+//
+// The session is followed in order. The data read at each point becomes
+// available once everything written before it in the session has been
+// written again, and, unless the Replayer was made to go as fast as
+// possible, no earlier than it was read originally, measured from the
+// start. What is written is discarded rather than checked, and errors in
+// the session aren't reproduced. Once the session is over, further writes
+// are discarded and reads wait for data that never comes.
+type Replayer struct {
+	*PipePort
+
+	name   string
+	device *PipePort
+	events []sessionEvent
+}
+
+// NewReplayer reads a session from r and starts playing it back. If fast is
+// set, the session's timing is ignored.
+func NewReplayer(r io.Reader, fast bool) (*Replayer, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("serial: reading session: %w", err)
+		}
+		return nil, errors.New("serial: empty session")
+	}
+
+	var h sessionHeader
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil || h.Format != sessionFormat {
+		return nil, errors.New("serial: not a recorded session")
+	}
+
+	if h.Version > sessionVersion {
+		return nil, fmt.Errorf("serial: session version %d is newer than this package supports (%d)", h.Version, sessionVersion)
+	}
+
+	var events []sessionEvent
+	for line := 2; scanner.Scan(); line++ {
+		var e sessionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("serial: session line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("serial: reading session: %w", err)
+	}
+
+	port, device := Pipe()
+	p := &Replayer{PipePort: port, name: h.Port, device: device, events: events}
+	go p.play(time.Now(), fast)
+
+	return p, nil
+}
+
+// String implements Port, giving the name of the port the session was
+// recorded on.
+func (p *Replayer) String() string {
+	return p.name
+}
+
+// Close implements Port, stopping the playback.
+func (p *Replayer) Close() error {
+	p.device.Close()
+	return p.PipePort.Close()
+}
+
+// play writes the session's reads to the device end of the pipe, consuming
+// its writes along the way, until the session or the pipe ends.
+func (p *Replayer) play(start time.Time, fast bool) {
+	defer p.device.Close()
+
+	buf := make([]byte, 4096)
+	for _, e := range p.events {
+		switch e.Dir {
+		case "w":
+			for left := len(e.Data); left > 0; {
+				chunk := buf
+				if left < len(chunk) {
+					chunk = chunk[:left]
+				}
+
+				n, err := p.device.Read(chunk)
+				if err != nil {
+					return
+				}
+				left -= n
+			}
+
+		case "r":
+			if len(e.Data) == 0 {
+				continue
+			}
+
+			if !fast {
+				time.Sleep(time.Until(start.Add(time.Duration(e.T))))
+			}
+
+			if _, err := p.device.Write(e.Data); err != nil {
+				return
+			}
+		}
+	}
+
+	// Discard anything written after the end of the session.
+	for {
+		if _, err := p.device.Read(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordSession records code writing "ping" and a device answering "pong"
+// 100ms after it started.
+func recordSession(t *testing.T) []byte {
+	a, b := Pipe()
+	defer b.Close()
+
+	var log bytes.Buffer
+	r := NewRecorder(a, &log)
+	defer r.Close()
+
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(b, buf); err != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		b.Write([]byte("pong"))
+	}()
+
+	var port Port = r
+	if _, err := port.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("Read: %q, %v", buf, err)
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return log.Bytes()
+}
+
+func TestRecorder(t *testing.T) {
+	log := recordSession(t)
+
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines:\n%s", len(lines), log)
+	}
+
+	if !strings.Contains(lines[0], `"version":1`) || !strings.Contains(lines[0], `"port":"pipe0"`) {
+		t.Errorf("header: %s", lines[0])
+	}
+
+	// base64 of "ping" and "pong".
+	if !strings.Contains(lines[1], `"dir":"w","data":"cGluZw=="`) {
+		t.Errorf("write: %s", lines[1])
+	}
+	if !strings.Contains(lines[2], `"dir":"r","data":"cG9uZw=="`) {
+		t.Errorf("read: %s", lines[2])
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	log := recordSession(t)
+
+	for _, fast := range []bool{false, true} {
+		p, err := NewReplayer(bytes.NewReader(log), fast)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var port Port = p
+		if port.String() != "pipe0" {
+			t.Errorf("String: %q", port.String())
+		}
+
+		// Nothing is read until the code has written what it wrote before.
+		if err := port.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if n, err := port.Read(buf); err == nil {
+			t.Errorf("fast=%v: read %q before writing", fast, buf[:n])
+		}
+		port.SetReadDeadline(time.Time{})
+
+		start := time.Now()
+		if _, err := port.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "pong" {
+			t.Fatalf("fast=%v: Read: %q, %v", fast, buf, err)
+		}
+
+		// The device answered 100ms into the session, which is 80ms after
+		// the write here.
+		elapsed := time.Since(start)
+		if !fast && elapsed < 50*time.Millisecond {
+			t.Errorf("replayed after %v", elapsed)
+		}
+		if fast && elapsed > 50*time.Millisecond {
+			t.Errorf("fast replay took %v", elapsed)
+		}
+
+		// Writes after the end of the session are discarded.
+		if _, err := port.Write([]byte("more")); err != nil {
+			t.Error(err)
+		}
+
+		if err := port.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReplayerVersion(t *testing.T) {
+	for _, log := range []string{
+		"",
+		"hello\n",
+		`{"format":"go-serial session","version":2}` + "\n",
+		`{"format":"go-serial session","version":1}` + "\n{bad\n",
+	} {
+		if _, err := NewReplayer(strings.NewReader(log), true); err == nil {
+			t.Errorf("%q: no error", log)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how OpenWithRetry waits for a port. The zero value
+// retries forever, starting at 100ms between attempts and backing off to 5s.
+type RetryPolicy struct {
+	// The maximum number of attempts, or zero for no limit beyond the
+	// context's.
+	MaxAttempts int
+
+	// The wait after the first failed attempt, doubling after each further
+	// failure up to MaxDelay. Default 100ms and 5s.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+
+	// Also retry when permission is denied, as happens on Linux between a
+	// USB adapter appearing and udev applying its rules. Busy ports are
+	// retried regardless, including on Windows, where they are reported as
+	// permission being denied.
+	RetryPermission bool
+}
+
+// OpenRetryError is returned by OpenWithRetry when it gives up.
+type OpenRetryError struct {
+	// The name of the port.
+	PortName string
+
+	// The number of attempts made.
+	Attempts int
+
+	// Whether the device was ever found, that is, whether any attempt failed
+	// for a reason other than the device not existing. If not, the adapter
+	// most likely never enumerated or the name is wrong; if so, it was there
+	// but busy or inaccessible.
+	Existed bool
+
+	// The error from the last attempt.
+	Err error
+}
+
+func (e *OpenRetryError) Error() string {
+	what := "never appeared"
+	if e.Existed {
+		what = "could not be opened"
+	}
+
+	return fmt.Sprintf("serial: %s %s after %d attempts: %v", e.PortName, what, e.Attempts, e.Err)
+}
+
+func (e *OpenRetryError) Unwrap() error {
+	return e.Err
+}
+
+// OpenWithRetry is like OpenContext, but keeps trying while the port doesn't
+// exist yet or is busy, for services that start before their USB adapter has
+// enumerated. Other errors are returned straight away. When the policy's
+// attempts run out, or ctx is done between attempts, it returns an
+// *OpenRetryError describing the last failure.
+func OpenWithRetry(ctx context.Context, options OpenOptions, policy RetryPolicy) (Port, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	return openWithRetry(ctx, options.PortName, policy, func() (Port, error) {
+		return OpenContext(ctx, options)
+	})
+}
+
+// openWithRetry implements OpenWithRetry, using open for each attempt.
+func openWithRetry(
+	ctx context.Context,
+	portName string,
+	policy RetryPolicy,
+	open func() (Port, error)) (Port, error) {
+	delay := policy.InitialDelay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+
+	maxDelay := policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+
+	retryErr := &OpenRetryError{PortName: portName}
+	for {
+		p, err := open()
+		if err == nil {
+			return p, nil
+		}
+
+		// OpenContext gives up with the context's error.
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			if retryErr.Attempts == 0 {
+				return nil, err
+			}
+
+			return nil, retryErr
+		}
+
+		retryErr.Attempts++
+		retryErr.Err = err
+		if !errors.Is(err, os.ErrNotExist) {
+			retryErr.Existed = true
+		}
+
+		if !retryable(err, policy) {
+			return nil, err
+		}
+
+		if policy.MaxAttempts > 0 && retryErr.Attempts >= policy.MaxAttempts {
+			return nil, retryErr
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, retryErr
+
+		case <-timer.C:
+		}
+
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// retryable reports whether OpenWithRetry should try again after err.
+func retryable(err error, policy RetryPolicy) bool {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrPortBusy), errors.Is(err, syscall.EBUSY):
+		return true
+
+	case errors.Is(err, os.ErrPermission):
+		return policy.RetryPermission
+	}
+
+	return false
+}
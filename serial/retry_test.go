@@ -0,0 +1,130 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeOpens returns an open function that fails with each of errs in turn
+// and then succeeds, counting the attempts in n.
+func fakeOpens(n *int, errs ...error) func() (Port, error) {
+	return func() (Port, error) {
+		*n++
+		if *n <= len(errs) {
+			return nil, errs[*n-1]
+		}
+
+		return &probePort{}, nil
+	}
+}
+
+func TestOpenWithRetry(t *testing.T) {
+	notExist := &os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.ENOENT}
+	busy := &os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EBUSY}
+	denied := &os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EACCES}
+	broken := errors.New("serial: something else")
+
+	policy := RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	withPermission := policy
+	withPermission.RetryPermission = true
+	limited := policy
+	limited.MaxAttempts = 3
+
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error
+		attempts int
+		err      error // nil for success
+		existed  bool
+	}{
+		{"first time", policy, nil, 1, nil, false},
+		{"appears", policy, []error{notExist, notExist}, 3, nil, false},
+		{"busy", policy, []error{busy}, 2, nil, false},
+		{"permission not retried", policy, []error{denied}, 1, denied, false},
+		{"permission retried", withPermission, []error{denied}, 2, nil, false},
+		{"other error", policy, []error{notExist, broken}, 2, broken, false},
+		{"never appears", limited, []error{notExist, notExist, notExist}, 3, notExist, false},
+		{"stays busy", limited, []error{notExist, busy, busy}, 3, busy, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var n int
+			p, err := openWithRetry(context.Background(), "/dev/ttyUSB0", tc.policy, fakeOpens(&n, tc.errs...))
+
+			if n != tc.attempts {
+				t.Errorf("%d attempts, want %d", n, tc.attempts)
+			}
+
+			if tc.err == nil {
+				if err != nil || p == nil {
+					t.Fatalf("got %v, %v", p, err)
+				}
+				return
+			}
+
+			if p != nil || !errors.Is(err, tc.err) {
+				t.Fatalf("got %v, %v; want error %v", p, err, tc.err)
+			}
+
+			var retryErr *OpenRetryError
+			if errors.As(err, &retryErr) != (tc.policy.MaxAttempts > 0) {
+				t.Fatalf("unexpected error type %T", err)
+			}
+
+			if retryErr != nil && (retryErr.Attempts != n || retryErr.Existed != tc.existed) {
+				t.Errorf("got %+v", retryErr)
+			}
+		})
+	}
+}
+
+func TestOpenWithRetryContext(t *testing.T) {
+	notExist := &os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.ENOENT}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var n int
+	_, err := openWithRetry(ctx, "/dev/ttyUSB0", RetryPolicy{InitialDelay: time.Millisecond}, func() (Port, error) {
+		n++
+		return nil, notExist
+	})
+
+	var retryErr *OpenRetryError
+	if !errors.As(err, &retryErr) || retryErr.Existed || retryErr.Attempts != n || n < 2 {
+		t.Fatalf("got %v after %d attempts", err, n)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%v doesn't wrap the last attempt's error", err)
+	}
+}
+
+func TestOpenWithRetryInvalidOptions(t *testing.T) {
+	_, err := OpenWithRetry(context.Background(), OpenOptions{}, RetryPolicy{})
+
+	var retryErr *OpenRetryError
+	if err == nil || errors.As(err, &retryErr) {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,1336 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrRFC2217Unsupported is returned by DialRFC2217 when the server refuses
+// the RFC 2217 COM port option or doesn't answer, as a plain TCP server
+// wouldn't.
+var ErrRFC2217Unsupported = errors.New("serial: server doesn't support RFC 2217")
+
+// How long to wait for the server to agree to the COM port option and to
+// answer each request. A variable for the tests.
+var rfc2217Timeout = 5 * time.Second
+
+// Telnet commands and options.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary  = 0
+	telnetSGA     = 3
+	telnetComPort = 44
+)
+
+// COM-PORT-OPTION commands sent by the client. The server's replies and
+// notifications add 100.
+const (
+	cpoSignature         = 0
+	cpoSetBaudRate       = 1
+	cpoSetDataSize       = 2
+	cpoSetParity         = 3
+	cpoSetStopSize       = 4
+	cpoSetControl        = 5
+	cpoNotifyLineState   = 6
+	cpoNotifyModemState  = 7
+	cpoFlowSuspend       = 8
+	cpoFlowResume        = 9
+	cpoSetLineStateMask  = 10
+	cpoSetModemStateMask = 11
+	cpoPurgeData         = 12
+
+	cpoServerOffset = 100
+)
+
+// Values of SET-CONTROL, SET-PARITY and PURGE-DATA, and bits of the line
+// and modem states.
+const (
+	cpoControlQueryFlow    = 0
+	cpoControlFlowNone     = 1
+	cpoControlFlowXonXoff  = 2
+	cpoControlFlowHW       = 3
+	cpoControlQueryBreak   = 4
+	cpoControlBreakOn      = 5
+	cpoControlBreakOff     = 6
+	cpoControlQueryDTR     = 7
+	cpoControlDTROn        = 8
+	cpoControlDTROff       = 9
+	cpoControlQueryRTS     = 10
+	cpoControlRTSOn        = 11
+	cpoControlRTSOff       = 12
+	cpoControlQueryInbound = 13
+	cpoControlInboundNone  = 14
+
+	cpoParityNone = 1
+	cpoParityOdd  = 2
+	cpoParityEven = 3
+
+	cpoPurgeReceive  = 1
+	cpoPurgeTransmit = 2
+	cpoPurgeBoth     = 3
+
+	cpoLineBreak   = 0x10
+	cpoLineFraming = 0x08
+	cpoLineParity  = 0x04
+	cpoLineOverrun = 0x02
+
+	cpoModemDCD        = 0x80
+	cpoModemRI         = 0x40
+	cpoModemDSR        = 0x20
+	cpoModemCTS        = 0x10
+	cpoModemDeltaDCD   = 0x08
+	cpoModemTrailingRI = 0x04
+	cpoModemDeltaDSR   = 0x02
+	cpoModemDeltaCTS   = 0x01
+)
+
+// RFC2217Port is a Port on a terminal server, such as a Moxa NPort or
+// ser2net, reached over the network with the Telnet COM port control
+// option of RFC 2217. Settings and modem lines are changed by asking the
+// server, which waits for it to confirm each change. Line errors are
+// counted from the server's notifications.
+//
+// The server's buffers lie between the port and the wire, so Drain only
+// waits until the data has been handed to the network, and the delays of
+// WriteWithRTS and PulseDTR are only as accurate as the network allows.
+// WriteNineBit, SetReceiverEnabled and SetLowLatency return
+// ErrNotSupported, and DescribeTermios does too, since the port has no
+// termios.
+type RFC2217Port struct {
+	// First, for alignment.
+	counters portCounters
+	log      portLogger
+
+	conn net.Conn
+	name string
+
+	// Serializes changes to settings, each of which waits for its reply.
+	cm sync.Mutex
+
+	// Serializes writes to conn.
+	wl sync.Mutex
+
+	// Received data and the rest, guarded by mu.
+	chunkPort
+
+	// Telnet option state: the options we have agreed to perform and asked
+	// the server to perform.
+	will, do [256]bool
+	accepted bool // the server agreed to the COM port option
+	refused  bool
+
+	// The latest value of each kind of reply, and how many have arrived.
+	replies [cpoPurgeData + 1][]byte
+	seq     [cpoPurgeData + 1]int
+
+	paused        bool // the server has asked us to stop sending
+	modem         byte
+	modemKnown    bool
+	errorCounters ErrorCounters
+
+	options       OpenOptions
+	writeDeadline time.Time
+}
+
+// DialRFC2217 connects to the RFC 2217 server at options.PortName, a
+// host:port address such as "moxa:4001", and configures the remote port
+// as Open would a local one. It fails with an error matching
+// ErrRFC2217Unsupported if the server refuses the COM port option or
+// doesn't agree to it within 5 seconds, rather than passing raw Telnet
+// through as data.
+//
+// Of the options, the framing, flow control, timeouts, InitialDTR,
+// InitialRTS, the Rs485 delays used by WriteWithRTS, Hooks and Logger are
+// used; the rest are ignored. The port's String is "rfc2217://" followed by
+// the address.
+func DialRFC2217(options OpenOptions) (*RFC2217Port, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	name := "rfc2217://" + options.PortName
+	conn, err := net.DialTimeout("tcp", options.PortName, rfc2217Timeout)
+	if err != nil {
+		return nil, newPortError(name, "dialing", err)
+	}
+
+	p := &RFC2217Port{
+		conn:    conn,
+		name:    name,
+		options: options,
+	}
+	p.counters.setHooks(options.Hooks)
+	p.log.set(options.Logger, name)
+
+	go p.readLoop()
+
+	if err := p.start(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// start negotiates the COM port option and applies the options.
+func (p *RFC2217Port) start() error {
+	p.mu.Lock()
+	p.will[telnetBinary], p.will[telnetSGA], p.will[telnetComPort] = true, true, true
+	p.do[telnetBinary], p.do[telnetSGA] = true, true
+	p.mu.Unlock()
+
+	err := p.send([]byte{
+		telnetIAC, telnetWILL, telnetBinary,
+		telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetWILL, telnetSGA,
+		telnetIAC, telnetDO, telnetSGA,
+		telnetIAC, telnetWILL, telnetComPort,
+	})
+	if err != nil {
+		return newPortError(p.name, "negotiating", err)
+	}
+
+	p.mu.Lock()
+	deadline := time.Now().Add(rfc2217Timeout)
+	for !p.accepted {
+		if p.refused {
+			p.mu.Unlock()
+			return newPortError(p.name, "negotiating", ErrRFC2217Unsupported)
+		}
+
+		if p.connErr != nil {
+			err := p.connErr
+			p.mu.Unlock()
+			return newPortError(p.name, "negotiating", err)
+		}
+
+		if p.wait(deadline) != nil {
+			p.mu.Unlock()
+			err := fmt.Errorf("no answer to the COM port option within %v", rfc2217Timeout)
+			return newPortError(p.name, "negotiating", markError(err, ErrRFC2217Unsupported))
+		}
+	}
+	p.mu.Unlock()
+
+	if l := p.log.get(); l != nil {
+		l.Debug("server accepted the COM port option")
+	}
+
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	if err := p.setMode(p.options); err != nil {
+		return err
+	}
+
+	if err := p.setBaudRate(p.options.BaudRate); err != nil {
+		return err
+	}
+
+	// Ask to be told about line errors and every change of the modem
+	// status lines.
+	if _, err := p.request("configuring", cpoSetLineStateMask,
+		cpoLineBreak|cpoLineFraming|cpoLineParity|cpoLineOverrun); err != nil {
+		return err
+	}
+
+	if _, err := p.request("configuring", cpoSetModemStateMask, 0xff); err != nil {
+		return err
+	}
+
+	// RTS is left alone when the server drives it for flow control.
+	rts := p.options.InitialRTS
+	if p.options.RTSCTSFlowControl {
+		rts = LINE_LEAVE
+	}
+
+	lines := []struct {
+		state   LineState
+		on, off byte
+	}{
+		{p.options.InitialDTR, cpoControlDTROn, cpoControlDTROff},
+		{rts, cpoControlRTSOn, cpoControlRTSOff},
+	}
+
+	for _, l := range lines {
+		var value byte
+		switch l.state {
+		case LINE_ASSERT:
+			value = l.on
+		case LINE_DEASSERT:
+			value = l.off
+		default:
+			continue
+		}
+
+		if _, err := p.request("configuring", cpoSetControl, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send writes raw Telnet to the connection. The write deadline is for
+// data, so it is cleared first.
+func (p *RFC2217Port) send(b []byte) error {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if err := p.conn.SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// subnegotiate sends a COM-PORT-OPTION command.
+func (p *RFC2217Port) subnegotiate(cmd byte, value []byte) error {
+	b := []byte{telnetIAC, telnetSB, telnetComPort, cmd}
+	b = appendEscaped(b, value)
+	b = append(b, telnetIAC, telnetSE)
+
+	return p.send(b)
+}
+
+// request sends a COM-PORT-OPTION command and waits for the server's reply,
+// returning its value. The caller must hold cm, so that only one request
+// of each kind is outstanding.
+func (p *RFC2217Port) request(op string, cmd byte, value ...byte) ([]byte, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPortClosed
+	}
+	seq := p.seq[cmd]
+	p.mu.Unlock()
+
+	if err := p.subnegotiate(cmd, value); err != nil {
+		return nil, p.portError(op, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deadline := time.Now().Add(rfc2217Timeout)
+	for p.seq[cmd] == seq {
+		if p.closed {
+			return nil, ErrPortClosed
+		}
+
+		if p.connErr != nil {
+			return nil, p.portError(op, p.connErr)
+		}
+
+		if p.wait(deadline) != nil {
+			return nil, p.portError(op, fmt.Errorf("no reply from server within %v", rfc2217Timeout))
+		}
+	}
+
+	return p.replies[cmd], nil
+}
+
+func (p *RFC2217Port) portError(op string, err error) error {
+	return newPortError(p.name, op, err)
+}
+
+// appendEscaped appends b to dst, doubling IAC bytes.
+func appendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == telnetIAC {
+			dst = append(dst, telnetIAC)
+		}
+		dst = append(dst, c)
+	}
+
+	return dst
+}
+
+// readLoop parses what the server sends until the connection fails or is
+// closed.
+func (p *RFC2217Port) readLoop() {
+	r := bufio.NewReader(p.conn)
+
+	var data []byte
+	err := func() error {
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if c != telnetIAC {
+				data = append(data, c)
+			} else if err := p.command(r, &data); err != nil {
+				return err
+			}
+
+			// Hand over what has arrived once nothing more is waiting, so
+			// that Read sees data in the chunks the server sent it in.
+			if len(data) > 0 && r.Buffered() == 0 {
+				p.deliver(data)
+				data = nil
+			}
+		}
+	}()
+
+	if len(data) > 0 {
+		p.deliver(data)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		if l := p.log.get(); l != nil && err != io.EOF {
+			l.Warn("connection failed", "err", err)
+		}
+	}
+
+	p.fail(err)
+}
+
+// command handles a Telnet command following IAC. An escaped IAC is
+// appended to data.
+func (p *RFC2217Port) command(r *bufio.Reader, data *[]byte) error {
+	cmd, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case telnetIAC:
+		*data = append(*data, telnetIAC)
+
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		opt, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		p.option(cmd, opt)
+
+	case telnetSB:
+		var sb []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if c == telnetIAC {
+				if c, err = r.ReadByte(); err != nil {
+					return err
+				}
+
+				if c == telnetSE {
+					break
+				}
+			}
+
+			sb = append(sb, c)
+		}
+
+		if len(sb) >= 2 && sb[0] == telnetComPort {
+			p.comPort(sb[1], sb[2:])
+		}
+	}
+
+	// Other commands, such as NOP, are ignored.
+	return nil
+}
+
+// option answers the server's request to enable or disable an option.
+// Requests that merely confirm the current state go unanswered, so that
+// the two ends don't loop.
+func (p *RFC2217Port) option(cmd, opt byte) {
+	supported := opt == telnetBinary || opt == telnetSGA || opt == telnetComPort
+
+	p.mu.Lock()
+	var reply byte
+	switch cmd {
+	case telnetDO:
+		if opt == telnetComPort {
+			p.accepted = true
+			p.notify()
+		}
+
+		if !supported {
+			reply = telnetWONT
+		} else if !p.will[opt] {
+			p.will[opt] = true
+			reply = telnetWILL
+		}
+
+	case telnetDONT:
+		if opt == telnetComPort {
+			p.refused = true
+			p.notify()
+		}
+
+		if p.will[opt] {
+			p.will[opt] = false
+			reply = telnetWONT
+		}
+
+	case telnetWILL:
+		if !supported || opt == telnetComPort {
+			reply = telnetDONT
+		} else if !p.do[opt] {
+			p.do[opt] = true
+			reply = telnetDO
+		}
+
+	case telnetWONT:
+		if p.do[opt] {
+			p.do[opt] = false
+			reply = telnetDONT
+		}
+	}
+	p.mu.Unlock()
+
+	if reply != 0 {
+		p.send([]byte{telnetIAC, reply, opt})
+	}
+}
+
+// comPort handles a COM-PORT-OPTION subnegotiation from the server.
+func (p *RFC2217Port) comPort(cmd byte, value []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cmd < cpoServerOffset {
+		return
+	}
+	cmd -= cpoServerOffset
+
+	switch cmd {
+	case cpoNotifyLineState:
+		if len(value) > 0 {
+			p.countLineState(value[0])
+		}
+		return
+
+	case cpoNotifyModemState:
+		if len(value) > 0 {
+			p.modem = value[0]
+			p.modemKnown = true
+		}
+		return
+
+	case cpoFlowSuspend, cpoFlowResume:
+		p.paused = cmd == cpoFlowSuspend
+		p.notify()
+		return
+	}
+
+	if int(cmd) < len(p.replies) {
+		p.replies[cmd] = append([]byte(nil), value...)
+		p.seq[cmd]++
+		p.notify()
+	}
+}
+
+// countLineState counts the errors in a line state notification. The
+// caller must hold mu.
+func (p *RFC2217Port) countLineState(state byte) {
+	if state&cpoLineBreak != 0 {
+		p.errorCounters.Break++
+	}
+
+	if state&cpoLineFraming != 0 {
+		p.errorCounters.Framing++
+	}
+
+	if state&cpoLineParity != 0 {
+		p.errorCounters.Parity++
+	}
+
+	if state&cpoLineOverrun != 0 {
+		p.errorCounters.Overrun++
+	}
+}
+
+// Read implements io.Reader, honouring MinimumReadSize,
+// InterCharacterTimeout and OverallReadTimeout as Open's ports do.
+func (p *RFC2217Port) Read(b []byte) (int, error) {
+	n, _, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port, giving the time at which the first of
+// the bytes arrived from the network.
+func (p *RFC2217Port) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, first, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, first, err
+}
+
+func (p *RFC2217Port) read(b []byte) (int, time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readChunks(p.name, b, p.options)
+}
+
+// Write implements io.Writer. It waits while the server has asked for
+// output to be suspended, and doubles IAC bytes as Telnet requires.
+func (p *RFC2217Port) Write(b []byte) (int, error) {
+	n, err := p.write(b)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *RFC2217Port) write(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.paused && !p.closed && p.connErr == nil {
+		if err := p.wait(p.writeDeadline); err != nil {
+			p.mu.Unlock()
+			return 0, p.portError("write", err)
+		}
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		return 0, ErrPortClosed
+	}
+	deadline := p.writeDeadline
+	p.mu.Unlock()
+
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if err := p.conn.SetWriteDeadline(deadline); err != nil {
+		return 0, p.portError("write", err)
+	}
+
+	escaped := appendEscaped(make([]byte, 0, len(b)), b)
+	m, err := p.conn.Write(escaped)
+	if err == nil {
+		return len(b), nil
+	}
+
+	// Count the bytes whose escaped form was written in full.
+	n := 0
+	for _, c := range b {
+		size := 1
+		if c == telnetIAC {
+			size = 2
+		}
+
+		if m < size {
+			break
+		}
+
+		m -= size
+		n++
+	}
+
+	return n, p.portError("write", err)
+}
+
+// Close implements Port.
+func (p *RFC2217Port) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	p.chunks = nil
+	p.notify()
+	p.mu.Unlock()
+
+	p.conn.Close()
+	return nil
+}
+
+// String implements Port.
+func (p *RFC2217Port) String() string {
+	return p.name
+}
+
+// Stats implements Port.
+func (p *RFC2217Port) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *RFC2217Port) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *RFC2217Port) SetHooks(hooks PortHooks) {
+	p.mu.Lock()
+	p.options.Hooks = hooks
+	p.mu.Unlock()
+
+	p.counters.setHooks(hooks)
+}
+
+// SetLogger implements Port.
+func (p *RFC2217Port) SetLogger(l *slog.Logger) {
+	p.mu.Lock()
+	p.options.Logger = l
+	p.mu.Unlock()
+
+	p.log.set(l, p.name)
+}
+
+// begin fails if the port is closed.
+func (p *RFC2217Port) begin() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	return nil
+}
+
+// Pause implements Port, asking the server to suspend sending.
+func (p *RFC2217Port) Pause() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return p.portError("pausing", p.subnegotiate(cpoFlowSuspend, nil))
+}
+
+// Resume implements Port.
+func (p *RFC2217Port) Resume() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return p.portError("resuming", p.subnegotiate(cpoFlowResume, nil))
+}
+
+// ErrorCounters implements Port, counting the line errors the server has
+// reported. BufferOverrun is always zero.
+func (p *RFC2217Port) ErrorCounters() (ErrorCounters, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrorCounters{}, ErrPortClosed
+	}
+
+	return p.errorCounters, nil
+}
+
+// ResetErrorCounters implements Port.
+func (p *RFC2217Port) ResetErrorCounters() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	p.errorCounters = ErrorCounters{}
+	return nil
+}
+
+// BufferSizes implements Port. The server doesn't report its buffers, so
+// all are zero.
+func (p *RFC2217Port) BufferSizes() (BufferSizes, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return BufferSizes{}, ErrPortClosed
+	}
+
+	return BufferSizes{}, nil
+}
+
+// SetLowLatency implements Port. It returns ErrNotSupported.
+func (p *RFC2217Port) SetLowLatency(on bool) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+// CurrentOptions implements Port, asking the server for its settings.
+func (p *RFC2217Port) CurrentOptions() (OpenOptions, error) {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	p.mu.Lock()
+	options := p.options
+	p.mu.Unlock()
+
+	baud, err := p.queryBaudRate()
+	if err != nil {
+		return OpenOptions{}, err
+	}
+	options.BaudRate = baud
+
+	v, err := p.requestByte("reading settings", cpoSetDataSize, 0)
+	if err != nil {
+		return OpenOptions{}, err
+	}
+	options.DataBits = uint(v)
+
+	v, err = p.requestByte("reading settings", cpoSetParity, 0)
+	if err != nil {
+		return OpenOptions{}, err
+	}
+
+	switch v {
+	case cpoParityNone:
+		options.ParityMode = PARITY_NONE
+	case cpoParityOdd:
+		options.ParityMode = PARITY_ODD
+	case cpoParityEven:
+		options.ParityMode = PARITY_EVEN
+	default:
+		return OpenOptions{}, p.portError("reading settings", fmt.Errorf("unsupported parity %d", v))
+	}
+
+	v, err = p.requestByte("reading settings", cpoSetStopSize, 0)
+	if err != nil {
+		return OpenOptions{}, err
+	}
+
+	if v != 1 && v != 2 {
+		return OpenOptions{}, p.portError("reading settings", fmt.Errorf("unsupported stop size %d", v))
+	}
+	options.StopBits = uint(v)
+
+	v, err = p.requestByte("reading settings", cpoSetControl, cpoControlQueryFlow)
+	if err != nil {
+		return OpenOptions{}, err
+	}
+	options.RTSCTSFlowControl = v == cpoControlFlowHW
+
+	return options, nil
+}
+
+// requestByte is like request, for replies of a single byte.
+func (p *RFC2217Port) requestByte(op string, cmd, value byte) (byte, error) {
+	reply, err := p.request(op, cmd, value)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(reply) != 1 {
+		return 0, p.portError(op, fmt.Errorf("malformed reply % x", reply))
+	}
+
+	return reply[0], nil
+}
+
+// BaudRate implements Port, asking the server for the rate.
+func (p *RFC2217Port) BaudRate() (uint, error) {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	return p.queryBaudRate()
+}
+
+// queryBaudRate asks the server for the baud rate. The caller must hold cm.
+func (p *RFC2217Port) queryBaudRate() (uint, error) {
+	return p.requestBaudRate("reading settings", 0)
+}
+
+// requestBaudRate sends SET-BAUDRATE with baud, zero meaning a query, and
+// returns the rate the server replies with. The caller must hold cm.
+func (p *RFC2217Port) requestBaudRate(op string, baud uint) (uint, error) {
+	reply, err := p.request(op, cpoSetBaudRate, binary.BigEndian.AppendUint32(nil, uint32(baud))...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(reply) != 4 {
+		return 0, p.portError(op, fmt.Errorf("malformed reply % x", reply))
+	}
+
+	return uint(binary.BigEndian.Uint32(reply)), nil
+}
+
+// DumpSettings implements Port. The modem status lines are as the server
+// last reported them.
+func (p *RFC2217Port) DumpSettings() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return "", ErrPortClosed
+	}
+
+	modem := ""
+	if p.modemKnown {
+		modem = decodeModemState(p.modem).String()
+	}
+
+	return joinSettings(p.name, FormatMode(p.options), modem), nil
+}
+
+// CarrierDetect implements Port, reporting DCD as the server last notified
+// it. It returns ErrNotSupported until the server has sent a notification.
+func (p *RFC2217Port) CarrierDetect() (bool, error) {
+	m, err := p.modemStatus()
+	return m.dcd, err
+}
+
+// modemStatus returns the modem status lines as the server last reported
+// them, for CarrierDetect and RFC2217Server.
+func (p *RFC2217Port) modemStatus() (modemStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return modemStatus{}, ErrPortClosed
+	}
+
+	if !p.modemKnown {
+		return modemStatus{}, ErrNotSupported
+	}
+
+	return decodeModemState(p.modem), nil
+}
+
+// decodeModemState decodes the modem state of a NOTIFY-MODEMSTATE.
+func decodeModemState(state byte) modemStatus {
+	return modemStatus{
+		cts: state&cpoModemCTS != 0,
+		dsr: state&cpoModemDSR != 0,
+		dcd: state&cpoModemDCD != 0,
+		ri:  state&cpoModemRI != 0,
+	}
+}
+
+// encodeModemState is the inverse of decodeModemState. The delta bits are
+// set for the lines that differ from prev.
+func encodeModemState(m, prev modemStatus) byte {
+	var state byte
+	lines := []struct {
+		on, was    bool
+		bit, delta byte
+	}{
+		{m.cts, prev.cts, cpoModemCTS, cpoModemDeltaCTS},
+		{m.dsr, prev.dsr, cpoModemDSR, cpoModemDeltaDSR},
+		{m.ri, prev.ri, cpoModemRI, cpoModemTrailingRI},
+		{m.dcd, prev.dcd, cpoModemDCD, cpoModemDeltaDCD},
+	}
+
+	for _, l := range lines {
+		if l.on {
+			state |= l.bit
+		}
+
+		// RI reports only its trailing edge.
+		if l.on != l.was && (l.bit != cpoModemRI || !l.on) {
+			state |= l.delta
+		}
+	}
+
+	return state
+}
+
+// DescribeTermios implements Port. There is no termios, so it returns
+// ErrNotSupported.
+func (p *RFC2217Port) DescribeTermios() (string, error) {
+	if err := p.begin(); err != nil {
+		return "", err
+	}
+
+	return "", ErrNotSupported
+}
+
+// SetMode implements Port.
+func (p *RFC2217Port) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	p.mu.Lock()
+	options := p.options
+	p.mu.Unlock()
+
+	options.DataBits = dataBits
+	options.ParityMode = parity
+	options.StopBits = stopBits
+	options.RTSCTSFlowControl = rtscts
+
+	if err := options.validate(false); err != nil {
+		return err
+	}
+
+	return p.setMode(options)
+}
+
+// setMode applies the framing and flow control of options. The caller must
+// hold cm.
+func (p *RFC2217Port) setMode(options OpenOptions) error {
+	var parity byte
+	switch options.ParityMode {
+	case PARITY_NONE:
+		parity = cpoParityNone
+	case PARITY_ODD:
+		parity = cpoParityOdd
+	case PARITY_EVEN:
+		parity = cpoParityEven
+	}
+
+	flow := byte(cpoControlFlowNone)
+	if options.RTSCTSFlowControl {
+		flow = cpoControlFlowHW
+	}
+
+	requests := []struct {
+		cmd, value byte
+	}{
+		{cpoSetDataSize, byte(options.DataBits)},
+		{cpoSetParity, parity},
+		{cpoSetStopSize, byte(options.StopBits)},
+		{cpoSetControl, flow},
+	}
+
+	for _, r := range requests {
+		if _, err := p.request("configuring", r.cmd, r.value); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	p.options.DataBits = options.DataBits
+	p.options.ParityMode = options.ParityMode
+	p.options.StopBits = options.StopBits
+	p.options.RTSCTSFlowControl = options.RTSCTSFlowControl
+	p.mu.Unlock()
+
+	return nil
+}
+
+// SetBaudRate implements Port.
+func (p *RFC2217Port) SetBaudRate(baud uint) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	p.mu.Lock()
+	options := p.options
+	p.mu.Unlock()
+
+	options.BaudRate = baud
+	if err := options.validate(false); err != nil {
+		return err
+	}
+
+	return p.setBaudRate(baud)
+}
+
+// setBaudRate asks the server for baud. The caller must hold cm.
+func (p *RFC2217Port) setBaudRate(baud uint) error {
+	got, err := p.requestBaudRate("setting baud rate", baud)
+	if err != nil {
+		return err
+	}
+
+	if l := p.log.get(); l != nil {
+		if got != baud {
+			l.Warn("server runs at a different baud rate", "requested", baud, "actual", got)
+		} else {
+			l.Debug("set baud rate", "baud", baud)
+		}
+	}
+
+	p.mu.Lock()
+	p.options.BaudRate = baud
+	p.mu.Unlock()
+
+	return nil
+}
+
+// WithBaudRate implements Port.
+func (p *RFC2217Port) WithBaudRate(baud uint, fn func() error) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPortClosed
+	}
+	prev := p.options.BaudRate
+	p.mu.Unlock()
+
+	return withBaudRate(p, prev, baud, fn)
+}
+
+// Drain implements Port. It waits for Writes in progress to hand their
+// data to the network; the server may still be transmitting it.
+func (p *RFC2217Port) Drain() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	p.wl.Lock()
+	p.wl.Unlock()
+
+	return nil
+}
+
+// Quiesce implements Port, asking the server to discard its received data
+// as well as discarding what has arrived.
+func (p *RFC2217Port) Quiesce() error {
+	if err := p.Drain(); err != nil {
+		return err
+	}
+
+	if err := p.purgeInput("quiescing"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// purgeInput discards input held by the server and here.
+func (p *RFC2217Port) purgeInput(op string) error {
+	p.cm.Lock()
+	_, err := p.request(op, cpoPurgeData, cpoPurgeReceive)
+	p.cm.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.chunks = nil
+	p.mu.Unlock()
+
+	return nil
+}
+
+// WriteNineBit implements Port. It returns ErrNotSupported.
+func (p *RFC2217Port) WriteNineBit(b []byte, address bool) (int, error) {
+	if err := p.begin(); err != nil {
+		return 0, err
+	}
+
+	return 0, ErrNotSupported
+}
+
+// SetReceiverEnabled implements Port. It returns ErrNotSupported.
+func (p *RFC2217Port) SetReceiverEnabled(on bool) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+// control sends SET-CONTROL with value.
+func (p *RFC2217Port) control(op string, value byte) error {
+	p.cm.Lock()
+	defer p.cm.Unlock()
+
+	_, err := p.request(op, cpoSetControl, value)
+	return err
+}
+
+// SetDTR implements Port.
+func (p *RFC2217Port) SetDTR(on bool) error {
+	if on {
+		return p.control("setting DTR", cpoControlDTROn)
+	}
+
+	return p.control("setting DTR", cpoControlDTROff)
+}
+
+// SetRTS implements Port.
+func (p *RFC2217Port) SetRTS(on bool) error {
+	p.mu.Lock()
+	rtscts := p.options.RTSCTSFlowControl
+	p.mu.Unlock()
+
+	if rtscts {
+		return errRTSFlowControl
+	}
+
+	if on {
+		return p.control("setting RTS", cpoControlRTSOn)
+	}
+
+	return p.control("setting RTS", cpoControlRTSOff)
+}
+
+// SetBreak implements Port.
+func (p *RFC2217Port) SetBreak(on bool) error {
+	if on {
+		return p.control("setting break", cpoControlBreakOn)
+	}
+
+	return p.control("setting break", cpoControlBreakOff)
+}
+
+// SendBreak holds the line in the break condition for d.
+func (p *RFC2217Port) SendBreak(d time.Duration) error {
+	if err := p.SetBreak(true); err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+	return p.SetBreak(false)
+}
+
+// WriteWithRTS implements Port. The delays are measured here, so the
+// server's buffering adds to them.
+func (p *RFC2217Port) WriteWithRTS(b []byte) (int, error) {
+	if err := p.SetRTS(true); err != nil {
+		p.counters.countWrite(0, err)
+		return 0, err
+	}
+
+	p.mu.Lock()
+	before := time.Duration(p.options.Rs485DelayRtsBeforeSend) * time.Millisecond
+	after := time.Duration(p.options.Rs485DelayRtsAfterSend) * time.Millisecond
+	p.mu.Unlock()
+
+	time.Sleep(before)
+	n, err := p.write(b)
+	time.Sleep(after)
+
+	if rtsErr := p.SetRTS(false); err == nil {
+		err = rtsErr
+	}
+
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+// PulseDTR implements Port.
+func (p *RFC2217Port) PulseDTR(d time.Duration) error {
+	return p.pulse("pulsing DTR", cpoControlDTROff, cpoControlDTROn, d)
+}
+
+// PulseRTS implements Port.
+func (p *RFC2217Port) PulseRTS(d time.Duration) error {
+	p.mu.Lock()
+	rtscts := p.options.RTSCTSFlowControl
+	p.mu.Unlock()
+
+	if rtscts {
+		return errRTSFlowControl
+	}
+
+	return p.pulse("pulsing RTS", cpoControlRTSOff, cpoControlRTSOn, d)
+}
+
+func (p *RFC2217Port) pulse(op string, off, on byte, d time.Duration) error {
+	if err := p.control(op, off); err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+
+	if err := p.control(op, on); err != nil {
+		return err
+	}
+
+	return p.purgeInput(op)
+}
+
+// WaitForData implements Port. Once the connection has failed it reports
+// that data is available, so that a subsequent Read returns the error.
+func (p *RFC2217Port) WaitForData(timeout time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for len(p.chunks) == 0 && p.connErr == nil {
+		if p.closed {
+			return false, ErrPortClosed
+		}
+
+		if err := p.wait(deadline); err != nil {
+			return false, nil
+		}
+	}
+
+	if p.closed {
+		return false, ErrPortClosed
+	}
+
+	return true, nil
+}
+
+// BytesAvailable implements Port, counting the data that has arrived from
+// the server.
+func (p *RFC2217Port) BytesAvailable() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, ErrPortClosed
+	}
+
+	return p.buffered(), nil
+}
+
+// SetReadDeadline implements Port.
+func (p *RFC2217Port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	p.readDeadline = t
+	p.notify()
+
+	return nil
+}
+
+// SetWriteDeadline implements Port.
+func (p *RFC2217Port) SetWriteDeadline(t time.Time) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPortClosed
+	}
+
+	p.writeDeadline = t
+	p.notify()
+	p.mu.Unlock()
+
+	// Interrupt a Write in progress.
+	return p.portError("setting write deadline", p.conn.SetWriteDeadline(t))
+}
@@ -0,0 +1,781 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often RFC2217Server checks the port's modem status lines and error
+// counters for changes to notify clients of. A variable for the tests.
+var rfc2217PollInterval = 100 * time.Millisecond
+
+// RFC2217Server shares a Port over the network with the Telnet COM port
+// control option of RFC 2217, so that clients such as DialRFC2217 can use
+// it as if it were attached to them. Set Port and call Serve.
+//
+// One client at a time controls the port: what it sends is written to the
+// port, and its requests to change the settings and modem lines are
+// carried out with SetBaudRate, SetMode, SetDTR, SetRTS, SetBreak and the
+// like. Everything read from the port is sent to every client, and is
+// discarded while none is connected. Clients that connect while another is
+// in control are turned away, or with AllowObservers admitted as
+// observers, whose data is discarded and whose requests are answered with
+// the current settings without changing them. When the controlling client
+// disconnects, the port is left open, with any break ended and flow
+// resumed, for the next client to connect to take control.
+//
+// Clients that enable them are notified of line errors, as counted by
+// ErrorCounters, and of changes to the modem status lines, for ports that
+// report them.
+type RFC2217Server struct {
+	// The port to share. The server doesn't close it. Reads should block
+	// until data arrives or a timeout expires, as they do by default.
+	Port Port
+
+	// Admit clients as observers while another is in control, rather than
+	// turning them away.
+	AllowObservers bool
+
+	// The signature sent to clients that ask for one. If empty, it is
+	// "go-serial " followed by the port's name.
+	Signature string
+
+	// Serve a plain TCP stream instead of Telnet, as ser2net does in raw
+	// mode: bytes pass through unescaped, for clients such as OpenTCP's,
+	// which can't change the port's settings or lines. One client is still
+	// in control, and the rest are observers or turned away.
+	Raw bool
+
+	// If positive, clients that send nothing for this long are
+	// disconnected, so that one left idle doesn't keep the port from
+	// others.
+	IdleTimeout time.Duration
+
+	// If non-nil, the server logs clients connecting and disconnecting at
+	// Info level, and requests the port fails to carry out at Warn level.
+	Logger *slog.Logger
+
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	mu         sync.Mutex
+	ln         net.Listener
+	closed     bool
+	err        error // why the port stopped being read
+	sessions   map[*rfc2217Session]bool
+	controller *rfc2217Session
+
+	// The state of the lines, as last set by a client. Drivers generally
+	// raise DTR and RTS when the port is opened.
+	dtr, rts, brk, paused bool
+}
+
+// rfc2217Session is a client connected to an RFC2217Server.
+type rfc2217Session struct {
+	s        *RFC2217Server
+	conn     net.Conn
+	observer bool
+
+	// Serializes writes to conn.
+	wl sync.Mutex
+
+	// The rest is guarded by s.mu.
+	will, do  [256]bool
+	comPort   bool
+	lineMask  byte
+	modemMask byte
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *RFC2217Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve accepts clients on ln until Close is called, returning nil, or
+// until ln or the port fails, returning the error. Either way ln is closed
+// along with every client's connection. A server serves once: Serve fails
+// if called again.
+func (s *RFC2217Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.ln != nil || s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return errors.New("serial: RFC2217Server already served")
+	}
+
+	s.ln = ln
+	s.done = make(chan struct{})
+	s.sessions = make(map[*rfc2217Session]bool)
+	s.dtr, s.rts = true, true
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+	go s.pump()
+	go s.poll()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			if s.err != nil {
+				err = s.err
+			}
+			s.mu.Unlock()
+
+			s.Close()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.admit(conn)
+	}
+}
+
+// Close stops the server, closing its listener and disconnecting every
+// client. It interrupts a Read of the port in progress by setting a read
+// deadline, and clears the deadline again before returning. The port is
+// left open.
+func (s *RFC2217Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	ln := s.ln
+	var conns []net.Conn
+	for sess := range s.sessions {
+		conns = append(conns, sess.conn)
+	}
+	s.mu.Unlock()
+
+	if ln == nil {
+		return nil
+	}
+
+	close(s.done)
+	ln.Close()
+	for _, c := range conns {
+		c.Close()
+	}
+
+	s.Port.SetReadDeadline(time.Now())
+	s.wg.Wait()
+	s.Port.SetReadDeadline(time.Time{})
+
+	return nil
+}
+
+// admit starts a session for conn, or closes it if the port is taken.
+func (s *RFC2217Server) admit(conn net.Conn) {
+	sess := &rfc2217Session{s: s, conn: conn, modemMask: 0xff}
+
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		conn.Close()
+		return
+
+	case s.controller == nil:
+		s.controller = sess
+
+	case s.AllowObservers:
+		sess.observer = true
+
+	default:
+		s.mu.Unlock()
+		if l := s.Logger; l != nil {
+			l.Info("turned away client; port in use", "client", conn.RemoteAddr())
+		}
+		conn.Close()
+		return
+	}
+
+	s.sessions[sess] = true
+	sess.will[telnetBinary], sess.will[telnetSGA] = true, true
+	sess.do[telnetBinary], sess.do[telnetSGA], sess.do[telnetComPort] = true, true, true
+	s.mu.Unlock()
+
+	if l := s.Logger; l != nil {
+		l.Info("client connected", "client", conn.RemoteAddr(), "observer", sess.observer)
+	}
+
+	if !s.Raw {
+		sess.send([]byte{
+			telnetIAC, telnetWILL, telnetBinary,
+			telnetIAC, telnetDO, telnetBinary,
+			telnetIAC, telnetWILL, telnetSGA,
+			telnetIAC, telnetDO, telnetSGA,
+			telnetIAC, telnetDO, telnetComPort,
+		})
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		sess.serve()
+		s.remove(sess)
+	}()
+}
+
+// remove ends a session. If it was in control, the port is left ready for
+// the next client.
+func (s *RFC2217Server) remove(sess *rfc2217Session) {
+	sess.conn.Close()
+
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	controller := s.controller == sess
+	if controller {
+		s.controller = nil
+	}
+	brk, paused := s.brk && controller, s.paused && controller
+	if controller {
+		s.brk, s.paused = false, false
+	}
+	s.mu.Unlock()
+
+	if brk {
+		s.warn("ending break", s.Port.SetBreak(false))
+	}
+
+	if paused {
+		s.warn("resuming", s.Port.Resume())
+	}
+
+	if l := s.Logger; l != nil {
+		l.Info("client disconnected", "client", sess.conn.RemoteAddr())
+	}
+}
+
+// warn logs a failed request.
+func (s *RFC2217Server) warn(op string, err error) {
+	if err == nil {
+		return
+	}
+
+	if l := s.Logger; l != nil {
+		l.Warn(op+" failed", "err", err)
+	}
+}
+
+// pump sends what is read from the port to every client.
+func (s *RFC2217Server) pump() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.Port.Read(buf)
+		if n > 0 && s.Raw {
+			s.broadcast(buf[:n])
+		} else if n > 0 {
+			s.broadcast(appendEscaped(nil, buf[:n]))
+		}
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if err == nil || err == io.EOF {
+			continue
+		}
+
+		s.mu.Lock()
+		s.err = err
+		ln := s.ln
+		s.mu.Unlock()
+
+		ln.Close()
+		return
+	}
+}
+
+// broadcast sends raw Telnet, or in Raw mode data, to every client.
+func (s *RFC2217Server) broadcast(b []byte) {
+	s.mu.Lock()
+	sessions := make([]*rfc2217Session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.send(b)
+	}
+}
+
+// poll notifies clients of changes to the line and modem states.
+func (s *RFC2217Server) poll() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(rfc2217PollInterval)
+	defer ticker.Stop()
+
+	reader, _ := s.Port.(interface{ modemStatus() (modemStatus, error) })
+
+	var modem modemStatus
+	modemKnown := false
+	counters, countersErr := s.Port.ErrorCounters()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if reader != nil {
+			if m, err := reader.modemStatus(); err == nil && (!modemKnown || m != modem) {
+				s.notify(cpoNotifyModemState, encodeModemState(m, modem), func(sess *rfc2217Session) byte {
+					return sess.modemMask
+				})
+				modem, modemKnown = m, true
+			}
+		}
+
+		c, err := s.Port.ErrorCounters()
+		if err != nil {
+			continue
+		}
+
+		if countersErr == nil {
+			var state byte
+			for _, e := range []struct {
+				now, was uint64
+				bit      byte
+			}{
+				{c.Break, counters.Break, cpoLineBreak},
+				{c.Framing, counters.Framing, cpoLineFraming},
+				{c.Parity, counters.Parity, cpoLineParity},
+				{c.Overrun + c.BufferOverrun, counters.Overrun + counters.BufferOverrun, cpoLineOverrun},
+			} {
+				if e.now > e.was {
+					state |= e.bit
+				}
+			}
+
+			if state != 0 {
+				s.notify(cpoNotifyLineState, state, func(sess *rfc2217Session) byte {
+					return sess.lineMask
+				})
+			}
+		}
+
+		counters, countersErr = c, nil
+	}
+}
+
+// notify sends a notification to the clients that have negotiated the COM
+// port option, masked by the mask that mask returns for each.
+func (s *RFC2217Server) notify(cmd, state byte, mask func(*rfc2217Session) byte) {
+	type target struct {
+		sess  *rfc2217Session
+		state byte
+	}
+
+	s.mu.Lock()
+	var targets []target
+	for sess := range s.sessions {
+		if m := state & mask(sess); sess.comPort && m != 0 {
+			targets = append(targets, target{sess, m})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		t.sess.reply(cmd, t.state)
+	}
+}
+
+// send writes raw Telnet to the client, disconnecting it if that fails.
+func (sess *rfc2217Session) send(b []byte) {
+	sess.wl.Lock()
+	defer sess.wl.Unlock()
+
+	sess.conn.SetWriteDeadline(time.Now().Add(rfc2217Timeout))
+	if _, err := sess.conn.Write(b); err != nil {
+		sess.conn.Close()
+	}
+}
+
+// reply sends a COM-PORT-OPTION reply or notification for cmd.
+func (sess *rfc2217Session) reply(cmd byte, value ...byte) {
+	b := []byte{telnetIAC, telnetSB, telnetComPort, cmd + cpoServerOffset}
+	b = appendEscaped(b, value)
+	sess.send(append(b, telnetIAC, telnetSE))
+}
+
+// serve handles what the client sends until it disconnects.
+func (sess *rfc2217Session) serve() {
+	r := bufio.NewReader(sess.conn)
+
+	var data []byte
+	for {
+		if d := sess.s.IdleTimeout; d > 0 && r.Buffered() == 0 {
+			sess.conn.SetReadDeadline(time.Now().Add(d))
+		}
+
+		c, err := r.ReadByte()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if l := sess.s.Logger; l != nil {
+				l.Info("disconnecting idle client", "client", sess.conn.RemoteAddr())
+			}
+		}
+		if err != nil {
+			return
+		}
+
+		if c != telnetIAC || sess.s.Raw {
+			data = append(data, c)
+		} else if err := sess.command(r, &data); err != nil {
+			return
+		}
+
+		if len(data) > 0 && r.Buffered() == 0 {
+			if !sess.observer {
+				if _, err := sess.s.Port.Write(data); err != nil {
+					sess.s.warn("writing", err)
+				}
+			}
+			data = data[:0]
+		}
+	}
+}
+
+// command handles a Telnet command following IAC. An escaped IAC is
+// appended to data.
+func (sess *rfc2217Session) command(r *bufio.Reader, data *[]byte) error {
+	cmd, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case telnetIAC:
+		*data = append(*data, telnetIAC)
+
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		opt, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		sess.option(cmd, opt)
+
+	case telnetSB:
+		var sb []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if c == telnetIAC {
+				if c, err = r.ReadByte(); err != nil {
+					return err
+				}
+
+				if c == telnetSE {
+					break
+				}
+			}
+
+			sb = append(sb, c)
+		}
+
+		if len(sb) >= 2 && sb[0] == telnetComPort {
+			sess.comPortCommand(sb[1], sb[2:])
+		}
+	}
+
+	return nil
+}
+
+// option answers the client's request to enable or disable an option,
+// leaving requests that confirm the current state unanswered.
+func (sess *rfc2217Session) option(cmd, opt byte) {
+	s := sess.s
+
+	s.mu.Lock()
+	var reply byte
+	switch cmd {
+	case telnetWILL:
+		switch {
+		case opt != telnetBinary && opt != telnetSGA && opt != telnetComPort:
+			reply = telnetDONT
+		case !sess.do[opt]:
+			sess.do[opt] = true
+			reply = telnetDO
+		}
+
+		if opt == telnetComPort {
+			sess.comPort = true
+		}
+
+	case telnetWONT:
+		if sess.do[opt] {
+			sess.do[opt] = false
+			reply = telnetDONT
+		}
+
+		if opt == telnetComPort {
+			sess.comPort = false
+		}
+
+	case telnetDO:
+		switch {
+		case opt != telnetBinary && opt != telnetSGA:
+			reply = telnetWONT
+		case !sess.will[opt]:
+			sess.will[opt] = true
+			reply = telnetWILL
+		}
+
+	case telnetDONT:
+		if sess.will[opt] {
+			sess.will[opt] = false
+			reply = telnetWONT
+		}
+	}
+	s.mu.Unlock()
+
+	if reply != 0 {
+		sess.send([]byte{telnetIAC, reply, opt})
+	}
+}
+
+// comPortCommand carries out a COM-PORT-OPTION command from the client and
+// replies to it. Observers' commands are treated as queries.
+func (sess *rfc2217Session) comPortCommand(cmd byte, value []byte) {
+	s := sess.s
+	control := !sess.observer
+
+	switch cmd {
+	case cpoSignature:
+		if len(value) == 0 {
+			sig := s.Signature
+			if sig == "" {
+				sig = "go-serial " + s.Port.String()
+			}
+			sess.reply(cmd, []byte(sig)...)
+		}
+
+	case cpoSetBaudRate:
+		if len(value) != 4 {
+			return
+		}
+
+		baud := uint(binary.BigEndian.Uint32(value))
+		if baud != 0 && control {
+			s.warn("setting baud rate", s.Port.SetBaudRate(baud))
+		}
+
+		if actual, err := s.Port.BaudRate(); err == nil {
+			baud = actual
+		}
+		sess.reply(cmd, binary.BigEndian.AppendUint32(nil, uint32(baud))...)
+
+	case cpoSetDataSize, cpoSetParity, cpoSetStopSize:
+		if len(value) != 1 {
+			return
+		}
+		sess.reply(cmd, sess.setMode(cmd, value[0], control))
+
+	case cpoSetControl:
+		if len(value) != 1 {
+			return
+		}
+		sess.reply(cmd, sess.setControl(value[0], control))
+
+	case cpoFlowSuspend, cpoFlowResume:
+		if !control {
+			return
+		}
+
+		suspend := cmd == cpoFlowSuspend
+		if suspend {
+			s.warn("pausing", s.Port.Pause())
+		} else {
+			s.warn("resuming", s.Port.Resume())
+		}
+
+		s.mu.Lock()
+		s.paused = suspend
+		s.mu.Unlock()
+
+	case cpoSetLineStateMask, cpoSetModemStateMask:
+		if len(value) != 1 {
+			return
+		}
+
+		s.mu.Lock()
+		if cmd == cpoSetLineStateMask {
+			sess.lineMask = value[0]
+		} else {
+			sess.modemMask = value[0]
+		}
+		s.mu.Unlock()
+
+		sess.reply(cmd, value[0])
+
+	case cpoPurgeData:
+		if len(value) != 1 {
+			return
+		}
+
+		// Output can't be discarded once written, so only input is.
+		if control && (value[0] == cpoPurgeReceive || value[0] == cpoPurgeBoth) {
+			s.warn("purging", s.Port.Quiesce())
+		}
+		sess.reply(cmd, value[0])
+	}
+}
+
+// setMode applies a SET-DATASIZE, SET-PARITY or SET-STOPSIZE request,
+// returning the setting as it now is. Zero is a query, as are values the
+// port can't represent, such as mark parity and 1.5 stop bits.
+func (sess *rfc2217Session) setMode(cmd, value byte, control bool) byte {
+	s := sess.s
+
+	options, err := s.Port.CurrentOptions()
+	if err != nil {
+		s.warn("reading settings", err)
+		return value
+	}
+
+	if value != 0 && control {
+		changed := options
+		switch cmd {
+		case cpoSetDataSize:
+			changed.DataBits = uint(value)
+		case cpoSetParity:
+			switch value {
+			case cpoParityNone:
+				changed.ParityMode = PARITY_NONE
+			case cpoParityOdd:
+				changed.ParityMode = PARITY_ODD
+			case cpoParityEven:
+				changed.ParityMode = PARITY_EVEN
+			}
+		case cpoSetStopSize:
+			if value == 1 || value == 2 {
+				changed.StopBits = uint(value)
+			}
+		}
+
+		if changed.DataBits != options.DataBits || changed.ParityMode != options.ParityMode || changed.StopBits != options.StopBits {
+			err := s.Port.SetMode(changed.DataBits, changed.ParityMode, changed.StopBits, changed.RTSCTSFlowControl)
+			if err == nil {
+				options = changed
+			}
+			s.warn("setting mode", err)
+		}
+	}
+
+	switch cmd {
+	case cpoSetDataSize:
+		return byte(options.DataBits)
+	case cpoSetParity:
+		return []byte{cpoParityNone, cpoParityOdd, cpoParityEven}[options.ParityMode]
+	default:
+		return byte(options.StopBits)
+	}
+}
+
+// setControl applies a SET-CONTROL request, returning the state it now
+// reports.
+func (sess *rfc2217Session) setControl(value byte, control bool) byte {
+	s := sess.s
+
+	// The line, if any, that value sets, and the values reporting it on
+	// and off.
+	var line *bool
+	var on, off byte
+	var set func(bool) error
+
+	switch value {
+	case cpoControlQueryFlow, cpoControlFlowNone, cpoControlFlowXonXoff, cpoControlFlowHW:
+		options, err := s.Port.CurrentOptions()
+		if err != nil {
+			s.warn("reading settings", err)
+			return value
+		}
+
+		rtscts := value == cpoControlFlowHW
+		if control && (value == cpoControlFlowNone || value == cpoControlFlowHW) && rtscts != options.RTSCTSFlowControl {
+			err := s.Port.SetMode(options.DataBits, options.ParityMode, options.StopBits, rtscts)
+			if err == nil {
+				options.RTSCTSFlowControl = rtscts
+			}
+			s.warn("setting flow control", err)
+		}
+
+		if options.RTSCTSFlowControl {
+			return cpoControlFlowHW
+		}
+		return cpoControlFlowNone
+
+	case cpoControlQueryBreak, cpoControlBreakOn, cpoControlBreakOff:
+		line, on, off, set = &s.brk, cpoControlBreakOn, cpoControlBreakOff, s.Port.SetBreak
+
+	case cpoControlQueryDTR, cpoControlDTROn, cpoControlDTROff:
+		line, on, off, set = &s.dtr, cpoControlDTROn, cpoControlDTROff, s.Port.SetDTR
+
+	case cpoControlQueryRTS, cpoControlRTSOn, cpoControlRTSOff:
+		line, on, off, set = &s.rts, cpoControlRTSOn, cpoControlRTSOff, s.Port.SetRTS
+
+	default:
+		// Inbound flow control isn't supported.
+		return cpoControlInboundNone
+	}
+
+	if control && (value == on || value == off) {
+		err := set(value == on)
+		if err == nil {
+			s.mu.Lock()
+			*line = value == on
+			s.mu.Unlock()
+		}
+		s.warn("setting control lines", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if *line {
+		return on
+	}
+	return off
+}
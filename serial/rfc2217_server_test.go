@@ -0,0 +1,352 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startRFC2217Server serves a on a local address, returning the server and
+// the address.
+func startRFC2217Server(t *testing.T, a Port, allowObservers bool) (*RFC2217Server, string, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &RFC2217Server{Port: a, AllowObservers: allowObservers}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	t.Cleanup(func() { s.Close() })
+
+	return s, ln.Addr().String(), served
+}
+
+// readFull reads len(want) bytes from p and checks them.
+func readFull(t *testing.T, p Port, want []byte) {
+	t.Helper()
+
+	p.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer p.SetReadDeadline(time.Time{})
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(p, got); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestRFC2217Server(t *testing.T) {
+	defer func(d time.Duration) { rfc2217PollInterval = d }(rfc2217PollInterval)
+	rfc2217PollInterval = 10 * time.Millisecond
+
+	a, b := Pipe()
+	defer b.Close()
+	defer a.Close()
+
+	var mu sync.Mutex
+	var ops []string
+	a.InjectErrors(func(op string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ops = append(ops, op)
+		return nil
+	})
+
+	s, addr, served := startRFC2217Server(t, a, false)
+
+	c, err := DialRFC2217(OpenOptions{
+		PortName:        addr,
+		BaudRate:        9600,
+		DataBits:        7,
+		ParityMode:      PARITY_EVEN,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The settings were applied to the local port.
+	options, err := a.CurrentOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.BaudRate != 9600 || options.DataBits != 7 || options.ParityMode != PARITY_EVEN || options.StopBits != 1 {
+		t.Errorf("local settings: got %d %d %v %d", options.BaudRate, options.DataBits, options.ParityMode, options.StopBits)
+	}
+
+	// Data flows both ways, IAC included.
+	data := []byte{'a', telnetIAC, 'b', 0}
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, b, data)
+
+	if _, err := b.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, c, data)
+
+	// Control lines are set on the local port.
+	if err := c.SetBreak(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBreak(false); err != nil {
+		t.Fatal(err)
+	}
+	if counters, _ := b.ErrorCounters(); counters.Break != 1 {
+		t.Errorf("breaks: got %d, want 1", counters.Break)
+	}
+
+	if err := c.SetDTR(false); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	found := false
+	for _, op := range ops {
+		found = found || op == "SetDTR"
+	}
+	mu.Unlock()
+	if !found {
+		t.Error("SetDTR wasn't called on the local port")
+	}
+
+	// Line errors are reported to the client.
+	a.SetErrorCounters(ErrorCounters{Parity: 1})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counters, err := c.ErrorCounters()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if counters.Parity == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("client's parity errors: got %d, want 1", counters.Parity)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Only one client may connect at a time.
+	if _, err := DialRFC2217(OpenOptions{PortName: addr, BaudRate: 9600, DataBits: 8, StopBits: 1}); err == nil {
+		t.Error("second client connected")
+	}
+
+	// Once the client disconnects, the next may connect, and the local port
+	// is still open.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = dialRFC2217Retrying(t, OpenOptions{PortName: addr, BaudRate: 19200, DataBits: 8, StopBits: 1, MinimumReadSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, b, []byte("again"))
+
+	if baud, _ := a.BaudRate(); baud != 19200 {
+		t.Errorf("local baud rate: got %d, want 19200", baud)
+	}
+
+	// Closing the server disconnects the client and leaves the port open.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+
+	if _, err := b.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, a, []byte("x"))
+}
+
+// dialRFC2217Retrying dials until the server has noticed the previous
+// client disconnect.
+func dialRFC2217Retrying(t *testing.T, options OpenOptions) (*RFC2217Port, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := DialRFC2217(options)
+		if err == nil || time.Now().After(deadline) {
+			return c, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRFC2217ServerObservers(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+	defer a.Close()
+
+	_, addr, _ := startRFC2217Server(t, a, true)
+
+	c, err := DialRFC2217(OpenOptions{PortName: addr, BaudRate: 9600, DataBits: 8, StopBits: 1, MinimumReadSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	o, err := DialRFC2217(OpenOptions{PortName: addr, BaudRate: 115200, DataBits: 8, StopBits: 1, MinimumReadSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	// The observer's settings had no effect, and it is told the real ones.
+	if baud, _ := a.BaudRate(); baud != 9600 {
+		t.Errorf("local baud rate: got %d, want 9600", baud)
+	}
+
+	if baud, err := o.BaudRate(); err != nil || baud != 9600 {
+		t.Errorf("observer's baud rate: got %d, %v; want 9600", baud, err)
+	}
+
+	// Both clients receive data, but only the controller's is sent.
+	if _, err := b.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, c, []byte("hello"))
+	readFull(t, o, []byte("hello"))
+
+	if _, err := o.Write([]byte("ignored")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("sent")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, b, []byte("sent"))
+
+	b.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _ := b.Read(make([]byte, 16)); n != 0 {
+		t.Errorf("read %d more bytes; want none", n)
+	}
+}
+
+// serveRFC2217 serves s on a local address, returning the address.
+func serveRFC2217(t *testing.T, s *RFC2217Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	return ln.Addr().String()
+}
+
+func TestRFC2217ServerRaw(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+	defer a.Close()
+
+	addr := serveRFC2217(t, &RFC2217Server{Port: a, Raw: true})
+
+	c, err := OpenTCP(addr, TCPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Data flows both ways unescaped, with no negotiation.
+	data := []byte{'a', telnetIAC, telnetDO, 'b', 0}
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, b, data)
+
+	if _, err := b.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, c, data)
+}
+
+func TestRFC2217ServerIdleTimeout(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+	defer a.Close()
+
+	addr := serveRFC2217(t, &RFC2217Server{Port: a, Raw: true, IdleTimeout: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Sending keeps the client connected.
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := conn.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFull(t, b, []byte("xxx"))
+
+	// Then it is disconnected once idle.
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("expected the server to disconnect, got %v", err)
+	}
+
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("disconnected after %v", d)
+	}
+
+	// The port is free for the next client, once the server has noticed.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		next, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer next.Close()
+
+		next.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := next.Read(make([]byte, 1)); errors.Is(err, os.ErrDeadlineExceeded) {
+			next.Write([]byte("y"))
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the next client was turned away")
+		}
+	}
+	readFull(t, b, []byte("y"))
+}
@@ -0,0 +1,340 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRFC2217Server is an RFC 2217 server that echoes data back, confirms
+// every setting it is given and records them.
+type fakeRFC2217Server struct {
+	t  *testing.T
+	ln net.Listener
+
+	// How to answer the COM port option: DO, DONT or nothing at all.
+	answer byte
+
+	mu       sync.Mutex
+	conn     net.Conn
+	settings map[byte][]byte // the latest value of each SET command
+	controls []byte          // the SET-CONTROL values received
+}
+
+func newFakeRFC2217Server(t *testing.T, answer byte) *fakeRFC2217Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeRFC2217Server{
+		t:        t,
+		ln:       ln,
+		answer:   answer,
+		settings: map[byte][]byte{cpoSetBaudRate: {0, 0, 0x25, 0x80}},
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+
+	go s.serve()
+	return s
+}
+
+func (s *fakeRFC2217Server) addr() string {
+	return s.ln.Addr().String()
+}
+
+// send writes raw Telnet to the client.
+func (s *fakeRFC2217Server) send(b ...byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.Write(b)
+}
+
+// notify sends a COM-PORT-OPTION notification.
+func (s *fakeRFC2217Server) notify(cmd, value byte) {
+	s.send(telnetIAC, telnetSB, telnetComPort, cmd+cpoServerOffset, value, telnetIAC, telnetSE)
+}
+
+func (s *fakeRFC2217Server) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		if c != telnetIAC {
+			s.send(appendEscaped(nil, []byte{c})...)
+			continue
+		}
+
+		cmd, _ := r.ReadByte()
+		switch cmd {
+		case telnetIAC:
+			s.send(telnetIAC, telnetIAC)
+
+		case telnetWILL:
+			opt, _ := r.ReadByte()
+			if opt == telnetComPort && s.answer != 0 {
+				s.send(telnetIAC, s.answer, opt)
+			}
+
+		case telnetDO, telnetDONT, telnetWONT:
+			r.ReadByte()
+
+		case telnetSB:
+			var sb []byte
+			for {
+				c, _ := r.ReadByte()
+				if c == telnetIAC {
+					if c, _ = r.ReadByte(); c == telnetSE {
+						break
+					}
+				}
+				sb = append(sb, c)
+			}
+			s.subnegotiation(sb[1], sb[2:])
+		}
+	}
+}
+
+// subnegotiation records a command and confirms it, answering queries with
+// the current setting.
+func (s *fakeRFC2217Server) subnegotiation(cmd byte, value []byte) {
+	s.mu.Lock()
+	query := true
+	for _, c := range value {
+		query = query && c == 0
+	}
+
+	switch {
+	case cmd == cpoSetControl && value[0] != cpoControlQueryFlow:
+		s.controls = append(s.controls, value[0])
+		if value[0] == cpoControlFlowHW || value[0] == cpoControlFlowNone {
+			s.settings[cmd] = value
+		}
+
+	case !query:
+		s.settings[cmd] = value
+	}
+
+	if query && s.settings[cmd] != nil {
+		value = s.settings[cmd]
+	}
+	s.mu.Unlock()
+
+	b := []byte{telnetIAC, telnetSB, telnetComPort, cmd + cpoServerOffset}
+	b = appendEscaped(b, value)
+	s.send(append(b, telnetIAC, telnetSE)...)
+}
+
+func (s *fakeRFC2217Server) setting(cmd byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.settings[cmd]
+}
+
+func TestRFC2217(t *testing.T) {
+	s := newFakeRFC2217Server(t, telnetDO)
+
+	p, err := DialRFC2217(OpenOptions{
+		PortName:        s.addr(),
+		BaudRate:        9600,
+		DataBits:        7,
+		ParityMode:      PARITY_EVEN,
+		StopBits:        2,
+		MinimumReadSize: 1,
+		InitialDTR:      LINE_DEASSERT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var port Port = p
+	if got, want := port.String(), "rfc2217://"+s.addr(); got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	// The settings were sent to the server, and are read back from it.
+	if got := binary.BigEndian.Uint32(s.setting(cpoSetBaudRate)); got != 9600 {
+		t.Errorf("server baud rate: got %d, want 9600", got)
+	}
+
+	options, err := port.CurrentOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := FormatMode(options), "9600 7E2"; got != want {
+		t.Errorf("CurrentOptions: got %q, want %q", got, want)
+	}
+
+	if err := port.SetBaudRate(115200); err != nil {
+		t.Fatal(err)
+	}
+
+	if baud, err := port.BaudRate(); err != nil || baud != 115200 {
+		t.Errorf("BaudRate: got %d, %v, want 115200", baud, err)
+	}
+
+	// IAC bytes are escaped on the way out and back.
+	want := "a\xffb\xff\xff"
+	if n, err := port.Write([]byte(want)); n != len(want) || err != nil {
+		t.Fatalf("Write: got %d, %v", n, err)
+	}
+
+	var got []byte
+	buf := make([]byte, 16)
+	for len(got) < len(want) {
+		n, err := port.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+
+	if string(got) != want {
+		t.Errorf("echo: got %q, want %q", got, want)
+	}
+
+	// Modem lines and break.
+	if err := port.SetRTS(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SendBreak(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	controls := string(s.controls)
+	s.mu.Unlock()
+
+	wantControls := string([]byte{
+		cpoControlFlowNone, cpoControlDTROff, cpoControlRTSOn, cpoControlBreakOn, cpoControlBreakOff})
+	if controls != wantControls {
+		t.Errorf("SET-CONTROL values: got % x, want % x", controls, wantControls)
+	}
+
+	// Notifications from the server.
+	s.notify(cpoNotifyLineState, cpoLineParity|cpoLineOverrun)
+	s.notify(cpoNotifyModemState, cpoModemCTS|cpoModemDCD)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c, err := port.ErrorCounters()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dump, _ := port.DumpSettings()
+		if c == (ErrorCounters{Parity: 1, Overrun: 1}) && strings.HasSuffix(dump, "CTS=1 DSR=0 DCD=1 RI=0") {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("notifications not seen: %+v, %q", c, dump)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Deadlines.
+	port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := port.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read past the deadline: got %v", err)
+	}
+
+	if err := port.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := port.Write([]byte("x")); err != ErrPortClosed {
+		t.Errorf("Write after Close: got %v, want ErrPortClosed", err)
+	}
+}
+
+func TestRFC2217ReadTimeout(t *testing.T) {
+	s := newFakeRFC2217Server(t, telnetDO)
+
+	options, err := portOptions(s.addr(), []Option{WithReadTimeout(100 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := DialRFC2217(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	start := time.Now()
+	if _, err := p.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read: got %v, want io.EOF", err)
+	}
+
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Read returned after %v", d)
+	}
+}
+
+func TestRFC2217Unsupported(t *testing.T) {
+	defer func(d time.Duration) { rfc2217Timeout = d }(rfc2217Timeout)
+	rfc2217Timeout = 100 * time.Millisecond
+
+	// A server that refuses the option, and a plain TCP server that never
+	// answers.
+	for _, answer := range []byte{telnetDONT, 0} {
+		s := newFakeRFC2217Server(t, answer)
+
+		_, err := DialRFC2217(OpenOptions{
+			PortName:        s.addr(),
+			BaudRate:        9600,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+		})
+		if !errors.Is(err, ErrRFC2217Unsupported) {
+			t.Errorf("answer %d: got %v, want ErrRFC2217Unsupported", answer, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// Selector waits for input on many ports at once, so that a program serving
+// a dozen ports can do so from one goroutine rather than one per port. It
+// works with ports opened by Open and OpenFd on POSIX systems, using
+// poll(2). The zero value is an empty Selector ready for use.
+type Selector struct {
+	mu    sync.Mutex
+	ports []Port
+}
+
+// Add adds port to the set that Wait watches. It returns ErrNotSupported
+// for ports that can't be polled, such as those on Windows and PipePorts.
+// Adding a port twice has no further effect.
+func (s *Selector) Add(port Port) error {
+	if !pollable(port) {
+		return ErrNotSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.ports {
+		if p == port {
+			return nil
+		}
+	}
+
+	s.ports = append(s.ports, port)
+	return nil
+}
+
+// Remove removes port from the set that Wait watches. Ports should be
+// removed once closed, since Wait reports a closed port as ready.
+func (s *Selector) Remove(port Port) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, p := range s.ports {
+		if p == port {
+			s.ports = append(s.ports[:i:i], s.ports[i+1:]...)
+			return
+		}
+	}
+}
+
+// Wait waits until at least one of the ports has data to be read or the
+// timeout elapses, and returns the ports that are ready, in the order they
+// were added. A Read on one of them won't block, though it may fail, for
+// example with ErrPortClosed or ErrDeviceRemoved. On timeout Wait returns
+// no ports and a nil error. A negative timeout waits indefinitely, unless
+// there are no ports to wait for, in which case Wait returns at once.
+//
+// Ports added or removed while Wait is blocked, or closed meanwhile, are
+// only taken into account by the next call.
+func (s *Selector) Wait(timeout time.Duration) ([]Port, error) {
+	s.mu.Lock()
+	ports := append([]Port(nil), s.ports...)
+	s.mu.Unlock()
+
+	return pollPorts(ports, timeout)
+}
@@ -0,0 +1,33 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !linux && !solaris
+
+package serial
+
+import "time"
+
+// pollable reports whether a Selector can watch port, which it can't on
+// this system.
+func pollable(port Port) bool {
+	return false
+}
+
+func pollPorts(ports []Port, timeout time.Duration) ([]Port, error) {
+	if len(ports) == 0 && timeout >= 0 {
+		time.Sleep(timeout)
+	}
+
+	return nil, nil
+}
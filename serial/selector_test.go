@@ -0,0 +1,91 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSelector(t *testing.T) {
+	var s Selector
+	var masters []*os.File
+	var ports []Port
+
+	for i := 0; i < 3; i++ {
+		master, name := openPty(t)
+
+		port, err := Open(OpenOptions{
+			PortName:        name,
+			BaudRate:        115200,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer port.Close()
+
+		if err := s.Add(port); err != nil {
+			t.Fatal(err)
+		}
+
+		masters = append(masters, master)
+		ports = append(ports, port)
+	}
+
+	if ready, err := s.Wait(10 * time.Millisecond); len(ready) != 0 || err != nil {
+		t.Fatalf("Wait with nothing to read: %v, %v", ready, err)
+	}
+
+	masters[1].Write([]byte("x"))
+
+	ready, err := s.Wait(time.Second)
+	if err != nil || len(ready) != 1 || ready[0] != ports[1] {
+		t.Fatalf("Wait: got %v, %v; want the second port", ready, err)
+	}
+
+	// A closed port is ready, since Read won't block, until it's removed.
+	ports[1].Read(make([]byte, 1))
+	ports[2].Close()
+
+	if ready, err := s.Wait(time.Second); err != nil || len(ready) != 1 || ready[0] != ports[2] {
+		t.Fatalf("Wait after Close: got %v, %v; want the third port", ready, err)
+	}
+
+	s.Remove(ports[2])
+	if ready, err := s.Wait(10 * time.Millisecond); len(ready) != 0 || err != nil {
+		t.Fatalf("Wait after Remove: %v, %v", ready, err)
+	}
+}
+
+func TestSelectorUnsupported(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var s Selector
+	if err := s.Add(a); err != ErrNotSupported {
+		t.Errorf("Add: got %v, want ErrNotSupported", err)
+	}
+
+	if ready, err := s.Wait(-1); ready != nil || err != nil {
+		t.Errorf("Wait with no ports: %v, %v", ready, err)
+	}
+}
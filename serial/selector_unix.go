@@ -0,0 +1,111 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux || solaris
+
+package serial
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollable reports whether a Selector can watch port.
+func pollable(port Port) bool {
+	_, ok := port.(*unixPort)
+	return ok
+}
+
+// pollPorts implements Selector.Wait. Input already in a port's read buffer
+// counts as ready without asking the kernel.
+func pollPorts(ports []Port, timeout time.Duration) ([]Port, error) {
+	var ready []Port
+	for _, port := range ports {
+		p := port.(*unixPort)
+		if p.buffered() > 0 || atomic.LoadInt32(&p.closed) != 0 {
+			ready = append(ready, port)
+		}
+	}
+
+	if len(ready) > 0 || len(ports) == 0 && timeout < 0 {
+		return ready, nil
+	}
+
+	ms := -1
+	if timeout >= 0 {
+		ms = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	fds := make([]unix.PollFd, len(ports))
+	err := withDescriptors(ports, fds, func() error {
+		for {
+			_, err := unix.Poll(fds, ms)
+			if err == unix.EINTR {
+				continue
+			}
+
+			if err != nil {
+				return os.NewSyscallError("poll", err)
+			}
+
+			return nil
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A port closed before its descriptor could be had is left out of the
+	// poll with a negative descriptor, and reported as ready.
+	for i, fd := range fds {
+		if fd.Fd < 0 || fd.Revents != 0 {
+			ready = append(ready, ports[i])
+		}
+	}
+
+	return ready, nil
+}
+
+// withDescriptors fills in fds with the descriptors of ports and calls fn,
+// holding every descriptor so that none can be closed and reused until fn
+// returns. Close doesn't wait for that.
+func withDescriptors(ports []Port, fds []unix.PollFd, fn func() error) error {
+	if len(ports) == 0 {
+		return fn()
+	}
+
+	fds[0] = unix.PollFd{Fd: -1, Events: unix.POLLIN}
+	rest := func() error { return withDescriptors(ports[1:], fds[1:], fn) }
+
+	rc, err := ports[0].(*unixPort).f.SyscallConn()
+	if err != nil {
+		return rest()
+	}
+
+	var fnErr error
+	err = rc.Control(func(fd uintptr) {
+		fds[0].Fd = int32(fd)
+		fnErr = rest()
+	})
+
+	if err != nil {
+		return rest()
+	}
+
+	return fnErr
+}
@@ -13,15 +13,144 @@
 // limitations under the License.
 
 // Package serial provides routines for interacting with serial ports.
-// Currently it supports only OS X; see the readme file for details.
+// See the readme file for the supported systems.
 
 package serial
 
 import (
+	"errors"
 	"io"
 	"math"
+	"os"
+	"sync/atomic"
 )
 
+// Errors returned by the package, usually wrapped with more detail. Check
+// for them with errors.Is.
+var (
+	// ErrPortClosed is returned by Read and Write once the port has been
+	// closed, including by calls that were already blocked when Close was
+	// called.
+	ErrPortClosed = errors.New("serial: port closed")
+
+	// ErrNotSupported is returned by Port methods that have no equivalent on
+	// the current platform.
+	ErrNotSupported = errors.New("serial: not supported on this platform")
+
+	// ErrInvalidBaudRate is matched by errors from Validate, Open, OpenFd
+	// and SetBaudRate for a baud rate that is zero or that the platform or
+	// driver can't do.
+	ErrInvalidBaudRate = errors.New("serial: invalid baud rate")
+
+	// ErrInvalidDataBits is matched by errors from Validate, Open, OpenFd
+	// and SetMode for a number of data bits other than 5 to 8.
+	ErrInvalidDataBits = errors.New("serial: invalid data bits")
+
+	// ErrPortBusy is matched by errors from Open when another program has
+	// the port open exclusively. On Windows, where ports are always opened
+	// exclusively, that is reported as access being denied, so such errors
+	// match os.ErrPermission too.
+	ErrPortBusy = errors.New("serial: port busy")
+
+	// ErrDeviceRemoved is matched by errors from Read, Write and the other
+	// Port methods once the device has gone away, typically because a USB
+	// adapter was unplugged. The port should be closed.
+	ErrDeviceRemoved = errors.New("serial: device removed")
+
+	// ErrUnsupportedPlatform is returned by Open, OpenFd and ListPorts on
+	// systems the package doesn't support.
+	ErrUnsupportedPlatform = errors.New("serial: unsupported platform")
+)
+
+// errRTSFlowControl is returned by SetRTS and WriteWithRTS while the driver
+// is driving RTS for RTS/CTS flow control.
+var errRTSFlowControl = errors.New("serial: RTS is in use for RTS/CTS flow control")
+
+// PortError records an error from Open or a Port method along with the
+// device it concerns and, for some errors from Open, the step that failed.
+// The underlying error, often a syscall.Errno, can be examined with
+// errors.Is and errors.As. Errors from reading and writing are usually an
+// *os.PathError, which names the device already, and io.EOF,
+// ErrPortClosed and ErrNotSupported are returned as they are.
+//
+// A *PortError implements net.Error, so that timeouts can be recognised
+// without reference to this package. Ports opened by Open report deadlines
+// that pass as a *PortError wrapping os.ErrDeadlineExceeded.
+type PortError struct {
+	Port string
+	Op   string // such as "configuring" or "read"; may be empty
+	Err  error
+}
+
+func (e *PortError) Error() string {
+	if e.Op == "" {
+		return e.Port + ": " + e.Err.Error()
+	}
+
+	return e.Port + ": " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *PortError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the error is a timeout.
+func (e *PortError) Timeout() bool {
+	var t interface{ Timeout() bool }
+	return errors.As(e.Err, &t) && t.Timeout()
+}
+
+// Temporary is the same as Timeout. It is deprecated in net.Error, but
+// needed to implement it.
+func (e *PortError) Temporary() bool {
+	return e.Timeout()
+}
+
+// newPortError wraps err in a *PortError for port, unless it is nil, one of
+// the errors returned as they are, or already names the port.
+func newPortError(port, op string, err error) error {
+	var portErr *PortError
+	var pathErr *os.PathError
+
+	switch {
+	case err == nil, err == io.EOF, err == ErrPortClosed, err == ErrNotSupported:
+		return err
+
+	case errors.As(err, &portErr):
+		return err
+
+	case op == "" && errors.As(err, &pathErr) && pathErr.Path == port:
+		// An *os.PathError doesn't implement net.Error, so timeouts are
+		// rewrapped.
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return &PortError{Port: port, Op: pathErr.Op, Err: pathErr.Err}
+		}
+
+		return err
+	}
+
+	return &PortError{Port: port, Op: op, Err: err}
+}
+
+// markedError is an error that also matches a sentinel error, leaving its
+// message unchanged.
+type markedError struct {
+	err, sentinel error
+}
+
+// markError returns err, made to match sentinel with errors.Is.
+func markError(err, sentinel error) error {
+	return &markedError{err, sentinel}
+}
+
+func (e *markedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *markedError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
+
 // Valid parity values.
 type ParityMode int
 
@@ -31,6 +160,18 @@ const (
 	PARITY_EVEN ParityMode = 2
 )
 
+// States for a modem control line when the port is opened.
+type LineState int
+
+const (
+	// Leave the line as the driver set it. Most drivers raise DTR and RTS
+	// when the port is opened.
+	LINE_LEAVE LineState = 0
+
+	LINE_ASSERT   LineState = 1
+	LINE_DEASSERT LineState = 2
+)
+
 var (
 	// The list of standard baud-rates.
 	StandardBaudRates = map[uint]bool{
@@ -65,104 +206,161 @@ var (
 // additional IOCTL.
 func IsStandardBaudRate(baudRate uint) bool { return StandardBaudRates[baudRate] }
 
-// OpenOptions is the struct containing all of the options necessary for
-// opening a serial port.
-type OpenOptions struct {
-	// The name of the port, e.g. "/dev/tty.usbserial-A8008HlV".
-	PortName string
-
-	// The baud rate for the port.
-	BaudRate uint
-
-	// The number of data bits per frame. Legal values are 5, 6, 7, and 8.
-	DataBits uint
-
-	// The number of stop bits per frame. Legal values are 1 and 2.
-	StopBits uint
-
-	// The type of parity bits to use for the connection. Currently parity errors
-	// are simply ignored; that is, bytes are delivered to the user no matter
-	// whether they were received with a parity error or not.
-	ParityMode ParityMode
-
-	// Enable RTS/CTS (hardware) flow control.
-	RTSCTSFlowControl bool
-
-	// An inter-character timeout value, in milliseconds, and a minimum number of
-	// bytes to block for on each read. A call to Read() that otherwise may block
-	// waiting for more data will return immediately if the specified amount of
-	// time elapses between successive bytes received from the device or if the
-	// minimum number of bytes has been exceeded.
-	//
-	// Note that the inter-character timeout value may be rounded to the nearest
-	// 100 ms on some systems, and that behavior is undefined if calls to Read
-	// supply a buffer whose length is less than the minimum read size.
-	//
-	// Behaviors for various settings for these values are described below. For
-	// more information, see the discussion of VMIN and VTIME here:
-	//
-	//     http://www.unixwiz.net/techtips/termios-vmin-vtime.html
-	//
-	// InterCharacterTimeout = 0 and MinimumReadSize = 0 (the default):
-	//     This arrangement is not legal; you must explicitly set at least one of
-	//     these fields to a positive number. (If MinimumReadSize is zero then
-	//     InterCharacterTimeout must be at least 100.)
-	//
-	// InterCharacterTimeout > 0 and MinimumReadSize = 0
-	//     If data is already available on the read queue, it is transferred to
-	//     the caller's buffer and the Read() call returns immediately.
-	//     Otherwise, the call blocks until some data arrives or the
-	//     InterCharacterTimeout milliseconds elapse from the start of the call.
-	//     Note that in this configuration, InterCharacterTimeout must be at
-	//     least 100 ms.
-	//
-	// InterCharacterTimeout > 0 and MinimumReadSize > 0
-	//     Calls to Read() return when at least MinimumReadSize bytes are
-	//     available or when InterCharacterTimeout milliseconds elapse between
-	//     received bytes. The inter-character timer is not started until the
-	//     first byte arrives.
-	//
-	// InterCharacterTimeout = 0 and MinimumReadSize > 0
-	//     Calls to Read() return only when at least MinimumReadSize bytes are
-	//     available. The inter-character timer is not used.
-	//
-	// For windows usage, these options (termios) do not conform well to the
-	//     windows serial port / comms abstractions.  Please see the code in
-	//		 open_windows setCommTimeouts function for full documentation.
-	//   	 Summary:
-	//			Setting MinimumReadSize > 0 will cause the serialPort to block until
-	//			until data is available on the port.
-	//			Setting IntercharacterTimeout > 0 and MinimumReadSize == 0 will cause
-	//			the port to either wait until IntercharacterTimeout wait time is
-	//			exceeded OR there is character data to return from the port.
-	//
-
-	InterCharacterTimeout uint
-	MinimumReadSize       uint
-
-	// Use to enable RS485 mode -- probably only valid on some Linux platforms
-	Rs485Enable bool
-
-	// Set to true for logic level high during send
-	Rs485RtsHighDuringSend bool
-
-	// Set to true for logic level high after send
-	Rs485RtsHighAfterSend bool
-
-	// set to receive data during sending
-	Rs485RxDuringTx bool
-
-	// RTS delay before send
-	Rs485DelayRtsBeforeSend int
-
-	// RTS delay after send
-	Rs485DelayRtsAfterSend int
-}
-
-// Open creates an io.ReadWriteCloser based on the supplied options struct.
-func Open(options OpenOptions) (io.ReadWriteCloser, error) {
-	// Redirect to the OS-specific function.
-	return openInternal(options)
+// ErrorCounters holds the number of line errors the driver has seen since the
+// port was opened.
+type ErrorCounters struct {
+	// Characters received with a framing error (a missing stop bit).
+	Framing uint64
+
+	// Characters received with the wrong parity.
+	Parity uint64
+
+	// Characters lost because the UART's receive register or FIFO overflowed.
+	Overrun uint64
+
+	// Characters lost because the driver's input buffer was full.
+	BufferOverrun uint64
+
+	// Break conditions detected on the line.
+	Break uint64
+}
+
+// BufferSizes describes the buffers between a port and the line, in bytes.
+// Sizes the driver doesn't report are zero.
+type BufferSizes struct {
+	// The driver's queues of bytes received but not yet read and of bytes
+	// written but not yet transmitted. On Windows these are the queues
+	// OpenOptions.InputQueueSize and OutputQueueSize recommend sizes for.
+	// On Linux the input queue is the line discipline's fixed 4096-byte
+	// buffer, and the output queue isn't reported; nor is either on OS X
+	// and Solaris.
+	InputQueue  int
+	OutputQueue int
+
+	// The package's own buffer of input read ahead of the caller, as set by
+	// OpenOptions.ReadBufferSize.
+	ReadBuffer int
+}
+
+// PortStats counts a port's traffic since it was opened or the counts were
+// last reset with ResetStats.
+type PortStats struct {
+	// Bytes returned by Read and ReadWithTimestamp, and accepted by Write
+	// and WriteNineBit.
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// Calls to those methods that failed. The io.EOF reported when an
+	// inter-character or overall read timeout expires doesn't count, but
+	// passed deadlines do.
+	ReadErrors  uint64
+	WriteErrors uint64
+
+	// Reads that timed out: those ended by the read deadline, which count as
+	// errors too, and those that returned io.EOF because
+	// InterCharacterTimeout or OverallReadTimeout expired with nothing read.
+	ReadTimeouts uint64
+}
+
+// PortHooks are functions a port calls as it counts its traffic in
+// PortStats, for feeding metrics systems without wrapping the port. Any of
+// them may be nil. They are called synchronously by Read, Write and the
+// like, from whichever goroutines are making those calls, so they must be
+// quick and safe for concurrent use.
+type PortHooks struct {
+	// Called with the number of bytes each read or write transferred, if
+	// any.
+	OnRead  func(n int)
+	OnWrite func(n int)
+
+	// Called with each error counted as a ReadError or WriteError.
+	OnError func(err error)
+}
+
+// portCounters keeps a port's PortStats, updated atomically, and calls its
+// PortHooks. It must be the first field of the struct containing it, so
+// that the counters are 64-bit aligned on 32-bit platforms.
+type portCounters struct {
+	bytesRead, bytesWritten uint64
+	readErrors, writeErrors uint64
+	readTimeouts            uint64
+
+	hooks atomic.Value // PortHooks
+}
+
+// countRead records the outcome of a read. io.EOF is taken to mean that a
+// read timeout expired.
+func (c *portCounters) countRead(n int, err error) {
+	hooks := c.getHooks()
+
+	if n > 0 {
+		atomic.AddUint64(&c.bytesRead, uint64(n))
+		if hooks.OnRead != nil {
+			hooks.OnRead(n)
+		}
+	}
+
+	if err == nil {
+		return
+	}
+
+	if err == io.EOF || errors.Is(err, os.ErrDeadlineExceeded) {
+		atomic.AddUint64(&c.readTimeouts, 1)
+	}
+
+	if err != io.EOF {
+		atomic.AddUint64(&c.readErrors, 1)
+		if hooks.OnError != nil {
+			hooks.OnError(err)
+		}
+	}
+}
+
+// countWrite records the outcome of a write.
+func (c *portCounters) countWrite(n int, err error) {
+	hooks := c.getHooks()
+
+	if n > 0 {
+		atomic.AddUint64(&c.bytesWritten, uint64(n))
+		if hooks.OnWrite != nil {
+			hooks.OnWrite(n)
+		}
+	}
+
+	if err != nil {
+		atomic.AddUint64(&c.writeErrors, 1)
+		if hooks.OnError != nil {
+			hooks.OnError(err)
+		}
+	}
+}
+
+func (c *portCounters) stats() PortStats {
+	return PortStats{
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		ReadErrors:   atomic.LoadUint64(&c.readErrors),
+		WriteErrors:  atomic.LoadUint64(&c.writeErrors),
+		ReadTimeouts: atomic.LoadUint64(&c.readTimeouts),
+	}
+}
+
+// reset sets the counts back to zero, one at a time.
+func (c *portCounters) reset() {
+	atomic.StoreUint64(&c.bytesRead, 0)
+	atomic.StoreUint64(&c.bytesWritten, 0)
+	atomic.StoreUint64(&c.readErrors, 0)
+	atomic.StoreUint64(&c.writeErrors, 0)
+	atomic.StoreUint64(&c.readTimeouts, 0)
+}
+
+func (c *portCounters) setHooks(hooks PortHooks) {
+	c.hooks.Store(hooks)
+}
+
+func (c *portCounters) getHooks() PortHooks {
+	hooks, _ := c.hooks.Load().(PortHooks)
+	return hooks
 }
 
 // Rounds a float to the nearest integer.
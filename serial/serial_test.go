@@ -1,7 +1,13 @@
 package serial
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -48,3 +54,117 @@ func TestIsStandardBaudRate(t *testing.T) {
 		})
 	}
 }
+
+func TestSentinelErrors(t *testing.T) {
+	valid := OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+
+	noBaud := valid
+	noBaud.BaudRate = 0
+
+	nineBits := valid
+	nineBits.DataBits = 9
+
+	// Several problems at once still match each sentinel.
+	both := nineBits
+	both.BaudRate = 0
+	both.StopBits = 3
+
+	testCases := []struct {
+		name     string
+		err      error
+		sentinel error
+	}{
+		{"Validate baud", noBaud.Validate(), ErrInvalidBaudRate},
+		{"Validate data bits", nineBits.Validate(), ErrInvalidDataBits},
+		{"Validate both, baud", both.Validate(), ErrInvalidBaudRate},
+		{"Validate both, data bits", both.Validate(), ErrInvalidDataBits},
+		{"Open", func() error { _, err := Open(nineBits); return err }(), ErrInvalidDataBits},
+		{"busy", openError(&os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EBUSY}), ErrPortBusy},
+		{"busy errno", openError(&os.PathError{Op: "open", Path: "/dev/ttyUSB0", Err: syscall.EBUSY}), syscall.EBUSY},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !errors.Is(tc.err, tc.sentinel) {
+				t.Errorf("%v doesn't match %v", tc.err, tc.sentinel)
+			}
+		})
+	}
+
+	// Marking an error leaves its message alone.
+	if err := nineBits.Validate(); strings.Contains(err.Error(), ErrInvalidDataBits.Error()) {
+		t.Errorf("sentinel leaked into message: %q", err)
+	}
+}
+
+func TestPortError(t *testing.T) {
+	const port = "/dev/ttyUSB0"
+
+	err := newPortError(port, "setting modem lines", syscall.EIO)
+	if got, want := err.Error(), port+": setting modem lines: "+syscall.EIO.Error(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var portErr *PortError
+	if !errors.As(err, &portErr) || portErr.Port != port || portErr.Op != "setting modem lines" {
+		t.Errorf("errors.As(%v) gave %#v", err, portErr)
+	}
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("%v doesn't wrap EIO", err)
+	}
+
+	if got, want := newPortError(port, "", syscall.EIO).Error(), port+": "+syscall.EIO.Error(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Errors that already say enough, or that callers compare with ==, are
+	// returned as they are.
+	pathErr := &os.PathError{Op: "open", Path: port, Err: syscall.ENOENT}
+	for _, e := range []error{nil, io.EOF, ErrPortClosed, ErrNotSupported, err, pathErr} {
+		if got := newPortError(port, "", e); got != e {
+			t.Errorf("newPortError(%v) = %v, want it unchanged", e, got)
+		}
+	}
+}
+
+func TestPortErrorTimeout(t *testing.T) {
+	pathErr := &os.PathError{Op: "read", Path: "/dev/ttyUSB0", Err: os.ErrDeadlineExceeded}
+
+	testCases := []struct {
+		name    string
+		err     error
+		timeout bool
+	}{
+		{"deadline", newPortError("/dev/ttyUSB0", "read", os.ErrDeadlineExceeded), true},
+		{"path error", newPortError("/dev/ttyUSB0", "", pathErr), true},
+		{"errno", newPortError("/dev/ttyUSB0", "", syscall.EIO), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			netErr, ok := tc.err.(net.Error)
+			if !ok {
+				t.Fatalf("%#v isn't a net.Error", tc.err)
+			}
+
+			if netErr.Timeout() != tc.timeout {
+				t.Errorf("Timeout: got %t, want %t", netErr.Timeout(), tc.timeout)
+			}
+
+			if errors.Is(tc.err, os.ErrDeadlineExceeded) != tc.timeout {
+				t.Errorf("errors.Is(%v, os.ErrDeadlineExceeded) = %t", tc.err, !tc.timeout)
+			}
+		})
+	}
+
+	// The rewrapped path error keeps its operation, and names the port once.
+	if got, want := newPortError("/dev/ttyUSB0", "", pathErr).Error(), "/dev/ttyUSB0: read: i/o timeout"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serialtest
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// Faults says what a FlakyPort does to the traffic through it. The zero
+// value does nothing, and each field left at zero turns its fault off.
+type Faults struct {
+	// Seed seeds the random choices, so that a failure can be reproduced by
+	// running again with the same seed.
+	Seed int64
+
+	// DropRate is the probability, from 0 to 1, that a byte read is lost.
+	DropRate float64
+
+	// CorruptRate is the probability that a byte read or written has one of
+	// its bits flipped. The caller's buffer isn't changed by a Write.
+	CorruptRate float64
+
+	// If non-zero, MaxWriteChunk splits each Write into chunks of random
+	// sizes from 1 to MaxWriteChunk bytes, written one at a time.
+	MaxWriteChunk int
+
+	// TimeoutRate is the probability that a Read, or a chunk of a Write,
+	// fails with a timeout error like that for a passed deadline instead of
+	// transferring anything.
+	TimeoutRate float64
+
+	// If non-zero, the device is treated as unplugged once RemoveAfter bytes
+	// have been read and written in all. The call that reaches the limit
+	// transfers the bytes up to it, and from then on it and every Read and
+	// Write fail with an error matching serial.ErrDeviceRemoved.
+	RemoveAfter int
+}
+
+// FlakyPort is a serial.Port that passes everything through to another but
+// introduces faults into its reads and writes, to check that code copes
+// with an unreliable link. The faults are chosen at random from the seed,
+// with reads and writes drawing on separate sources, so a test that makes
+// the same calls in the same order sees the same faults each time.
+//
+// Only Read, ReadWithTimestamp and the writing methods are affected, so
+// WaitForData and BytesAvailable may report data that is then dropped.
+type FlakyPort struct {
+	serial.Port
+
+	faults Faults
+
+	rl    sync.Mutex
+	readR *rand.Rand
+
+	wl     sync.Mutex
+	writeR *rand.Rand
+
+	mu      sync.Mutex
+	count   int  // bytes transferred, for RemoveAfter
+	removed bool // RemoveAfter has been reached
+}
+
+// NewFlakyPort returns a FlakyPort wrapping port.
+func NewFlakyPort(port serial.Port, faults Faults) *FlakyPort {
+	return &FlakyPort{
+		Port:   port,
+		faults: faults,
+		readR:  rand.New(rand.NewSource(faults.Seed)),
+		writeR: rand.New(rand.NewSource(faults.Seed + 1)),
+	}
+}
+
+// Read implements io.Reader.
+func (p *FlakyPort) Read(b []byte) (int, error) {
+	n, _, err := p.read(b, func(b []byte) (int, time.Time, error) {
+		n, err := p.Port.Read(b)
+		return n, time.Time{}, err
+	})
+	return n, err
+}
+
+// ReadWithTimestamp implements serial.Port.
+func (p *FlakyPort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	return p.read(b, p.Port.ReadWithTimestamp)
+}
+
+// read reads with fn, dropping and corrupting bytes. If every byte read is
+// dropped it reads again, as if they had never arrived.
+func (p *FlakyPort) read(b []byte, fn func([]byte) (int, time.Time, error)) (int, time.Time, error) {
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	for {
+		if err := p.check("read"); err != nil {
+			return 0, time.Time{}, err
+		}
+
+		if happens(p.readR, p.faults.TimeoutRate) {
+			return 0, time.Time{}, p.timeout("read")
+		}
+
+		n, at, err := fn(b)
+
+		kept := 0
+		for _, c := range b[:n] {
+			if happens(p.readR, p.faults.DropRate) {
+				continue
+			}
+			if happens(p.readR, p.faults.CorruptRate) {
+				c ^= 1 << uint(p.readR.Intn(8))
+			}
+			b[kept] = c
+			kept++
+		}
+
+		kept, removeErr := p.transfer("read", kept)
+		if removeErr != nil {
+			return kept, at, removeErr
+		}
+
+		if kept > 0 || n == 0 || err != nil {
+			return kept, at, err
+		}
+	}
+}
+
+// Write implements io.Writer.
+func (p *FlakyPort) Write(b []byte) (int, error) {
+	return p.write("write", b, p.Port.Write)
+}
+
+// WriteNineBit implements serial.Port.
+func (p *FlakyPort) WriteNineBit(b []byte, address bool) (int, error) {
+	return p.write("write", b, func(b []byte) (int, error) {
+		return p.Port.WriteNineBit(b, address)
+	})
+}
+
+// WriteWithRTS implements serial.Port.
+func (p *FlakyPort) WriteWithRTS(b []byte) (int, error) {
+	return p.write("write", b, p.Port.WriteWithRTS)
+}
+
+// write writes b with fn, in chunks and with the faults chosen.
+func (p *FlakyPort) write(op string, b []byte, fn func([]byte) (int, error)) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if p.faults.CorruptRate > 0 {
+		corrupted := make([]byte, len(b))
+		for i, c := range b {
+			if happens(p.writeR, p.faults.CorruptRate) {
+				c ^= 1 << uint(p.writeR.Intn(8))
+			}
+			corrupted[i] = c
+		}
+		b = corrupted
+	}
+
+	written := 0
+	for {
+		if err := p.check(op); err != nil {
+			return written, err
+		}
+
+		if happens(p.writeR, p.faults.TimeoutRate) {
+			return written, p.timeout(op)
+		}
+
+		chunk := b[written:]
+		if max := p.faults.MaxWriteChunk; max > 0 && len(chunk) > 1 {
+			if size := 1 + p.writeR.Intn(max); size < len(chunk) {
+				chunk = chunk[:size]
+			}
+		}
+
+		chunk = chunk[:p.allowed(len(chunk))]
+
+		n, err := fn(chunk)
+		written += n
+
+		if _, removeErr := p.transfer(op, n); removeErr != nil {
+			return written, removeErr
+		}
+
+		if err != nil || written == len(b) {
+			return written, err
+		}
+	}
+}
+
+// happens reports whether an event with probability rate occurs, without
+// drawing on r when the rate is zero.
+func happens(r *rand.Rand, rate float64) bool {
+	return rate > 0 && r.Float64() < rate
+}
+
+// check returns the error for a removed device if RemoveAfter has been
+// reached.
+func (p *FlakyPort) check(op string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.removed {
+		return p.removedError(op)
+	}
+	return nil
+}
+
+// allowed returns how many of n bytes may be transferred before RemoveAfter
+// is reached.
+func (p *FlakyPort) allowed(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if limit := p.faults.RemoveAfter; limit > 0 && p.count+n > limit {
+		return limit - p.count
+	}
+	return n
+}
+
+// transfer counts n bytes towards RemoveAfter, returning how many of them
+// get through and, once the limit is reached, the error for a removed
+// device.
+func (p *FlakyPort) transfer(op string, n int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	limit := p.faults.RemoveAfter
+	if limit == 0 {
+		return n, nil
+	}
+
+	if p.count+n >= limit {
+		n = limit - p.count
+		p.count = limit
+		p.removed = true
+		return n, p.removedError(op)
+	}
+
+	p.count += n
+	return n, nil
+}
+
+func (p *FlakyPort) removedError(op string) error {
+	return &serial.PortError{Port: p.String(), Op: op, Err: serial.ErrDeviceRemoved}
+}
+
+func (p *FlakyPort) timeout(op string) error {
+	return &serial.PortError{Port: p.String(), Op: op, Err: os.ErrDeadlineExceeded}
+}
@@ -0,0 +1,178 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serialtest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// flakyRead sends data through a FlakyPort with the given faults and
+// returns what is read at the other end before the deadline.
+func flakyRead(t *testing.T, faults Faults, data []byte) []byte {
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := a.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var port serial.Port = NewFlakyPort(b, faults)
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	var got []byte
+	buf := make([]byte, 16)
+	for {
+		n, err := port.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			return got
+		}
+	}
+}
+
+func TestFlakyPortZeroFaults(t *testing.T) {
+	data := []byte("nothing happens to this")
+	if got := flakyRead(t, Faults{}, data); !bytes.Equal(got, data) {
+		t.Errorf("read %q", got)
+	}
+
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	failure := errors.New("unplugged")
+	a.InjectErrors(func(op string) error { return failure })
+
+	port := NewFlakyPort(a, Faults{Seed: 7})
+	if _, err := port.Write(data); err != failure {
+		t.Errorf("Write: got %v, want the error unchanged", err)
+	}
+}
+
+func TestFlakyPortReproducible(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 20)
+	faults := Faults{Seed: 42, DropRate: 0.2, CorruptRate: 0.1}
+
+	first := flakyRead(t, faults, data)
+	if len(first) == 0 || len(first) >= len(data) {
+		t.Fatalf("read %d of %d bytes", len(first), len(data))
+	}
+
+	if again := flakyRead(t, faults, data); !bytes.Equal(again, first) {
+		t.Errorf("same seed, different faults:\n%q\n%q", first, again)
+	}
+
+	faults.Seed++
+	if other := flakyRead(t, faults, data); bytes.Equal(other, first) {
+		t.Error("different seed, same faults")
+	}
+}
+
+func TestFlakyPortWrites(t *testing.T) {
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	writes := 0
+	a.InjectErrors(func(op string) error {
+		if op == "Write" {
+			writes++
+		}
+		return nil
+	})
+
+	data := bytes.Repeat([]byte("x"), 100)
+	port := NewFlakyPort(a, Faults{Seed: 1, MaxWriteChunk: 8, CorruptRate: 1})
+
+	if n, err := port.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+
+	if writes < 100/8 {
+		t.Errorf("written in %d chunks", writes)
+	}
+
+	if !bytes.Equal(data, bytes.Repeat([]byte("x"), 100)) {
+		t.Error("the caller's buffer was changed")
+	}
+
+	got := make([]byte, 100)
+	if _, err := io.ReadFull(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range got {
+		if diff := c ^ 'x'; diff == 0 || diff&(diff-1) != 0 {
+			t.Fatalf("byte %d is %#x, not 'x' with one bit flipped", i, c)
+		}
+	}
+}
+
+func TestFlakyPortTimeout(t *testing.T) {
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	port := NewFlakyPort(a, Faults{TimeoutRate: 1})
+
+	_, err := port.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: %v", err)
+	}
+
+	if n, err := port.Write([]byte("x")); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write: %d, %v", n, err)
+	}
+}
+
+func TestFlakyPortRemoveAfter(t *testing.T) {
+	a, b := serial.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	port := NewFlakyPort(a, Faults{RemoveAfter: 8})
+
+	b.Write([]byte("abc"))
+	buf := make([]byte, 8)
+	if n, err := port.Read(buf); n != 3 || err != nil {
+		t.Fatalf("Read: %d, %v", n, err)
+	}
+
+	if n, err := port.Write([]byte("0123456789")); n != 5 || !errors.Is(err, serial.ErrDeviceRemoved) {
+		t.Errorf("Write: %d, %v", n, err)
+	}
+
+	if n, _ := b.Read(buf); string(buf[:n]) != "01234" {
+		t.Errorf("the device received %q", buf[:n])
+	}
+
+	b.Write([]byte("more"))
+	if _, err := port.Read(buf); !errors.Is(err, serial.ErrDeviceRemoved) {
+		t.Errorf("Read after removal: %v", err)
+	}
+
+	if _, err := port.Write([]byte("x")); !errors.Is(err, serial.ErrDeviceRemoved) {
+		t.Errorf("Write after removal: %v", err)
+	}
+}
@@ -0,0 +1,807 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package serialtest provides serial.Ports for testing code that talks to a
+// device: MockPort, which plays the device from a script, and FlakyPort,
+// which makes the link to a device unreliable.
+package serialtest
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// Step is one entry in a MockPort's script. Steps are taken in order.
+type Step struct {
+	kind  stepKind
+	data  []byte
+	delay time.Duration
+	err   error
+}
+
+type stepKind int
+
+const (
+	expectStep stepKind = iota
+	respondStep
+	timeoutStep
+	failStep
+)
+
+// Expect is a step that consumes data written to the port. The data may be
+// written in any number of calls, and one call may cover several Expect
+// steps.
+func Expect(data ...byte) Step {
+	return Step{kind: expectStep, data: data}
+}
+
+// Respond is a step that makes data available to Read straight away.
+func Respond(data ...byte) Step {
+	return RespondAfter(0, data...)
+}
+
+// RespondAfter is a step that makes data available to Read once d has passed
+// since the previous step was completed.
+func RespondAfter(d time.Duration, data ...byte) Step {
+	return Step{kind: respondStep, data: data, delay: d}
+}
+
+// Timeout is a step that makes the next Read fail as though its deadline had
+// passed, once any data already available has been read.
+func Timeout() Step {
+	return Step{kind: timeoutStep}
+}
+
+// Fail is a step that makes the next Read or Write fail with err, for
+// example serial.ErrDeviceRemoved.
+func Fail(err error) Step {
+	return Step{kind: failStep, err: err}
+}
+
+func (s Step) String() string {
+	switch s.kind {
+	case expectStep:
+		return fmt.Sprintf("expect % x", s.data)
+	case respondStep:
+		return fmt.Sprintf("respond after %v with % x", s.delay, s.data)
+	case timeoutStep:
+		return "timeout"
+	default:
+		return fmt.Sprintf("fail with %v", s.err)
+	}
+}
+
+// MockPort is a serial.Port that plays the part of a device according to a
+// script, for unit tests of protocol code. Writes must match the script's
+// Expect steps; any that don't, and any steps left over when the test ends,
+// are reported as test failures. Read returns the data of the Respond steps
+// as it becomes due, honouring the read deadline, and fails where the script
+// says so.
+//
+// The other methods behave like those of a port with nothing attached:
+// settings are recorded for CurrentOptions, PulseDTR, PulseRTS and Quiesce
+// discard available input, and the calls made are listed by Calls.
+// WriteNineBit and WriteWithRTS are checked like Write, ignoring the ninth
+// bit and RTS. CarrierDetect reports the carrier as set by
+// SetCarrierDetect, asserted to begin with.
+type MockPort struct {
+	t    testing.TB
+	name string
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced when anything changes
+
+	script  []Step
+	next    int       // index of the current step
+	since   time.Time // when the current step became current
+	matched int       // bytes of the current Expect step written so far
+
+	pending     []byte // responded but not yet read
+	pendingTime time.Time
+
+	closed       bool
+	receiverOff  bool
+	noCarrier    bool
+	readDeadline time.Time
+	options      serial.OpenOptions
+	calls        []string
+	stats        serial.PortStats
+}
+
+// NewMockPort returns a MockPort named "mock", configured as 115200 8N1,
+// that follows script and reports problems to t.
+func NewMockPort(t testing.TB, script ...Step) *MockPort {
+	p := &MockPort{
+		t:       t,
+		name:    "mock",
+		changed: make(chan struct{}),
+		script:  script,
+		since:   time.Now(),
+		options: serial.OpenOptions{
+			PortName:        "mock",
+			BaudRate:        115200,
+			DataBits:        8,
+			StopBits:        1,
+			MinimumReadSize: 1,
+		},
+	}
+
+	t.Cleanup(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.next < len(p.script) {
+			p.t.Errorf("serialtest: script not finished; step %d (%v) not reached", p.next+1, p.script[p.next])
+		}
+	})
+
+	return p
+}
+
+// Calls returns the control methods called so far, such as "PulseDTR(10ms)".
+func (p *MockPort) Calls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.calls...)
+}
+
+// notify wakes blocked calls. The caller must hold mu.
+func (p *MockPort) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// advance moves on to the next step. The caller must hold mu.
+func (p *MockPort) advance() {
+	p.next++
+	p.since = time.Now()
+	p.matched = 0
+	p.notify()
+}
+
+// current returns the current step, or nil at the end of the script. The
+// caller must hold mu.
+func (p *MockPort) current() *Step {
+	if p.next < len(p.script) {
+		return &p.script[p.next]
+	}
+
+	return nil
+}
+
+// arrive takes the Respond steps that are due, returning the time at which
+// the next one will be, or the zero time if there isn't one. The caller must
+// hold mu.
+func (p *MockPort) arrive() time.Time {
+	for {
+		s := p.current()
+		if s == nil || s.kind != respondStep {
+			return time.Time{}
+		}
+
+		due := p.since.Add(s.delay)
+		if time.Now().Before(due) {
+			return due
+		}
+
+		if !p.receiverOff {
+			if len(p.pending) == 0 {
+				p.pendingTime = due
+			}
+			p.pending = append(p.pending, s.data...)
+		}
+
+		p.advance()
+	}
+}
+
+// wait releases mu until something changes or until is reached, returning
+// false in the latter case. The zero time means no limit.
+func (p *MockPort) wait(until time.Time) bool {
+	var timeout <-chan time.Time
+	if !until.IsZero() {
+		d := time.Until(until)
+		if d <= 0 {
+			return false
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	changed := p.changed
+	p.mu.Unlock()
+	defer p.mu.Lock()
+
+	select {
+	case <-changed:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// timeoutError is what a real port returns once its read deadline passes.
+func (p *MockPort) timeoutError() error {
+	return &serial.PortError{Port: p.name, Op: "read", Err: os.ErrDeadlineExceeded}
+}
+
+// Read implements io.Reader.
+func (p *MockPort) Read(b []byte) (int, error) {
+	n, _, err := p.ReadWithTimestamp(b)
+	return n, err
+}
+
+// ReadWithTimestamp implements serial.Port, giving the time the data became
+// due.
+func (p *MockPort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	p.mu.Lock()
+	n, at, err := p.read(b)
+	p.stats.BytesRead += uint64(n)
+	if err != nil {
+		p.stats.ReadErrors++
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		p.stats.ReadTimeouts++
+	}
+	hooks := p.options.Hooks
+	p.mu.Unlock()
+
+	callHooks(hooks.OnRead, hooks.OnError, n, err)
+	return n, at, err
+}
+
+func (p *MockPort) read(b []byte) (int, time.Time, error) {
+	if len(b) == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	for {
+		if p.closed {
+			return 0, time.Time{}, serial.ErrPortClosed
+		}
+
+		due := p.arrive()
+
+		if len(p.pending) > 0 {
+			n := copy(b, p.pending)
+			p.pending = p.pending[n:]
+			return n, p.pendingTime, nil
+		}
+
+		if s := p.current(); s != nil {
+			switch s.kind {
+			case timeoutStep:
+				p.advance()
+				return 0, time.Time{}, p.timeoutError()
+
+			case failStep:
+				p.advance()
+				return 0, time.Time{}, s.err
+			}
+		}
+
+		until := p.readDeadline
+		if !due.IsZero() && (until.IsZero() || due.Before(until)) {
+			until = due
+		}
+
+		if !p.wait(until) && until.Equal(p.readDeadline) {
+			return 0, time.Time{}, p.timeoutError()
+		}
+	}
+}
+
+// Write implements io.Writer, checking b against the script.
+func (p *MockPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	n, err := p.write(b)
+	p.stats.BytesWritten += uint64(n)
+	if err != nil {
+		p.stats.WriteErrors++
+	}
+	hooks := p.options.Hooks
+	p.mu.Unlock()
+
+	callHooks(hooks.OnWrite, hooks.OnError, n, err)
+	return n, err
+}
+
+// callHooks calls the hooks for a read or write that transferred n bytes and
+// failed with err, once the port is unlocked so that they can use it.
+func callHooks(onData func(int), onError func(error), n int, err error) {
+	if n > 0 && onData != nil {
+		onData(n)
+	}
+
+	if err != nil && onError != nil {
+		onError(err)
+	}
+}
+
+func (p *MockPort) write(b []byte) (int, error) {
+	if p.closed {
+		return 0, serial.ErrPortClosed
+	}
+
+	p.arrive()
+
+	if s := p.current(); s != nil && s.kind == failStep {
+		p.advance()
+		return 0, s.err
+	}
+
+	written := len(b)
+	for len(b) > 0 {
+		s := p.current()
+		if s == nil || s.kind != expectStep {
+			where := "at the end of the script"
+			if s != nil {
+				where = fmt.Sprintf("at step %d (%v)", p.next+1, s)
+			}
+
+			p.t.Errorf("serialtest: unexpected write of % x %s", b, where)
+			break
+		}
+
+		want := s.data[p.matched:]
+		got := b
+		if len(got) > len(want) {
+			got = got[:len(want)]
+		}
+
+		for i := range got {
+			if got[i] != want[i] {
+				p.t.Errorf(
+					"serialtest: step %d (%v): got % x, want % x (first difference at byte %d)",
+					p.next+1, s, got, want[:len(got)], p.matched+i)
+				break
+			}
+		}
+
+		b = b[len(got):]
+		p.matched += len(got)
+		if p.matched == len(s.data) {
+			p.advance()
+		}
+	}
+
+	return written, nil
+}
+
+// WriteNineBit implements serial.Port. The ninth bit isn't checked.
+func (p *MockPort) WriteNineBit(b []byte, address bool) (int, error) {
+	return p.Write(b)
+}
+
+// WriteWithRTS implements serial.Port.
+func (p *MockPort) WriteWithRTS(b []byte) (int, error) {
+	return p.Write(b)
+}
+
+// Close implements serial.Port.
+func (p *MockPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		p.notify()
+	}
+
+	return nil
+}
+
+// control records a call to a control method and fails if the port is
+// closed. On success the caller must unlock mu.
+func (p *MockPort) control(format string, args ...interface{}) error {
+	p.mu.Lock()
+	p.calls = append(p.calls, fmt.Sprintf(format, args...))
+
+	if p.closed {
+		p.mu.Unlock()
+		return serial.ErrPortClosed
+	}
+
+	return nil
+}
+
+// WaitForData implements serial.Port.
+func (p *MockPort) WaitForData(timeout time.Duration) (bool, error) {
+	var end time.Time
+	if timeout >= 0 {
+		end = time.Now().Add(timeout)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.closed {
+			return false, serial.ErrPortClosed
+		}
+
+		due := p.arrive()
+		if len(p.pending) > 0 {
+			return true, nil
+		}
+
+		until := end
+		if !due.IsZero() && (until.IsZero() || due.Before(until)) {
+			until = due
+		}
+
+		if !p.wait(until) && until.Equal(end) {
+			return false, nil
+		}
+	}
+}
+
+// BytesAvailable implements serial.Port.
+func (p *MockPort) BytesAvailable() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, serial.ErrPortClosed
+	}
+
+	p.arrive()
+	return len(p.pending), nil
+}
+
+// SetReadDeadline implements serial.Port.
+func (p *MockPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return serial.ErrPortClosed
+	}
+
+	p.readDeadline = t
+	p.notify()
+	return nil
+}
+
+// SetWriteDeadline implements serial.Port. Writes never block, so the
+// deadline has no effect.
+func (p *MockPort) SetWriteDeadline(t time.Time) error {
+	if err := p.control("SetWriteDeadline(%v)", t); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Stats implements serial.Port.
+func (p *MockPort) Stats() serial.PortStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// ResetStats implements serial.Port.
+func (p *MockPort) ResetStats() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stats = serial.PortStats{}
+}
+
+// SetHooks implements serial.Port.
+func (p *MockPort) SetHooks(hooks serial.PortHooks) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls = append(p.calls, "SetHooks()")
+	p.options.Hooks = hooks
+}
+
+// String implements serial.Port.
+func (p *MockPort) String() string {
+	return p.name
+}
+
+// Pause implements serial.Port.
+func (p *MockPort) Pause() error {
+	if err := p.control("Pause()"); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Resume implements serial.Port.
+func (p *MockPort) Resume() error {
+	if err := p.control("Resume()"); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// ErrorCounters implements serial.Port. The counts are always zero.
+func (p *MockPort) ErrorCounters() (serial.ErrorCounters, error) {
+	if err := p.control("ErrorCounters()"); err != nil {
+		return serial.ErrorCounters{}, err
+	}
+	p.mu.Unlock()
+
+	return serial.ErrorCounters{}, nil
+}
+
+// ResetErrorCounters implements serial.Port.
+func (p *MockPort) ResetErrorCounters() error {
+	if err := p.control("ResetErrorCounters()"); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// BufferSizes implements serial.Port. The sizes are always zero.
+func (p *MockPort) BufferSizes() (serial.BufferSizes, error) {
+	if err := p.control("BufferSizes()"); err != nil {
+		return serial.BufferSizes{}, err
+	}
+	p.mu.Unlock()
+
+	return serial.BufferSizes{}, nil
+}
+
+// SetLowLatency implements serial.Port.
+func (p *MockPort) SetLowLatency(on bool) error {
+	if err := p.control("SetLowLatency(%t)", on); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// CurrentOptions implements serial.Port.
+func (p *MockPort) CurrentOptions() (serial.OpenOptions, error) {
+	if err := p.control("CurrentOptions()"); err != nil {
+		return serial.OpenOptions{}, err
+	}
+	defer p.mu.Unlock()
+
+	return p.options, nil
+}
+
+// BaudRate implements serial.Port.
+func (p *MockPort) BaudRate() (uint, error) {
+	if err := p.control("BaudRate()"); err != nil {
+		return 0, err
+	}
+	defer p.mu.Unlock()
+
+	return p.options.BaudRate, nil
+}
+
+// SetLogger implements serial.Port. The logger is only recorded in the
+// options.
+func (p *MockPort) SetLogger(l *slog.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls = append(p.calls, "SetLogger()")
+	p.options.Logger = l
+}
+
+// DumpSettings implements serial.Port.
+func (p *MockPort) DumpSettings() (string, error) {
+	if err := p.control("DumpSettings()"); err != nil {
+		return "", err
+	}
+	defer p.mu.Unlock()
+
+	return p.name + ": " + serial.FormatMode(p.options), nil
+}
+
+// DescribeTermios implements serial.Port. A mock has no termios, so it
+// returns serial.ErrNotSupported.
+func (p *MockPort) DescribeTermios() (string, error) {
+	if err := p.control("DescribeTermios()"); err != nil {
+		return "", err
+	}
+	p.mu.Unlock()
+
+	return "", serial.ErrNotSupported
+}
+
+// SetMode implements serial.Port.
+func (p *MockPort) SetMode(dataBits uint, parity serial.ParityMode, stopBits uint, rtscts bool) error {
+	if err := p.control("SetMode(%d, %d, %d, %t)", dataBits, parity, stopBits, rtscts); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.DataBits = dataBits
+	options.ParityMode = parity
+	options.StopBits = stopBits
+	options.RTSCTSFlowControl = rtscts
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	p.options = options
+	return nil
+}
+
+// SetBaudRate implements serial.Port.
+func (p *MockPort) SetBaudRate(baud uint) error {
+	if err := p.control("SetBaudRate(%d)", baud); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	options := p.options
+	options.BaudRate = baud
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	p.options = options
+	return nil
+}
+
+// WithBaudRate implements serial.Port.
+func (p *MockPort) WithBaudRate(baud uint, fn func() error) (err error) {
+	p.mu.Lock()
+	prev := p.options.BaudRate
+	p.mu.Unlock()
+
+	if err := p.SetBaudRate(baud); err != nil {
+		return err
+	}
+
+	defer func() {
+		if restoreErr := p.SetBaudRate(prev); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	return fn()
+}
+
+// Drain implements serial.Port. Writes are consumed at once, so there is
+// nothing to wait for.
+func (p *MockPort) Drain() error {
+	if err := p.control("Drain()"); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Quiesce implements serial.Port.
+func (p *MockPort) Quiesce() error {
+	if err := p.control("Quiesce()"); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	p.arrive()
+	p.pending = nil
+	return nil
+}
+
+// SetReceiverEnabled implements serial.Port. While the receiver is off,
+// responses that fall due are dropped.
+func (p *MockPort) SetReceiverEnabled(on bool) error {
+	if err := p.control("SetReceiverEnabled(%t)", on); err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	p.arrive()
+	p.receiverOff = !on
+	return nil
+}
+
+// SetRTS implements serial.Port.
+func (p *MockPort) SetRTS(on bool) error {
+	if err := p.control("SetRTS(%t)", on); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// SetDTR implements serial.Port.
+func (p *MockPort) SetDTR(on bool) error {
+	if err := p.control("SetDTR(%t)", on); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// SetBreak implements serial.Port.
+func (p *MockPort) SetBreak(on bool) error {
+	if err := p.control("SetBreak(%t)", on); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// CarrierDetect implements serial.Port.
+func (p *MockPort) CarrierDetect() (bool, error) {
+	if err := p.control("CarrierDetect()"); err != nil {
+		return false, err
+	}
+	defer p.mu.Unlock()
+
+	return !p.noCarrier, nil
+}
+
+// SetCarrierDetect sets the state of DCD that CarrierDetect reports, to
+// simulate a modem gaining or losing its connection.
+func (p *MockPort) SetCarrierDetect(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.noCarrier = !on
+}
+
+// PulseDTR implements serial.Port.
+func (p *MockPort) PulseDTR(d time.Duration) error {
+	return p.pulse("PulseDTR", d)
+}
+
+// PulseRTS implements serial.Port.
+func (p *MockPort) PulseRTS(d time.Duration) error {
+	return p.pulse("PulseRTS", d)
+}
+
+// pulse waits for d, then discards the input that has become available.
+func (p *MockPort) pulse(op string, d time.Duration) error {
+	if err := p.control("%s(%v)", op, d); err != nil {
+		return err
+	}
+	p.mu.Unlock()
+
+	time.Sleep(d)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.arrive()
+	p.pending = nil
+	return nil
+}
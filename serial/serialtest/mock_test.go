@@ -0,0 +1,176 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serialtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// recorder is a testing.TB that records failures instead of reporting them,
+// so that tests can check what a MockPort complains about.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+// finish runs the cleanups, as the end of a test would.
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestMockPort(t *testing.T) {
+	var port serial.Port = NewMockPort(t,
+		Expect(0x01, 0x02),
+		RespondAfter(20*time.Millisecond, 0xaa),
+		Expect(0x03),
+		Respond(0xbb, 0xcc),
+	)
+
+	// The expected bytes may be split across writes.
+	port.Write([]byte{0x01})
+	start := time.Now()
+	port.Write([]byte{0x02})
+
+	buf := make([]byte, 4)
+	n, err := port.Read(buf)
+	if err != nil || n != 1 || buf[0] != 0xaa {
+		t.Fatalf("Read: % x, %v", buf[:n], err)
+	}
+
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("response came after %v", d)
+	}
+
+	port.Write([]byte{0x03})
+	if _, err := io.ReadFull(port, buf[:2]); err != nil || buf[0] != 0xbb || buf[1] != 0xcc {
+		t.Fatalf("ReadFull: % x, %v", buf[:2], err)
+	}
+
+	if got, want := port.Stats(), (serial.PortStats{BytesRead: 3, BytesWritten: 3}); got != want {
+		t.Errorf("Stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestMockPortErrors(t *testing.T) {
+	port := NewMockPort(t,
+		Expect(0x01),
+		Timeout(),
+		Fail(serial.ErrDeviceRemoved),
+	)
+
+	port.Write([]byte{0x01})
+
+	buf := make([]byte, 1)
+	_, err := port.Read(buf)
+
+	var netErr net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read: got %v, want a timeout", err)
+	}
+
+	if _, err := port.Write([]byte{0x02}); !errors.Is(err, serial.ErrDeviceRemoved) {
+		t.Errorf("Write: got %v, want ErrDeviceRemoved", err)
+	}
+
+	// With the script over, the device is silent until the deadline.
+	port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := port.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: got %v, want a timeout", err)
+	}
+}
+
+func TestMockPortReportsMismatches(t *testing.T) {
+	r := &recorder{}
+	port := NewMockPort(r, Expect(0x01, 0x02, 0x03), Respond(0xaa))
+
+	port.Write([]byte{0x01, 0x09, 0x03, 0x04})
+	r.finish()
+
+	want := []string{
+		"step 1 (expect 01 02 03): got 01 09 03, want 01 02 03 (first difference at byte 1)",
+		"unexpected write of 04 at step 2",
+		"script not finished; step 2",
+	}
+
+	if len(r.errors) != len(want) {
+		t.Fatalf("got errors %q", r.errors)
+	}
+
+	for i := range want {
+		if !strings.Contains(r.errors[i], want[i]) {
+			t.Errorf("error %d: got %q, want it to contain %q", i, r.errors[i], want[i])
+		}
+	}
+}
+
+func TestMockPortControl(t *testing.T) {
+	port := NewMockPort(t, Respond(0xaa))
+
+	if err := port.WithBaudRate(9600, func() error { return port.PulseDTR(time.Millisecond) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pulse discarded the response.
+	if n, err := port.BytesAvailable(); n != 0 || err != nil {
+		t.Errorf("BytesAvailable: %d, %v", n, err)
+	}
+
+	want := []string{"SetBaudRate(9600)", "PulseDTR(1ms)", "SetBaudRate(115200)"}
+	if got := port.Calls(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Calls: got %q, want %q", got, want)
+	}
+
+	port.Close()
+	if _, err := port.Read(make([]byte, 1)); err != serial.ErrPortClosed {
+		t.Errorf("Read after Close: got %v", err)
+	}
+}
+
+func TestMockPortCarrierDetect(t *testing.T) {
+	port := NewMockPort(t)
+
+	for _, want := range []bool{true, false} {
+		port.SetCarrierDetect(want)
+
+		got, err := port.CarrierDetect()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("CarrierDetect: got %t, want %t", got, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseMode parses a description of a port's speed, framing and flow control
+// such as "9600,7E1", "115200/8-N-1", "19200 8N2" or "115200,8N1,rtscts".
+// The speed comes first, followed by the data bits, parity (N, O or E) and
+// stop bits, separated by commas, slashes, spaces or dashes or run together.
+// The framing defaults to 8N1 if left out, and a trailing "rtscts" turns on
+// RTS/CTS flow control.
+//
+// Only BaudRate, DataBits, ParityMode, StopBits and RTSCTSFlowControl are
+// set; the caller fills in PortName and the read timeouts.
+func ParseMode(s string) (OpenOptions, error) {
+	options, err := parseMode(s)
+	if err != nil {
+		return OpenOptions{}, fmt.Errorf("serial: %w", err)
+	}
+
+	return options, nil
+}
+
+// parseMode implements ParseMode, returning errors without the package
+// prefix so that ModeFlag can report them as the flag package expects.
+func parseMode(s string) (OpenOptions, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '/' || unicode.IsSpace(r)
+	})
+
+	if len(fields) == 0 {
+		return OpenOptions{}, fmt.Errorf("empty mode %q", s)
+	}
+
+	baud, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil || baud == 0 {
+		return OpenOptions{}, fmt.Errorf("invalid baud rate %q in mode %q", fields[0], s)
+	}
+
+	options := OpenOptions{
+		BaudRate:   uint(baud),
+		DataBits:   8,
+		ParityMode: PARITY_NONE,
+		StopBits:   1,
+	}
+
+	rest := fields[1:]
+	if n := len(rest); n > 0 && strings.EqualFold(rest[n-1], "rtscts") {
+		options.RTSCTSFlowControl = true
+		rest = rest[:n-1]
+	}
+
+	frame := strings.ReplaceAll(strings.Join(rest, ""), "-", "")
+	if frame == "" {
+		return options, nil
+	}
+
+	if len(frame) != 3 {
+		return OpenOptions{}, fmt.Errorf("invalid framing %q in mode %q, want e.g. 8N1", frame, s)
+	}
+
+	switch c := frame[0]; c {
+	case '5', '6', '7', '8':
+		options.DataBits = uint(c - '0')
+	default:
+		return OpenOptions{}, fmt.Errorf("invalid data bits '%c' in mode %q", c, s)
+	}
+
+	switch c := frame[1]; c {
+	case 'N', 'n':
+		options.ParityMode = PARITY_NONE
+	case 'O', 'o':
+		options.ParityMode = PARITY_ODD
+	case 'E', 'e':
+		options.ParityMode = PARITY_EVEN
+	default:
+		return OpenOptions{}, fmt.Errorf("unknown parity '%c' in mode %q", c, s)
+	}
+
+	switch c := frame[2]; c {
+	case '1', '2':
+		options.StopBits = uint(c - '0')
+	default:
+		return OpenOptions{}, fmt.Errorf("invalid stop bits '%c' in mode %q", c, s)
+	}
+
+	return options, nil
+}
+
+// FormatMode describes the speed, framing and flow control in options in the
+// usual shorthand, such as "115200 8N1" or "9600 7E1, rtscts", which
+// ParseMode accepts.
+func FormatMode(options OpenOptions) string {
+	parity := "?"
+	switch options.ParityMode {
+	case PARITY_NONE:
+		parity = "N"
+	case PARITY_ODD:
+		parity = "O"
+	case PARITY_EVEN:
+		parity = "E"
+	}
+
+	s := fmt.Sprintf("%d %d%s%d", options.BaudRate, options.DataBits, parity, options.StopBits)
+	if options.RTSCTSFlowControl {
+		s += ", rtscts"
+	}
+
+	return s
+}
+
+// modemStatus holds the state of the modem status lines.
+type modemStatus struct {
+	cts, dsr, dcd, ri bool
+}
+
+func (m modemStatus) String() string {
+	bit := func(on bool) int {
+		if on {
+			return 1
+		}
+		return 0
+	}
+
+	return fmt.Sprintf("CTS=%d DSR=%d DCD=%d RI=%d", bit(m.cts), bit(m.dsr), bit(m.dcd), bit(m.ri))
+}
+
+// joinSettings joins the non-empty parts of a settings dump.
+func joinSettings(name string, parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+
+	return name + ": " + strings.Join(nonEmpty, ", ")
+}
+
+// withBaudRate implements Port.WithBaudRate for a port currently running at
+// prev.
+func withBaudRate(p Port, prev, baud uint, fn func() error) (err error) {
+	if err := p.SetBaudRate(baud); err != nil {
+		return err
+	}
+
+	defer func() {
+		if restoreErr := p.SetBaudRate(prev); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	return fn()
+}
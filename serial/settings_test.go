@@ -0,0 +1,76 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	testCases := []struct {
+		s    string
+		want string
+	}{
+		{"9600,7E1", "9600 7E1"},
+		{"115200/8-N-1", "115200 8N1"},
+		{"19200 8n2", "19200 8N2"},
+		{"9600,8,O,1", "9600 8O1"},
+		{"115200,8N1,rtscts", "115200 8N1, rtscts"},
+		{"57600", "57600 8N1"},
+		{"57600/RTSCTS", "57600 8N1, rtscts"},
+		{"9600 7E1, rtscts", "9600 7E1, rtscts"},
+	}
+
+	for _, tc := range testCases {
+		options, err := ParseMode(tc.s)
+		if err != nil {
+			t.Errorf("ParseMode(%q): %v", tc.s, err)
+			continue
+		}
+
+		if got := FormatMode(options); got != tc.want {
+			t.Errorf("ParseMode(%q): got %q, want %q", tc.s, got, tc.want)
+		}
+
+		// The formatted mode must parse back to the same thing.
+		again, err := ParseMode(FormatMode(options))
+		if err != nil || FormatMode(again) != tc.want {
+			t.Errorf("round trip of %q: got %q, %v", tc.want, FormatMode(again), err)
+		}
+	}
+}
+
+func TestParseModeErrors(t *testing.T) {
+	testCases := []struct {
+		s    string
+		want string
+	}{
+		{"", "empty mode"},
+		{"fast,8N1", `invalid baud rate "fast"`},
+		{"0,8N1", `invalid baud rate "0"`},
+		{"9600,8Q1", "unknown parity 'Q'"},
+		{"9600,9N1", "invalid data bits '9'"},
+		{"9600,8N3", "invalid stop bits '3'"},
+		{"9600,8N1.5", `invalid framing "8N1.5"`},
+	}
+
+	for _, tc := range testCases {
+		_, err := ParseMode(tc.s)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseMode(%q): got %v, want an error containing %q", tc.s, err, tc.want)
+		}
+	}
+}
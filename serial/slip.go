@@ -0,0 +1,120 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+// The special bytes of SLIP, from RFC 1055.
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// SlipReader reads packets framed with SLIP, as RFC 1055 describes: each
+// ends with an END byte, and END and ESC bytes within it are escaped.
+type SlipReader struct {
+	f      *FrameReader
+	maxLen int
+}
+
+// NewSlipReader returns a SlipReader that reads from p, returning packets
+// of at most maxLen bytes. A maxLen of zero or less means no limit.
+func NewSlipReader(p Port, maxLen int) *SlipReader {
+	// Escaping at most doubles a packet's length.
+	frameLen := 0
+	if maxLen > 0 {
+		frameLen = 2 * maxLen
+	}
+
+	return &SlipReader{f: NewFrameReader(p, slipEnd, frameLen), maxLen: maxLen}
+}
+
+// ReadFrame returns the next packet, unescaped. Empty packets, as between
+// the END bytes that senders put both before and after a packet, are
+// skipped. A packet split across several reads from the port is put back
+// together, and errors are returned as by FrameReader.ReadFrame. A packet
+// with an ESC byte followed by anything other than ESC_END or ESC_ESC is
+// discarded with ErrCorruptFrame, and one longer than maxLen with
+// ErrFrameTooLong.
+func (r *SlipReader) ReadFrame() ([]byte, error) {
+	frame, err := r.f.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unescape in place; the result is never longer.
+	n := 0
+	for i := 0; i < len(frame); i++ {
+		c := frame[i]
+		if c == slipEsc {
+			i++
+			if i == len(frame) {
+				return nil, ErrCorruptFrame
+			}
+
+			switch frame[i] {
+			case slipEscEnd:
+				c = slipEnd
+			case slipEscEsc:
+				c = slipEsc
+			default:
+				return nil, ErrCorruptFrame
+			}
+		}
+
+		frame[n] = c
+		n++
+	}
+
+	if r.maxLen > 0 && n > r.maxLen {
+		return nil, ErrFrameTooLong
+	}
+
+	return frame[:n], nil
+}
+
+// SlipWriter writes packets framed with SLIP, the counterpart of
+// SlipReader.
+type SlipWriter struct {
+	p Port
+}
+
+// NewSlipWriter returns a SlipWriter that writes to p.
+func NewSlipWriter(p Port) *SlipWriter {
+	return &SlipWriter{p: p}
+}
+
+// WriteFrame writes b as one packet, escaped and with an END byte both
+// before and after it, the first flushing out any noise the receiver has
+// picked up since the last packet. The frame is written to the port with a
+// single Write.
+func (w *SlipWriter) WriteFrame(b []byte) error {
+	frame := make([]byte, 0, len(b)+len(b)/8+2)
+	frame = append(frame, slipEnd)
+	for _, c := range b {
+		switch c {
+		case slipEnd:
+			frame = append(frame, slipEsc, slipEscEnd)
+		case slipEsc:
+			frame = append(frame, slipEsc, slipEscEsc)
+		default:
+			frame = append(frame, c)
+		}
+	}
+	frame = append(frame, slipEnd)
+
+	_, err := w.p.Write(frame)
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSlip(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	packets := [][]byte{
+		[]byte("plain"),
+		{slipEnd, 'x', slipEsc, slipEscEnd, slipEnd},
+		{slipEsc},
+	}
+
+	w := NewSlipWriter(a)
+	for _, p := range packets {
+		if err := w.WriteFrame(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewSlipReader(b, 0)
+	for _, want := range packets {
+		got, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("expected %x, got %x", want, got)
+		}
+	}
+}
+
+func TestSlipReader(t *testing.T) {
+	// Arriving a byte at a time, with a bad escape and an overlong packet.
+	p := framePort("\xc0a\xdb\xdcb\xc0c\xdb\xdd\xc0bad\xdbx\xc0toolong\xc0\xc0ok\xc0")
+	defer p.Close()
+	r := NewSlipReader(p, 4)
+
+	expected := []struct {
+		packet string
+		err    error
+	}{
+		{"a\xc0b", nil},
+		{"c\xdb", nil},
+		{"", ErrCorruptFrame},
+		{"", ErrFrameTooLong},
+		{"ok", nil},
+		{"", ErrDeviceRemoved},
+	}
+
+	for _, want := range expected {
+		got, err := r.ReadFrame()
+		if !errors.Is(err, want.err) {
+			t.Fatalf("expected error %v, got %v", want.err, err)
+		}
+
+		if string(got) != want.packet {
+			t.Errorf("expected %q, got %q", want.packet, got)
+		}
+	}
+}
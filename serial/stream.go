@@ -0,0 +1,508 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// streamConn is what a streamPort reads and writes: an *os.File open on a
+// FIFO, or a net.Conn.
+type streamConn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// streamPort is a Port on a named pipe or Unix domain socket standing in
+// for a device.
+type streamPort struct {
+	// First, for alignment.
+	counters portCounters
+	log      portLogger
+
+	conn streamConn
+	name string
+
+	// Serializes writes to conn.
+	wl sync.Mutex
+
+	// Received data and the rest, guarded by mu.
+	chunkPort
+	options OpenOptions
+}
+
+// OpenStream opens options.PortName, which must be a named pipe (FIFO) or
+// a Unix domain socket, for talking to a process that simulates a device
+// in tests. Open does the same when given such a path on Unix.
+//
+// The port behaves as one returned by Open does, honouring the read
+// timeouts and deadlines, and Close interrupting a Read or Write in
+// progress, but there is no termios to configure: the settings are only
+// recorded, for CurrentOptions to report and SetMode and SetBaudRate to
+// change. Operations that need a real line, such as SetDTR, SetRTS,
+// SetBreak, PulseDTR, CarrierDetect, Pause and ErrorCounters, return
+// ErrNotSupported.
+//
+// A socket is connected to, so the simulator must be listening on it. A
+// FIFO carries data one way only; it is opened for both reading and
+// writing, so whatever the port writes it may read back itself.
+func OpenStream(options OpenOptions) (Port, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	return openStream(options)
+}
+
+func openStream(options OpenOptions) (Port, error) {
+	name := options.PortName
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, openError(err)
+	}
+
+	var conn streamConn
+	switch mode := fi.Mode(); {
+	case mode&os.ModeSocket != 0:
+		conn, err = net.Dial("unix", name)
+	case mode&os.ModeNamedPipe != 0:
+		conn, err = os.OpenFile(name, os.O_RDWR, 0)
+	default:
+		return nil, fmt.Errorf("serial: %s is not a named pipe or Unix domain socket", name)
+	}
+
+	if err != nil {
+		return nil, newPortError(name, "opening", err)
+	}
+
+	p := &streamPort{
+		conn:    conn,
+		name:    name,
+		options: options,
+	}
+	p.counters.setHooks(options.Hooks)
+	p.log.set(options.Logger, name)
+
+	go p.readLoop()
+
+	return p, nil
+}
+
+// readLoop delivers what arrives on conn until reading it fails.
+func (p *streamPort) readLoop() {
+	var err error
+	for err == nil {
+		buf := make([]byte, 4096)
+
+		var n int
+		n, err = p.conn.Read(buf)
+		if n > 0 {
+			p.deliver(buf[:n])
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		if l := p.log.get(); l != nil && err != io.EOF {
+			l.Warn("reading failed", "err", err)
+		}
+	}
+
+	p.fail(err)
+}
+
+func (p *streamPort) portError(op string, err error) error {
+	return newPortError(p.name, op, err)
+}
+
+// Read implements io.Reader, honouring MinimumReadSize,
+// InterCharacterTimeout and OverallReadTimeout as Open's ports do.
+func (p *streamPort) Read(b []byte) (int, error) {
+	n, _, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port.
+func (p *streamPort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, first, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, first, err
+}
+
+func (p *streamPort) read(b []byte) (int, time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readChunks(p.name, b, p.options)
+}
+
+// Write implements io.Writer, honouring MaxWriteChunk.
+func (p *streamPort) Write(b []byte) (int, error) {
+	n, err := p.write(b)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *streamPort) write(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, ErrPortClosed
+	}
+	options := p.options
+	p.mu.Unlock()
+
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	n, err := writeChunked(b, options.MaxWriteChunk, options.WriteChunkDelay, p.conn.Write)
+	if err != nil && p.begin() != nil {
+		return n, ErrPortClosed
+	}
+
+	return n, p.portError("write", err)
+}
+
+// Close implements Port.
+func (p *streamPort) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	p.chunks = nil
+	p.notify()
+	p.mu.Unlock()
+
+	p.conn.Close()
+	return nil
+}
+
+// String implements Port.
+func (p *streamPort) String() string {
+	return p.name
+}
+
+// Stats implements Port.
+func (p *streamPort) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *streamPort) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *streamPort) SetHooks(hooks PortHooks) {
+	p.mu.Lock()
+	p.options.Hooks = hooks
+	p.mu.Unlock()
+
+	p.counters.setHooks(hooks)
+}
+
+// SetLogger implements Port.
+func (p *streamPort) SetLogger(l *slog.Logger) {
+	p.mu.Lock()
+	p.options.Logger = l
+	p.mu.Unlock()
+
+	p.log.set(l, p.name)
+}
+
+// begin fails if the port is closed.
+func (p *streamPort) begin() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	return nil
+}
+
+// unsupported returns ErrNotSupported, or ErrPortClosed once the port is
+// closed.
+func (p *streamPort) unsupported() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+// Pause implements Port. It returns ErrNotSupported.
+func (p *streamPort) Pause() error {
+	return p.unsupported()
+}
+
+// Resume implements Port. It returns ErrNotSupported.
+func (p *streamPort) Resume() error {
+	return p.unsupported()
+}
+
+// ErrorCounters implements Port. It returns ErrNotSupported.
+func (p *streamPort) ErrorCounters() (ErrorCounters, error) {
+	return ErrorCounters{}, p.unsupported()
+}
+
+// ResetErrorCounters implements Port. It returns ErrNotSupported.
+func (p *streamPort) ResetErrorCounters() error {
+	return p.unsupported()
+}
+
+// BufferSizes implements Port. Only the package's buffers would count, and
+// there are none, so all are zero.
+func (p *streamPort) BufferSizes() (BufferSizes, error) {
+	return BufferSizes{}, p.begin()
+}
+
+// SetLowLatency implements Port. It has no effect.
+func (p *streamPort) SetLowLatency(on bool) error {
+	return p.begin()
+}
+
+// CurrentOptions implements Port, returning the options as last set.
+func (p *streamPort) CurrentOptions() (OpenOptions, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return OpenOptions{}, ErrPortClosed
+	}
+
+	return p.options, nil
+}
+
+// BaudRate implements Port.
+func (p *streamPort) BaudRate() (uint, error) {
+	options, err := p.CurrentOptions()
+	return options.BaudRate, err
+}
+
+// DumpSettings implements Port.
+func (p *streamPort) DumpSettings() (string, error) {
+	options, err := p.CurrentOptions()
+	if err != nil {
+		return "", err
+	}
+
+	return joinSettings(p.name, FormatMode(options)), nil
+}
+
+// DescribeTermios implements Port. There is no termios, so it returns
+// ErrNotSupported.
+func (p *streamPort) DescribeTermios() (string, error) {
+	return "", p.unsupported()
+}
+
+// setOptions validates changed options and records them.
+func (p *streamPort) setOptions(change func(o *OpenOptions)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	options := p.options
+	change(&options)
+
+	if err := options.validate(false); err != nil {
+		return err
+	}
+
+	p.options = options
+	return nil
+}
+
+// SetMode implements Port, recording the settings.
+func (p *streamPort) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	return p.setOptions(func(o *OpenOptions) {
+		o.DataBits = dataBits
+		o.ParityMode = parity
+		o.StopBits = stopBits
+		o.RTSCTSFlowControl = rtscts
+	})
+}
+
+// SetBaudRate implements Port, recording the rate.
+func (p *streamPort) SetBaudRate(baud uint) error {
+	return p.setOptions(func(o *OpenOptions) { o.BaudRate = baud })
+}
+
+// WithBaudRate implements Port.
+func (p *streamPort) WithBaudRate(baud uint, fn func() error) error {
+	prev, err := p.BaudRate()
+	if err != nil {
+		return err
+	}
+
+	return withBaudRate(p, prev, baud, fn)
+}
+
+// Drain implements Port. It waits for Writes in progress to finish; the
+// other end may not have read the data yet.
+func (p *streamPort) Drain() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	p.wl.Lock()
+	p.wl.Unlock()
+
+	return nil
+}
+
+// Quiesce implements Port, discarding what has arrived once Writes in
+// progress have finished.
+func (p *streamPort) Quiesce() error {
+	if err := p.Drain(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.chunks = nil
+	p.mu.Unlock()
+
+	return nil
+}
+
+// WriteNineBit implements Port. It returns ErrNotSupported.
+func (p *streamPort) WriteNineBit(b []byte, address bool) (int, error) {
+	return 0, p.unsupported()
+}
+
+// SetReceiverEnabled implements Port. It returns ErrNotSupported.
+func (p *streamPort) SetReceiverEnabled(on bool) error {
+	return p.unsupported()
+}
+
+// SetDTR implements Port. It returns ErrNotSupported.
+func (p *streamPort) SetDTR(on bool) error {
+	return p.unsupported()
+}
+
+// SetRTS implements Port. It returns ErrNotSupported.
+func (p *streamPort) SetRTS(on bool) error {
+	return p.unsupported()
+}
+
+// SetBreak implements Port. It returns ErrNotSupported.
+func (p *streamPort) SetBreak(on bool) error {
+	return p.unsupported()
+}
+
+// CarrierDetect implements Port. It returns ErrNotSupported.
+func (p *streamPort) CarrierDetect() (bool, error) {
+	return false, p.unsupported()
+}
+
+// WriteWithRTS implements Port. It returns ErrNotSupported without writing
+// anything.
+func (p *streamPort) WriteWithRTS(b []byte) (int, error) {
+	err := p.unsupported()
+	p.counters.countWrite(0, err)
+	return 0, err
+}
+
+// PulseDTR implements Port. It returns ErrNotSupported.
+func (p *streamPort) PulseDTR(d time.Duration) error {
+	return p.unsupported()
+}
+
+// PulseRTS implements Port. It returns ErrNotSupported.
+func (p *streamPort) PulseRTS(d time.Duration) error {
+	return p.unsupported()
+}
+
+// WaitForData implements Port. Once reading has failed it reports that
+// data is available, so that a subsequent Read returns the error.
+func (p *streamPort) WaitForData(timeout time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for len(p.chunks) == 0 && p.connErr == nil {
+		if p.closed {
+			return false, ErrPortClosed
+		}
+
+		if err := p.wait(deadline); err != nil {
+			return false, nil
+		}
+	}
+
+	if p.closed {
+		return false, ErrPortClosed
+	}
+
+	return true, nil
+}
+
+// BytesAvailable implements Port.
+func (p *streamPort) BytesAvailable() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, ErrPortClosed
+	}
+
+	return p.buffered(), nil
+}
+
+// SetReadDeadline implements Port.
+func (p *streamPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	p.readDeadline = t
+	p.notify()
+
+	return nil
+}
+
+// SetWriteDeadline implements Port.
+func (p *streamPort) SetWriteDeadline(t time.Time) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return p.portError("setting write deadline", p.conn.SetWriteDeadline(t))
+}
@@ -0,0 +1,201 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func streamOptions(name string) OpenOptions {
+	return OpenOptions{
+		PortName:        name,
+		BaudRate:        9600,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+}
+
+func TestOpenSocket(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "device.sock")
+	ln, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	p, err := Open(streamOptions(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	device, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+
+	// Data flows both ways.
+	if _, err := p.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(device, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("device read %q, want %q", got, "ping")
+	}
+
+	if _, err := device.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, p, []byte("pong"))
+
+	// Settings are recorded, but the lines can't be driven.
+	if err := p.SetBaudRate(115200); err != nil {
+		t.Fatal(err)
+	}
+	if baud, _ := p.BaudRate(); baud != 115200 {
+		t.Errorf("BaudRate: got %d, want 115200", baud)
+	}
+
+	if err := p.SetDTR(true); err != ErrNotSupported {
+		t.Errorf("SetDTR: got %v, want ErrNotSupported", err)
+	}
+	if err := p.SetBreak(true); err != ErrNotSupported {
+		t.Errorf("SetBreak: got %v, want ErrNotSupported", err)
+	}
+
+	// The read deadline applies.
+	p.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: got %v, want a deadline error", err)
+	}
+	p.SetReadDeadline(time.Time{})
+
+	// Close interrupts a blocked Read.
+	result := make(chan error, 1)
+	go func() {
+		_, err := p.Read(make([]byte, 1))
+		result <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	p.Close()
+
+	select {
+	case err := <-result:
+		if err != ErrPortClosed {
+			t.Errorf("Read: got %v, want ErrPortClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read still blocked after Close")
+	}
+}
+
+func TestOpenSocketClosed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "device.sock")
+	ln, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	options := streamOptions(name)
+	options.MinimumReadSize = 0
+	options.InterCharacterTimeout = 100
+	p, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	device, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A timeout gives io.EOF, but the simulator going away doesn't.
+	if _, err := p.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read: got %v, want io.EOF", err)
+	}
+
+	device.Write([]byte("x"))
+	device.Close()
+	readFull(t, p, []byte("x"))
+
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Read after the simulator closed: got %v, want ErrDeviceRemoved", err)
+	}
+}
+
+func TestOpenFIFO(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "device.fifo")
+	if err := syscall.Mkfifo(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options := streamOptions(name)
+	options.InterCharacterTimeout = 50
+	p, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// What another process writes to the FIFO is read, in one go once the
+	// inter-character timeout has expired.
+	w, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "data" {
+		t.Errorf("read %q, want %q", buf[:n], "data")
+	}
+}
+
+func TestOpenStreamNotStream(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenStream(streamOptions(name)); err == nil {
+		t.Error("OpenStream of a regular file succeeded")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+// sysCall records a request made through sysCalls. req is the fcntl command
+// or ioctl request, and zero for open.
+type sysCall struct {
+	fn  string
+	req uint
+}
+
+// fakeSys stands in for the kernel. open returns a regular file, which the
+// runtime poller won't accept, so ports opened through it run in blocking
+// mode, after calling onOpen if set. fcntl is passed through to that file.
+// ioctl succeeds without doing anything unless onIoctl says otherwise.
+type fakeSys struct {
+	dir     string
+	onOpen  func(path string) error
+	onIoctl func(req uint, arg unsafe.Pointer) error
+
+	mu    sync.Mutex
+	calls []sysCall
+	files []*os.File // as returned by open
+}
+
+// useFakeSys installs a fakeSys for the duration of the test.
+func useFakeSys(t *testing.T) *fakeSys {
+	f := &fakeSys{dir: t.TempDir()}
+
+	prev := sys
+	sys = f
+	t.Cleanup(func() { sys = prev })
+
+	return f
+}
+
+func (f *fakeSys) record(fn string, req uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, sysCall{fn, req})
+}
+
+// Calls returns the requests made so far.
+func (f *fakeSys) Calls() []sysCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]sysCall(nil), f.calls...)
+}
+
+func (f *fakeSys) open(path string, flag int) (*os.File, error) {
+	f.record("open", 0)
+	if f.onOpen != nil {
+		if err := f.onOpen(path); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(f.dir, filepath.Base(path)), os.O_RDWR|os.O_CREATE, 0600)
+	if err == nil {
+		f.mu.Lock()
+		f.files = append(f.files, file)
+		f.mu.Unlock()
+	}
+
+	return file, err
+}
+
+func (f *fakeSys) fcntl(fd uintptr, cmd int, arg int) (int, error) {
+	f.record("fcntl", uint(cmd))
+	return realSys{}.fcntl(fd, cmd, arg)
+}
+
+func (f *fakeSys) ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	f.record("ioctl", req)
+	if f.onIoctl != nil {
+		return f.onIoctl(req, arg)
+	}
+
+	return nil
+}
+
+// checkCalls fails the test if calls differs from want.
+func checkCalls(t *testing.T, calls, want []sysCall) {
+	t.Helper()
+
+	if len(calls) != len(want) {
+		t.Fatalf("calls: got %v, want %v", calls, want)
+	}
+
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls: got %v, want %v", calls, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux || solaris
+
+package serial
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysCalls is the boundary between the POSIX implementation and the kernel
+// for opening and configuring a port. Tests substitute a fake that records
+// the requests made, so that the translation of OpenOptions can be checked
+// without a serial port to hand.
+type sysCalls interface {
+	// open opens the device at path with the given flags.
+	open(path string, flag int) (*os.File, error)
+
+	// fcntl performs a fcntl command taking and returning an int.
+	fcntl(fd uintptr, cmd int, arg int) (int, error)
+
+	// ioctl makes an ioctl request whose argument is a pointer.
+	ioctl(fd uintptr, req uint, arg unsafe.Pointer) error
+}
+
+// The implementation used by the package.
+var sys sysCalls = realSys{}
+
+// realSys passes requests straight through to the kernel.
+type realSys struct{}
+
+func (realSys) open(path string, flag int) (*os.File, error) {
+	return os.OpenFile(path, flag, 0600)
+}
+
+func (realSys) fcntl(fd uintptr, cmd int, arg int) (int, error) {
+	return unix.FcntlInt(fd, cmd, arg)
+}
+
+// ignoringEINTR calls fn until it returns something other than EINTR. The
+// os package does this for reads and writes; the ioctls that wait for output
+// to drain need the same treatment, since a signal such as SIGCHLD can
+// interrupt them.
+func ignoringEINTR(fn func() error) error {
+	for {
+		if err := fn(); err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// setNonblock sets or clears O_NONBLOCK on fd.
+func setNonblock(fd uintptr, on bool) error {
+	flags, err := sys.fcntl(fd, unix.F_GETFL, 0)
+	if err != nil {
+		return os.NewSyscallError("fcntl", err)
+	}
+
+	if on {
+		flags |= unix.O_NONBLOCK
+	} else {
+		flags &^= unix.O_NONBLOCK
+	}
+
+	if _, err := sys.fcntl(fd, unix.F_SETFL, flags); err != nil {
+		return os.NewSyscallError("fcntl", err)
+	}
+
+	return nil
+}
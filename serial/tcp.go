@@ -0,0 +1,591 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPOptions configures a connection made by OpenTCP.
+type TCPOptions struct {
+	// How long to wait for each attempt to connect. Zero means 5 seconds.
+	DialTimeout time.Duration
+
+	// The interval between TCP keep-alive probes, which detect a server
+	// that has gone away without closing the connection, as one that loses
+	// power does. Zero means the net package's default of 15 seconds, and a
+	// negative value turns keep-alives off.
+	KeepAlive time.Duration
+
+	// Connect again when the server closes the connection or it fails,
+	// rather than failing every later Read and Write, for loggers that must
+	// outlast the server restarting. Attempts are made every
+	// ReconnectInterval, or every second if that is zero, until one
+	// succeeds or the port is closed. Reads and Writes wait meanwhile,
+	// subject to their deadlines. Bytes in flight when the connection
+	// failed may be lost.
+	Reconnect         bool
+	ReconnectInterval time.Duration
+
+	// If non-zero, Read returns io.EOF once this long has passed without
+	// anything arriving, as when OpenOptions.OverallReadTimeout expires.
+	// Otherwise Read blocks until data arrives.
+	ReadTimeout time.Duration
+
+	// As for OpenOptions.
+	Hooks  PortHooks
+	Logger *slog.Logger
+}
+
+// TCPPort is a Port reached over a plain TCP connection to a device or
+// server that relays a serial stream as is, such as ESP-Link or ser2net
+// in raw mode. Read, Write and their deadlines act on the connection;
+// Read returns as soon as any data has arrived.
+//
+// With no way to ask the far end to change anything, the methods that
+// change settings or modem lines, such as SetBaudRate, SetMode, SetDTR and
+// Pause, return ErrNotSupported, as do CurrentOptions, BaudRate and
+// ErrorCounters. Configure the port at the server instead, or use
+// DialRFC2217 if it supports RFC 2217.
+type TCPPort struct {
+	// First, for alignment.
+	counters portCounters
+	log      portLogger
+
+	addr    string
+	name    string
+	options TCPOptions
+
+	// Closed by Close, to stop reconnecting.
+	done chan struct{}
+
+	// Serializes writes to the connection.
+	wl sync.Mutex
+
+	// Received data and the rest, guarded by mu. connErr stays nil while
+	// reconnecting.
+	chunkPort
+	conn          net.Conn // nil while reconnecting
+	writeDeadline time.Time
+}
+
+// OpenTCP connects to addr, a host:port address such as "esp-link:23",
+// returning a port that relays data over the connection. The port's String
+// is "tcp://" followed by the address. The first connection must succeed
+// even with Reconnect set.
+func OpenTCP(addr string, options TCPOptions) (*TCPPort, error) {
+	p := &TCPPort{
+		addr:    addr,
+		name:    "tcp://" + addr,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	p.counters.setHooks(options.Hooks)
+	p.log.set(options.Logger, p.name)
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, newPortError(p.name, "dialing", err)
+	}
+
+	p.conn = conn
+	go p.readLoop(conn)
+
+	return p, nil
+}
+
+// dial makes one attempt to connect.
+func (p *TCPPort) dial() (net.Conn, error) {
+	d := net.Dialer{Timeout: p.options.DialTimeout, KeepAlive: p.options.KeepAlive}
+	if d.Timeout == 0 {
+		d.Timeout = 5 * time.Second
+	}
+
+	return d.Dial("tcp", p.addr)
+}
+
+// readLoop delivers what arrives on conn, and on any connections that
+// replace it, until the port is closed or the connection fails for good.
+func (p *TCPPort) readLoop(conn net.Conn) {
+	for {
+		err := p.receive(conn)
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+
+		p.conn = nil
+		if !p.options.Reconnect {
+			p.fail(err)
+			p.mu.Unlock()
+
+			if l := p.log.get(); l != nil && err != io.EOF {
+				l.Warn("connection failed", "err", err)
+			}
+			return
+		}
+		p.notify()
+		p.mu.Unlock()
+
+		if l := p.log.get(); l != nil {
+			l.Warn("connection lost; reconnecting", "err", err)
+		}
+
+		if conn = p.reconnect(); conn == nil {
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+
+		p.conn = conn
+		p.notify()
+		p.mu.Unlock()
+
+		if l := p.log.get(); l != nil {
+			l.Info("reconnected")
+		}
+	}
+}
+
+// receive delivers what arrives on conn until reading it fails.
+func (p *TCPPort) receive(conn net.Conn) error {
+	for {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if n > 0 {
+			p.deliver(buf[:n])
+		}
+
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+}
+
+// reconnect tries to connect until it succeeds, returning the connection,
+// or the port is closed, returning nil.
+func (p *TCPPort) reconnect() net.Conn {
+	interval := p.options.ReconnectInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return nil
+		case <-timer.C:
+		}
+
+		conn, err := p.dial()
+		if err == nil {
+			return conn
+		}
+
+		if l := p.log.get(); l != nil {
+			l.Debug("reconnecting failed", "err", err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (p *TCPPort) portError(op string, err error) error {
+	return newPortError(p.name, op, err)
+}
+
+// Read implements io.Reader, returning what has arrived once anything has.
+func (p *TCPPort) Read(b []byte) (int, error) {
+	n, _, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port, giving the time at which the first of
+// the bytes arrived from the network.
+func (p *TCPPort) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, first, err := p.read(b)
+	p.counters.countRead(n, err)
+	return n, first, err
+}
+
+func (p *TCPPort) read(b []byte) (int, time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readChunks(p.name, b, OpenOptions{OverallReadTimeout: p.options.ReadTimeout})
+}
+
+// Write implements io.Writer. While reconnecting it waits for the new
+// connection.
+func (p *TCPPort) Write(b []byte) (int, error) {
+	n, err := p.write(b)
+	p.counters.countWrite(n, err)
+	return n, err
+}
+
+func (p *TCPPort) write(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.conn == nil && !p.closed && p.connErr == nil {
+		if err := p.wait(p.writeDeadline); err != nil {
+			p.mu.Unlock()
+			return 0, p.portError("write", err)
+		}
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		return 0, ErrPortClosed
+	}
+
+	if p.connErr != nil {
+		err := p.connErr
+		p.mu.Unlock()
+		return 0, p.portError("write", err)
+	}
+
+	conn, deadline := p.conn, p.writeDeadline
+	p.mu.Unlock()
+
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return 0, p.portError("write", err)
+	}
+
+	n, err := conn.Write(b)
+	if err != nil && p.begin() != nil {
+		return n, ErrPortClosed
+	}
+
+	return n, p.portError("write", err)
+}
+
+// Close implements Port.
+func (p *TCPPort) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	p.chunks = nil
+	conn := p.conn
+	close(p.done)
+	p.notify()
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// String implements Port.
+func (p *TCPPort) String() string {
+	return p.name
+}
+
+// Stats implements Port.
+func (p *TCPPort) Stats() PortStats {
+	return p.counters.stats()
+}
+
+// ResetStats implements Port.
+func (p *TCPPort) ResetStats() {
+	p.counters.reset()
+}
+
+// SetHooks implements Port.
+func (p *TCPPort) SetHooks(hooks PortHooks) {
+	p.counters.setHooks(hooks)
+}
+
+// SetLogger implements Port.
+func (p *TCPPort) SetLogger(l *slog.Logger) {
+	p.log.set(l, p.name)
+}
+
+// begin fails if the port is closed.
+func (p *TCPPort) begin() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	return nil
+}
+
+// unsupported returns ErrNotSupported, or ErrPortClosed once the port is
+// closed.
+func (p *TCPPort) unsupported() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+// Pause implements Port. It returns ErrNotSupported.
+func (p *TCPPort) Pause() error {
+	return p.unsupported()
+}
+
+// Resume implements Port. It returns ErrNotSupported.
+func (p *TCPPort) Resume() error {
+	return p.unsupported()
+}
+
+// ErrorCounters implements Port. It returns ErrNotSupported.
+func (p *TCPPort) ErrorCounters() (ErrorCounters, error) {
+	return ErrorCounters{}, p.unsupported()
+}
+
+// ResetErrorCounters implements Port. It returns ErrNotSupported.
+func (p *TCPPort) ResetErrorCounters() error {
+	return p.unsupported()
+}
+
+// BufferSizes implements Port. The server doesn't report its buffers, so
+// all are zero.
+func (p *TCPPort) BufferSizes() (BufferSizes, error) {
+	return BufferSizes{}, p.begin()
+}
+
+// SetLowLatency implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetLowLatency(on bool) error {
+	return p.unsupported()
+}
+
+// CurrentOptions implements Port. It returns ErrNotSupported.
+func (p *TCPPort) CurrentOptions() (OpenOptions, error) {
+	return OpenOptions{}, p.unsupported()
+}
+
+// BaudRate implements Port. It returns ErrNotSupported.
+func (p *TCPPort) BaudRate() (uint, error) {
+	return 0, p.unsupported()
+}
+
+// DumpSettings implements Port, reporting whether the port is connected.
+func (p *TCPPort) DumpSettings() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.closed:
+		return "", ErrPortClosed
+	case p.connErr != nil:
+		return joinSettings(p.name, "disconnected"), nil
+	case p.conn == nil:
+		return joinSettings(p.name, "reconnecting"), nil
+	default:
+		return joinSettings(p.name, "connected"), nil
+	}
+}
+
+// DescribeTermios implements Port. It returns ErrNotSupported.
+func (p *TCPPort) DescribeTermios() (string, error) {
+	return "", p.unsupported()
+}
+
+// SetMode implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetMode(dataBits uint, parity ParityMode, stopBits uint, rtscts bool) error {
+	return p.unsupported()
+}
+
+// SetBaudRate implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetBaudRate(baud uint) error {
+	return p.unsupported()
+}
+
+// WithBaudRate implements Port. It returns ErrNotSupported without calling
+// fn.
+func (p *TCPPort) WithBaudRate(baud uint, fn func() error) error {
+	return p.unsupported()
+}
+
+// Drain implements Port. It waits for Writes in progress to hand their
+// data to the network; the server may still be transmitting it.
+func (p *TCPPort) Drain() error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+
+	p.wl.Lock()
+	p.wl.Unlock()
+
+	return nil
+}
+
+// Quiesce implements Port, discarding what has arrived once Writes in
+// progress have finished. Data still in the server's buffers isn't
+// discarded.
+func (p *TCPPort) Quiesce() error {
+	if err := p.Drain(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.chunks = nil
+	p.mu.Unlock()
+
+	return nil
+}
+
+// WriteNineBit implements Port. It returns ErrNotSupported.
+func (p *TCPPort) WriteNineBit(b []byte, address bool) (int, error) {
+	return 0, p.unsupported()
+}
+
+// SetReceiverEnabled implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetReceiverEnabled(on bool) error {
+	return p.unsupported()
+}
+
+// SetDTR implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetDTR(on bool) error {
+	return p.unsupported()
+}
+
+// SetRTS implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetRTS(on bool) error {
+	return p.unsupported()
+}
+
+// SetBreak implements Port. It returns ErrNotSupported.
+func (p *TCPPort) SetBreak(on bool) error {
+	return p.unsupported()
+}
+
+// CarrierDetect implements Port. It returns ErrNotSupported.
+func (p *TCPPort) CarrierDetect() (bool, error) {
+	return false, p.unsupported()
+}
+
+// WriteWithRTS implements Port. It returns ErrNotSupported without writing
+// anything.
+func (p *TCPPort) WriteWithRTS(b []byte) (int, error) {
+	err := p.unsupported()
+	p.counters.countWrite(0, err)
+	return 0, err
+}
+
+// PulseDTR implements Port. It returns ErrNotSupported.
+func (p *TCPPort) PulseDTR(d time.Duration) error {
+	return p.unsupported()
+}
+
+// PulseRTS implements Port. It returns ErrNotSupported.
+func (p *TCPPort) PulseRTS(d time.Duration) error {
+	return p.unsupported()
+}
+
+// WaitForData implements Port. Once the connection has failed for good it
+// reports that data is available, so that a subsequent Read returns the
+// error.
+func (p *TCPPort) WaitForData(timeout time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for len(p.chunks) == 0 && p.connErr == nil {
+		if p.closed {
+			return false, ErrPortClosed
+		}
+
+		if err := p.wait(deadline); err != nil {
+			return false, nil
+		}
+	}
+
+	if p.closed {
+		return false, ErrPortClosed
+	}
+
+	return true, nil
+}
+
+// BytesAvailable implements Port, counting the data that has arrived from
+// the server.
+func (p *TCPPort) BytesAvailable() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, ErrPortClosed
+	}
+
+	return p.buffered(), nil
+}
+
+// SetReadDeadline implements Port.
+func (p *TCPPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPortClosed
+	}
+
+	p.readDeadline = t
+	p.notify()
+
+	return nil
+}
+
+// SetWriteDeadline implements Port.
+func (p *TCPPort) SetWriteDeadline(t time.Time) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPortClosed
+	}
+
+	p.writeDeadline = t
+	conn := p.conn
+	p.notify()
+	p.mu.Unlock()
+
+	// Interrupt a Write in progress.
+	if conn == nil {
+		return nil
+	}
+
+	return p.portError("setting write deadline", conn.SetWriteDeadline(t))
+}
@@ -0,0 +1,164 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// listenTCP listens on a local address, closing the listener when the test
+// ends.
+func listenTCP(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	return ln
+}
+
+// accept accepts a connection on ln, closing it when the test ends.
+func accept(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestTCP(t *testing.T) {
+	ln := listenTCP(t)
+
+	p, err := OpenTCP(ln.Addr().String(), TCPOptions{ReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	conn := accept(t, ln)
+
+	var port Port = p
+	if got, want := port.String(), "tcp://"+ln.Addr().String(); got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	// Data passes through untouched both ways.
+	data := []byte{'a', 0xff, 0, '\n'}
+	if _, err := p.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("server read %q, want %q", got, data)
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, p, data)
+
+	// Nothing arriving within ReadTimeout gives io.EOF, and a deadline a
+	// deadline error.
+	if _, err := p.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read: got %v, want io.EOF", err)
+	}
+
+	p.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read after deadline: got %v, want a deadline error", err)
+	}
+	p.SetReadDeadline(time.Time{})
+
+	if err := p.SetBaudRate(9600); err != ErrNotSupported {
+		t.Errorf("SetBaudRate: got %v, want ErrNotSupported", err)
+	}
+
+	if err := p.SetDTR(false); err != ErrNotSupported {
+		t.Errorf("SetDTR: got %v, want ErrNotSupported", err)
+	}
+
+	// Without Reconnect, the server closing the connection ends the stream.
+	conn.Close()
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Read after the server closed: got %v, want ErrDeviceRemoved", err)
+	}
+
+	if _, err := p.Write([]byte("x")); err == nil {
+		t.Error("Write after the server closed succeeded")
+	}
+
+	p.Close()
+	if err := p.SetBaudRate(9600); err != ErrPortClosed {
+		t.Errorf("SetBaudRate after Close: got %v, want ErrPortClosed", err)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	ln := listenTCP(t)
+
+	p, err := OpenTCP(ln.Addr().String(), TCPOptions{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The server drops the connection; the port connects again and carries
+	// on in both directions.
+	accept(t, ln).Close()
+	conn := accept(t, ln)
+
+	if _, err := conn.Write([]byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	readFull(t, p, []byte("back"))
+
+	if _, err := p.Write([]byte("again")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "again" {
+		t.Errorf("server read %q, want %q", got, "again")
+	}
+
+	// Closing the port stops it reconnecting.
+	p.Close()
+	conn.Close()
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if c, err := ln.Accept(); err == nil {
+		c.Close()
+		t.Error("port reconnected after Close")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux || solaris
+
+package serial
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// flagName names a value of a termios flag or multi-bit field: the flag is
+// set when the bits in mask equal value.
+type flagName struct {
+	mask, value uint64
+	name        string
+}
+
+// ccName names an entry in c_cc.
+type ccName struct {
+	index int
+	name  string
+}
+
+// termiosNames lists the names DescribeTermios uses.
+type termiosNames struct {
+	iflag, oflag, cflag, lflag []flagName
+	cc                         []ccName
+}
+
+// bitFlag names a single-bit flag.
+func bitFlag(bit uint64, name string) flagName {
+	return flagName{bit, bit, name}
+}
+
+// The flags and control characters common to the POSIX systems. The per-OS
+// files add their own in osTermiosNames.
+var posixTermiosNames = termiosNames{
+	iflag: []flagName{
+		bitFlag(unix.IGNBRK, "IGNBRK"),
+		bitFlag(unix.BRKINT, "BRKINT"),
+		bitFlag(unix.IGNPAR, "IGNPAR"),
+		bitFlag(unix.PARMRK, "PARMRK"),
+		bitFlag(unix.INPCK, "INPCK"),
+		bitFlag(unix.ISTRIP, "ISTRIP"),
+		bitFlag(unix.INLCR, "INLCR"),
+		bitFlag(unix.IGNCR, "IGNCR"),
+		bitFlag(unix.ICRNL, "ICRNL"),
+		bitFlag(unix.IXON, "IXON"),
+		bitFlag(unix.IXANY, "IXANY"),
+		bitFlag(unix.IXOFF, "IXOFF"),
+		bitFlag(unix.IMAXBEL, "IMAXBEL"),
+	},
+	oflag: []flagName{
+		bitFlag(unix.OPOST, "OPOST"),
+		bitFlag(unix.ONLCR, "ONLCR"),
+		bitFlag(unix.OCRNL, "OCRNL"),
+		bitFlag(unix.ONOCR, "ONOCR"),
+		bitFlag(unix.ONLRET, "ONLRET"),
+	},
+	cflag: []flagName{
+		{unix.CSIZE, unix.CS5, "CS5"},
+		{unix.CSIZE, unix.CS6, "CS6"},
+		{unix.CSIZE, unix.CS7, "CS7"},
+		{unix.CSIZE, unix.CS8, "CS8"},
+		bitFlag(unix.CSTOPB, "CSTOPB"),
+		bitFlag(unix.CREAD, "CREAD"),
+		bitFlag(unix.PARENB, "PARENB"),
+		bitFlag(unix.PARODD, "PARODD"),
+		bitFlag(unix.HUPCL, "HUPCL"),
+		bitFlag(unix.CLOCAL, "CLOCAL"),
+	},
+	lflag: []flagName{
+		bitFlag(unix.ISIG, "ISIG"),
+		bitFlag(unix.ICANON, "ICANON"),
+		bitFlag(unix.ECHO, "ECHO"),
+		bitFlag(unix.ECHOE, "ECHOE"),
+		bitFlag(unix.ECHOK, "ECHOK"),
+		bitFlag(unix.ECHONL, "ECHONL"),
+		bitFlag(unix.NOFLSH, "NOFLSH"),
+		bitFlag(unix.TOSTOP, "TOSTOP"),
+		bitFlag(unix.ECHOCTL, "ECHOCTL"),
+		bitFlag(unix.ECHOPRT, "ECHOPRT"),
+		bitFlag(unix.ECHOKE, "ECHOKE"),
+		bitFlag(unix.FLUSHO, "FLUSHO"),
+		bitFlag(unix.PENDIN, "PENDIN"),
+		bitFlag(unix.IEXTEN, "IEXTEN"),
+	},
+	cc: []ccName{
+		{unix.VINTR, "VINTR"},
+		{unix.VQUIT, "VQUIT"},
+		{unix.VERASE, "VERASE"},
+		{unix.VKILL, "VKILL"},
+		{unix.VEOF, "VEOF"},
+		{unix.VEOL, "VEOL"},
+		{unix.VEOL2, "VEOL2"},
+		{unix.VSTART, "VSTART"},
+		{unix.VSTOP, "VSTOP"},
+		{unix.VSUSP, "VSUSP"},
+		{unix.VREPRINT, "VREPRINT"},
+		{unix.VDISCARD, "VDISCARD"},
+		{unix.VWERASE, "VWERASE"},
+		{unix.VLNEXT, "VLNEXT"},
+		{unix.VMIN, "VMIN"},
+		{unix.VTIME, "VTIME"},
+	},
+}
+
+// describeTermios renders t for DescribeTermios, one line per flag word
+// followed by the control characters.
+func describeTermios(t *Termios) string {
+	var b strings.Builder
+
+	words := []struct {
+		name   string
+		bits   uint64
+		common []flagName
+		extra  []flagName
+	}{
+		{"iflag", t.iflag, posixTermiosNames.iflag, osTermiosNames.iflag},
+		{"oflag", t.oflag, posixTermiosNames.oflag, osTermiosNames.oflag},
+		{"cflag", t.cflag, posixTermiosNames.cflag, osTermiosNames.cflag},
+		{"lflag", t.lflag, posixTermiosNames.lflag, osTermiosNames.lflag},
+	}
+
+	for _, w := range words {
+		fmt.Fprintf(&b, "%s: 0x%08x", w.name, w.bits)
+		for _, s := range describeFlags(w.bits, append(w.common, w.extra...)) {
+			b.WriteString(" " + s)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("cc:")
+	for _, c := range append(posixTermiosNames.cc, osTermiosNames.cc...) {
+		if c.index >= len(t.cc) {
+			continue
+		}
+
+		fmt.Fprintf(&b, " %s=%s", c.name, describeControlChar(c.name, t.cc[c.index]))
+	}
+
+	return b.String()
+}
+
+// describeFlags returns the names of the flags set in bits, followed by any
+// bits left unnamed in hex.
+func describeFlags(bits uint64, names []flagName) []string {
+	var result []string
+	var named uint64
+	for _, f := range names {
+		if bits&f.mask == f.value {
+			result = append(result, f.name)
+			named |= f.mask
+		}
+	}
+
+	if rest := bits &^ named; rest != 0 {
+		result = append(result, fmt.Sprintf("%#x", rest))
+	}
+
+	return result
+}
+
+// describeControlChar formats the value of the c_cc entry called name:
+// VMIN and VTIME are counts, and the others characters, shown in caret
+// notation when they are control characters.
+func describeControlChar(name string, c byte) string {
+	switch {
+	case name == "VMIN" || name == "VTIME":
+		return fmt.Sprint(c)
+	case c < 0x20:
+		return "^" + string(rune(c+'@'))
+	case c == 0x7f:
+		return "^?"
+	case c < 0x7f:
+		return string(rune(c))
+	default:
+		return fmt.Sprintf("%#02x", c)
+	}
+}
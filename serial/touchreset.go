@@ -0,0 +1,109 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"time"
+)
+
+// How often TouchReset looks for the board to come back.
+const touchPollInterval = 100 * time.Millisecond
+
+// TouchReset puts a board with native USB (SAMD, RP2040, ATmega32U4 and
+// similar) into its bootloader by opening the port at 1200 baud and closing
+// it again. The board then re-enumerates, often under a different name.
+// TouchReset waits up to wait for that to happen and returns the name of the
+// port the bootloader appeared on.
+//
+// A port that wasn't present before the reset is preferred. Failing that,
+// the original name is returned once it has disappeared and come back, or
+// when wait expires if it never went away, since some boards and systems
+// keep the same device throughout.
+func TouchReset(portName string, wait time.Duration) (string, error) {
+	before, err := portNames()
+	if err != nil {
+		return "", err
+	}
+
+	port, err := Open(OpenOptions{
+		PortName:              portName,
+		BaudRate:              1200,
+		DataBits:              8,
+		StopBits:              1,
+		InterCharacterTimeout: 100,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := port.Close(); err != nil {
+		return "", err
+	}
+
+	return waitForReenumeration(portName, before, wait, portNames)
+}
+
+// waitForReenumeration polls list until a port not in before appears, or
+// portName disappears and reappears, or wait expires.
+func waitForReenumeration(
+	portName string,
+	before []string,
+	wait time.Duration,
+	list func() ([]string, error)) (string, error) {
+	known := make(map[string]bool)
+	for _, name := range before {
+		known[name] = true
+	}
+
+	var gone, present bool
+	deadline := time.Now().Add(wait)
+
+	for {
+		names, err := list()
+		if err != nil {
+			return "", err
+		}
+
+		present = false
+		for _, name := range names {
+			if !known[name] {
+				return name, nil
+			}
+
+			if name == portName {
+				present = true
+			}
+		}
+
+		if !present {
+			gone = true
+		} else if gone {
+			return portName, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+
+		time.Sleep(touchPollInterval)
+	}
+
+	if present {
+		return portName, nil
+	}
+
+	return "", errors.New("serial: no port appeared after touch reset")
+}
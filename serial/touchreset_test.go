@@ -0,0 +1,90 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeList returns each of the listings in turn, repeating the last one.
+func fakeList(listings ...[]string) func() ([]string, error) {
+	return func() ([]string, error) {
+		l := listings[0]
+		if len(listings) > 1 {
+			listings = listings[1:]
+		}
+		return l, nil
+	}
+}
+
+func TestWaitForReenumeration(t *testing.T) {
+	before := []string{"/dev/ttyS0", "/dev/ttyACM0"}
+
+	testCases := []struct {
+		name     string
+		listings [][]string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "new name",
+			listings: [][]string{
+				{"/dev/ttyS0", "/dev/ttyACM0"},
+				{"/dev/ttyS0"},
+				{"/dev/ttyS0", "/dev/ttyACM1"},
+			},
+			want: "/dev/ttyACM1",
+		},
+		{
+			name: "same name after disappearing",
+			listings: [][]string{
+				{"/dev/ttyS0"},
+				{"/dev/ttyS0", "/dev/ttyACM0"},
+			},
+			want: "/dev/ttyACM0",
+		},
+		{
+			name: "never went away",
+			listings: [][]string{
+				{"/dev/ttyS0", "/dev/ttyACM0"},
+			},
+			want: "/dev/ttyACM0",
+		},
+		{
+			name: "never came back",
+			listings: [][]string{
+				{"/dev/ttyS0"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := waitForReenumeration("/dev/ttyACM0", before, 250*time.Millisecond, fakeList(tc.listings...))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+
+			if err != nil || got != tc.want {
+				t.Errorf("got %q, %v; want %q", got, err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer is the Port returned by NewTracer and NewLogTracer.
+type tracer struct {
+	Port
+
+	start time.Time
+
+	mu  sync.Mutex
+	out func(lines []string)
+}
+
+// NewTracer returns a Port that passes everything through to port, writing
+// a hex and ASCII dump of each chunk read and written to w, for seeing
+// what is actually on the wire. Each line starts with the time in seconds
+// since NewTracer was called and "read" or "write", for example:
+//
+//	0.012345 write 00000000  41 54 0d                                          |AT.|
+//	0.034567 read  00000000  4f 4b 0d 0a                                       |OK..|
+//
+// Errors are traced too. The data and errors are passed on unchanged. If w
+// is nil, port is returned as it is, so tracing can be left in place and
+// costs nothing when turned off. The format is meant for people and may
+// change.
+func NewTracer(port Port, w io.Writer) Port {
+	if w == nil {
+		return port
+	}
+
+	return &tracer{
+		Port:  port,
+		start: time.Now(),
+		out: func(lines []string) {
+			io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		},
+	}
+}
+
+// NewLogTracer is like NewTracer, printing each line of the dump with l.
+func NewLogTracer(port Port, l *log.Logger) Port {
+	if l == nil {
+		return port
+	}
+
+	return &tracer{
+		Port:  port,
+		start: time.Now(),
+		out: func(lines []string) {
+			for _, line := range lines {
+				l.Print(line)
+			}
+		},
+	}
+}
+
+func (t *tracer) trace(dir string, data []byte, err error) {
+	if len(data) == 0 && err == nil {
+		return
+	}
+
+	prefix := fmt.Sprintf("%.6f %-5s ", time.Since(t.start).Seconds(), dir)
+
+	var lines []string
+	if len(data) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(hex.Dump(data), "\n"), "\n") {
+			lines = append(lines, prefix+line)
+		}
+	}
+
+	if err != nil {
+		lines = append(lines, prefix+"error: "+err.Error())
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.out(lines)
+}
+
+// Read implements io.Reader.
+func (t *tracer) Read(b []byte) (int, error) {
+	n, err := t.Port.Read(b)
+	t.trace("read", b[:n], err)
+	return n, err
+}
+
+// ReadWithTimestamp implements Port.
+func (t *tracer) ReadWithTimestamp(b []byte) (int, time.Time, error) {
+	n, at, err := t.Port.ReadWithTimestamp(b)
+	t.trace("read", b[:n], err)
+	return n, at, err
+}
+
+// Write implements io.Writer.
+func (t *tracer) Write(b []byte) (int, error) {
+	n, err := t.Port.Write(b)
+	t.trace("write", b[:n], err)
+	return n, err
+}
+
+// WriteNineBit implements Port.
+func (t *tracer) WriteNineBit(b []byte, address bool) (int, error) {
+	n, err := t.Port.WriteNineBit(b, address)
+	t.trace("write", b[:n], err)
+	return n, err
+}
+
+// WriteWithRTS implements Port.
+func (t *tracer) WriteWithRTS(b []byte) (int, error) {
+	n, err := t.Port.WriteWithRTS(b)
+	t.trace("write", b[:n], err)
+	return n, err
+}
@@ -0,0 +1,102 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	var out bytes.Buffer
+	port := NewTracer(a, &out)
+
+	if n, err := port.Write([]byte("AT\r")); n != 3 || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+
+	b.Write([]byte("OK\r\n"))
+	buf := make([]byte, 8)
+	if n, err := port.Read(buf); err != nil || string(buf[:n]) != "OK\r\n" {
+		t.Fatalf("Read: %q, %v", buf[:n], err)
+	}
+
+	failure := errors.New("unplugged")
+	a.InjectErrors(func(op string) error { return failure })
+	if _, err := port.Write([]byte("x")); err != failure {
+		t.Errorf("Write: got %v, want the error unchanged", err)
+	}
+
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^\d+\.\d{6} write 00000000  41 54 0d +\|AT\.\|$`),
+		regexp.MustCompile(`^\d+\.\d{6} read  00000000  4f 4b 0d 0a +\|OK\.\.\|$`),
+		regexp.MustCompile(`^\d+\.\d{6} write error: unplugged$`),
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got:\n%s", out.String())
+	}
+
+	for i, re := range want {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("line %d: %q", i, lines[i])
+		}
+	}
+
+	a.InjectErrors(nil)
+	port.Close()
+}
+
+func TestTracerDisabled(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if port := NewTracer(a, nil); port != Port(a) {
+		t.Errorf("NewTracer with no writer: %T", port)
+	}
+
+	if port := NewLogTracer(a, nil); port != Port(a) {
+		t.Errorf("NewLogTracer with no logger: %T", port)
+	}
+}
+
+func TestLogTracer(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var out bytes.Buffer
+	port := NewLogTracer(a, log.New(&out, "com1: ", 0))
+
+	// Two lines of dump, each printed with the logger's prefix.
+	if _, err := port.Write([]byte("0123456789abcdefXYZ")); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "com1: ") || !strings.HasPrefix(lines[1], "com1: ") ||
+		!strings.Contains(lines[1], " write 00000010  58 59 5a ") {
+		t.Errorf("got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import "time"
+
+// Transact performs one exchange with a request/response device: it writes
+// req and then reads the reply into resp with ReadFull, returning how much
+// of it arrived. If discard is set, input already waiting, such as a late
+// reply to an earlier request, is first discarded with Quiesce, so that
+// it can't be taken for the reply to this one.
+//
+// The timeout bounds the whole exchange, writing included. It is
+// implemented with the port's deadlines, which are cleared again before
+// returning. If the reply is shorter than resp, Transact returns what
+// arrived along with the timeout error, satisfying
+// errors.Is(err, os.ErrDeadlineExceeded).
+func Transact(p Port, req, resp []byte, timeout time.Duration, discard bool) (int, error) {
+	if discard {
+		if err := p.Quiesce(); err != nil {
+			return 0, err
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := p.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	defer p.SetWriteDeadline(time.Time{})
+
+	if err := p.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	defer p.SetReadDeadline(time.Time{})
+
+	if _, err := p.Write(req); err != nil {
+		return 0, err
+	}
+
+	return ReadFull(p, resp)
+}
@@ -0,0 +1,76 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// echoDevice answers each request read from p with reply, until p is closed.
+func echoDevice(p Port, reply []byte) {
+	buf := make([]byte, 64)
+	for {
+		if _, err := p.Read(buf); err != nil && err != io.EOF {
+			return
+		}
+		p.Write(reply)
+	}
+}
+
+func TestTransact(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+	go echoDevice(b, []byte("pong"))
+
+	// Stale input is discarded first.
+	a.peer.Write([]byte("stale"))
+
+	resp := make([]byte, 4)
+	n, err := Transact(a, []byte("ping"), resp, time.Second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(resp[:n]) != "pong" {
+		t.Errorf("expected %q, got %q", "pong", resp[:n])
+	}
+
+	// A reply shorter than the buffer ends with a timeout.
+	start := time.Now()
+	resp = make([]byte, 8)
+	n, err = Transact(a, []byte("ping"), resp, 50*time.Millisecond, false)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+
+	if string(resp[:n]) != "pong" {
+		t.Errorf("expected %q, got %q", "pong", resp[:n])
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Transact took %v", d)
+	}
+
+	// The deadlines were cleared.
+	a.peer.Write([]byte("x"))
+	if n, err := a.Read(resp); n != 1 || err != nil {
+		t.Errorf("Read after Transact: %d, %v", n, err)
+	}
+}
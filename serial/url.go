@@ -0,0 +1,179 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OpenURL opens the port described by a serial URL, such as
+//
+//	serial:///dev/ttyUSB0?baud=115200&parity=even
+//	serial://COM3?baud=9600&timeout=500ms
+//	rfc2217://moxa:4001?baud=9600
+//
+// for applications that describe their transports as URLs. The port name is
+// the path, or on Windows the host. An rfc2217 URL names the host:port of
+// an RFC 2217 server instead, which is dialed with DialRFC2217. The query
+// may set:
+//
+//	baud      the baud rate
+//	databits  5, 6, 7 or 8
+//	parity    none, odd or even
+//	stopbits  1 or 2
+//	flow      none or rtscts
+//	timeout   a read timeout such as 500ms, as for WithReadTimeout
+//
+// Anything else is an error, so that a misspelt parameter doesn't silently
+// leave the default in place. The defaults are those of OpenPort: 115200 8N1
+// without flow control, with Read blocking until a byte arrives.
+func OpenURL(rawurl string) (Port, error) {
+	options, remote, err := urlOptions(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if remote {
+		return DialRFC2217(options)
+	}
+
+	return Open(options)
+}
+
+// urlOptions returns the OpenOptions that OpenURL opens rawurl with, and
+// whether they are for an RFC 2217 server.
+func urlOptions(rawurl string) (OpenOptions, bool, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return OpenOptions{}, false, fmt.Errorf("serial: %w", err)
+	}
+
+	remote := u.Scheme == "rfc2217"
+	if u.Scheme != "serial" && !remote {
+		return OpenOptions{}, false, fmt.Errorf("serial: URL scheme %q is not serial or rfc2217", u.Scheme)
+	}
+
+	var name string
+	switch {
+	case remote && (u.Host == "" || u.Path != ""):
+		return OpenOptions{}, false, fmt.Errorf("serial: URL %q doesn't name an RFC 2217 server as host:port", rawurl)
+	case remote:
+		name = u.Host
+	case u.Opaque != "":
+		name = u.Opaque
+	case u.Host != "" && u.Path != "":
+		return OpenOptions{}, false, fmt.Errorf("serial: URL %q names a host; remote ports aren't supported", rawurl)
+	case u.Host != "":
+		name = u.Host
+	default:
+		name = u.Path
+	}
+
+	if name == "" {
+		return OpenOptions{}, false, fmt.Errorf("serial: URL %q has no port name", rawurl)
+	}
+
+	opts, err := urlQueryOptions(u.Query())
+	if err != nil {
+		return OpenOptions{}, false, err
+	}
+
+	options, err := portOptions(name, opts)
+	return options, remote, err
+}
+
+// urlQueryOptions turns the query parameters of a serial URL into Options.
+func urlQueryOptions(query url.Values) ([]Option, error) {
+	// Go through the parameters in a fixed order, so that a URL with
+	// several mistakes always reports the same one.
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var opts []Option
+	for _, key := range keys {
+		if len(query[key]) != 1 {
+			return nil, fmt.Errorf("serial: URL parameter %s given more than once", key)
+		}
+		value := query.Get(key)
+
+		uintValue := func() (uint, error) {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("serial: invalid %s %q in URL", key, value)
+			}
+			return uint(n), nil
+		}
+
+		switch key {
+		case "baud":
+			n, err := uintValue()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithBaudRate(n))
+
+		case "databits":
+			n, err := uintValue()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithDataBits(n))
+
+		case "stopbits":
+			n, err := uintValue()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithStopBits(n))
+
+		case "parity":
+			mode, err := parseParity(value)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithParity(mode))
+
+		case "flow":
+			switch value {
+			case "none":
+				opts = append(opts, WithRTSCTSFlowControl(false))
+			case "rtscts":
+				opts = append(opts, WithRTSCTSFlowControl(true))
+			default:
+				return nil, fmt.Errorf(`serial: invalid flow %q in URL (want "none" or "rtscts")`, value)
+			}
+
+		case "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("serial: invalid timeout %q in URL", value)
+			}
+			opts = append(opts, WithReadTimeout(d))
+
+		default:
+			return nil, errors.New("serial: unknown URL parameter " + strconv.Quote(key))
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestURLOptions(t *testing.T) {
+	testCases := []struct {
+		url     string
+		name    string
+		mode    string
+		timeout uint
+		minRead uint
+	}{
+		{"serial:///dev/ttyUSB0", "/dev/ttyUSB0", "115200 8N1", 0, 1},
+		{"serial:///dev/ttyUSB0?baud=9600&parity=even&databits=7&stopbits=2",
+			"/dev/ttyUSB0", "9600 7E2", 0, 1},
+		{"serial://COM3?baud=9600&flow=rtscts&timeout=500ms", "COM3", "9600 8N1, rtscts", 500, 0},
+		{"serial:COM10?baud=57600", "COM10", "57600 8N1", 0, 1},
+		{"rfc2217://moxa:4001?baud=9600", "moxa:4001", "9600 8N1", 0, 1},
+	}
+
+	for _, tc := range testCases {
+		options, remote, err := urlOptions(tc.url)
+		if err != nil {
+			t.Errorf("%s: %v", tc.url, err)
+			continue
+		}
+
+		if options.PortName != tc.name || FormatMode(options) != tc.mode {
+			t.Errorf("%s: got %q, %q, want %q, %q", tc.url, options.PortName, FormatMode(options), tc.name, tc.mode)
+		}
+
+		if options.InterCharacterTimeout != tc.timeout || options.MinimumReadSize != tc.minRead {
+			t.Errorf(
+				"%s: got timeout %d, minimum read %d, want %d, %d",
+				tc.url, options.InterCharacterTimeout, options.MinimumReadSize, tc.timeout, tc.minRead)
+		}
+
+		if want := strings.HasPrefix(tc.url, "rfc2217:"); remote != want {
+			t.Errorf("%s: got remote %v, want %v", tc.url, remote, want)
+		}
+	}
+}
+
+func TestURLOptionsErrors(t *testing.T) {
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"tcp://localhost:1234", `scheme "tcp"`},
+		{"rfc2217:///dev/ttyUSB0", "RFC 2217 server"},
+		{"rfc2217://moxa:4001/port1", "RFC 2217 server"},
+		{"serial://", "no port name"},
+		{"serial://host/dev/ttyUSB0", "remote ports"},
+		{"serial:///dev/ttyUSB0?baud=fast", `invalid baud "fast"`},
+		{"serial:///dev/ttyUSB0?baud=0", "invalid baud rate 0"},
+		{"serial:///dev/ttyUSB0?buad=9600", `unknown URL parameter "buad"`},
+		{"serial:///dev/ttyUSB0?parity=mark", `invalid parity "mark"`},
+		{"serial:///dev/ttyUSB0?flow=xonxoff", `invalid flow "xonxoff"`},
+		{"serial:///dev/ttyUSB0?timeout=5", `invalid timeout "5"`},
+		{"serial:///dev/ttyUSB0?stopbits=3", "invalid number of stop bits 3"},
+		{"serial:///dev/ttyUSB0?baud=9600&baud=19200", "more than once"},
+	}
+
+	for _, tc := range testCases {
+		_, _, err := urlOptions(tc.url)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.url, err, tc.want)
+		}
+	}
+}
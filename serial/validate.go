@@ -0,0 +1,124 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks the options for mistakes that would make Open fail,
+// reporting all of them rather than just the first: each field with a bad
+// value, and combinations such as zero InterCharacterTimeout and
+// MinimumReadSize. The errors are joined, one per line, each naming the
+// field and the value. Open and OpenFd call Validate before touching the
+// device, so a bad configuration never leaves a port half set up.
+//
+// Some limits depend on the platform, such as the baud rates Solaris
+// supports and RS485 being Linux only; those are still reported by Open.
+func (o OpenOptions) Validate() error {
+	return o.validate(true)
+}
+
+// validate implements Validate. OpenFd doesn't need a port name, so
+// needName says whether a missing one is a problem.
+func (o OpenOptions) validate(needName bool) error {
+	var errs []error
+	problem := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("serial: "+format, args...))
+	}
+
+	// invalid is like problem, for problems with a sentinel error.
+	invalid := func(sentinel error, format string, args ...interface{}) {
+		errs = append(errs, markError(fmt.Errorf("serial: "+format, args...), sentinel))
+	}
+
+	if needName && o.PortName == "" {
+		problem("PortName is empty")
+	}
+
+	if o.BaudRate == 0 {
+		invalid(ErrInvalidBaudRate, "BaudRate is zero")
+	}
+
+	if o.DataBits < 5 || o.DataBits > 8 {
+		invalid(ErrInvalidDataBits, "invalid DataBits %d (want 5, 6, 7 or 8)", o.DataBits)
+	}
+
+	if o.StopBits != 1 && o.StopBits != 2 {
+		problem("invalid StopBits %d (want 1 or 2)", o.StopBits)
+	}
+
+	switch o.ParityMode {
+	case PARITY_NONE, PARITY_ODD, PARITY_EVEN:
+	default:
+		problem("invalid ParityMode %d", o.ParityMode)
+	}
+
+	// The timeouts are checked as the POSIX implementations use them, as
+	// VTIME in tenths of a second and VMIN in a byte.
+	vtime := uint(round(float64(o.InterCharacterTimeout)/100.0) * 100)
+	if vtime > 25500 {
+		problem("invalid InterCharacterTimeout %d (want at most 25500ms)", o.InterCharacterTimeout)
+	}
+
+	if o.MinimumReadSize > 255 {
+		problem("invalid MinimumReadSize %d (want at most 255)", o.MinimumReadSize)
+	}
+
+	if o.OverallReadTimeout < 0 {
+		problem("invalid OverallReadTimeout %v", o.OverallReadTimeout)
+	}
+
+	if o.MinimumReadSize == 0 && vtime < 100 && o.OverallReadTimeout == 0 {
+		problem("MinimumReadSize 0 needs an InterCharacterTimeout of at least 100ms, not %d", o.InterCharacterTimeout)
+	}
+
+	if o.MaxWriteChunk < 0 {
+		problem("invalid MaxWriteChunk %d", o.MaxWriteChunk)
+	}
+
+	if o.WriteChunkDelay < 0 {
+		problem("invalid WriteChunkDelay %v", o.WriteChunkDelay)
+	}
+
+	if o.OpenTimeout < 0 {
+		problem("invalid OpenTimeout %v", o.OpenTimeout)
+	}
+
+	if o.Rs485DelayRtsBeforeSend < 0 {
+		problem("invalid Rs485DelayRtsBeforeSend %d", o.Rs485DelayRtsBeforeSend)
+	}
+
+	if o.Rs485DelayRtsAfterSend < 0 {
+		problem("invalid Rs485DelayRtsAfterSend %d", o.Rs485DelayRtsAfterSend)
+	}
+
+	for _, l := range []struct {
+		name  string
+		state LineState
+	}{
+		{"InitialDTR", o.InitialDTR},
+		{"InitialRTS", o.InitialRTS},
+	} {
+		switch l.state {
+		case LINE_LEAVE, LINE_ASSERT, LINE_DEASSERT:
+		default:
+			problem("invalid %s %d", l.name, l.state)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	good := OpenOptions{
+		PortName:        "/dev/ttyUSB0",
+		BaudRate:        115200,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	}
+
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid options: %v", err)
+	}
+
+	bad := OpenOptions{
+		DataBits:              9,
+		StopBits:              3,
+		ParityMode:            ParityMode(5),
+		InterCharacterTimeout: 40,
+		InitialRTS:            LineState(9),
+	}
+
+	err := bad.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	want := []string{
+		"serial: PortName is empty",
+		"serial: BaudRate is zero",
+		"serial: invalid DataBits 9 (want 5, 6, 7 or 8)",
+		"serial: invalid StopBits 3 (want 1 or 2)",
+		"serial: invalid ParityMode 5",
+		"serial: MinimumReadSize 0 needs an InterCharacterTimeout of at least 100ms, not 40",
+		"serial: invalid InitialRTS 9",
+	}
+	if err.Error() != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", err, strings.Join(want, "\n"))
+	}
+
+	// Open must refuse the options before going near the device.
+	if _, err := Open(bad); err == nil || !strings.Contains(err.Error(), "invalid DataBits 9") {
+		t.Errorf("Open: got %v", err)
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	options := OpenOptions{
+		PortName:                "COM1",
+		BaudRate:                9600,
+		DataBits:                8,
+		StopBits:                1,
+		InterCharacterTimeout:   30000,
+		MinimumReadSize:         300,
+		MaxWriteChunk:           -1,
+		Rs485DelayRtsBeforeSend: -1,
+	}
+
+	err := options.Validate()
+	for _, want := range []string{"InterCharacterTimeout 30000", "MinimumReadSize 300", "MaxWriteChunk -1", "Rs485DelayRtsBeforeSend -1"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want an error mentioning %s", err, want)
+		}
+	}
+}
+
+func TestValidateOverallReadTimeout(t *testing.T) {
+	options := OpenOptions{
+		PortName:           "/dev/ttyUSB0",
+		BaudRate:           115200,
+		DataBits:           8,
+		StopBits:           1,
+		OverallReadTimeout: 500 * time.Millisecond,
+	}
+
+	// An overall timeout stands in for the inter-character one.
+	if err := options.Validate(); err != nil {
+		t.Errorf("valid options: %v", err)
+	}
+
+	options.OverallReadTimeout = -time.Second
+	if err := options.Validate(); err == nil || !strings.Contains(err.Error(), "invalid OverallReadTimeout -1s") {
+		t.Errorf("got %v, want an error for the negative timeout", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// How often WaitForPort and WaitForMatchingPort look for the port.
+const waitInterval = 100 * time.Millisecond
+
+// WaitForPort waits until the port named portName exists and can be read and
+// written by this process, for programs that start while a USB adapter is
+// still enumerating. On Linux that includes udev applying the device's
+// permissions, which happens a moment after the node appears. The port
+// isn't opened, since opening and closing it pulses DTR and so resets some
+// boards; another program may still have it busy. A negative timeout waits
+// indefinitely. If timeout passes first, WaitForPort returns a *PortError
+// wrapping os.ErrDeadlineExceeded, naming what was wrong with the port if
+// it did appear. OpenWithRetry does the waiting and opening in one.
+func WaitForPort(portName string, timeout time.Duration) error {
+	err := waitFor(timeout, func() error {
+		return portReady(portName)
+	})
+
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return newPortError(portName, "waiting", err)
+	}
+
+	return err
+}
+
+// WaitForMatchingPort is like WaitForPort for the first port matching f, as
+// OpenFirst would choose it, returning the port's details.
+func WaitForMatchingPort(f Filter, timeout time.Duration) (PortInfo, error) {
+	var found PortInfo
+	err := waitFor(timeout, func() error {
+		ports, err := FindPorts(f)
+		if err != nil {
+			return err
+		}
+
+		if len(ports) == 0 {
+			return os.ErrNotExist
+		}
+
+		found = ports[0]
+		return portReady(found.Name)
+	})
+
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("serial: waiting for a port matching %+v: %w", f, err)
+		}
+
+		return PortInfo{}, err
+	}
+
+	return found, nil
+}
+
+// waitFor calls ready every waitInterval until it succeeds, returns
+// ErrUnsupportedPlatform or timeout passes. In the last case the error
+// wraps os.ErrDeadlineExceeded and mentions ready's last error unless that
+// was just the port not existing.
+func waitFor(timeout time.Duration, ready func() error) error {
+	var end time.Time
+	if timeout >= 0 {
+		end = time.Now().Add(timeout)
+	}
+
+	for {
+		err := ready()
+		if err == nil || errors.Is(err, ErrUnsupportedPlatform) {
+			return err
+		}
+
+		if !end.IsZero() && !time.Now().Before(end) {
+			if errors.Is(err, os.ErrNotExist) {
+				return os.ErrDeadlineExceeded
+			}
+
+			return fmt.Errorf("%w (%v)", os.ErrDeadlineExceeded, err)
+		}
+
+		wait := waitInterval
+		if !end.IsZero() {
+			if remaining := time.Until(end); remaining < wait {
+				wait = remaining
+			}
+		}
+
+		time.Sleep(wait)
+	}
+}
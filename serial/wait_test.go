@@ -0,0 +1,94 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || linux
+
+package serial
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForPortAppearing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ttyUSB0")
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		os.WriteFile(path, nil, 0600)
+	}()
+
+	start := time.Now()
+	if err := WaitForPort(path, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("returned after %v, before the port appeared", elapsed)
+	}
+
+	// Once it's there, there's no waiting.
+	if err := WaitForPort(path, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWaitForPortTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ttyUSB0")
+
+	start := time.Now()
+	err := WaitForPort(path, 150*time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+
+	var pe *PortError
+	if !errors.As(err, &pe) || pe.Port != path || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("not a timeout: %v", err)
+	}
+}
+
+func TestWaitForPortPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can access anything")
+	}
+
+	path := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(path, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WaitForPort(path, 0)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWaitForMatchingPort(t *testing.T) {
+	f := Filter{SerialNumberPrefix: "no such serial number"}
+
+	if _, err := WaitForMatchingPort(f, 0); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
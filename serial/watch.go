@@ -0,0 +1,116 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"context"
+	"sort"
+)
+
+// Kinds of PortEvent.
+type PortEventType int
+
+const (
+	PORT_ADDED   PortEventType = 1
+	PORT_REMOVED PortEventType = 2
+)
+
+// PortEvent reports a port appearing or disappearing.
+type PortEvent struct {
+	Type PortEventType
+
+	// The port, as DetailedPorts reported it when it was added.
+	Port PortInfo
+}
+
+// WatchPorts reports serial ports as they are plugged in and removed. It
+// starts with a PORT_ADDED event for each port already present. The channel
+// is closed when ctx is cancelled.
+//
+// The system notifies the package of device changes: through a uevent
+// netlink socket on Linux, a kqueue watch on /dev on OS X and
+// CM_Register_Notification on Windows. On other systems WatchPorts returns
+// ErrNotSupported.
+func WatchPorts(ctx context.Context) (<-chan PortEvent, error) {
+	changes, err := watchChanges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan PortEvent)
+	go func() {
+		defer close(events)
+
+		known := make(map[string]PortInfo)
+		for {
+			// If listing fails, try again at the next change.
+			if ports, err := DetailedPorts(); err == nil {
+				for _, e := range diffPorts(known, ports) {
+					select {
+					case events <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// diffPorts compares ports with the known ports, updating known and
+// returning events for the differences: removals first, then additions, each
+// in order of name.
+func diffPorts(known map[string]PortInfo, ports []PortInfo) []PortEvent {
+	var events []PortEvent
+
+	present := make(map[string]bool)
+	for _, p := range ports {
+		present[p.Name] = true
+	}
+
+	var removed []string
+	for name := range known {
+		if !present[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, name := range removed {
+		events = append(events, PortEvent{Type: PORT_REMOVED, Port: known[name]})
+		delete(known, name)
+	}
+
+	for _, p := range ports {
+		if _, ok := known[p.Name]; !ok {
+			events = append(events, PortEvent{Type: PORT_ADDED, Port: p})
+			known[p.Name] = p
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,44 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffPorts(t *testing.T) {
+	a := PortInfo{Name: "a"}
+	b := PortInfo{Name: "b", IsUSB: true, VendorID: 0x0403}
+	c := PortInfo{Name: "c"}
+
+	known := map[string]PortInfo{}
+
+	got := diffPorts(known, []PortInfo{a, b})
+	want := []PortEvent{{PORT_ADDED, a}, {PORT_ADDED, b}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("initial snapshot: got %v, want %v", got, want)
+	}
+
+	got = diffPorts(known, []PortInfo{a, c})
+	want = []PortEvent{{PORT_REMOVED, b}, {PORT_ADDED, c}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after change: got %v, want %v", got, want)
+	}
+
+	if got = diffPorts(known, []PortInfo{a, c}); len(got) != 0 {
+		t.Errorf("no change: got %v", got)
+	}
+}
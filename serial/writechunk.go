@@ -0,0 +1,46 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import "time"
+
+// writeChunked writes b with write, at most max bytes at a time and pausing
+// for delay between chunks, as OpenOptions.MaxWriteChunk describes. A max
+// of zero writes b in one go.
+func writeChunked(b []byte, max int, delay time.Duration, write func([]byte) (int, error)) (int, error) {
+	if max <= 0 || len(b) <= max {
+		return write(b)
+	}
+
+	n := 0
+	for n < len(b) {
+		if n > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+
+		end := n + max
+		if end > len(b) {
+			end = len(b)
+		}
+
+		m, err := write(b[n:end])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2011 Aaron Jacobs. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serial
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteChunked(t *testing.T) {
+	var chunks []string
+	write := func(b []byte) (int, error) {
+		chunks = append(chunks, string(b))
+		return len(b), nil
+	}
+
+	start := time.Now()
+	n, err := writeChunked([]byte("abcdefgh"), 3, 10*time.Millisecond, write)
+	if n != 8 || err != nil {
+		t.Fatalf("writeChunked: %d, %v", n, err)
+	}
+
+	if got := len(chunks); got != 3 || chunks[0] != "abc" || chunks[1] != "def" || chunks[2] != "gh" {
+		t.Errorf("chunks: %q", chunks)
+	}
+
+	// Two pauses, between the three chunks.
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("took %v, want at least 20ms", d)
+	}
+
+	// Without a limit the buffer goes in one piece.
+	chunks = nil
+	writeChunked([]byte("abcdefgh"), 0, time.Second, write)
+	if len(chunks) != 1 {
+		t.Errorf("chunks: %q", chunks)
+	}
+
+	// A failure stops the write, reporting what got through.
+	failure := errors.New("taco")
+	calls := 0
+	n, err = writeChunked([]byte("abcdefgh"), 3, 0, func(b []byte) (int, error) {
+		if calls++; calls == 2 {
+			return 1, failure
+		}
+		return len(b), nil
+	})
+
+	if n != 4 || err != failure {
+		t.Errorf("writeChunked: %d, %v; want 4, taco", n, err)
+	}
+}